@@ -13,15 +13,21 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
 	"github.com/hanwen/go-fuse/fuse/pathfs"
 	"github.com/hanwen/go-fuse/internal/testutil"
 )
 
 func setupFs(fs pathfs.FileSystem, N int) (string, func()) {
+	return setupFsOptions(fs, N, &fuse.MountOptions{})
+}
+
+func setupFsOptions(fs pathfs.FileSystem, N int, mountOpts *fuse.MountOptions) (string, func()) {
 	opts := &nodefs.Options{
 		EntryTimeout:    0.0,
 		AttrTimeout:     0.0,
@@ -29,7 +35,8 @@ func setupFs(fs pathfs.FileSystem, N int) (string, func()) {
 	}
 	mountPoint := testutil.TempDir()
 	nfs := pathfs.NewPathNodeFs(fs, nil)
-	state, _, err := nodefs.MountRoot(mountPoint, nfs.Root(), opts)
+	conn := nodefs.NewFileSystemConnector(nfs.Root(), opts)
+	state, err := fuse.NewServer(conn.RawFS(), mountPoint, mountOpts)
 	if err != nil {
 		panic(fmt.Sprintf("cannot mount %v", err)) // ugh - benchmark has no error methods.
 	}
@@ -38,6 +45,9 @@ func setupFs(fs pathfs.FileSystem, N int) (string, func()) {
 		state.RecordLatencies(lmap)
 	}
 	go state.Serve()
+	if err := state.WaitMount(); err != nil {
+		panic(fmt.Sprintf("WaitMount: %v", err))
+	}
 
 	return mountPoint, func() {
 		if testutil.VerboseTest() {
@@ -138,6 +148,54 @@ func BenchmarkGoFuseStat(b *testing.B) {
 	}
 }
 
+// slowStatFS adds latency to GetAttr, as a network file system
+// would.
+type slowStatFS struct {
+	*StatFS
+	delay time.Duration
+}
+
+func (fs *slowStatFS) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	time.Sleep(fs.delay)
+	return fs.StatFS.GetAttr(name, context)
+}
+
+// benchmarkStatSameDir stats files in a single directory from
+// several goroutines. Without parallel directory operations, the
+// kernel sends their lookups one at a time.
+func benchmarkStatSameDir(b *testing.B, parallel bool) {
+	fs := &slowStatFS{NewStatFS(), time.Millisecond}
+	var names []string
+	for i := 0; i < 1000; i++ {
+		names = append(names, fmt.Sprintf("file%d", i))
+		fs.AddFile(names[i])
+	}
+
+	wd, clean := setupFsOptions(fs, b.N, &fuse.MountOptions{ParallelDirOps: parallel})
+	defer clean()
+
+	var next int64
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&next, 1)
+			if _, err := os.Lstat(filepath.Join(wd, names[int(i)%len(names)])); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkGoFuseStatSameDir(b *testing.B) {
+	benchmarkStatSameDir(b, false)
+}
+
+func BenchmarkGoFuseStatSameDirParallelDirOps(b *testing.B) {
+	benchmarkStatSameDir(b, true)
+}
+
 func readdir(d string) error {
 	f, err := os.Open(d)
 	if err != nil {
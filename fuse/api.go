@@ -8,6 +8,8 @@
 
 package fuse
 
+import "log"
+
 // Types for users to implement.
 
 // The result of Read is an array of bytes, but for performance
@@ -29,22 +31,57 @@ type ReadResult interface {
 }
 
 type MountOptions struct {
+	// If set, other users may access the mount. For non-root
+	// users, this requires user_allow_other in /etc/fuse.conf.
 	AllowOther bool
 
-	// Options are passed as -o string to fusermount.
+	// If set, root may access the mount in addition to the
+	// mounting user. The kernel lets everyone through, and the
+	// server fails requests from other users with EACCES. This
+	// cannot be combined with AllowOther, and has the same
+	// /etc/fuse.conf requirement.
+	AllowRoot bool
+
+	// If set, pass default_permissions to the mount, so the
+	// kernel checks access against the mode, uid and gid in the
+	// attributes the file system returns, and never sends
+	// ACCESS. File systems that rewrite ownership, like nodefs
+	// with Options.Owner, are checked against the rewritten
+	// owner. Root passes all checks, except execute on files
+	// without any x bit.
+	DefaultPermissions bool
+
+	// Options are passed as -o string to fusermount, along
+	// with those set through the other fields; see String().
+	// NewServer fails on unknown options, and on options that
+	// conflict with each other.
 	Options []string
 
 	// Default is _DEFAULT_BACKGROUND_TASKS, 12.  This numbers
 	// controls the allowed number of requests that relate to
-	// async I/O.  Concurrency for synchronous I/O is not limited.
+	// async I/O, such as read ahead.  Concurrency for synchronous
+	// I/O is not limited. The kernel caps this at
+	// /proc/sys/fs/fuse/max_user_bgreq for unprivileged mounts.
+	// Raising it only helps if MaxWorkers is 0 or at least as
+	// large.
 	MaxBackground int
 
+	// When this many background requests are pending, the kernel
+	// considers the mount congested and slows read ahead. If 0,
+	// use 3/4 of MaxBackground.
+	CongestionThreshold int
+
+	// If set, do not ask for CAP_ASYNC_READ, so the kernel issues
+	// at most one READ per file at a time.
+	SyncRead bool
+
 	// Write size to use.  If 0, use default. This number is
 	// capped at the kernel maximum.
 	MaxWrite int
 
 	// Max read ahead to use.  If 0, use default. This number is
-	// capped at the kernel maximum.
+	// capped at the kernel maximum. After INIT, the negotiated
+	// value is in Server.KernelSettings().MaxReadAhead.
 	MaxReadAhead int
 
 	// If IgnoreSecurityLabels is set, all security related xattr
@@ -61,21 +98,164 @@ type MountOptions struct {
 	// This may be useful for NFS.
 	RememberInodes bool
 
+	// If positive, the kernel sends READ requests of at most
+	// this many bytes.
+	MaxRead int
+
 	// Values shown in "df -T" and friends
 	// First column, "Filesystem"
 	FsName string
-	// Second column, "Type", will be shown as "fuse." + Name
+	// Second column, "Type", will be shown as "fuse." + Name. If
+	// empty, and Options has no subtype, the file system's
+	// String() is used. On OS X, this is the volume name (the
+	// volname option) shown in the Finder instead.
 	Name string
 
 	// If set, wrap the file system in a single-threaded locking wrapper.
 	SingleThreaded bool
 
+	// If positive, at most this many requests are processed
+	// concurrently. A single goroutine reads from the kernel and
+	// starts a goroutine per request, which waits until fewer
+	// than MaxWorkers requests are being processed. The reader
+	// does not wait: it handles FORGET, INTERRUPT and replies to
+	// notifications itself, so requests can still be interrupted
+	// when all workers are busy.
+	//
+	// This limits concurrency, not the number of goroutines or
+	// the memory in use: each waiting request still holds a
+	// goroutine and its read buffer. Waiting requests are not
+	// served in any particular order. If 0, a new goroutine is
+	// started whenever all others are busy.
+	MaxWorkers int
+
 	// If set, return ENOSYS for Getxattr calls, so the kernel does not issue any
 	// Xattr operations at all.
 	DisableXAttrs bool
 
 	// If set, print debugging information.
 	Debug bool
+
+	// If set, debugging information and diagnostics are written
+	// here rather than to the standard logger.
+	Logger *log.Logger
+
+	// If set, do not negotiate READDIRPLUS with the kernel, so
+	// directory listings are always served through ReadDir. Set
+	// this if the file system cannot provide attributes cheaply
+	// while listing a directory.
+	DisableReadDirPlus bool
+
+	// If set, ask the kernel to forward POSIX file locks
+	// (fcntl F_GETLK, F_SETLK and F_SETLKW) and flock(2) locks to
+	// the file system, rather than handling them locally. Only set
+	// this if the file system implements GetLk, SetLk, SetLkw and
+	// Flock.
+	EnableLocks bool
+
+	// If set, ask the kernel to use its writeback cache. Writes
+	// are then gathered in the page cache and sent in larger
+	// batches, and the kernel keeps track of file size and mtime
+	// itself: while a file has dirty pages, the size returned by
+	// GetAttr is ignored. The kernel may also read pages of files
+	// that were opened write-only, and handles O_APPEND itself.
+	// Size changes through SetAttr are sent after the dirty pages
+	// have been written.
+	WritebackCache bool
+
+	// If set, let the kernel send LOOKUP and READDIR requests for
+	// a directory in parallel, rather than one at a time. Only set
+	// this if the file system handles concurrent lookups in one
+	// directory; nodefs and pathfs do.
+	ParallelDirOps bool
+
+	// If set, ask the kernel to pass O_TRUNC in the flags for
+	// Open. The file system must then truncate the file while
+	// opening it. Otherwise, the kernel strips O_TRUNC and sends
+	// a SetAttr with size 0 before the Open.
+	AtomicTruncate bool
+
+	// If set, forward poll(2), select(2) and epoll(7) on open
+	// files to the file system through RawFileSystem.Poll.
+	// Normally, go-fuse switches off POLL when mounting, because
+	// the Go runtime polls files it opens itself; if the server
+	// process accesses its own mount, it must then have threads
+	// to spare for serving those POLL requests.
+	EnablePoll bool
+
+	// If set, fusermount unmounts the file system when the server
+	// process exits, even if it crashes. This passes auto_unmount
+	// to fusermount, which then keeps running to watch the
+	// process. It is only supported on Linux.
+	AutoUnmount bool
+
+	// If set, and the file system implements
+	// SpliceWriteFileSystem, read requests from the kernel with
+	// splice(2), so the data of writes can be moved into a file
+	// descriptor without copying it through the server. It is
+	// only supported on Linux.
+	SpliceWrite bool
+
+	// If set, ask the kernel for CAP_EXPORT_SUPPORT, so the
+	// mount can be exported over NFS. The kernel then resolves
+	// file handles of inodes it no longer caches by looking up
+	// "." and ".." in the node ID from the handle, and checks the
+	// generation of the result. The file system must keep such
+	// node IDs valid.
+	ExportSupport bool
+}
+
+// SpliceWriteFileSystem is an optional interface for RawFileSystems
+// that keep file data in file descriptors. If
+// MountOptions.SpliceWrite is set, the server calls WriteFd for each
+// WRITE, and splices the data into the returned descriptor at
+// input.Offset; Write is then not called. If WriteFd returns false,
+// or the descriptor does not support splicing, for example because
+// it was opened with O_APPEND, the data is passed to Write instead.
+type SpliceWriteFileSystem interface {
+	WriteFd(cancel <-chan struct{}, input *WriteIn) (fd uintptr, ok bool)
+}
+
+// TmpfileFileSystem is an optional interface for RawFileSystems that
+// support open(2) with O_TMPFILE, which the kernel sends as TMPFILE
+// since protocol version 7.37 regardless of the negotiated version.
+// Tmpfile creates an open file without a name in the directory
+// input.NodeId, and answers like Create. Without it, O_TMPFILE fails
+// with EOPNOTSUPP.
+type TmpfileFileSystem interface {
+	Tmpfile(cancel <-chan struct{}, input *CreateIn, out *CreateOut) (code Status)
+}
+
+// NodePathFileSystem is an optional interface for RawFileSystems that
+// can name their nodes. With MountOptions.Debug, the server logs the
+// path of the node with each request it dispatches. NodePath returns
+// false if the node has no known path.
+type NodePathFileSystem interface {
+	NodePath(nodeID uint64) (path string, ok bool)
+}
+
+// Transport carries FUSE messages between a Server and the kernel,
+// or whatever plays its part. Messages are in the kernel's wire
+// format. Servers from NewServer and NewServerFd use the /dev/fuse
+// descriptor; NewServerTransport takes another Transport, for
+// example a bridge to a platform that has no FUSE device.
+//
+// Read and Write are called from several goroutines at once.
+type Transport interface {
+	// Read blocks until a request is available, and reads it
+	// into buf, which is large enough for any request of the
+	// negotiated size. Once the connection is gone, Read returns
+	// ENODEV, or 0 bytes.
+	Read(buf []byte) (n int, err error)
+
+	// Write sends a reply or notification, which is the
+	// concatenation of msg. Concurrent messages must not
+	// interleave.
+	Write(msg [][]byte) error
+
+	// Close releases the connection. The Server calls it when
+	// Serve returns.
+	Close() error
 }
 
 // RawFileSystem is an interface close to the FUSE wire protocol.
@@ -86,6 +266,10 @@ type MountOptions struct {
 // somewhat tricky and not very interesting.
 //
 // A null implementation is provided by NewDefaultRawFileSystem.
+//
+// The cancel channel passed to the request methods is closed when
+// the kernel interrupts the request. Implementations that may block
+// for a long time should then give up and return EINTR.
 type RawFileSystem interface {
 	String() string
 
@@ -96,7 +280,7 @@ type RawFileSystem interface {
 	// about a file inside a directory. Many lookup calls can
 	// occur in parallel, but only one call happens for each (dir,
 	// name) pair.
-	Lookup(header *InHeader, name string, out *EntryOut) (status Status)
+	Lookup(cancel <-chan struct{}, header *InHeader, name string, out *EntryOut) (status Status)
 
 	// Forget is called when the kernel discards entries from its
 	// dentry cache. This happens on unmount, and when the kernel
@@ -107,50 +291,110 @@ type RawFileSystem interface {
 	Forget(nodeid, nlookup uint64)
 
 	// Attributes.
-	GetAttr(input *GetAttrIn, out *AttrOut) (code Status)
-	SetAttr(input *SetAttrIn, out *AttrOut) (code Status)
-
-	// Modifying structure.
-	Mknod(input *MknodIn, name string, out *EntryOut) (code Status)
-	Mkdir(input *MkdirIn, name string, out *EntryOut) (code Status)
-	Unlink(header *InHeader, name string) (code Status)
-	Rmdir(header *InHeader, name string) (code Status)
-	Rename(input *RenameIn, oldName string, newName string) (code Status)
-	Link(input *LinkIn, filename string, out *EntryOut) (code Status)
-
-	Symlink(header *InHeader, pointedTo string, linkName string, out *EntryOut) (code Status)
-	Readlink(header *InHeader) (out []byte, code Status)
-	Access(input *AccessIn) (code Status)
+	GetAttr(cancel <-chan struct{}, input *GetAttrIn, out *AttrOut) (code Status)
+	SetAttr(cancel <-chan struct{}, input *SetAttrIn, out *AttrOut) (code Status)
+
+	// Modifying structure. The kernel applies the umask of the
+	// calling process to the Mode of MknodIn, MkdirIn and
+	// CreateIn: Go-FUSE does not negotiate CAP_DONT_MASK, so
+	// Umask is for information only. Backends that create files
+	// with the mode, such as the loopback file systems, are
+	// subject to the umask of the daemon too, and should run with
+	// syscall.Umask(0).
+	Mknod(cancel <-chan struct{}, input *MknodIn, name string, out *EntryOut) (code Status)
+	Mkdir(cancel <-chan struct{}, input *MkdirIn, name string, out *EntryOut) (code Status)
+	Unlink(cancel <-chan struct{}, header *InHeader, name string) (code Status)
+	Rmdir(cancel <-chan struct{}, header *InHeader, name string) (code Status)
+
+	// Rename also serves renameat2(2): input.Flags holds the
+	// RENAME_* flags. File systems that do not support a flag
+	// should return EINVAL; returning ENOSYS for a call with
+	// flags makes the kernel fail all later ones with EINVAL.
+	Rename(cancel <-chan struct{}, input *RenameIn, oldName string, newName string) (code Status)
+	Link(cancel <-chan struct{}, input *LinkIn, filename string, out *EntryOut) (code Status)
+
+	Symlink(cancel <-chan struct{}, header *InHeader, pointedTo string, linkName string, out *EntryOut) (code Status)
+	Readlink(cancel <-chan struct{}, header *InHeader) (out []byte, code Status)
+	Access(cancel <-chan struct{}, input *AccessIn) (code Status)
 
 	// Extended attributes.
-	GetXAttrSize(header *InHeader, attr string) (sz int, code Status)
-	GetXAttrData(header *InHeader, attr string) (data []byte, code Status)
-	ListXAttr(header *InHeader) (attributes []byte, code Status)
-	SetXAttr(input *SetXAttrIn, attr string, data []byte) Status
-	RemoveXAttr(header *InHeader, attr string) (code Status)
+	GetXAttrSize(cancel <-chan struct{}, header *InHeader, attr string) (sz int, code Status)
+	GetXAttrData(cancel <-chan struct{}, header *InHeader, attr string) (data []byte, code Status)
+	ListXAttr(cancel <-chan struct{}, header *InHeader) (attributes []byte, code Status)
+	SetXAttr(cancel <-chan struct{}, input *SetXAttrIn, attr string, data []byte) Status
+	RemoveXAttr(cancel <-chan struct{}, header *InHeader, attr string) (code Status)
 
 	// File handling.
-	Create(input *CreateIn, name string, out *CreateOut) (code Status)
-	Open(input *OpenIn, out *OpenOut) (status Status)
-	Read(input *ReadIn, buf []byte) (ReadResult, Status)
-
-	Flock(input *FlockIn, flags int) (code Status)
-
-	Release(input *ReleaseIn)
-	Write(input *WriteIn, data []byte) (written uint32, code Status)
-	Flush(input *FlushIn) Status
-	Fsync(input *FsyncIn) (code Status)
-	Fallocate(input *FallocateIn) (code Status)
-
-	// Directory handling
-	OpenDir(input *OpenIn, out *OpenOut) (status Status)
-	ReadDir(input *ReadIn, out *DirEntryList) Status
-	ReadDirPlus(input *ReadIn, out *DirEntryList) Status
-	ReleaseDir(input *ReleaseIn)
-	FsyncDir(input *FsyncIn) (code Status)
+	Create(cancel <-chan struct{}, input *CreateIn, name string, out *CreateOut) (code Status)
+	Open(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status)
+	Read(cancel <-chan struct{}, input *ReadIn, buf []byte) (ReadResult, Status)
+
+	// Flock is called for flock(2) locks if
+	// MountOptions.EnableLocks is set. The flags are as for
+	// flock(2); without LOCK_NB, Flock should block until the
+	// lock is acquired.
+	Flock(cancel <-chan struct{}, input *FlockIn, flags int) (code Status)
+
+	// File locking. These are only called if
+	// MountOptions.EnableLocks is set. SetLkw should block
+	// until the lock is acquired.
+	GetLk(cancel <-chan struct{}, input *LkIn, out *LkOut) (code Status)
+	SetLk(cancel <-chan struct{}, input *LkIn) (code Status)
+	SetLkw(cancel <-chan struct{}, input *LkIn) (code Status)
+
+	Release(cancel <-chan struct{}, input *ReleaseIn)
+	Write(cancel <-chan struct{}, input *WriteIn, data []byte) (written uint32, code Status)
+	Flush(cancel <-chan struct{}, input *FlushIn) Status
+	Fsync(cancel <-chan struct{}, input *FsyncIn) (code Status)
+	Fallocate(cancel <-chan struct{}, input *FallocateIn) (code Status)
+
+	// Lseek is called for SEEK_DATA and SEEK_HOLE; the kernel
+	// handles other whence values itself. If it returns ENOSYS,
+	// the kernel treats the whole file as data.
+	Lseek(cancel <-chan struct{}, input *LseekIn, out *LseekOut) (code Status)
+
+	// CopyFileRange copies data between two open files on this
+	// mount, for copy_file_range(2). If it returns ENOSYS, the
+	// kernel falls back to reading and writing for this and all
+	// later copies.
+	CopyFileRange(cancel <-chan struct{}, input *CopyFileRangeIn) (written uint32, code Status)
+
+	// Poll is called for poll(2) and friends, if
+	// MountOptions.EnablePoll is set. It should fill in the
+	// events that are ready. If input.Flags has
+	// FUSE_POLL_SCHEDULE_NOTIFY, the file system should call
+	// Server.PollNotify with input.Kh once the file becomes
+	// ready. If it returns ENOSYS, the kernel stops sending
+	// POLL and treats all files as always ready.
+	Poll(cancel <-chan struct{}, input *PollIn, out *PollOut) (code Status)
+
+	// Bmap maps a block of a file to a block of the underlying
+	// device. It is only called for file systems mounted with
+	// the blkdev option (fuseblk). If it returns ENOSYS, the
+	// kernel does not ask again.
+	Bmap(cancel <-chan struct{}, input *BmapIn, out *BmapOut) (code Status)
+
+	// Ioctl is called for ioctl(2) on an open file. The input
+	// holds input.InSize bytes copied from the caller, and the
+	// returned data, at most input.OutSize bytes, is copied
+	// back. Unrestricted ioctls (FUSE_IOCTL_UNRESTRICTED, only
+	// sent for CUSE devices) may ask for other buffers with
+	// IoctlOut.Retry.
+	Ioctl(cancel <-chan struct{}, input *IoctlIn, inbuf []byte, out *IoctlOut) (data []byte, code Status)
+
+	// Directory handling. The Fh set by OpenDir is passed to
+	// ReadDir, ReadDirPlus, FsyncDir and ReleaseDir, so a listing
+	// can be kept consistent for the lifetime of the handle. If
+	// OpenDir returns ENOSYS, the kernel stops sending it, and
+	// the other calls get a zero Fh.
+	OpenDir(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status)
+	ReadDir(cancel <-chan struct{}, input *ReadIn, out *DirEntryList) Status
+	ReadDirPlus(cancel <-chan struct{}, input *ReadIn, out *DirEntryList) Status
+	ReleaseDir(cancel <-chan struct{}, input *ReleaseIn)
+	FsyncDir(cancel <-chan struct{}, input *FsyncIn) (code Status)
 
 	//
-	StatFs(input *InHeader, out *StatfsOut) (code Status)
+	StatFs(cancel <-chan struct{}, input *InHeader, out *StatfsOut) (code Status)
 
 	// This is called on processing the first request. The
 	// filesystem implementation can use the server argument to
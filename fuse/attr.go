@@ -65,15 +65,53 @@ func ToStatT(f os.FileInfo) *syscall.Stat_t {
 	return nil
 }
 
+// ToAttr converts f to an Attr. If f does not come from a stat
+// system call, the type, permissions, size and modification time,
+// to the nanosecond, are taken from its methods; the modification
+// time also serves as access and change time.
 func ToAttr(f os.FileInfo) *Attr {
 	if f == nil {
 		return nil
 	}
-	s := ToStatT(f)
-	if s != nil {
-		a := &Attr{}
+	a := &Attr{}
+	if s := ToStatT(f); s != nil {
 		a.FromStat(s)
 		return a
 	}
-	return nil
+	a.Mode = fileModeToMode(f.Mode())
+	a.Size = uint64(f.Size())
+	t := f.ModTime()
+	a.SetTimes(&t, &t, &t)
+	return a
+}
+
+// fileModeToMode converts an os.FileMode to the mode of stat(2).
+func fileModeToMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	switch {
+	case m&os.ModeDir != 0:
+		mode |= syscall.S_IFDIR
+	case m&os.ModeSymlink != 0:
+		mode |= syscall.S_IFLNK
+	case m&os.ModeNamedPipe != 0:
+		mode |= syscall.S_IFIFO
+	case m&os.ModeSocket != 0:
+		mode |= syscall.S_IFSOCK
+	case m&os.ModeCharDevice != 0:
+		mode |= syscall.S_IFCHR
+	case m&os.ModeDevice != 0:
+		mode |= syscall.S_IFBLK
+	default:
+		mode |= syscall.S_IFREG
+	}
+	if m&os.ModeSetuid != 0 {
+		mode |= syscall.S_ISUID
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= syscall.S_ISGID
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= syscall.S_ISVTX
+	}
+	return mode
 }
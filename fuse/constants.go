@@ -18,6 +18,10 @@ const (
 
 	FUSE_LK_FLOCK = (1 << 0)
 
+	// OFFSET_MAX is the End of a FileLock that extends to the end
+	// of the file.
+	OFFSET_MAX = (1 << 63) - 1
+
 	FUSE_IOCTL_MAX_IOV = 256
 
 	FUSE_POLL_SCHEDULE_NOTIFY = (1 << 0)
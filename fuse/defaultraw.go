@@ -26,131 +26,163 @@ func (fs *defaultRawFileSystem) String() string {
 func (fs *defaultRawFileSystem) SetDebug(dbg bool) {
 }
 
-func (fs *defaultRawFileSystem) StatFs(header *InHeader, out *StatfsOut) Status {
+func (fs *defaultRawFileSystem) StatFs(cancel <-chan struct{}, header *InHeader, out *StatfsOut) Status {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Lookup(header *InHeader, name string, out *EntryOut) (code Status) {
+func (fs *defaultRawFileSystem) Lookup(cancel <-chan struct{}, header *InHeader, name string, out *EntryOut) (code Status) {
 	return ENOSYS
 }
 
 func (fs *defaultRawFileSystem) Forget(nodeID, nlookup uint64) {
 }
 
-func (fs *defaultRawFileSystem) GetAttr(input *GetAttrIn, out *AttrOut) (code Status) {
+func (fs *defaultRawFileSystem) GetAttr(cancel <-chan struct{}, input *GetAttrIn, out *AttrOut) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Open(input *OpenIn, out *OpenOut) (status Status) {
+func (fs *defaultRawFileSystem) Open(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status) {
 	return OK
 }
 
-func (fs *defaultRawFileSystem) SetAttr(input *SetAttrIn, out *AttrOut) (code Status) {
+func (fs *defaultRawFileSystem) SetAttr(cancel <-chan struct{}, input *SetAttrIn, out *AttrOut) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Readlink(header *InHeader) (out []byte, code Status) {
+func (fs *defaultRawFileSystem) Readlink(cancel <-chan struct{}, header *InHeader) (out []byte, code Status) {
 	return nil, ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Mknod(input *MknodIn, name string, out *EntryOut) (code Status) {
+func (fs *defaultRawFileSystem) Mknod(cancel <-chan struct{}, input *MknodIn, name string, out *EntryOut) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Mkdir(input *MkdirIn, name string, out *EntryOut) (code Status) {
+func (fs *defaultRawFileSystem) Mkdir(cancel <-chan struct{}, input *MkdirIn, name string, out *EntryOut) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Unlink(header *InHeader, name string) (code Status) {
+func (fs *defaultRawFileSystem) Unlink(cancel <-chan struct{}, header *InHeader, name string) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Rmdir(header *InHeader, name string) (code Status) {
+func (fs *defaultRawFileSystem) Rmdir(cancel <-chan struct{}, header *InHeader, name string) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Symlink(header *InHeader, pointedTo string, linkName string, out *EntryOut) (code Status) {
+func (fs *defaultRawFileSystem) Symlink(cancel <-chan struct{}, header *InHeader, pointedTo string, linkName string, out *EntryOut) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Rename(input *RenameIn, oldName string, newName string) (code Status) {
+func (fs *defaultRawFileSystem) Rename(cancel <-chan struct{}, input *RenameIn, oldName string, newName string) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Link(input *LinkIn, name string, out *EntryOut) (code Status) {
+func (fs *defaultRawFileSystem) Link(cancel <-chan struct{}, input *LinkIn, name string, out *EntryOut) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) GetXAttrSize(header *InHeader, attr string) (size int, code Status) {
+func (fs *defaultRawFileSystem) GetXAttrSize(cancel <-chan struct{}, header *InHeader, attr string) (size int, code Status) {
 	return 0, ENOSYS
 }
 
-func (fs *defaultRawFileSystem) GetXAttrData(header *InHeader, attr string) (data []byte, code Status) {
-	return nil, ENOATTR
+func (fs *defaultRawFileSystem) GetXAttrData(cancel <-chan struct{}, header *InHeader, attr string) (data []byte, code Status) {
+	return nil, ENOSYS
 }
 
-func (fs *defaultRawFileSystem) SetXAttr(input *SetXAttrIn, attr string, data []byte) Status {
+func (fs *defaultRawFileSystem) SetXAttr(cancel <-chan struct{}, input *SetXAttrIn, attr string, data []byte) Status {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) ListXAttr(header *InHeader) (data []byte, code Status) {
+func (fs *defaultRawFileSystem) ListXAttr(cancel <-chan struct{}, header *InHeader) (data []byte, code Status) {
 	return nil, ENOSYS
 }
 
-func (fs *defaultRawFileSystem) RemoveXAttr(header *InHeader, attr string) Status {
+func (fs *defaultRawFileSystem) RemoveXAttr(cancel <-chan struct{}, header *InHeader, attr string) Status {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Access(input *AccessIn) (code Status) {
+func (fs *defaultRawFileSystem) Access(cancel <-chan struct{}, input *AccessIn) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Create(input *CreateIn, name string, out *CreateOut) (code Status) {
+func (fs *defaultRawFileSystem) Create(cancel <-chan struct{}, input *CreateIn, name string, out *CreateOut) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) OpenDir(input *OpenIn, out *OpenOut) (status Status) {
+func (fs *defaultRawFileSystem) OpenDir(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Read(input *ReadIn, buf []byte) (ReadResult, Status) {
+func (fs *defaultRawFileSystem) Read(cancel <-chan struct{}, input *ReadIn, buf []byte) (ReadResult, Status) {
 	return nil, ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Flock(input *FlockIn, flags int) Status {
+func (fs *defaultRawFileSystem) Flock(cancel <-chan struct{}, input *FlockIn, flags int) Status {
+	return ENOSYS
+}
+
+func (fs *defaultRawFileSystem) Lseek(cancel <-chan struct{}, input *LseekIn, out *LseekOut) (code Status) {
+	return ENOSYS
+}
+
+func (fs *defaultRawFileSystem) CopyFileRange(cancel <-chan struct{}, input *CopyFileRangeIn) (written uint32, code Status) {
+	return 0, ENOSYS
+}
+
+func (fs *defaultRawFileSystem) Bmap(cancel <-chan struct{}, input *BmapIn, out *BmapOut) (code Status) {
+	return ENOSYS
+}
+
+func (fs *defaultRawFileSystem) Ioctl(cancel <-chan struct{}, input *IoctlIn, inbuf []byte, out *IoctlOut) (data []byte, code Status) {
+	return nil, ENOSYS
+}
+
+func (fs *defaultRawFileSystem) Poll(cancel <-chan struct{}, input *PollIn, out *PollOut) (code Status) {
+	return ENOSYS
+}
+
+func (fs *defaultRawFileSystem) GetLk(cancel <-chan struct{}, input *LkIn, out *LkOut) (code Status) {
+	return ENOSYS
+}
+
+func (fs *defaultRawFileSystem) SetLk(cancel <-chan struct{}, input *LkIn) (code Status) {
+	return ENOSYS
+}
+
+func (fs *defaultRawFileSystem) SetLkw(cancel <-chan struct{}, input *LkIn) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Release(input *ReleaseIn) {
+func (fs *defaultRawFileSystem) Release(cancel <-chan struct{}, input *ReleaseIn) {
 }
 
-func (fs *defaultRawFileSystem) Write(input *WriteIn, data []byte) (written uint32, code Status) {
+func (fs *defaultRawFileSystem) Write(cancel <-chan struct{}, input *WriteIn, data []byte) (written uint32, code Status) {
 	return 0, ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Flush(input *FlushIn) Status {
+func (fs *defaultRawFileSystem) Flush(cancel <-chan struct{}, input *FlushIn) Status {
 	return OK
 }
 
-func (fs *defaultRawFileSystem) Fsync(input *FsyncIn) (code Status) {
+func (fs *defaultRawFileSystem) Fsync(cancel <-chan struct{}, input *FsyncIn) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) ReadDir(input *ReadIn, l *DirEntryList) Status {
+func (fs *defaultRawFileSystem) ReadDir(cancel <-chan struct{}, input *ReadIn, l *DirEntryList) Status {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) ReadDirPlus(input *ReadIn, l *DirEntryList) Status {
+func (fs *defaultRawFileSystem) ReadDirPlus(cancel <-chan struct{}, input *ReadIn, l *DirEntryList) Status {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) ReleaseDir(input *ReleaseIn) {
+func (fs *defaultRawFileSystem) ReleaseDir(cancel <-chan struct{}, input *ReleaseIn) {
 }
 
-func (fs *defaultRawFileSystem) FsyncDir(input *FsyncIn) (code Status) {
+func (fs *defaultRawFileSystem) FsyncDir(cancel <-chan struct{}, input *FsyncIn) (code Status) {
 	return ENOSYS
 }
 
-func (fs *defaultRawFileSystem) Fallocate(in *FallocateIn) (code Status) {
+func (fs *defaultRawFileSystem) Fallocate(cancel <-chan struct{}, in *FallocateIn) (code Status) {
 	return ENOSYS
 }
@@ -39,6 +39,11 @@ type DirEntryList struct {
 	buf    []byte
 	size   int
 	offset uint64
+
+	// If set, AddDirEntry prefixes each entry with a zeroed
+	// EntryOut, so READDIR results can be returned for
+	// READDIRPLUS.
+	emptyLookups bool
 }
 
 // NewDirEntryList creates a DirEntryList with the given data buffer
@@ -54,6 +59,16 @@ func NewDirEntryList(data []byte, off uint64) *DirEntryList {
 // AddDirEntry tries to add an entry, and reports whether it
 // succeeded.
 func (l *DirEntryList) AddDirEntry(e DirEntry) (bool, uint64) {
+	if l.emptyLookups {
+		entry, off := l.AddDirLookupEntry(e)
+		if entry == nil {
+			return false, off
+		}
+		// A NodeId of 0 tells the kernel there is no lookup
+		// data for this entry.
+		*entry = EntryOut{}
+		return true, off
+	}
 	return l.Add(0, e.Name, e.Ino, e.Mode)
 }
 
@@ -0,0 +1,339 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fusetest runs a fuse.Server against a fake kernel in the
+// same process, so the handling of individual opcodes can be tested
+// without /dev/fuse or a mount.
+//
+// The fake kernel talks to the server over a socket pair. Tests
+// craft raw request buffers, for example with Request, and get the
+// raw reply buffers back:
+//
+//	conn := nodefs.NewFileSystemConnector(root, nil)
+//	k, err := fusetest.NewKernel(conn.RawFS(), nil)
+//	...
+//	defer k.Close()
+//	out, code, err := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+package fusetest
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Opcodes, as numbered in the kernel ABI.
+const (
+	OpLookup        = int32(1)
+	OpForget        = int32(2)
+	OpGetAttr       = int32(3)
+	OpSetAttr       = int32(4)
+	OpReadlink      = int32(5)
+	OpSymlink       = int32(6)
+	OpMknod         = int32(8)
+	OpMkdir         = int32(9)
+	OpUnlink        = int32(10)
+	OpRmdir         = int32(11)
+	OpRename        = int32(12)
+	OpLink          = int32(13)
+	OpOpen          = int32(14)
+	OpRead          = int32(15)
+	OpWrite         = int32(16)
+	OpStatFs        = int32(17)
+	OpRelease       = int32(18)
+	OpFsync         = int32(20)
+	OpSetXAttr      = int32(21)
+	OpGetXAttr      = int32(22)
+	OpListXAttr     = int32(23)
+	OpRemoveXAttr   = int32(24)
+	OpFlush         = int32(25)
+	OpInit          = int32(26)
+	OpOpenDir       = int32(27)
+	OpReadDir       = int32(28)
+	OpReleaseDir    = int32(29)
+	OpFsyncDir      = int32(30)
+	OpGetLk         = int32(31)
+	OpSetLk         = int32(32)
+	OpSetLkw        = int32(33)
+	OpAccess        = int32(34)
+	OpCreate        = int32(35)
+	OpInterrupt     = int32(36)
+	OpBmap          = int32(37)
+	OpDestroy       = int32(38)
+	OpIoctl         = int32(39)
+	OpPoll          = int32(40)
+	OpNotifyReply   = int32(41)
+	OpBatchForget   = int32(42)
+	OpFallocate     = int32(43)
+	OpReadDirPlus   = int32(44)
+	OpRename2       = int32(45)
+	OpLseek         = int32(46)
+	OpCopyFileRange = int32(47)
+	OpTmpfile       = int32(51)
+)
+
+// Minor is the protocol minor version that NewKernel offers in
+// INIT.
+const Minor = 28
+
+var sizeOfInHeader = int(unsafe.Sizeof(fuse.InHeader{}))
+var sizeOfOutHeader = int(unsafe.Sizeof(fuse.OutHeader{}))
+
+// Kernel plays the kernel side of a FUSE connection.
+type Kernel struct {
+	// Server serves the file system under test.
+	Server *fuse.Server
+
+	// InitOut is the server's reply to INIT.
+	InitOut fuse.InitOut
+
+	fd       int
+	messages chan []byte
+	served   chan struct{}
+	read     chan struct{}
+	quit     chan struct{}
+
+	mu      sync.Mutex
+	unique  uint64
+	pending map[uint64]chan []byte
+	closed  bool
+}
+
+// NewKernel starts serving fs for a fake kernel, which has sent
+// INIT for protocol 7.Minor with all capabilities. opts may be nil;
+// the options that only affect mounting are ignored.
+func NewKernel(fs fuse.RawFileSystem, opts *fuse.MountOptions) (*Kernel, error) {
+	return NewKernelInit(fs, opts, &fuse.InitIn{
+		Major:        7,
+		Minor:        Minor,
+		MaxReadAhead: 1 << 17,
+		Flags:        ^uint32(0),
+	})
+}
+
+// NewKernelInit is like NewKernel, but sends init as INIT, so tests
+// can pick the protocol version and capabilities.
+func NewKernelInit(fs fuse.RawFileSystem, opts *fuse.MountOptions, init *fuse.InitIn) (*Kernel, error) {
+	// SOCK_SEQPACKET keeps message boundaries, like /dev/fuse.
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fds[1])
+	k := &Kernel{
+		fd:       fds[1],
+		unique:   1,
+		messages: make(chan []byte, 64),
+		served:   make(chan struct{}),
+		read:     make(chan struct{}),
+		quit:     make(chan struct{}),
+		pending:  map[uint64]chan []byte{},
+	}
+
+	// The server reads INIT while it is created, so send it first.
+	if _, err := k.Send(Request(OpInit, 0, init)); err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return nil, err
+	}
+	k.Server, err = fuse.NewServerFd(fs, fds[0], "", opts)
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, err
+	}
+	reply, err := k.readMessage(make([]byte, sizeOfOutHeader+int(unsafe.Sizeof(fuse.InitOut{}))))
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, err
+	}
+	if code := Status(reply); !code.Ok() {
+		syscall.Close(fds[1])
+		return nil, fmt.Errorf("INIT: %v", code)
+	}
+	copy((*[unsafe.Sizeof(fuse.InitOut{})]byte)(unsafe.Pointer(&k.InitOut))[:], reply[sizeOfOutHeader:])
+
+	go func() {
+		k.Server.Serve()
+		close(k.served)
+	}()
+	go k.readLoop()
+	return k, nil
+}
+
+// Request returns a request buffer for opcode on node. in points to
+// the input struct of the opcode, which starts with a fuse.InHeader,
+// and may be nil for opcodes that only take a header. The names
+// follow the struct, each terminated by a NUL. Send fills in the
+// Length and Unique fields of the header.
+func Request(opcode int32, node uint64, in interface{}, names ...string) []byte {
+	var buf []byte
+	if in == nil {
+		buf = make([]byte, sizeOfInHeader)
+	} else {
+		v := reflect.ValueOf(in)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+			panic(fmt.Sprintf("Request: need pointer to struct, got %T", in))
+		}
+		size := int(v.Elem().Type().Size())
+		if size < sizeOfInHeader {
+			panic(fmt.Sprintf("Request: %T does not start with an InHeader", in))
+		}
+		buf = make([]byte, size)
+		copy(buf, (*[1 << 30]byte)(unsafe.Pointer(v.Pointer()))[:size:size])
+	}
+	for _, n := range names {
+		buf = append(buf, n...)
+		buf = append(buf, 0)
+	}
+	h := header(buf)
+	h.Opcode = opcode
+	h.NodeId = node
+	return buf
+}
+
+func header(req []byte) *fuse.InHeader {
+	return (*fuse.InHeader)(unsafe.Pointer(&req[0]))
+}
+
+// Status returns the status of the reply buffer reply.
+func Status(reply []byte) fuse.Status {
+	return fuse.Status(-(*fuse.OutHeader)(unsafe.Pointer(&reply[0])).Status)
+}
+
+// Send writes the request req to the server, without waiting for a
+// reply. It sets the Length and Unique fields of the header of req,
+// and returns the Unique. A reply to req shows up in Messages.
+func (k *Kernel) Send(req []byte) (uint64, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.send(req)
+}
+
+func (k *Kernel) send(req []byte) (uint64, error) {
+	unique := k.unique
+	k.unique++
+	return unique, k.sendUnique(req, unique)
+}
+
+func (k *Kernel) sendUnique(req []byte, unique uint64) error {
+	if k.closed {
+		return syscall.ENOTCONN
+	}
+	h := header(req)
+	h.Length = uint32(len(req))
+	h.Unique = unique
+	_, err := syscall.Write(k.fd, req)
+	return err
+}
+
+// Call writes the request req to the server, as Send does, and
+// returns the reply, which starts with a fuse.OutHeader.
+func (k *Kernel) Call(req []byte) ([]byte, error) {
+	ch := make(chan []byte, 1)
+	k.mu.Lock()
+	unique, err := k.send(req)
+	if err == nil {
+		k.pending[unique] = ch
+	}
+	k.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, ok := <-ch
+	if !ok {
+		return nil, syscall.ENOTCONN
+	}
+	return reply, nil
+}
+
+// Messages returns the messages that the server sent without a
+// Call waiting for them: notifications, which have Unique 0, and
+// replies to requests made with Send. Tests must drain it if they
+// expect more than a few of those, as replies to Call are held up
+// while it is full.
+func (k *Kernel) Messages() <-chan []byte {
+	return k.messages
+}
+
+// Close disconnects the fake kernel, as unmounting does, and waits
+// for the server to stop.
+func (k *Kernel) Close() error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return nil
+	}
+	k.closed = true
+	k.mu.Unlock()
+	close(k.quit)
+
+	// Wakes up our reader, and makes the server see EOF.
+	err := syscall.Shutdown(k.fd, syscall.SHUT_RDWR)
+	<-k.read
+	<-k.served
+	if cerr := syscall.Close(k.fd); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (k *Kernel) readMessage(buf []byte) ([]byte, error) {
+	for {
+		n, err := syscall.Read(k.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, syscall.ENOTCONN
+		}
+		if n < sizeOfOutHeader {
+			return nil, fmt.Errorf("short message: %d bytes", n)
+		}
+		return buf[:n], nil
+	}
+}
+
+func (k *Kernel) readLoop() {
+	defer close(k.read)
+	defer close(k.messages)
+	defer func() {
+		k.mu.Lock()
+		for u, ch := range k.pending {
+			close(ch)
+			delete(k.pending, u)
+		}
+		k.mu.Unlock()
+	}()
+
+	size := k.Server.MaxWrite() + sizeOfOutHeader + 4096
+	for {
+		msg, err := k.readMessage(make([]byte, size))
+		if err != nil {
+			return
+		}
+		unique := (*fuse.OutHeader)(unsafe.Pointer(&msg[0])).Unique
+
+		k.mu.Lock()
+		ch := k.pending[unique]
+		delete(k.pending, unique)
+		k.mu.Unlock()
+		if unique != 0 && ch != nil {
+			ch <- msg
+		} else {
+			select {
+			case k.messages <- msg:
+			case <-k.quit:
+				return
+			}
+		}
+	}
+}
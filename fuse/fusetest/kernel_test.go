@@ -0,0 +1,121 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fusetest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+func setupLoopback(t *testing.T) (string, *pathfs.PathNodeFs, *nodefs.FileSystemConnector, *Kernel) {
+	dir, err := ioutil.TempDir("", "fusetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(dir), nil)
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	k, err := NewKernel(conn.RawFS(), nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewKernel: %v", err)
+	}
+	return dir, pfs, conn, k
+}
+
+func TestKernelOps(t *testing.T) {
+	dir, _, _, k := setupLoopback(t)
+	defer os.RemoveAll(dir)
+	defer k.Close()
+
+	if k.InitOut.Minor != Minor {
+		t.Errorf("got minor %d, want %d", k.InitOut.Minor, Minor)
+	}
+
+	entry, code, err := k.Lookup(fuse.FUSE_ROOT_ID, "a")
+	if err != nil || !code.Ok() {
+		t.Fatalf("Lookup: %v, %v", code, err)
+	}
+	attr, code, err := k.GetAttr(entry.NodeId)
+	if err != nil || !code.Ok() {
+		t.Fatalf("GetAttr: %v, %v", code, err)
+	}
+	if attr.Mode&syscall.S_IFMT != syscall.S_IFREG || attr.Size != 5 {
+		t.Errorf("got mode %o size %d, want a 5 byte file", attr.Mode, attr.Size)
+	}
+
+	if code, err := k.Rename(fuse.FUSE_ROOT_ID, "a", fuse.FUSE_ROOT_ID, "b"); err != nil || !code.Ok() {
+		t.Fatalf("Rename: %v, %v", code, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "b")); err != nil {
+		t.Errorf("Lstat after rename: %v", err)
+	}
+	if _, code, _ := k.Lookup(fuse.FUSE_ROOT_ID, "a"); code != fuse.ENOENT {
+		t.Errorf("Lookup of old name: got %v, want ENOENT", code)
+	}
+
+	// A raw request gets the raw reply.
+	reply, err := k.Call(Request(OpLookup, fuse.FUSE_ROOT_ID, nil, "b"))
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if code := Status(reply); !code.Ok() {
+		t.Fatalf("LOOKUP b: %v", code)
+	}
+	if len(reply) < sizeOfOutHeader {
+		t.Fatalf("short reply: %d bytes", len(reply))
+	}
+
+	// FORGET has no reply; the next reply is for the next call.
+	if err := k.Forget(entry.NodeId, 2); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if _, code, err := k.GetAttr(fuse.FUSE_ROOT_ID); err != nil || !code.Ok() {
+		t.Fatalf("GetAttr root: %v, %v", code, err)
+	}
+	select {
+	case msg := <-k.Messages():
+		t.Errorf("unexpected message %v", msg)
+	default:
+	}
+}
+
+func TestKernelNotify(t *testing.T) {
+	dir, pfs, conn, k := setupLoopback(t)
+	defer os.RemoveAll(dir)
+	defer k.Close()
+
+	if code := conn.EntryNotify(pfs.Root().Inode(), "a"); !code.Ok() {
+		t.Fatalf("EntryNotify: %v", code)
+	}
+	msg := <-k.Messages()
+	if code := Status(msg); code != fuse.NOTIFY_INVAL_ENTRY {
+		t.Errorf("got notify code %v, want NOTIFY_INVAL_ENTRY", code)
+	}
+	if got, want := string(msg[len(msg)-2:]), "a\x00"; got != want {
+		t.Errorf("got name %q, want %q", got, want)
+	}
+}
+
+func TestKernelClose(t *testing.T) {
+	dir, _, _, k := setupLoopback(t)
+	defer os.RemoveAll(dir)
+
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, _, err := k.GetAttr(fuse.FUSE_ROOT_ID); err != syscall.ENOTCONN {
+		t.Errorf("GetAttr after Close: got %v, want ENOTCONN", err)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fusetest
+
+import (
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// reply calls req, and copies the reply data into out, which points
+// to a struct of size bytes, if the call succeeded.
+func (k *Kernel) reply(req []byte, out unsafe.Pointer, size uintptr) (fuse.Status, error) {
+	reply, err := k.Call(req)
+	if err != nil {
+		return fuse.EIO, err
+	}
+	code := Status(reply)
+	if code.Ok() && out != nil {
+		copy((*[1 << 30]byte)(out)[:size:size], reply[sizeOfOutHeader:])
+	}
+	return code, nil
+}
+
+// Lookup sends LOOKUP for name in the directory parent.
+func (k *Kernel) Lookup(parent uint64, name string) (*fuse.EntryOut, fuse.Status, error) {
+	out := &fuse.EntryOut{}
+	code, err := k.reply(Request(OpLookup, parent, nil, name), unsafe.Pointer(out), unsafe.Sizeof(*out))
+	return out, code, err
+}
+
+// Forget sends FORGET for nlookup lookups of node. The server does
+// not reply.
+func (k *Kernel) Forget(node, nlookup uint64) error {
+	_, err := k.Send(Request(OpForget, node, &fuse.ForgetIn{Nlookup: nlookup}))
+	return err
+}
+
+// GetAttr sends GETATTR for node.
+func (k *Kernel) GetAttr(node uint64) (*fuse.AttrOut, fuse.Status, error) {
+	out := &fuse.AttrOut{}
+	code, err := k.reply(Request(OpGetAttr, node, &fuse.GetAttrIn{}), unsafe.Pointer(out), unsafe.Sizeof(*out))
+	return out, code, err
+}
+
+// Rename sends RENAME of oldName in the directory oldParent to
+// newName in newParent.
+func (k *Kernel) Rename(oldParent uint64, oldName string, newParent uint64, newName string) (fuse.Status, error) {
+	return k.reply(Request(OpRename, oldParent, &fuse.Rename1In{Newdir: newParent}, oldName, newName), nil, 0)
+}
+
+// Unlink sends UNLINK for name in the directory parent.
+func (k *Kernel) Unlink(parent uint64, name string) (fuse.Status, error) {
+	return k.reply(Request(OpUnlink, parent, nil, name), nil, 0)
+}
+
+// Create sends CREATE for name in the directory parent.
+func (k *Kernel) Create(parent uint64, name string, flags, mode uint32) (*fuse.CreateOut, fuse.Status, error) {
+	out := &fuse.CreateOut{}
+	code, err := k.reply(Request(OpCreate, parent, &fuse.CreateIn{Flags: flags, Mode: mode}, name), unsafe.Pointer(out), unsafe.Sizeof(*out))
+	return out, code, err
+}
+
+// Release sends RELEASE for the file handle fh of node.
+func (k *Kernel) Release(node, fh uint64) (fuse.Status, error) {
+	return k.reply(Request(OpRelease, node, &fuse.ReleaseIn{Fh: fh}), nil, 0)
+}
+
+// NotifyReply answers the NOTIFY_RETRIEVE with the given
+// NotifyUnique, returning data as the cached content of node at
+// offset. The server does not reply.
+func (k *Kernel) NotifyReply(unique, node, offset uint64, data []byte) error {
+	req := Request(OpNotifyReply, node, &fuse.NotifyRetrieveIn{Offset: offset, Size: uint32(len(data))})
+	req = append(req, data...)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.sendUnique(req, unique)
+}
@@ -23,8 +23,8 @@ func (code Status) String() string {
 			"NOTIFY_POLL",
 			"NOTIFY_INVAL_INODE",
 			"NOTIFY_INVAL_ENTRY",
-			"NOTIFY_INVAL_STORE",
-			"NOTIFY_INVAL_RETRIEVE",
+			"NOTIFY_STORE",
+			"NOTIFY_RETRIEVE",
 			"NOTIFY_INVAL_DELETE",
 		}[-code]
 	}
@@ -74,6 +74,24 @@ func toSlice(dest *[]byte, ptr unsafe.Pointer, byteCount uintptr) {
 	}
 }
 
+// Retry sets up out to ask the kernel to restart an unrestricted
+// ioctl. On the retried call, the input holds the contents of the in
+// buffers, and the output is copied to the outs buffers. It returns
+// the data to send along with out.
+func (out *IoctlOut) Retry(in, outs []IoctlIovec) []byte {
+	iovs := append(append([]IoctlIovec{}, in...), outs...)
+	out.Flags |= FUSE_IOCTL_RETRY
+	out.InIovs = uint32(len(in))
+	out.OutIovs = uint32(len(outs))
+	if len(iovs) == 0 {
+		return nil
+	}
+
+	var data []byte
+	toSlice(&data, unsafe.Pointer(&iovs[0]), uintptr(len(iovs))*unsafe.Sizeof(IoctlIovec{}))
+	return data
+}
+
 func CurrentOwner() *Owner {
 	return &Owner{
 		Uid: uint32(os.Getuid()),
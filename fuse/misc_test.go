@@ -8,6 +8,7 @@ import (
 	"os"
 	"syscall"
 	"testing"
+	"time"
 )
 
 func TestToStatus(t *testing.T) {
@@ -28,3 +29,26 @@ func TestToStatus(t *testing.T) {
 		t.Errorf("Wrong conversion %v != %v", errNo, syscall.ENOENT)
 	}
 }
+
+// fileInfo is an os.FileInfo that does not come from stat(2).
+type fileInfo struct {
+	os.FileInfo
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) Size() int64        { return 5 }
+func (fi *fileInfo) ModTime() time.Time { return fi.mtime }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func TestToAttrFileInfo(t *testing.T) {
+	mtime := time.Unix(1500000000, 123456789)
+	a := ToAttr(&fileInfo{mode: os.ModeDir | 0755, mtime: mtime})
+	if a.Mode != syscall.S_IFDIR|0755 || a.Size != 5 {
+		t.Errorf("got mode %o size %d, want %o 5", a.Mode, a.Size, syscall.S_IFDIR|0755)
+	}
+	if !a.ModTime().Equal(mtime) || !a.ChangeTime().Equal(mtime) {
+		t.Errorf("got mtime %v ctime %v, want %v", a.ModTime(), a.ChangeTime(), mtime)
+	}
+}
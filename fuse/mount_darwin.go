@@ -52,40 +52,108 @@ const newLoadBin = "/Library/Filesystems/osxfuse.fs/Contents/Resources/load_osxf
 const oldMountBin = "/Library/Filesystems/osxfusefs.fs/Support/mount_osxfusefs"
 const newMountBin = "/Library/Filesystems/osxfuse.fs/Contents/Resources/mount_osxfuse"
 
-func mount(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, err error) {
-	f, err := openFUSEDevice()
-	if err != nil {
-		return 0, err
+// macFUSE 4 and later. Its mount helper opens the device itself, and
+// passes it back like fusermount does.
+const macfuseMountBin = "/Library/Filesystems/macfuse.fs/Contents/Resources/mount_macfuse"
+
+// nameOption is the mount option that MountOptions.Name sets.
+const nameOption = "volname"
+
+// mountOptionNames is nil, as the options of mount_osxfusefs vary
+// between versions. Unknown options are left for it to reject.
+var mountOptionNames map[string]bool
+
+func mount(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, keep *os.File, err error) {
+	if _, err := os.Stat(macfuseMountBin); err == nil {
+		fd, err = mountMacfuse(mountPoint, opts, ready)
+	} else {
+		fd, err = mountOsxfuse(mountPoint, opts, ready)
 	}
+	return fd, nil, err
+}
 
-	bin := oldMountBin
-	if _, err := os.Stat(newMountBin); err == nil {
-		bin = newMountBin
+// mountArgs returns the arguments for the mount helper, before the
+// mount point.
+func mountArgs(opts *MountOptions) []string {
+	args := []string{"-o", fmt.Sprintf("iosize=%d", opts.MaxWrite)}
+	if s := opts.optionsStrings(); len(s) > 0 {
+		args = append(args, "-o", strings.Join(s, ","))
 	}
+	return args
+}
 
-	cmd := exec.Command(bin, "-o", strings.Join(opts.optionsStrings(), ","), "-o", fmt.Sprintf("iosize=%d", opts.MaxWrite), "3", mountPoint)
-	cmd.ExtraFiles = []*os.File{f}
-	cmd.Env = append(os.Environ(), "MOUNT_FUSEFS_CALL_BY_LIB=", "MOUNT_OSXFUSE_CALL_BY_LIB=",
-		"MOUNT_OSXFUSE_DAEMON_PATH="+os.Args[0],
-		"MOUNT_FUSEFS_DAEMON_PATH="+os.Args[0])
-
+// runMountHelper starts cmd, and reports its result on ready once it
+// exits. The helper finishes the mount after we answer INIT.
+func runMountHelper(cmd *exec.Cmd, ready chan<- error) error {
 	var out, errOut bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &errOut
-
 	if err := cmd.Start(); err != nil {
-		f.Close()
-		return 0, err
+		return err
 	}
 	go func() {
 		err := cmd.Wait()
 		if err != nil {
-			err = fmt.Errorf("mount_osxfusefs failed: %v. Stderr: %s, Stdout: %s", err, errOut.String(), out.String())
+			err = fmt.Errorf("%s failed: %v. Stderr: %s, Stdout: %s", filepath.Base(cmd.Path), err, errOut.String(), out.String())
 		}
 
 		ready <- err
 		close(ready)
 	}()
+	return nil
+}
+
+// mountMacfuse mounts with mount_macfuse, which sends the device
+// descriptor over the socket in _FUSE_COMMFD.
+func mountMacfuse(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, err error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return -1, os.NewSyscallError("socketpair", err)
+	}
+	local := os.NewFile(uintptr(fds[0]), "socketpair-half1")
+	remote := os.NewFile(uintptr(fds[1]), "socketpair-half2")
+	defer local.Close()
+	defer remote.Close()
+	syscall.CloseOnExec(fds[0])
+
+	cmd := exec.Command(macfuseMountBin, append(mountArgs(opts), mountPoint)...)
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Env = append(os.Environ(), "_FUSE_CALL_BY_LIB=", "_FUSE_COMMFD=3", "_FUSE_COMMVERS=2",
+		"_FUSE_DAEMON_PATH="+os.Args[0])
+	if err := runMountHelper(cmd, ready); err != nil {
+		return -1, err
+	}
+
+	fd, err = getConnection(local)
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(fd)
+	return fd, nil
+}
+
+// mountOsxfuse mounts with the mount helper of OSXFUSE 3 and
+// earlier, which is passed the opened device.
+func mountOsxfuse(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, err error) {
+	f, err := openFUSEDevice()
+	if err != nil {
+		return 0, err
+	}
+
+	bin := oldMountBin
+	if _, err := os.Stat(newMountBin); err == nil {
+		bin = newMountBin
+	}
+
+	cmd := exec.Command(bin, append(mountArgs(opts), "3", mountPoint)...)
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(), "MOUNT_FUSEFS_CALL_BY_LIB=", "MOUNT_OSXFUSE_CALL_BY_LIB=",
+		"MOUNT_OSXFUSE_DAEMON_PATH="+os.Args[0],
+		"MOUNT_FUSEFS_DAEMON_PATH="+os.Args[0])
+	if err := runMountHelper(cmd, ready); err != nil {
+		f.Close()
+		return 0, err
+	}
 
 	// The finalizer for f will close its fd so we return a dup.
 	defer f.Close()
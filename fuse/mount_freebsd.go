@@ -0,0 +1,77 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// nameOption is the mount option that MountOptions.Name sets.
+const nameOption = "subtype"
+
+// mountOptionNames is nil: mount_fusefs takes the options of
+// mount(8) as well as its own, and rejects unknown ones itself.
+var mountOptionNames map[string]bool
+
+// mount opens /dev/fuse and has mount_fusefs mount it, passing the
+// device as descriptor 3. mount_fusefs exits once the mount is
+// done; the kernel then sends INIT.
+func mount(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, keep *os.File, err error) {
+	f, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+	// The finalizer for f will close its fd so we return a dup.
+	defer f.Close()
+
+	bin, err := lookPathFallback("mount_fusefs", "/sbin")
+	if err != nil {
+		return -1, nil, err
+	}
+	var args []string
+	if s := opts.optionsStrings(); len(s) > 0 {
+		args = append(args, "-o", strings.Join(s, ","))
+	}
+	cmd := exec.Command(bin, append(args, "3", mountPoint)...)
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(), "MOUNT_FUSEFS_SAFE=1", "MOUNT_FUSEFS_CALL_BY_LIB=1")
+
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	if err := cmd.Run(); err != nil {
+		return -1, nil, fmt.Errorf("mount_fusefs failed: %v. Stderr: %s, Stdout: %s", err, errOut.String(), out.String())
+	}
+
+	fd, err = syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return -1, nil, err
+	}
+	syscall.CloseOnExec(fd)
+	close(ready)
+	return fd, nil, nil
+}
+
+func unmount(mountPoint string) error {
+	bin, err := lookPathFallback("umount", "/sbin")
+	if err != nil {
+		return err
+	}
+	errBuf := bytes.Buffer{}
+	cmd := exec.Command(bin, mountPoint)
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		if errBuf.Len() > 0 {
+			return fmt.Errorf("%s (code %v)", errBuf.String(), err)
+		}
+		return err
+	}
+	return nil
+}
@@ -9,14 +9,47 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path"
 	"strings"
 	"syscall"
-	"unsafe"
 )
 
+// nameOption is the mount option that MountOptions.Name sets.
+const nameOption = "subtype"
+
+// mountOptionNames are the options accepted by fusermount, either
+// as a flag for mount(2) or as an option for the kernel.
+var mountOptionNames = map[string]bool{
+	"rw": true, "ro": true,
+	"suid": true, "nosuid": true,
+	"dev": true, "nodev": true,
+	"exec": true, "noexec": true,
+	"sync": true, "async": true,
+	"atime": true, "noatime": true,
+	"nodiratime": true, "relatime": true, "norelatime": true,
+	"strictatime": true, "nostrictatime": true,
+	"lazytime": true, "nolazytime": true,
+	"dirsync": true,
+
+	"default_permissions": true,
+	"allow_other":         true,
+	"allow_root":          true,
+	"auto_unmount":        true,
+	"nonempty":            true,
+	"blkdev":              true,
+	"blksize":             true,
+	"max_read":            true,
+	"fsname":              true,
+	"subtype":             true,
+	"context":             true,
+	"fscontext":           true,
+	"defcontext":          true,
+	"rootcontext":         true,
+}
+
 func unixgramSocketpair() (l, r *os.File, err error) {
-	fd, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	// CLOEXEC, so fusermount does not inherit our end: with
+	// auto_unmount, it waits for that end to be closed.
+	fd, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return nil, nil, os.NewSyscallError("socketpair",
 			err.(syscall.Errno))
@@ -27,23 +60,33 @@ func unixgramSocketpair() (l, r *os.File, err error) {
 }
 
 // Create a FUSE FS on the specified mount point.  The returned
-// mount point is always absolute.
-func mount(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, err error) {
+// mount point is always absolute. With AutoUnmount, keep is our end
+// of the socket to fusermount, which must stay open for as long as
+// the file system is served.
+func mount(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, keep *os.File, err error) {
 	local, remote, err := unixgramSocketpair()
 	if err != nil {
 		return
 	}
 
-	defer local.Close()
+	defer func() {
+		if keep == nil {
+			local.Close()
+		}
+	}()
 	defer remote.Close()
 
 	bin, err := fusermountBinary()
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
 	cmd := []string{bin, mountPoint}
-	if s := opts.optionsStrings(); len(s) > 0 {
+	s := opts.optionsStrings()
+	if opts.AutoUnmount {
+		s = append(s, "auto_unmount")
+	}
+	if len(s) > 0 {
 		cmd = append(cmd, "-o", strings.Join(s, ","))
 	}
 	proc, err := os.StartProcess(bin,
@@ -56,18 +99,30 @@ func mount(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, e
 		return
 	}
 
-	w, err := proc.Wait()
-	if err != nil {
-		return
-	}
-	if !w.Success() {
-		err = fmt.Errorf("fusermount exited with code %v\n", w.Sys())
-		return
+	if opts.AutoUnmount {
+		// fusermount stays around until its end of the
+		// socket is closed, which happens when we exit. If it
+		// fails, closing our copy of remote makes
+		// getConnection see EOF.
+		remote.Close()
+		go proc.Wait()
+	} else {
+		w, err := proc.Wait()
+		if err != nil {
+			return 0, nil, err
+		}
+		if !w.Success() {
+			return 0, nil, fmt.Errorf("fusermount exited with code %v\n", w.Sys())
+		}
 	}
 
 	fd, err = getConnection(local)
 	if err != nil {
-		return -1, err
+		return -1, nil, err
+	}
+
+	if opts.AutoUnmount {
+		keep = local
 	}
 
 	// golang sets CLOEXEC on file descriptors when they are
@@ -76,7 +131,7 @@ func mount(mountPoint string, opts *MountOptions, ready chan<- error) (fd int, e
 	syscall.CloseOnExec(fd)
 
 	close(ready)
-	return fd, err
+	return fd, keep, err
 }
 
 func unmount(mountPoint string) (err error) {
@@ -98,45 +153,6 @@ func unmount(mountPoint string) (err error) {
 	return err
 }
 
-func getConnection(local *os.File) (int, error) {
-	var data [4]byte
-	control := make([]byte, 4*256)
-
-	// n, oobn, recvflags, from, errno  - todo: error checking.
-	_, oobn, _, _,
-		err := syscall.Recvmsg(
-		int(local.Fd()), data[:], control[:], 0)
-	if err != nil {
-		return 0, err
-	}
-
-	message := *(*syscall.Cmsghdr)(unsafe.Pointer(&control[0]))
-	fd := *(*int32)(unsafe.Pointer(uintptr(unsafe.Pointer(&control[0])) + syscall.SizeofCmsghdr))
-
-	if message.Type != 1 {
-		return 0, fmt.Errorf("getConnection: recvmsg returned wrong control type: %d", message.Type)
-	}
-	if oobn <= syscall.SizeofCmsghdr {
-		return 0, fmt.Errorf("getConnection: too short control message. Length: %d", oobn)
-	}
-	if fd < 0 {
-		return 0, fmt.Errorf("getConnection: fd < 0: %d", fd)
-	}
-	return int(fd), nil
-}
-
-// lookPathFallback - search binary in PATH and, if that fails,
-// in fallbackDir. This is useful if PATH is possible empty.
-func lookPathFallback(file string, fallbackDir string) (string, error) {
-	binPath, err := exec.LookPath(file)
-	if err == nil {
-		return binPath, nil
-	}
-
-	abs := path.Join(fallbackDir, file)
-	return exec.LookPath(abs)
-}
-
 func fusermountBinary() (string, error) {
 	return lookPathFallback("fusermount", "/bin")
 }
@@ -0,0 +1,112 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mount options that cannot be given together.
+var mountOptionOpposites = map[string]string{
+	"ro":      "rw",
+	"rw":      "ro",
+	"suid":    "nosuid",
+	"nosuid":  "suid",
+	"dev":     "nodev",
+	"nodev":   "dev",
+	"exec":    "noexec",
+	"noexec":  "exec",
+	"sync":    "async",
+	"async":   "sync",
+	"atime":   "noatime",
+	"noatime": "atime",
+
+	"allow_other": "allow_root",
+	"allow_root":  "allow_other",
+}
+
+// String returns the options as passed to the mount helper with -o.
+func (o *MountOptions) String() string {
+	return strings.Join(o.optionsStrings(), ",")
+}
+
+func (o *MountOptions) optionsStrings() []string {
+	var r []string
+	r = append(r, o.Options...)
+
+	if o.AllowOther {
+		r = append(r, "allow_other")
+	}
+	if o.AllowRoot {
+		r = append(r, "allow_root")
+	}
+	if o.DefaultPermissions {
+		r = append(r, "default_permissions")
+	}
+
+	if o.MaxRead > 0 {
+		r = append(r, fmt.Sprintf("max_read=%d", o.MaxRead))
+	}
+	if o.FsName != "" {
+		r = append(r, "fsname="+o.FsName)
+	}
+	if o.Name != "" {
+		r = append(r, nameOption+"="+o.Name)
+	}
+
+	return r
+}
+
+// optionName returns the part of a mount option before the '='.
+func optionName(s string) string {
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// hasOption returns true if Options contains the named option.
+func (o *MountOptions) hasOption(name string) bool {
+	for _, s := range o.Options {
+		if optionName(s) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error for options that the mount helper would
+// reject, so we fail before trying to mount.
+func (o *MountOptions) check() error {
+	if o.AllowOther && o.AllowRoot {
+		return fmt.Errorf("AllowOther and AllowRoot are mutually exclusive")
+	}
+	if o.MaxRead < 0 {
+		return fmt.Errorf("negative MaxRead %d", o.MaxRead)
+	}
+
+	seen := map[string]string{}
+	for _, s := range o.optionsStrings() {
+		if s == "" {
+			return fmt.Errorf("found empty option string")
+		}
+		if strings.Contains(s, ",") {
+			return fmt.Errorf("found ',' in option string %q", s)
+		}
+		name := optionName(s)
+		if mountOptionNames != nil && !mountOptionNames[name] {
+			return fmt.Errorf("unknown mount option %q", s)
+		}
+		if prev, ok := seen[name]; ok && prev != s {
+			return fmt.Errorf("mount option %q conflicts with %q", s, prev)
+		}
+		if prev, ok := seen[mountOptionOpposites[name]]; ok {
+			return fmt.Errorf("mount option %q conflicts with %q", s, prev)
+		}
+		seen[name] = s
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"testing"
+)
+
+func TestMountOptionsString(t *testing.T) {
+	o := MountOptions{
+		Options:    []string{"ro"},
+		AllowOther: true,
+		MaxRead:    4096,
+		FsName:     "src",
+		Name:       "myfs",
+	}
+	want := "ro,allow_other,max_read=4096,fsname=src,subtype=myfs"
+	if got := o.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMountOptionsCheck(t *testing.T) {
+	for _, o := range []MountOptions{
+		{},
+		{Options: []string{"ro", "noexec", "ro"}},
+		{Options: []string{"subtype=myfs"}},
+		{Options: []string{"allow_other"}, AllowOther: true},
+	} {
+		if err := o.check(); err != nil {
+			t.Errorf("%q: %v", o.String(), err)
+		}
+	}
+
+	for _, o := range []MountOptions{
+		{Options: []string{"frobnicate"}},
+		{Options: []string{""}},
+		{Options: []string{"ro", "rw"}},
+		{Options: []string{"fsname=a"}, FsName: "b"},
+		{Options: []string{"allow_root"}, AllowOther: true},
+		{AllowOther: true, AllowRoot: true},
+		{FsName: "a,b"},
+		{MaxRead: -1},
+	} {
+		if err := o.check(); err == nil {
+			t.Errorf("%q: check succeeded", o.String())
+		}
+	}
+}
@@ -10,6 +10,7 @@
 package nodefs
 
 import (
+	"log"
 	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
@@ -58,14 +59,19 @@ type Node interface {
 	// This is called from within a treeLock critical section.
 	OnForget()
 
-	// Misc.
+	// Access is called for access(2) and chdir(2), unless the
+	// mount uses default_permissions. The mode holds R_OK, W_OK
+	// and X_OK bits, and the context the caller.
 	Access(mode uint32, context *fuse.Context) (code fuse.Status)
 	Readlink(c *fuse.Context) ([]byte, fuse.Status)
 
 	// Namespace operations; these are only called on directory Nodes.
 
 	// Mknod should create the node, add it to the receiver's
-	// inode, and return it
+	// inode, and return it. The mode includes the file type, eg.
+	// S_IFIFO, S_IFSOCK, S_IFCHR or S_IFBLK, and dev is the device
+	// number for the latter two. The file type of the new Inode
+	// is taken from its GetAttr.
 	Mknod(name string, mode uint32, dev uint32, context *fuse.Context) (newNode *Inode, code fuse.Status)
 
 	// Mkdir should create the directory Inode, add it to the
@@ -85,15 +91,28 @@ type Node interface {
 	// 'existing'.
 	Link(name string, existing Node, context *fuse.Context) (newNode *Inode, code fuse.Status)
 
-	// Create should return an open file, and the Inode for that file.
+	// Create should return an open file, and the Inode for that
+	// file. Both go to the kernel in one reply, so open(O_CREAT)
+	// needs no separate Lookup or Open. As with Open, the file
+	// may be nil. The kernel may send Create for a name that
+	// exists. If flags has O_EXCL, Create must then fail with
+	// EEXIST, and it must check and claim the name atomically.
 	Create(name string, flags uint32, mode uint32, context *fuse.Context) (file File, child *Inode, code fuse.Status)
 
 	// Open opens a file, and returns a File which is associated
-	// with a file handle. It is OK to return (nil, OK) here. In
+	// with a file handle. Each Open gets its own handle, and the
+	// File is used for all later operations on that handle, up to
+	// and including Release. It is OK to return (nil, OK) here. In
 	// that case, the Node should implement Read or Write
-	// directly.
+	// directly. The flags only include O_TRUNC if
+	// fuse.MountOptions.AtomicTruncate is set.
 	Open(flags uint32, context *fuse.Context) (file File, code fuse.Status)
 	OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status)
+
+	// FsyncDir is called for fsync(2) on a directory, eg. to make
+	// renames durable. Bit 0 of flags is set for fdatasync(2).
+	FsyncDir(flags uint32, context *fuse.Context) (code fuse.Status)
+
 	Read(file File, dest []byte, off int64, context *fuse.Context) (fuse.ReadResult, fuse.Status)
 	Write(file File, data []byte, off int64, context *fuse.Context) (written uint32, code fuse.Status)
 
@@ -103,7 +122,9 @@ type Node interface {
 	SetXAttr(attr string, data []byte, flags int, context *fuse.Context) fuse.Status
 	ListXAttr(context *fuse.Context) (attrs []string, code fuse.Status)
 
-	// Attributes
+	// Attributes. file is the open file that the request came
+	// with, as for ftruncate(2), or nil. Only the file may still
+	// reach an unlinked inode.
 	GetAttr(out *fuse.Attr, file File, context *fuse.Context) (code fuse.Status)
 	Chmod(file File, perms uint32, context *fuse.Context) (code fuse.Status)
 	Chown(file File, uid uint32, gid uint32, context *fuse.Context) (code fuse.Status)
@@ -111,7 +132,117 @@ type Node interface {
 	Utimens(file File, atime *time.Time, mtime *time.Time, context *fuse.Context) (code fuse.Status)
 	Fallocate(file File, off uint64, size uint64, mode uint32, context *fuse.Context) (code fuse.Status)
 
+	// File locking, see File. SetLkw may block; it should
+	// return EINTR once context.Cancel is closed.
+	GetLk(file File, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock, context *fuse.Context) (code fuse.Status)
+	SetLk(file File, owner uint64, lk *fuse.FileLock, flags uint32, context *fuse.Context) (code fuse.Status)
+	SetLkw(file File, owner uint64, lk *fuse.FileLock, flags uint32, context *fuse.Context) (code fuse.Status)
+
 	StatFs() *fuse.StatfsOut
+
+	// Bmap maps block, counted in units of blocksize, to a block
+	// of the device backing the file system. It is only used for
+	// fuseblk mounts.
+	Bmap(block uint64, blocksize uint32, context *fuse.Context) (uint64, fuse.Status)
+}
+
+// NegativeTimeoutNode is an additional interface that directory
+// Nodes can implement. When Lookup of a name returns ENOENT, the
+// kernel may cache its absence for the returned timeout. If ok is
+// false, Options.NegativeTimeout is used.
+type NegativeTimeoutNode interface {
+	NegativeTimeout(name string) (timeout time.Duration, ok bool)
+}
+
+// AttrTimeoutNode is an additional interface for Nodes whose
+// attributes change more or less often than others. The returned
+// timeout is used for GetAttr and Lookup replies on the node. If ok
+// is false, Options.AttrTimeout is used.
+type AttrTimeoutNode interface {
+	AttrTimeout() (timeout time.Duration, ok bool)
+}
+
+// InoNode is an additional interface for Nodes with a stable inode
+// number, for example derived from the identity of the object in the
+// backend. The connector reports it as st_ino, also in directory
+// listings of known children, rather than the node ID, which is
+// allocated per mount and changes across remounts. The node ID and
+// its generation, which tells apart reused node IDs, stay internal to
+// the connector. If Ino returns 0, the node ID is reported.
+type InoNode interface {
+	Ino() uint64
+}
+
+// SetAttrNode is an additional interface for Nodes that change
+// attributes in one call, eg. to apply them atomically. When it is
+// implemented, SETATTR calls SetAttr instead of Chmod, Chown,
+// Truncate and Utimens. Only the attributes whose fuse.FATTR_* bits
+// are set in input.Valid should change. fuse.FATTR_ATIME_NOW and
+// fuse.FATTR_MTIME_NOW ask for the current time rather than the
+// timestamp in input. file is nil unless fuse.FATTR_FH is set.
+type SetAttrNode interface {
+	SetAttr(file File, input *fuse.SetAttrIn, context *fuse.Context) fuse.Status
+}
+
+// DirStream lists a directory one entry at a time.
+type DirStream interface {
+	// HasNext reports whether there are further entries.
+	HasNext() bool
+
+	// Next returns the next entry. It is only called after
+	// HasNext returned true.
+	Next() (fuse.DirEntry, fuse.Status)
+
+	// Close is called when the directory is closed, or when it
+	// is reopened for a seek backwards.
+	Close()
+}
+
+// Rename2Node is an additional interface that Nodes can implement to
+// support the flags of renameat2(2). Rename2 is called for renames
+// with flags instead of Rename, and should return EINVAL for flags it
+// does not support. Without it, the connector emulates
+// fuse.RENAME_NOREPLACE by looking up the destination before calling
+// Rename, and fails other flags with EINVAL. The emulation is atomic
+// with respect to other requests through the connector, which cannot
+// add entries to the destination directory in between, but not
+// against changes made to the backing store directly; Nodes that can
+// rename atomically there should implement Rename2.
+// Implementations are responsible for updating the Inode tree, eg.
+// with Inode.ExchangeChild for fuse.RENAME_EXCHANGE.
+type Rename2Node interface {
+	Rename2(oldName string, newParent Node, newName string, flags uint32, context *fuse.Context) fuse.Status
+}
+
+// TmpfileNode is an additional interface for directory Nodes that
+// support open(2) with O_TMPFILE. Tmpfile creates a file like
+// Create, but without a name: the connector gives node an Inode
+// that is not in the tree, and that goes away once the kernel forgets
+// it. linkat(2) may give it a name later, through Link on the new
+// parent.
+type TmpfileNode interface {
+	Tmpfile(flags uint32, mode uint32, context *fuse.Context) (file File, node Node, code fuse.Status)
+}
+
+// DirStreamNode is an additional interface that directory Nodes can
+// implement to list their entries incrementally, rather than all at
+// once from OpenDir. The connector takes entries as they fit into the
+// kernel's READDIR buffers. OpenDirStream is called again on
+// rewinddir() and other seeks backwards.
+type DirStreamNode interface {
+	OpenDirStream(context *fuse.Context) (DirStream, fuse.Status)
+}
+
+// CacheDirNode is an additional interface for directory Nodes whose
+// listings the kernel may cache. CacheDir is called on each OpenDir.
+// If cache is true, the kernel keeps the READDIR results of the open
+// directory (FOPEN_CACHE_DIR) and serves rewinds from them; if keep
+// is also true, later opens use them too (FOPEN_KEEP_CACHE), until
+// the directory is invalidated with FileSystemConnector.FileNotify.
+// Without CacheDirNode, listings are not cached, and each one reads
+// the directory again. This needs protocol version 7.28.
+type CacheDirNode interface {
+	CacheDir() (cache, keep bool)
 }
 
 // A File object is returned from FileSystem.Open and
@@ -128,17 +259,30 @@ type File interface {
 	// the inner file here.
 	InnerFile() File
 
+	// Read may fill dest, or return fuse.ReadResultFd for files
+	// backed by a file descriptor, so the data can be spliced.
 	Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status)
 	Write(data []byte, off int64) (written uint32, code fuse.Status)
 
+	// Flock is called for flock(2) locks if
+	// fuse.MountOptions.EnableLocks is set. The flags are as for
+	// flock(2); the lock belongs to the open file.
 	Flock(flags int) fuse.Status
 
+	// POSIX file locks, see fcntl(2). The owner identifies the
+	// lock holder on the kernel side. These are only called if
+	// fuse.MountOptions.EnableLocks is set; SetLkw should block
+	// until the lock is acquired.
+	GetLk(owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (code fuse.Status)
+	SetLk(owner uint64, lk *fuse.FileLock, flags uint32) (code fuse.Status)
+	SetLkw(owner uint64, lk *fuse.FileLock, flags uint32) (code fuse.Status)
+
 	// Flush is called for close() call on a file descriptor. In
 	// case of duplicated descriptor, it may be called more than
-	// once for a file.
+	// once for a file. An error status is returned from close().
 	Flush() fuse.Status
 
-	// This is called to before the file handle is forgotten. This
+	// This is called once, before the file handle is forgotten. This
 	// method has no return value, so nothing can synchronizes on
 	// the call. Any cleanup that requires specific synchronization or
 	// could fail with I/O errors should happen in Flush instead.
@@ -153,6 +297,57 @@ type File interface {
 	Chmod(perms uint32) fuse.Status
 	Utimens(atime *time.Time, mtime *time.Time) fuse.Status
 	Allocate(off uint64, size uint64, mode uint32) (code fuse.Status)
+
+	// Lseek returns the offset of the next data (SEEK_DATA) or
+	// hole (SEEK_HOLE) at or after off. Other whence values are
+	// handled by the kernel.
+	Lseek(off int64, whence uint32) (int64, fuse.Status)
+
+	// CopyFileRange copies len bytes at off to dest at destOff,
+	// for copy_file_range(2). Return EXDEV if the copy cannot be
+	// done for this pair of files, so the kernel copies the data
+	// itself.
+	CopyFileRange(off int64, dest File, destOff int64, len uint64, flags uint64) (written uint32, code fuse.Status)
+
+	// Poll returns which of the poll(2) events are ready. It is
+	// only called if fuse.MountOptions.EnablePoll is set. If
+	// flags has fuse.FUSE_POLL_SCHEDULE_NOTIFY, call
+	// FileSystemConnector.NotifyPoll with kh when the file
+	// becomes ready.
+	Poll(kh uint64, flags uint32, events uint32) (revents uint32, code fuse.Status)
+
+	// Ioctl serves ioctl(2) on the file. The input holds the
+	// bytes the kernel copied in for cmd; output is copied back
+	// to the caller, and result is the return value of
+	// ioctl(2). Return ENOTTY for commands the file does not
+	// know.
+	Ioctl(input []byte, cmd uint32, arg uint64, flags uint32) (output []byte, result int32, code fuse.Status)
+
+	// Prefetch hints that size bytes at off will be read soon.
+	// It is called by FileSystemConnector.Prefetch, and for
+	// sequential reads if Options.PrefetchSequential is set. It
+	// should not block; start fetching in the background
+	// instead.
+	Prefetch(off int64, size int64)
+}
+
+// IoctlRetryFile is an additional interface for Files that serve
+// unrestricted ioctls, see Options.UnrestrictedIoctl. It is called
+// before Ioctl; if retry is set, the kernel restarts the ioctl, and
+// the next call has the contents of the in buffers as input, and
+// copies the output to the out buffers. The buffers are addresses in
+// the calling process, typically derived from arg.
+type IoctlRetryFile interface {
+	IoctlRetry(input []byte, cmd uint32, arg uint64, flags uint32) (in, out []fuse.IoctlIovec, retry bool)
+}
+
+// WriteFdFile is an additional interface for Files backed by a file
+// descriptor. If fuse.MountOptions.SpliceWrite is set, the data of
+// writes is spliced into the descriptor, and neither the Write method
+// of the File nor that of its Node is called. If ok is false, writes
+// go through Write as usual.
+type WriteFdFile interface {
+	WriteFd() (fd uintptr, ok bool)
 }
 
 // Wrap a File return in this to set FUSE flags.  Also used internally
@@ -166,13 +361,56 @@ type WithFlags struct {
 	// Put FOPEN_* flags here.
 	FuseFlags uint32
 
+	// If set, reads and writes bypass the page cache, and are
+	// passed to the file with the sizes and offsets the caller
+	// used (FOPEN_DIRECT_IO).
+	DirectIO bool
+
+	// If set, the file cannot be seeked, like a pipe
+	// (FOPEN_NONSEEKABLE). lseek(2), pread(2) and pwrite(2) fail
+	// with ESPIPE, so reads and writes come at sequential
+	// offsets.
+	NonSeekable bool
+
+	// If set, the file is a stream without a position, like a
+	// socket: reads and writes all come at offset 0, and the
+	// kernel does not serialize them against each other
+	// (FOPEN_STREAM). Kernels before protocol 7.31 ignore this,
+	// so combine it with NonSeekable.
+	Stream bool
+
+	// If set, data the kernel cached from earlier opens stays
+	// valid (FOPEN_KEEP_CACHE). Use FileSystemConnector.FileNotify
+	// to drop it when the file changes.
+	KeepCache bool
+
 	// O_RDWR, O_TRUNCATE, etc.
 	OpenFlags uint32
 }
 
+// fuseFlags returns FuseFlags along with the flags set through the
+// boolean fields.
+func (f *WithFlags) fuseFlags() uint32 {
+	flags := f.FuseFlags
+	if f.DirectIO {
+		flags |= fuse.FOPEN_DIRECT_IO
+	}
+	if f.NonSeekable {
+		flags |= fuse.FOPEN_NONSEEKABLE
+	}
+	if f.Stream {
+		flags |= fuse.FOPEN_STREAM
+	}
+	if f.KeepCache {
+		flags |= fuse.FOPEN_KEEP_CACHE
+	}
+	return flags
+}
+
 // Options contains time out options for a node FileSystem.  The
 // default copied from libfuse and set in NewMountOptions() is
-// (1s,1s,0s).
+// (1s,1s,0s). The timeouts are passed to the kernel with nanosecond
+// precision, so fractions of a second, eg. 100ms, work as expected.
 type Options struct {
 	EntryTimeout    time.Duration
 	AttrTimeout     time.Duration
@@ -180,7 +418,9 @@ type Options struct {
 
 	// If set, replace all uids with given UID.
 	// NewOptions() will set this to the daemon's
-	// uid/gid.
+	// uid/gid. With DefaultPermissions, the kernel then checks
+	// the owner bits of the mode for the daemon's user, and the
+	// other bits for everyone else.
 	*fuse.Owner
 
 	// This option exists for compatibility and is ignored.
@@ -189,8 +429,120 @@ type Options struct {
 	// If set, print debug information.
 	Debug bool
 
+	// If set, diagnostics and debug output are written here
+	// rather than to the standard logger. MountRoot also sets
+	// fuse.MountOptions.Logger.
+	Logger *log.Logger
+
+	// If set, the connector fails calls that would change the
+	// file system with EROFS, and does not pass them on to the
+	// Nodes. This covers writes, SetAttr, opening for writing,
+	// creating, removing, renaming and linking entries, and
+	// changing extended attributes. MountRoot also mounts with
+	// "ro", so the kernel rejects most of these itself.
+	ReadOnly bool
+
+	// If set, MountRoot mounts with
+	// fuse.MountOptions.DefaultPermissions, so the kernel checks
+	// permissions and Node.Access is not called.
+	DefaultPermissions bool
+
 	// If set, issue Lookup rather than GetAttr calls for known
 	// children. This allows the filesystem to update its inode
 	// hierarchy in response to kernel calls.
 	LookupKnownChildren bool
+
+	// If set, names of known children are matched without regard
+	// to case, so "Foo" and "foo" resolve to the same Inode. The
+	// name under which a child was added is kept.
+	CaseInsensitive bool
+
+	// If positive, Readlink results are cached for this long, per
+	// inode. The cache is dropped when the kernel forgets the
+	// inode, and by FileNotify, EntryNotify and DeleteNotify.
+	SymlinkCacheTimeout time.Duration
+
+	// If set, attributes returned by Lookup, which also serves
+	// ReadDirPlus, and GetAttr are cached per inode for the
+	// attribute timeout, and GETATTR is answered from the cache.
+	// Operations through the connector that change attributes,
+	// such as SetAttr, Write and changes to directory entries,
+	// drop the cache, as do FileNotify, EntryNotify and
+	// DeleteNotify. Changes behind the connector's back are only
+	// seen after the timeout. An inode's cache goes away when the
+	// kernel forgets it.
+	CacheAttributes bool
+
+	// If positive, writes smaller than this many bytes are
+	// collected per file handle, and passed to Node.Write as one
+	// write once they stop being contiguous, would overflow the
+	// buffer, or on FLUSH, FSYNC and RELEASE. Reads, GetAttr,
+	// SetAttr, Lseek, Fallocate and CopyFileRange on the inode
+	// flush its buffers first. Writes are reported as done before
+	// they reach the Node, so a failure of Node.Write is returned
+	// by the next write, flush or fsync on the handle instead;
+	// on RELEASE it can only be logged. Call fsync(2) to make
+	// sure that data has been written. This helps Nodes that do
+	// not want a request per small write(2) without
+	// fuse.MountOptions.WritebackCache.
+	WriteBufferSize int
+
+	// If set, ioctls with fuse.FUSE_IOCTL_UNRESTRICTED are
+	// passed to File.Ioctl. These may read and write arbitrary
+	// memory of the caller through IoctlRetryFile. Otherwise,
+	// they fail with ENOTTY.
+	UnrestrictedIoctl bool
+
+	// If set, a read that starts where the previous read on the
+	// same file handle ended calls File.Prefetch for the range
+	// following it.
+	PrefetchSequential bool
+
+	// If set, support exporting the mount over NFS. Each node ID
+	// given to the kernel stays valid after the kernel forgets
+	// it, until FileSystemConnector.Expire is called for the
+	// Inode, so NFS file handles can be resolved; the lookups of
+	// "." and ".." for that are answered by the connector.
+	// MountRoot also sets fuse.MountOptions.ExportSupport.
+	ExportSupport bool
+
+	// If set, a rename between this mount and another one that
+	// also sets CrossMountRename is carried out by copying the
+	// entry, recursively for directories, under a temporary name
+	// in the new directory, renaming that over the destination,
+	// and then removing the original. An existing destination is
+	// left alone if the copy fails. Contents, extended
+	// attributes, owner, permissions and timestamps are copied as
+	// far as the Nodes allow. This is not atomic, and may be slow
+	// for large trees. Otherwise, such renames fail with EXDEV.
+	//
+	// The copy has some limits. Creating entries in the new
+	// directory through the connector waits until the copy is
+	// done. Clients can see the temporary entry, called
+	// ".NAME.rename-N", while it is being filled. Hard links
+	// within the tree become separate files. With
+	// RENAME_NOREPLACE, the final rename goes through Rename2 if
+	// the new directory's Node implements Rename2Node. If that
+	// fails, even with EINVAL, the rename fails rather than
+	// falling back to Rename.
+	CrossMountRename bool
+
+	// If positive, the connector asks the kernel to forget the
+	// least recently looked up or stat'ed Inodes, with
+	// EntryNotify, once it knows more than this many. This bounds
+	// the memory used after walking a large tree. Mount points
+	// and Inodes with open files are kept. The kernel may keep
+	// Inodes that are in use, eg. as a working directory, so the
+	// bound is not strict. Only the value in the options of the
+	// root file system is used. The current count is
+	// FileSystemConnector.InodeHandleCount.
+	MaxCachedInodes int
+
+	// If positive, the connector remembers the names it returned
+	// as negative entries (see NegativeTimeout), and once there
+	// are more than this many, asks the kernel to drop the oldest
+	// with EntryNotify. This bounds the kernel's cache of
+	// nonexistent names, eg. from searching PATH. Only the value
+	// in the options of the root file system is used.
+	MaxNegativeEntries int
 }
@@ -0,0 +1,296 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// copyBufferSize is the chunk size for copying file contents in a
+// cross-mount rename.
+const copyBufferSize = 128 * 1024
+
+// crossMountRename moves oldName in oldParent to newName in
+// newParent, which lie in different mounts, by copying the entry
+// over and removing the original, see Options.CrossMountRename.
+//
+// The copy is made under a temporary name in newParent, and then
+// renamed over newName, so an existing destination is only replaced
+// once the copy is complete. With fuse.RENAME_NOREPLACE in flags, an
+// existing destination fails the rename with EEXIST. The final rename
+// then goes through Rename2 if newParent supports it, and otherwise
+// is emulated as for renames within a mount.
+func (c *FileSystemConnector) crossMountRename(oldParent *Inode, oldName string, newParent *Inode, newName string, flags uint32, context *fuse.Context) fuse.Status {
+	var attr fuse.Attr
+	src, code := c.internalLookup(&attr, oldParent, oldName, context)
+	if !code.Ok() {
+		return code
+	}
+	isDir := attr.Mode&syscall.S_IFMT == syscall.S_IFDIR
+
+	// As in rename(2), an existing destination is replaced if it
+	// has a compatible type. The rename below checks that a
+	// directory is empty.
+	var destAttr fuse.Attr
+	if dest, code := c.internalLookup(&destAttr, newParent, newName, context); code.Ok() && dest != nil {
+		if flags&fuse.RENAME_NOREPLACE != 0 {
+			return fuse.Status(syscall.EEXIST)
+		}
+		destIsDir := destAttr.Mode&syscall.S_IFMT == syscall.S_IFDIR
+		switch {
+		case isDir && !destIsDir:
+			return fuse.Status(syscall.ENOTDIR)
+		case !isDir && destIsDir:
+			return fuse.Status(syscall.EISDIR)
+		}
+	}
+
+	tmpName := c.renameTempName(newParent, newName, context)
+	code = c.copyEntry(src, &attr, newParent, tmpName, context)
+	if code.Ok() {
+		code = c.renameCopy(newParent, tmpName, newName, flags, context)
+	}
+	if !code.Ok() {
+		// Don't leave a partial copy behind.
+		var tmpAttr fuse.Attr
+		if tmp, tmpCode := c.internalLookup(&tmpAttr, newParent, tmpName, context); tmpCode.Ok() && tmp != nil {
+			c.removeEntry(newParent, tmpName, tmp, isDir, context)
+		}
+		return code
+	}
+	return c.removeEntry(oldParent, oldName, src, isDir, context)
+}
+
+// renameCopy renames the finished copy tmpName over newName. A
+// Rename2 that fails is not retried with Rename, as that would
+// replace a destination created meanwhile.
+func (c *FileSystemConnector) renameCopy(parent *Inode, tmpName, newName string, flags uint32, context *fuse.Context) fuse.Status {
+	if flags&fuse.RENAME_NOREPLACE != 0 {
+		if r2, ok := parent.fsInode.(Rename2Node); ok {
+			return r2.Rename2(tmpName, parent.fsInode, newName, flags, context)
+		}
+		// The copy may have taken a while, so check again.
+		if c.exists(parent, newName, context) {
+			return fuse.Status(syscall.EEXIST)
+		}
+	}
+	return parent.fsInode.Rename(tmpName, parent.fsInode, newName, context)
+}
+
+// renameTempCounter makes the names from renameTempName unique.
+var renameTempCounter uint64
+
+// renameTempName returns a name for a copy of name that does not
+// exist in parent.
+func (c *FileSystemConnector) renameTempName(parent *Inode, name string, context *fuse.Context) string {
+	for {
+		tmp := fmt.Sprintf(".%s.rename-%d", name, atomic.AddUint64(&renameTempCounter, 1))
+		if !c.exists(parent, tmp, context) {
+			return tmp
+		}
+	}
+}
+
+// copyEntry creates name in parent as a copy of src, whose
+// attributes are in attr. Directories are copied recursively.
+func (c *FileSystemConnector) copyEntry(src *Inode, attr *fuse.Attr, parent *Inode, name string, context *fuse.Context) fuse.Status {
+	if src.mountPoint != nil {
+		return fuse.EBUSY
+	}
+	mode := attr.Mode &^ syscall.S_IFMT
+	var dest *Inode
+	var code fuse.Status
+	switch attr.Mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		dest, code = parent.fsInode.Mkdir(name, mode, context)
+		if !code.Ok() {
+			return code
+		}
+		code = c.copyChildren(src, dest, context)
+	case syscall.S_IFLNK:
+		var target []byte
+		target, code = src.fsInode.Readlink(context)
+		if !code.Ok() {
+			return code
+		}
+		dest, code = parent.fsInode.Symlink(name, string(target), context)
+	case syscall.S_IFREG:
+		dest, code = copyFile(src, parent, name, mode, context)
+	default:
+		dest, code = parent.fsInode.Mknod(name, attr.Mode, attr.Rdev, context)
+	}
+	if !code.Ok() {
+		return code
+	}
+	if dest == nil {
+		return fuse.EIO
+	}
+	copyAttr(src, dest, attr, context)
+	return fuse.OK
+}
+
+// copyChildren copies the entries of the directory src into dest.
+func (c *FileSystemConnector) copyChildren(src *Inode, dest *Inode, context *fuse.Context) fuse.Status {
+	entries, code := listDir(src, context)
+	if !code.Ok() {
+		return code
+	}
+	for _, e := range entries {
+		var attr fuse.Attr
+		ch, code := c.internalLookup(&attr, src, e.Name, context)
+		if !code.Ok() {
+			return code
+		}
+		if code := c.copyEntry(ch, &attr, dest, e.Name, context); !code.Ok() {
+			return code
+		}
+	}
+	return fuse.OK
+}
+
+// copyFile creates name in parent with the contents of src. The
+// contents go through Node.Read and Node.Write, as the Files may be
+// nil.
+func copyFile(src *Inode, parent *Inode, name string, mode uint32, context *fuse.Context) (*Inode, fuse.Status) {
+	in, code := src.fsInode.Open(uint32(syscall.O_RDONLY), context)
+	if !code.Ok() {
+		return nil, code
+	}
+	if in != nil {
+		defer in.Release()
+	}
+
+	out, dest, code := parent.fsInode.Create(name, uint32(syscall.O_WRONLY|syscall.O_CREAT|syscall.O_EXCL), mode, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	if out != nil {
+		defer out.Release()
+	}
+	if dest == nil {
+		return nil, fuse.EIO
+	}
+
+	buf := make([]byte, copyBufferSize)
+	var off int64
+	for {
+		res, code := src.fsInode.Read(in, buf, off, context)
+		if !code.Ok() {
+			return nil, code
+		}
+		data, code := res.Bytes(buf)
+		if !code.Ok() {
+			res.Done()
+			return nil, code
+		}
+		if len(data) == 0 {
+			res.Done()
+			break
+		}
+		for len(data) > 0 {
+			n, code := dest.fsInode.Write(out, data, off, context)
+			if !code.Ok() {
+				res.Done()
+				return nil, code
+			}
+			if n == 0 {
+				res.Done()
+				return nil, fuse.EIO
+			}
+			data = data[n:]
+			off += int64(n)
+		}
+		res.Done()
+	}
+	if out == nil {
+		return dest, fuse.OK
+	}
+	return dest, out.Flush()
+}
+
+// copyAttr copies the extended attributes, owner, permissions and
+// timestamps of src to dest. This is done on a best effort basis:
+// the destination may not support extended attributes, or we may
+// not be allowed to change the owner.
+func copyAttr(src *Inode, dest *Inode, attr *fuse.Attr, context *fuse.Context) {
+	if names, code := src.fsInode.ListXAttr(context); code.Ok() {
+		for _, n := range names {
+			if data, code := src.fsInode.GetXAttr(n, context); code.Ok() {
+				dest.fsInode.SetXAttr(n, data, 0, context)
+			}
+		}
+	}
+	dest.fsInode.Chown(nil, attr.Uid, attr.Gid, context)
+	if attr.Mode&syscall.S_IFMT != syscall.S_IFLNK {
+		dest.fsInode.Chmod(nil, attr.Mode&07777, context)
+	}
+	atime, mtime := attr.AccessTime(), attr.ModTime()
+	dest.fsInode.Utimens(nil, &atime, &mtime, context)
+}
+
+// removeEntry removes name, whose Inode is n, from parent.
+// Directories are removed recursively.
+func (c *FileSystemConnector) removeEntry(parent *Inode, name string, n *Inode, isDir bool, context *fuse.Context) fuse.Status {
+	if !isDir {
+		return parent.fsInode.Unlink(name, context)
+	}
+	entries, code := listDir(n, context)
+	if !code.Ok() {
+		return code
+	}
+	for _, e := range entries {
+		var attr fuse.Attr
+		ch, code := c.internalLookup(&attr, n, e.Name, context)
+		if !code.Ok() {
+			return code
+		}
+		if ch.mountPoint != nil {
+			return fuse.EBUSY
+		}
+		isDir := attr.Mode&syscall.S_IFMT == syscall.S_IFDIR
+		if code := c.removeEntry(n, e.Name, ch, isDir, context); !code.Ok() {
+			return code
+		}
+	}
+	return parent.fsInode.Rmdir(name, context)
+}
+
+// listDir returns the entries of a directory Node, other than "."
+// and "..".
+func listDir(n *Inode, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	var entries []fuse.DirEntry
+	if sn, ok := n.fsInode.(DirStreamNode); ok {
+		stream, code := sn.OpenDirStream(context)
+		if !code.Ok() {
+			return nil, code
+		}
+		defer stream.Close()
+		for stream.HasNext() {
+			e, code := stream.Next()
+			if !code.Ok() {
+				return nil, code
+			}
+			entries = append(entries, e)
+		}
+	} else {
+		var code fuse.Status
+		entries, code = n.fsInode.OpenDir(context)
+		if !code.Ok() {
+			return nil, code
+		}
+	}
+
+	out := entries[:0]
+	for _, e := range entries {
+		if e.Name != "." && e.Name != ".." {
+			out = append(out, e)
+		}
+	}
+	return out, fuse.OK
+}
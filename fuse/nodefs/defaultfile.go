@@ -5,6 +5,7 @@
 package nodefs
 
 import (
+	"syscall"
 	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
@@ -38,6 +39,19 @@ func (f *defaultFile) Write(data []byte, off int64) (uint32, fuse.Status) {
 }
 
 func (f *defaultFile) Flock(flags int) fuse.Status { return fuse.ENOSYS }
+
+func (f *defaultFile) GetLk(owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (code fuse.Status) {
+	return fuse.ENOSYS
+}
+
+func (f *defaultFile) SetLk(owner uint64, lk *fuse.FileLock, flags uint32) (code fuse.Status) {
+	return fuse.ENOSYS
+}
+
+func (f *defaultFile) SetLkw(owner uint64, lk *fuse.FileLock, flags uint32) (code fuse.Status) {
+	return fuse.ENOSYS
+}
+
 func (f *defaultFile) Flush() fuse.Status {
 	return fuse.OK
 }
@@ -73,3 +87,22 @@ func (f *defaultFile) Chmod(perms uint32) fuse.Status {
 func (f *defaultFile) Allocate(off uint64, size uint64, mode uint32) (code fuse.Status) {
 	return fuse.ENOSYS
 }
+
+func (f *defaultFile) Lseek(off int64, whence uint32) (int64, fuse.Status) {
+	return 0, fuse.ENOSYS
+}
+
+func (f *defaultFile) CopyFileRange(off int64, dest File, destOff int64, len uint64, flags uint64) (uint32, fuse.Status) {
+	return 0, fuse.ENOSYS
+}
+
+func (f *defaultFile) Poll(kh uint64, flags uint32, events uint32) (uint32, fuse.Status) {
+	return 0, fuse.ENOSYS
+}
+
+func (f *defaultFile) Prefetch(off int64, size int64) {
+}
+
+func (f *defaultFile) Ioctl(input []byte, cmd uint32, arg uint64, flags uint32) ([]byte, int32, fuse.Status) {
+	return nil, 0, fuse.Status(syscall.ENOTTY)
+}
@@ -30,6 +30,10 @@ func (n *defaultNode) StatFs() *fuse.StatfsOut {
 	return nil
 }
 
+func (n *defaultNode) Bmap(block uint64, blocksize uint32, context *fuse.Context) (uint64, fuse.Status) {
+	return 0, fuse.ENOSYS
+}
+
 func (n *defaultNode) SetInode(node *Inode) {
 	n.inode = node
 }
@@ -50,7 +54,8 @@ func (n *defaultNode) Lookup(out *fuse.Attr, name string, context *fuse.Context)
 }
 
 func (n *defaultNode) Access(mode uint32, context *fuse.Context) (code fuse.Status) {
-	return fuse.ENOSYS
+	// Permit everything, as if access(2) were not passed to us.
+	return fuse.OK
 }
 
 func (n *defaultNode) Readlink(c *fuse.Context) ([]byte, fuse.Status) {
@@ -109,6 +114,10 @@ func (n *defaultNode) OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Stat
 	return s, fuse.OK
 }
 
+func (n *defaultNode) FsyncDir(flags uint32, context *fuse.Context) (code fuse.Status) {
+	return fuse.OK
+}
+
 func (n *defaultNode) GetXAttr(attribute string, context *fuse.Context) (data []byte, code fuse.Status) {
 	return nil, fuse.ENOATTR
 }
@@ -138,22 +147,58 @@ func (n *defaultNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context)
 }
 
 func (n *defaultNode) Chmod(file File, perms uint32, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.Chmod(perms)
+	}
 	return fuse.ENOSYS
 }
 
 func (n *defaultNode) Chown(file File, uid uint32, gid uint32, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.Chown(uid, gid)
+	}
 	return fuse.ENOSYS
 }
 
 func (n *defaultNode) Truncate(file File, size uint64, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.Truncate(size)
+	}
 	return fuse.ENOSYS
 }
 
 func (n *defaultNode) Utimens(file File, atime *time.Time, mtime *time.Time, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.Utimens(atime, mtime)
+	}
 	return fuse.ENOSYS
 }
 
 func (n *defaultNode) Fallocate(file File, off uint64, size uint64, mode uint32, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.Allocate(off, size, mode)
+	}
+	return fuse.ENOSYS
+}
+
+func (n *defaultNode) GetLk(file File, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.GetLk(owner, lk, flags, out)
+	}
+	return fuse.ENOSYS
+}
+
+func (n *defaultNode) SetLk(file File, owner uint64, lk *fuse.FileLock, flags uint32, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.SetLk(owner, lk, flags)
+	}
+	return fuse.ENOSYS
+}
+
+func (n *defaultNode) SetLkw(file File, owner uint64, lk *fuse.FileLock, flags uint32, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.SetLkw(owner, lk, flags)
+	}
 	return fuse.ENOSYS
 }
 
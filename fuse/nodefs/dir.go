@@ -5,96 +5,217 @@
 package nodefs
 
 import (
-	"log"
 	"sync"
 
 	"github.com/hanwen/go-fuse/fuse"
 )
 
 type connectorDir struct {
-	node  Node
+	inode *Inode
 	rawFS fuse.RawFileSystem
 
-	// Protect stream and lastOffset.  These are written in case
+	// Protect stream, pos and pending. The stream is replaced if
 	// there is a seek on the directory.
 	mu     sync.Mutex
-	stream []fuse.DirEntry
+	stream DirStream
 
-	// lastOffset stores the last offset for a readdir. This lets
-	// readdir pick up changes to the directory made after opening
-	// it.
-	lastOffset uint64
+	// pos is the offset of the next entry to take from stream.
+	pos uint64
+
+	// pending is an entry taken from stream that did not fit in
+	// the previous reply.
+	pending *fuse.DirEntry
+
+	// failed is an error from stream that was held back, so the
+	// previous reply could return the entries before it.
+	failed fuse.Status
 }
 
-func (d *connectorDir) ReadDir(input *fuse.ReadIn, out *fuse.DirEntryList) (code fuse.Status) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+type listDirStream struct {
+	list []fuse.DirEntry
+}
+
+// NewListDirStream returns a DirStream that serves the given
+// entries.
+func NewListDirStream(list []fuse.DirEntry) DirStream {
+	return &listDirStream{list}
+}
+
+func (s *listDirStream) HasNext() bool {
+	return len(s.list) > 0
+}
+
+func (s *listDirStream) Next() (fuse.DirEntry, fuse.Status) {
+	e := s.list[0]
+	s.list = s.list[1:]
+	return e, fuse.OK
+}
+
+func (s *listDirStream) Close() {
+	s.list = nil
+}
+
+// mountDirStream follows the entries of the Node with the mount
+// points in the directory, and "." and "..".
+type mountDirStream struct {
+	DirStream
+	extra []fuse.DirEntry
+}
+
+func (s *mountDirStream) HasNext() bool {
+	return s.DirStream.HasNext() || len(s.extra) > 0
+}
 
-	if d.stream == nil {
-		return fuse.OK
+func (s *mountDirStream) Next() (fuse.DirEntry, fuse.Status) {
+	if s.DirStream.HasNext() {
+		return s.DirStream.Next()
 	}
-	// rewinddir() should be as if reopening directory.
-	// TODO - test this.
-	if d.lastOffset > 0 && input.Offset == 0 {
-		d.stream, code = d.node.OpenDir((*fuse.Context)(&input.Context))
+	e := s.extra[0]
+	s.extra = s.extra[1:]
+	return e, fuse.OK
+}
+
+// openDirStream opens the listing of node, using OpenDirStream if
+// the Node supports it and OpenDir otherwise.
+func openDirStream(node *Inode, context *fuse.Context) (DirStream, fuse.Status) {
+	var stream DirStream
+	if sn, ok := node.Node().(DirStreamNode); ok {
+		s, code := sn.OpenDirStream(context)
 		if !code.Ok() {
-			return code
+			return nil, code
+		}
+		stream = s
+	} else {
+		list, code := node.Node().OpenDir(context)
+		if !code.Ok() {
+			return nil, code
 		}
+		stream = NewListDirStream(list)
 	}
 
-	if input.Offset > uint64(len(d.stream)) {
-		// This shouldn't happen, but let's not crash.
-		return fuse.EINVAL
-	}
+	return &mountDirStream{
+		DirStream: stream,
+		extra: append(node.getMountDirEntries(),
+			fuse.DirEntry{Mode: fuse.S_IFDIR, Name: "."},
+			fuse.DirEntry{Mode: fuse.S_IFDIR, Name: ".."}),
+	}, fuse.OK
+}
 
-	todo := d.stream[input.Offset:]
-	for _, e := range todo {
+// next returns the next entry to send, or false at the end of the
+// directory. It does not advance pos.
+func (d *connectorDir) next() (fuse.DirEntry, bool, fuse.Status) {
+	if d.pending != nil {
+		e := *d.pending
+		d.pending = nil
+		return e, true, fuse.OK
+	}
+	if !d.failed.Ok() {
+		code := d.failed
+		d.failed = fuse.OK
+		return fuse.DirEntry{}, false, code
+	}
+	for d.stream.HasNext() {
+		e, code := d.stream.Next()
+		if !code.Ok() {
+			return e, false, code
+		}
 		if e.Name == "" {
-			log.Printf("got empty directory entry, mode %o.", e.Mode)
+			d.inode.mount.connector.logf("got empty directory entry, mode %o.", e.Mode)
 			continue
 		}
-		ok, off := out.AddDirEntry(e)
-		d.lastOffset = off
+		return e, true, fuse.OK
+	}
+	return fuse.DirEntry{}, false, fuse.OK
+}
+
+// seek positions the stream at off. Offsets before the current
+// position reopen the directory, so rewinddir() picks up changes made
+// after opening it.
+func (d *connectorDir) seek(off uint64, context *fuse.Context) fuse.Status {
+	if off < d.pos {
+		stream, code := openDirStream(d.inode, context)
+		if !code.Ok() {
+			return code
+		}
+		d.stream.Close()
+		d.stream = stream
+		d.pos = 0
+		d.pending = nil
+		d.failed = fuse.OK
+	}
+
+	for d.pos < off {
+		_, ok, code := d.next()
+		if !code.Ok() {
+			return code
+		}
 		if !ok {
-			break
+			// This shouldn't happen, but let's not crash.
+			return fuse.EINVAL
 		}
+		d.pos++
 	}
 	return fuse.OK
 }
 
-func (d *connectorDir) ReadDirPlus(input *fuse.ReadIn, out *fuse.DirEntryList) (code fuse.Status) {
+func (d *connectorDir) ReadDir(cancel <-chan struct{}, input *fuse.ReadIn, out *fuse.DirEntryList) (code fuse.Status) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if d.stream == nil {
-		return fuse.OK
+	if code := d.seek(input.Offset, &fuse.Context{Caller: input.Caller, Cancel: cancel}); !code.Ok() {
+		return code
 	}
 
-	// rewinddir() should be as if reopening directory.
-	if d.lastOffset > 0 && input.Offset == 0 {
-		d.stream, code = d.node.OpenDir((*fuse.Context)(&input.Context))
+	for {
+		e, ok, code := d.next()
 		if !code.Ok() {
 			return code
 		}
+		if !ok {
+			break
+		}
+		d.fillIno(&e)
+		if ok, _ := out.AddDirEntry(e); !ok {
+			d.pending = &e
+			break
+		}
+		d.pos++
 	}
+	return fuse.OK
+}
+
+func (d *connectorDir) ReadDirPlus(cancel <-chan struct{}, input *fuse.ReadIn, out *fuse.DirEntryList) (code fuse.Status) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	if input.Offset > uint64(len(d.stream)) {
-		// This shouldn't happen, but let's not crash.
-		return fuse.EINVAL
+	if code := d.seek(input.Offset, &fuse.Context{Caller: input.Caller, Cancel: cancel}); !code.Ok() {
+		return code
 	}
-	todo := d.stream[input.Offset:]
-	for _, e := range todo {
-		if e.Name == "" {
-			log.Printf("got empty directory entry, mode %o.", e.Mode)
-			continue
+
+	for emitted := false; ; emitted = true {
+		e, ok, code := d.next()
+		if !code.Ok() {
+			if emitted {
+				// The kernel only takes the lookups of
+				// the entries already in out on success,
+				// so return the error on the next call.
+				d.failed = code
+				break
+			}
+			return code
+		}
+		if !ok {
+			break
 		}
 
 		// we have to be sure entry will fit if we try to add
 		// it, or we'll mess up the lookup counts.
-		entryDest, off := out.AddDirLookupEntry(e)
+		entryDest, _ := out.AddDirLookupEntry(e)
 		if entryDest == nil {
+			d.pending = &e
 			break
 		}
+		d.pos++
 		entryDest.Ino = uint64(fuse.FUSE_UNKNOWN_INO)
 
 		// No need to fill attributes for . and ..
@@ -105,11 +226,29 @@ func (d *connectorDir) ReadDirPlus(input *fuse.ReadIn, out *fuse.DirEntryList) (
 		// Clear entryDest before use it, some fields can be corrupted if does not set all fields in rawFS.Lookup
 		*entryDest = fuse.EntryOut{}
 
-		d.rawFS.Lookup(&input.InHeader, e.Name, entryDest)
-		d.lastOffset = off
+		d.rawFS.Lookup(cancel, &input.InHeader, e.Name, entryDest)
 	}
 	return fuse.OK
+}
+
+// fillIno sets the inode number of e if it is a known child with a
+// stable number. READDIRPLUS gets these from Lookup.
+func (d *connectorDir) fillIno(e *fuse.DirEntry) {
+	if e.Ino != 0 || e.Name == "." || e.Name == ".." {
+		return
+	}
+	if ch := d.inode.GetChild(e.Name); ch != nil {
+		if in, ok := ch.Node().(InoNode); ok {
+			e.Ino = in.Ino()
+		}
+	}
+}
 
+// release closes the stream when the directory is closed.
+func (d *connectorDir) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stream.Close()
 }
 
 type rawDir interface {
@@ -11,6 +11,7 @@ import (
 	"syscall"
 
 	"github.com/hanwen/go-fuse/fuse"
+	"golang.org/x/sys/unix"
 )
 
 // DataFile is for implementing read-only filesystems.  This
@@ -137,6 +138,10 @@ func (f *loopbackFile) Write(data []byte, off int64) (uint32, fuse.Status) {
 	return uint32(n), fuse.ToStatus(err)
 }
 
+func (f *loopbackFile) WriteFd() (uintptr, bool) {
+	return f.File.Fd(), true
+}
+
 func (f *loopbackFile) Release() {
 	f.lock.Lock()
 	f.File.Close()
@@ -175,6 +180,63 @@ func (f *loopbackFile) Flock(flags int) fuse.Status {
 	return r
 }
 
+func (f *loopbackFile) GetLk(owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (code fuse.Status) {
+	flk := syscall.Flock_t{}
+	lk.ToFlockT(&flk)
+	f.lock.Lock()
+	code = fuse.ToStatus(syscall.FcntlFlock(f.File.Fd(), _OFD_GETLK, &flk))
+	f.lock.Unlock()
+	out.FromFlockT(&flk)
+	return code
+}
+
+func (f *loopbackFile) SetLk(owner uint64, lk *fuse.FileLock, flags uint32) (code fuse.Status) {
+	flk := syscall.Flock_t{}
+	lk.ToFlockT(&flk)
+	f.lock.Lock()
+	code = fuse.ToStatus(syscall.FcntlFlock(f.File.Fd(), _OFD_SETLK, &flk))
+	f.lock.Unlock()
+	return code
+}
+
+func (f *loopbackFile) SetLkw(owner uint64, lk *fuse.FileLock, flags uint32) (code fuse.Status) {
+	flk := syscall.Flock_t{}
+	lk.ToFlockT(&flk)
+	// Don't hold f.lock while waiting for the lock.
+	return fuse.ToStatus(syscall.FcntlFlock(f.File.Fd(), _OFD_SETLKW, &flk))
+}
+
+func (f *loopbackFile) Lseek(off int64, whence uint32) (int64, fuse.Status) {
+	// The file position is not used for reading and writing, so
+	// moving it is harmless.
+	f.lock.Lock()
+	n, err := syscall.Seek(int(f.File.Fd()), off, int(whence))
+	f.lock.Unlock()
+	return n, fuse.ToStatus(err)
+}
+
+func (f *loopbackFile) Poll(kh uint64, flags uint32, events uint32) (uint32, fuse.Status) {
+	// Kernels before protocol 7.21 don't say which events they
+	// want.
+	if events == 0 {
+		events = unix.POLLIN | unix.POLLOUT | unix.POLLPRI
+	}
+
+	// We cannot be told when the underlying file becomes ready,
+	// so just report its current state.
+	fds := []unix.PollFd{{Fd: int32(f.File.Fd()), Events: int16(events)}}
+	if _, err := unix.Poll(fds, 0); err != nil {
+		return 0, fuse.ToStatus(err)
+	}
+	return uint32(fds[0].Revents), fuse.OK
+}
+
+func (f *loopbackFile) Ioctl(input []byte, cmd uint32, arg uint64, flags uint32) ([]byte, int32, fuse.Status) {
+	// Passing ioctls to the underlying file would need knowledge
+	// of each command's arguments.
+	return nil, 0, fuse.Status(syscall.ENOTTY)
+}
+
 func (f *loopbackFile) Truncate(size uint64) fuse.Status {
 	f.lock.Lock()
 	r := fuse.ToStatus(syscall.Ftruncate(int(f.File.Fd()), int64(size)))
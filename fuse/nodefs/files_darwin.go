@@ -13,6 +13,18 @@ import (
 	"github.com/hanwen/go-fuse/internal/utimens"
 )
 
+// OSX has no open file description locks, so fall back to classic
+// POSIX locks.
+const (
+	_OFD_GETLK  = syscall.F_GETLK
+	_OFD_SETLK  = syscall.F_SETLK
+	_OFD_SETLKW = syscall.F_SETLKW
+)
+
+func (f *loopbackFile) Prefetch(off int64, size int64) {
+	// The kernel reads ahead by itself.
+}
+
 func (f *loopbackFile) Allocate(off uint64, sz uint64, mode uint32) fuse.Status {
 	// TODO: Handle `mode` parameter.
 
@@ -95,3 +107,7 @@ func (f *loopbackFile) Utimens(a *time.Time, m *time.Time) fuse.Status {
 	f.lock.Unlock()
 	return fuse.ToStatus(err)
 }
+
+func (f *loopbackFile) CopyFileRange(off int64, dest File, destOff int64, len uint64, flags uint64) (uint32, fuse.Status) {
+	return 0, fuse.ENOSYS
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// FreeBSD has no open file description locks, so fall back to
+// classic POSIX locks.
+const (
+	_OFD_GETLK  = syscall.F_GETLK
+	_OFD_SETLK  = syscall.F_SETLK
+	_OFD_SETLKW = syscall.F_SETLKW
+)
+
+func (f *loopbackFile) Allocate(off uint64, sz uint64, mode uint32) fuse.Status {
+	// posix_fallocate(2) has no modes.
+	if mode != 0 {
+		return fuse.ENOSYS
+	}
+	f.lock.Lock()
+	_, _, errno := syscall.Syscall(syscall.SYS_POSIX_FALLOCATE, f.File.Fd(), uintptr(off), uintptr(sz))
+	f.lock.Unlock()
+	if errno != 0 {
+		return fuse.ToStatus(errno)
+	}
+	return fuse.OK
+}
+
+func (f *loopbackFile) Prefetch(off int64, size int64) {
+	f.lock.Lock()
+	unix.Fadvise(int(f.File.Fd()), off, size, unix.FADV_WILLNEED)
+	f.lock.Unlock()
+}
+
+// Utimens - file handle based version of loopbackFileSystem.Utimens()
+func (f *loopbackFile) Utimens(a *time.Time, m *time.Time) fuse.Status {
+	ts := [2]syscall.Timespec{
+		fuse.UtimeToTimespec(a),
+		fuse.UtimeToTimespec(m),
+	}
+	f.lock.Lock()
+	_, _, errno := syscall.Syscall(unix.SYS_FUTIMENS, f.File.Fd(), uintptr(unsafe.Pointer(&ts)), 0)
+	f.lock.Unlock()
+	if errno != 0 {
+		return fuse.ToStatus(errno)
+	}
+	return fuse.OK
+}
+
+func (f *loopbackFile) CopyFileRange(off int64, dest File, destOff int64, len uint64, flags uint64) (uint32, fuse.Status) {
+	return 0, fuse.ENOSYS
+}
@@ -8,9 +8,20 @@ import (
 	"syscall"
 	"time"
 
+	"golang.org/x/sys/unix"
+
 	"github.com/hanwen/go-fuse/fuse"
 )
 
+// Open file description locks, see fcntl(2). Unlike classic POSIX
+// locks, these belong to the open file rather than to our process, so
+// locks taken through different opens conflict with each other.
+const (
+	_OFD_GETLK  = 36
+	_OFD_SETLK  = 37
+	_OFD_SETLKW = 38
+)
+
 func (f *loopbackFile) Allocate(off uint64, sz uint64, mode uint32) fuse.Status {
 	f.lock.Lock()
 	err := syscall.Fallocate(int(f.File.Fd()), mode, int64(off), int64(sz))
@@ -21,6 +32,12 @@ func (f *loopbackFile) Allocate(off uint64, sz uint64, mode uint32) fuse.Status
 	return fuse.OK
 }
 
+func (f *loopbackFile) Prefetch(off int64, size int64) {
+	f.lock.Lock()
+	unix.Fadvise(int(f.File.Fd()), off, size, unix.FADV_WILLNEED)
+	f.lock.Unlock()
+}
+
 // Utimens - file handle based version of loopbackFileSystem.Utimens()
 func (f *loopbackFile) Utimens(a *time.Time, m *time.Time) fuse.Status {
 	var ts [2]syscall.Timespec
@@ -31,3 +48,18 @@ func (f *loopbackFile) Utimens(a *time.Time, m *time.Time) fuse.Status {
 	f.lock.Unlock()
 	return fuse.ToStatus(err)
 }
+
+func (f *loopbackFile) CopyFileRange(off int64, dest File, destOff int64, len uint64, flags uint64) (uint32, fuse.Status) {
+	for dest.InnerFile() != nil {
+		dest = dest.InnerFile()
+	}
+	destFile, ok := dest.(*loopbackFile)
+	if !ok {
+		return 0, fuse.EXDEV
+	}
+
+	f.lock.Lock()
+	n, err := unix.CopyFileRange(int(f.File.Fd()), &off, int(destFile.File.Fd()), &destOff, int(len), int(flags))
+	f.lock.Unlock()
+	return uint32(n), fuse.ToStatus(err)
+}
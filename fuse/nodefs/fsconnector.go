@@ -9,9 +9,14 @@ package nodefs
 // are in fsops.go
 
 import (
+	"container/list"
+	"fmt"
 	"log"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unsafe"
 
@@ -27,6 +32,9 @@ var paranoia = false
 type FileSystemConnector struct {
 	debug bool
 
+	// Destination of diagnostics, or nil for the standard logger.
+	logger *log.Logger
+
 	// Callbacks for talking back to the kernel.
 	server *fuse.Server
 
@@ -35,6 +43,12 @@ type FileSystemConnector struct {
 
 	// The root of the FUSE file system.
 	rootNode *Inode
+
+	// Non-nil if Options.MaxCachedInodes is set.
+	lru *inodeLRU
+
+	// Non-nil if Options.MaxNegativeEntries is set.
+	negative *negativeCache
 }
 
 // NewOptions generates FUSE options that correspond to libfuse's
@@ -56,7 +70,16 @@ func NewFileSystemConnector(root Node, opts *Options) (c *FileSystemConnector) {
 		opts = NewOptions()
 	}
 	c.inodeMap = newPortableHandleMap()
-	c.rootNode = newInode(true, root)
+	c.rootNode = newInode(syscall.S_IFDIR, root)
+	if opts.MaxCachedInodes > 0 {
+		c.lru = &inodeLRU{max: opts.MaxCachedInodes}
+	}
+	if opts.MaxNegativeEntries > 0 {
+		c.negative = &negativeCache{
+			max:     opts.MaxNegativeEntries,
+			entries: map[parentData]*list.Element{},
+		}
+	}
 
 	c.verify()
 	c.mountRoot(opts)
@@ -65,15 +88,37 @@ func NewFileSystemConnector(root Node, opts *Options) (c *FileSystemConnector) {
 	// issue a forget.  This lookupUpdate is to make the counts match.
 	c.lookupUpdate(c.rootNode)
 	c.debug = opts.Debug
+	c.logger = opts.Logger
 
 	return c
 }
 
+// logf writes diagnostics to Options.Logger, or to the standard
+// logger.
+func (c *FileSystemConnector) logf(format string, args ...interface{}) {
+	if c.logger != nil {
+		c.logger.Output(2, fmt.Sprintf(format, args...))
+	} else {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
+
 // Server returns the fuse.Server that talking to the kernel.
 func (c *FileSystemConnector) Server() *fuse.Server {
 	return c.server
 }
 
+// InitOut returns the settings agreed with the kernel at mount time,
+// such as the capability flags and the maximum write size. It returns
+// nil if the connector is not served yet. As the kernel sends nothing
+// before INIT, it is safe to call from any Node or File method.
+func (c *FileSystemConnector) InitOut() *fuse.InitOut {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.InitOut()
+}
+
 // SetDebug toggles printing of debug information. This function is
 // deprecated. Set the Debug option in the Options struct instead.
 func (c *FileSystemConnector) SetDebug(debug bool) {
@@ -93,11 +138,9 @@ func (c *FileSystemConnector) verify() {
 // childLookup fills entry information for a newly created child inode
 func (c *rawBridge) childLookup(out *fuse.EntryOut, n *Inode, context *fuse.Context) {
 	n.Node().GetAttr((*fuse.Attr)(&out.Attr), nil, context)
-	n.mount.fillEntry(out)
+	n.mount.fillEntry(out, n)
 	out.NodeId, out.Generation = c.fsConn().lookupUpdate(n)
-	if out.Ino == 0 {
-		out.Ino = out.NodeId
-	}
+	setIno((*fuse.Attr)(&out.Attr), n, out.NodeId)
 	if out.Nlink == 0 {
 		// With Nlink == 0, newer kernels will refuse link
 		// operations.
@@ -105,6 +148,9 @@ func (c *rawBridge) childLookup(out *fuse.EntryOut, n *Inode, context *fuse.Cont
 	}
 }
 
+// toInode returns the inode for a node ID from the kernel, or nil if
+// the ID is not in use, for example because the kernel sent it after
+// forgetting it. Operations then fail with ESTALE.
 func (c *rawBridge) toInode(nodeid uint64) *Inode {
 	if nodeid == fuse.FUSE_ROOT_ID {
 		return c.rootNode
@@ -116,10 +162,65 @@ func (c *rawBridge) toInode(nodeid uint64) *Inode {
 // Must run outside treeLock.  Returns the nodeId and generation.
 func (c *FileSystemConnector) lookupUpdate(node *Inode) (id, generation uint64) {
 	id, generation = c.inodeMap.Register(&node.handled)
+	if node.mount != nil && node.mount.options.ExportSupport &&
+		atomic.CompareAndSwapInt32(&node.exportPin, 0, 1) {
+		// Keep the node ID for NFS file handles, until Expire.
+		c.inodeMap.Register(&node.handled)
+	}
+	c.touch(node)
 	c.verify()
 	return
 }
 
+// LookupHandle returns the Inode for a node ID and generation as
+// given to the kernel, and found in NFS file handles. It returns nil
+// if the node ID is no longer valid, or was reused for another Inode.
+func (c *FileSystemConnector) LookupHandle(nodeID, generation uint64) *Inode {
+	if nodeID == fuse.FUSE_ROOT_ID {
+		return c.rootNode
+	}
+	h := c.inodeMap.DecodeGeneration(nodeID, generation)
+	if h == nil {
+		return nil
+	}
+	return (*Inode)(unsafe.Pointer(h))
+}
+
+// Expire releases node from Options.ExportSupport, so it is
+// forgotten as usual once the kernel forgets it. NFS file handles
+// for it then become stale. If the kernel looks up node again before
+// it is forgotten, it is kept again.
+func (c *FileSystemConnector) Expire(node *Inode) {
+	if !atomic.CompareAndSwapInt32(&node.exportPin, 1, 0) {
+		return
+	}
+	if id := c.inodeMap.Handle(&node.handled); id != 0 {
+		c.forgetUpdate(id, 1)
+	}
+}
+
+// considerDropInode removes the given inodes from the tree if they
+// are no longer needed, i.e. they are unknown to the kernel, are not
+// mount points and have no children or open files. Dropping an inode
+// may make its parents droppable in turn. The tree is walked with an
+// explicit worklist, so arbitrarily deep trees cannot exhaust the
+// stack. Must be called with treeLock held.
+func (c *FileSystemConnector) considerDropInode(todo []*Inode) {
+	for len(todo) > 0 {
+		n := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		// An inode without parents is no longer in the tree,
+		// e.g. because we reached it twice through hard links.
+		if n == c.rootNode || len(n.parents) == 0 || !c.isCached(n) {
+			continue
+		}
+
+		todo = append(todo, n.dropFromParents()...)
+		n.fsInode.OnForget()
+	}
+}
+
 // forgetUpdate decrements the reference counter for "nodeID" by "forgetCount".
 // Must run outside treeLock.
 func (c *FileSystemConnector) forgetUpdate(nodeID uint64, forgetCount int) {
@@ -134,36 +235,70 @@ func (c *FileSystemConnector) forgetUpdate(nodeID uint64, forgetCount int) {
 	// Prevent concurrent modification of the tree while we are processing
 	// the FORGET
 	node := (*Inode)(unsafe.Pointer(c.inodeMap.Decode(nodeID)))
+	if node == nil {
+		c.logf("FORGET for unknown node ID %d", nodeID)
+		return
+	}
 	node.mount.treeLock.Lock()
 	defer node.mount.treeLock.Unlock()
 
 	if forgotten, _ := c.inodeMap.Forget(nodeID, forgetCount); forgotten {
+		node.invalidateLink()
+		node.invalidateAttr()
 		if len(node.children) > 0 || !node.Node().Deletable() ||
 			node == c.rootNode || node.mountPoint != nil {
 			// We cannot forget a directory that still has children as these
 			// would become unreachable.
 			return
 		}
-		// We have to remove ourself from all parents.
-		// Create a copy of node.parents so we can safely iterate over it
-		// while modifying the original.
-		parents := make(map[parentData]struct{}, len(node.parents))
-		for k, v := range node.parents {
-			parents[k] = v
-		}
-
-		for p := range parents {
-			// This also modifies node.parents
-			p.parent.rmChild(p.name)
-		}
-
+		parents := node.dropFromParents()
 		node.fsInode.OnForget()
+
+		// Parents that the kernel forgot earlier were kept
+		// alive by this node.
+		c.considerDropInode(parents)
 	}
-	// TODO - try to drop children even forget was not successful.
 	c.verify()
 }
 
-// InodeCount returns the number of inodes registered with the kernel.
+// ProtocolVersion returns the FUSE protocol version negotiated with
+// the kernel, or zeros if the connector is not served by a
+// fuse.Server.
+func (c *FileSystemConnector) ProtocolVersion() (major, minor uint32) {
+	if c.server == nil {
+		return 0, 0
+	}
+	return c.server.ProtocolVersion()
+}
+
+// InflightRequest is a request that is being served, with the path
+// of its node, if known.
+type InflightRequest struct {
+	fuse.RequestInfo
+	Path string
+}
+
+// InflightRequests returns a snapshot of the requests that are being
+// served, oldest first. The path is relative to the root, and empty
+// for the root and for nodes that are no longer in the tree.
+func (c *FileSystemConnector) InflightRequests() []InflightRequest {
+	if c.server == nil {
+		return nil
+	}
+	infos := c.server.InflightRequestInfo()
+	out := make([]InflightRequest, 0, len(infos))
+	for _, info := range infos {
+		r := InflightRequest{RequestInfo: info}
+		if n := (*rawBridge)(c).toInode(info.NodeId); n != nil {
+			r.Path, _ = n.Path()
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// InodeHandleCount returns the number of inodes registered with the
+// kernel, that is, those that have a node ID.
 func (c *FileSystemConnector) InodeHandleCount() int {
 	return c.inodeMap.Count()
 }
@@ -171,6 +306,11 @@ func (c *FileSystemConnector) InodeHandleCount() int {
 // Finds a node within the currently known inodes, returns the last
 // known node and the remaining unknown path components.  If parent is
 // nil, start from FUSE mountpoint.
+//
+// The walk holds the tree locks of the mounts it passes, so file
+// systems are not mounted or unmounted under it, but entries may be
+// added and removed while it runs. The result may be removed or
+// unmounted as soon as Node returns.
 func (c *FileSystemConnector) Node(parent *Inode, fullPath string) (*Inode, []string) {
 	if parent == nil {
 		parent = c.rootNode
@@ -183,26 +323,28 @@ func (c *FileSystemConnector) Node(parent *Inode, fullPath string) (*Inode, []st
 	fullPath = strings.TrimLeft(filepath.Clean(fullPath), sep)
 	comps := strings.Split(fullPath, sep)
 
+	// Lock the mounts from the top down, in the same order as
+	// Unmount does. The mount of an Inode never changes, so it
+	// can be read without a lock.
 	node := parent
-	if node.mountPoint == nil {
-		node.mount.treeLock.RLock()
-		defer node.mount.treeLock.RUnlock()
-	}
+	node.mount.treeLock.RLock()
+	defer node.mount.treeLock.RUnlock()
 
 	for i, component := range comps {
 		if len(component) == 0 {
 			continue
 		}
 
-		if node.mountPoint != nil {
-			node.mount.treeLock.RLock()
-			defer node.mount.treeLock.RUnlock()
-		}
-
-		next := node.children[component]
+		node.treeMu.Lock()
+		next := node.children[node.childKey(component)]
+		node.treeMu.Unlock()
 		if next == nil {
 			return node, comps[i:]
 		}
+		if next.mount != node.mount {
+			next.mount.treeLock.RLock()
+			defer next.mount.treeLock.RUnlock()
+		}
 		node = next
 	}
 
@@ -221,7 +363,7 @@ func (c *FileSystemConnector) LookupNode(parent *Inode, path string) *Inode {
 		var a fuse.Attr
 		// This will not affect inode ID lookup counts, which
 		// are only update in response to kernel requests.
-		var dummy fuse.InHeader
+		var dummy fuse.Context
 		child, _ := c.internalLookup(&a, parent, r, &dummy)
 		if child == nil {
 			return nil
@@ -236,16 +378,27 @@ func (c *FileSystemConnector) LookupNode(parent *Inode, path string) *Inode {
 func (c *FileSystemConnector) mountRoot(opts *Options) {
 	c.rootNode.mountFs(opts)
 	c.rootNode.mount.connector = c
+	c.rootNode.setRootType()
 	c.verify()
 }
 
 // Mount() generates a synthetic directory node, and mounts the file
-// system there.  If opts is nil, the mount options of the root file
-// system are inherited.  The encompassing filesystem should pretend
-// the mount point does not exist.
+// system there. If GetAttr on root reports a type other than a
+// directory, the mount point is a file of that type instead.  If
+// opts is nil, the mount options of the root file system are
+// inherited.  The encompassing filesystem should pretend the mount
+// point does not exist.
 //
 // It returns ENOENT if the directory containing the mount point does
-// not exist, and EBUSY if the intended mount point already exists.
+// not exist, and EBUSY if the intended mount point already exists. An
+// Inode that is only cached does not count: if the kernel holds no
+// lookup count on it, and it would be dropped once forgotten, see
+// Node.Deletable, the Inode is dropped. Afterwards, Mount
+// asynchronously makes the kernel forget its entry for name, so the
+// mount shows up even if the kernel cached the name as nonexistent;
+// until that notification lands, the mount may stay hidden. As with
+// EntryNotify, no filesystem related locks should be held when
+// calling this.
 func (c *FileSystemConnector) Mount(parent *Inode, name string, root Node, opts *Options) fuse.Status {
 	node, code := c.lockMount(parent, name, root, opts)
 	if !code.Ok() {
@@ -253,55 +406,197 @@ func (c *FileSystemConnector) Mount(parent *Inode, name string, root Node, opts
 	}
 
 	node.Node().OnMount(c)
+	// Mount may be called while serving a request, so the
+	// notification, which waits for the kernel, must not block it.
+	go c.EntryNotify(parent, name)
 	return code
 }
 
+// isCached returns whether n is only a cached child, which the
+// connector may drop: the kernel holds no lookup count on it, and it
+// has no children or open files. Must be called with the treeLock held
+// for writing.
+func (c *FileSystemConnector) isCached(n *Inode) bool {
+	if n.mountPoint != nil || len(n.children) > 0 ||
+		c.inodeMap.Handle(&n.handled) != 0 || !n.Node().Deletable() {
+		return false
+	}
+	n.openFilesMutex.Lock()
+	defer n.openFilesMutex.Unlock()
+	return len(n.openFiles) == 0
+}
+
+// setRootType makes the root n of a file system a file, if GetAttr
+// on its Node reports a type other than a directory, so a single file
+// can be mounted on a file. It must be called once the mount is set
+// up, so GetAttr sees a complete Inode.
+func (n *Inode) setRootType() {
+	var attr fuse.Attr
+	if !n.fsInode.GetAttr(&attr, nil, &fuse.Context{}).Ok() {
+		return
+	}
+	if t := attr.Mode & syscall.S_IFMT; t != 0 && t != syscall.S_IFDIR {
+		n.fileType = t
+		n.children = nil
+	}
+}
+
 func (c *FileSystemConnector) lockMount(parent *Inode, name string, root Node, opts *Options) (*Inode, fuse.Status) {
 	defer c.verify()
 	parent.mount.treeLock.Lock()
 	defer parent.mount.treeLock.Unlock()
-	node := parent.children[name]
+	node := parent.children[parent.childKey(name)]
 	if node != nil {
-		return nil, fuse.EBUSY
+		if !c.isCached(node) {
+			return nil, fuse.EBUSY
+		}
+		parent.rmChild(name)
+		if len(node.parents) == 0 {
+			node.fsInode.OnForget()
+		}
 	}
 
-	node = newInode(true, root)
+	node = newInode(syscall.S_IFDIR, root)
 	if opts == nil {
 		opts = c.rootNode.mountPoint.options
 	}
 
 	node.mountFs(opts)
 	node.mount.connector = c
+	node.setRootType()
 	parent.addChild(name, node)
 
 	node.mountPoint.parentInode = parent
 	if c.debug {
-		log.Printf("Mount %T on subdir %s, parent %d", node,
+		c.logf("Mount %T on subdir %s, parent %d", node,
 			name, c.inodeMap.Handle(&parent.handled))
 	}
 	return node, fuse.OK
 }
 
+// MountInfo describes a file system mounted with Mount.
+type MountInfo struct {
+	// Path of the mount point, relative to the root of the
+	// FUSE mount.
+	Path string
+
+	// The mount point. Its Node() is the root of the mounted
+	// file system, and it can be passed to Unmount.
+	Root *Inode
+
+	// Options of the mounted file system.
+	Options *Options
+}
+
+// ListMounts returns all file systems mounted below the root, sorted
+// by path, so parents come before the mounts nested inside them.
+func (c *FileSystemConnector) ListMounts() []MountInfo {
+	// Hold the treeLocks of all mounts we visit until we are done,
+	// acquiring them from the root downwards.
+	locked := []*fileSystemMount{c.rootNode.mount}
+	c.rootNode.mount.treeLock.RLock()
+	defer func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].treeLock.RUnlock()
+		}
+	}()
+
+	type dir struct {
+		node *Inode
+		path string
+	}
+	var out []MountInfo
+	todo := []dir{{c.rootNode, ""}}
+	for len(todo) > 0 {
+		d := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		d.node.treeMu.Lock()
+		children := make([]dir, 0, len(d.node.children))
+		for k, ch := range d.node.children {
+			children = append(children, dir{ch, filepath.Join(d.path, d.node.sharedChildName(k, ch))})
+		}
+		d.node.treeMu.Unlock()
+		for _, e := range children {
+			ch, p := e.node, e.path
+			if ch.mountPoint != nil {
+				ch.mountPoint.treeLock.RLock()
+				locked = append(locked, ch.mountPoint)
+				out = append(out, MountInfo{
+					Path:    p,
+					Root:    ch,
+					Options: ch.mountPoint.options,
+				})
+			}
+			if ch.IsDir() {
+				todo = append(todo, dir{ch, p})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// Flags for UnmountFlags.
+const (
+	// UNMOUNT_LAZY detaches the file system from the tree even
+	// if it has open files, like umount -l. New lookups fail
+	// immediately, but OnUnmount is deferred until the last open
+	// file is released.
+	UNMOUNT_LAZY = 1 << iota
+
+	// UNMOUNT_FORCE first unmounts all file systems mounted
+	// below the node, rather than failing with EBUSY.
+	UNMOUNT_FORCE
+)
+
 // Unmount() tries to unmount the given inode.  It returns EINVAL if the
 // path does not exist, or is not a mount point, and EBUSY if there
 // are open files or submounts below this node.
 func (c *FileSystemConnector) Unmount(node *Inode) fuse.Status {
-	// TODO - racy.
-	if node.mountPoint == nil {
-		log.Println("not a mountpoint:", c.inodeMap.Handle(&node.handled))
+	return c.UnmountFlags(node, 0)
+}
+
+// UnmountFlags is like Unmount, but takes a combination of
+// UNMOUNT_LAZY and UNMOUNT_FORCE.
+func (c *FileSystemConnector) UnmountFlags(node *Inode, flags int) fuse.Status {
+	node.mount.treeLock.RLock()
+	mount := node.mountPoint
+	node.mount.treeLock.RUnlock()
+	if mount == nil {
+		c.logf("not a mountpoint: %d", c.inodeMap.Handle(&node.handled))
 		return fuse.EINVAL
 	}
 
+	lazy := flags&UNMOUNT_LAZY != 0
+	if flags&UNMOUNT_FORCE != 0 {
+		// Don't leave the tree half unmounted if an open file
+		// will make us fail.
+		if !lazy && hasOpenFiles(node) {
+			return fuse.EBUSY
+		}
+		node.mount.treeLock.RLock()
+		subs := node.subMounts()
+		node.mount.treeLock.RUnlock()
+		for _, sub := range subs {
+			if code := c.UnmountFlags(sub, flags); !code.Ok() {
+				return code
+			}
+		}
+	}
+
 	nodeID := c.inodeMap.Handle(&node.handled)
 
 	// Must lock parent to update tree structure.
-	parentNode := node.mountPoint.parentInode
+	parentNode := mount.parentInode
 	parentNode.mount.treeLock.Lock()
 	defer parentNode.mount.treeLock.Unlock()
 
-	mount := node.mountPoint
-	name := node.mountPoint.mountName()
-	if mount.openFiles.Count() > 0 {
+	name := mount.mountName()
+	if name == "" {
+		// Unmounted concurrently.
+		return fuse.EINVAL
+	}
+	if !lazy && mount.openFiles.Count() > 0 {
 		return fuse.EBUSY
 	}
 
@@ -314,12 +609,15 @@ func (c *FileSystemConnector) Unmount(node *Inode) fuse.Status {
 			c.inodeMap.Handle(&node.handled))
 	}
 
-	if !node.canUnmount() {
+	if !node.canUnmount(!lazy) {
 		return fuse.EBUSY
 	}
 
-	delete(parentNode.children, name)
-	node.Node().OnUnmount()
+	delete(parentNode.children, parentNode.childKey(name))
+	detached := lazy && mount.openFiles.Count() > 0
+	if !detached {
+		node.Node().OnUnmount()
+	}
 
 	parentId := c.inodeMap.Handle(&parentNode.handled)
 	if parentNode == c.rootNode {
@@ -332,9 +630,24 @@ func (c *FileSystemConnector) Unmount(node *Inode) fuse.Status {
 	// racy.
 	mount.treeLock.Unlock()
 	parentNode.mount.treeLock.Unlock()
-	code := c.server.DeleteNotify(parentId, nodeID, name)
+	code := fuse.OK
+	if c.server != nil {
+		code = c.server.DeleteNotify(parentId, nodeID, name)
+	}
 
-	if code.Ok() {
+	if detached {
+		// The kernel will not forget the mountpoint while
+		// files are open, so finish when they are closed.
+		mount.setOnIdle(func() {
+			node.Node().OnUnmount()
+			parentNode.mount.treeLock.Lock()
+			mount.treeLock.Lock()
+			mount.mountInode = nil
+			node.mountPoint = nil
+			mount.treeLock.Unlock()
+			parentNode.mount.treeLock.Unlock()
+		})
+	} else if code.Ok() {
 		delay := 100 * time.Microsecond
 
 		for {
@@ -350,7 +663,7 @@ func (c *FileSystemConnector) Unmount(node *Inode) fuse.Status {
 				// We limit the wait at one second. If
 				// it takes longer, something else is
 				// amiss, and we would be waiting forever.
-				log.Println("kernel did not issue FORGET for node on Unmount.")
+				c.logf("kernel did not issue FORGET for node on Unmount.")
 				break
 			}
 		}
@@ -359,18 +672,41 @@ func (c *FileSystemConnector) Unmount(node *Inode) fuse.Status {
 
 	parentNode.mount.treeLock.Lock()
 	mount.treeLock.Lock()
-	mount.mountInode = nil
-	node.mountPoint = nil
+	if !detached {
+		mount.mountInode = nil
+		node.mountPoint = nil
+	}
 
 	return fuse.OK
 }
 
+// hasOpenFiles returns whether the mount at node, or one mounted
+// below it, has open files.
+func hasOpenFiles(node *Inode) bool {
+	node.mount.treeLock.RLock()
+	open := node.mount.openFiles.Count() > 0
+	subs := node.subMounts()
+	node.mount.treeLock.RUnlock()
+	if open {
+		return true
+	}
+	for _, sub := range subs {
+		if hasOpenFiles(sub) {
+			return true
+		}
+	}
+	return false
+}
+
 // FileNotify notifies the kernel that data and metadata of this inode
 // has changed.  After this call completes, the kernel will issue a
 // new GetAttr requests for metadata and new Read calls for content.
 // Use negative offset for metadata-only invalidation, and zero-length
 // for invalidating all content.
 func (c *FileSystemConnector) FileNotify(node *Inode, off int64, length int64) fuse.Status {
+	node.invalidateLink()
+	node.invalidateAttr()
+
 	var nId uint64
 	if node == c.rootNode {
 		nId = fuse.FUSE_ROOT_ID
@@ -381,14 +717,17 @@ func (c *FileSystemConnector) FileNotify(node *Inode, off int64, length int64) f
 	if nId == 0 {
 		return fuse.OK
 	}
+	if c.server == nil {
+		return fuse.ENOSYS
+	}
 	return c.server.InodeNotify(nId, off, length)
 }
 
-// EntryNotify makes the kernel forget the entry data from the given
-// name from a directory.  After this call, the kernel will issue a
-// new lookup request for the given name when necessary. No filesystem
-// related locks should be held when calling this.
-func (c *FileSystemConnector) EntryNotify(node *Inode, name string) fuse.Status {
+// InvalidateAttr makes the kernel drop its cached attributes for
+// the inode, so they are fetched with GetAttr on next use. Cached
+// data is kept. No filesystem related locks should be held when
+// calling this.
+func (c *FileSystemConnector) InvalidateAttr(node *Inode) fuse.Status {
 	var nId uint64
 	if node == c.rootNode {
 		nId = fuse.FUSE_ROOT_ID
@@ -399,13 +738,166 @@ func (c *FileSystemConnector) EntryNotify(node *Inode, name string) fuse.Status
 	if nId == 0 {
 		return fuse.OK
 	}
+	// A negative offset only invalidates attributes.
+	if c.server == nil {
+		return fuse.ENOSYS
+	}
+	return c.server.InodeNotify(nId, -1, 0)
+}
+
+// StoreData puts data into the kernel's page cache for the inode,
+// starting at off. This saves the kernel a Read call for data the
+// file system already knows. If the inode is unknown to the kernel,
+// ENOENT is returned. No filesystem related locks should be held
+// when calling this.
+func (c *FileSystemConnector) StoreData(node *Inode, off int64, data []byte) fuse.Status {
+	var nId uint64
+	if node == c.rootNode {
+		nId = fuse.FUSE_ROOT_ID
+	} else {
+		nId = c.inodeMap.Handle(&node.handled)
+	}
+
+	if nId == 0 {
+		return fuse.ENOENT
+	}
+	if c.server == nil {
+		return fuse.ENOSYS
+	}
+	return c.server.InodeNotifyStoreCache(nId, off, data)
+}
+
+// RetrieveData asks the kernel for up to size bytes of the inode's
+// cached data at off. The kernel replies asynchronously, and fn is
+// called with the data it returned, which is only valid during the
+// call. fn is also called if the kernel forgets the inode before it
+// replies. If the inode is unknown to the kernel, ENOENT is
+// returned.
+func (c *FileSystemConnector) RetrieveData(node *Inode, off int64, size int, fn func(data []byte)) fuse.Status {
+	var nId uint64
+	if node == c.rootNode {
+		nId = fuse.FUSE_ROOT_ID
+	} else {
+		nId = c.inodeMap.Handle(&node.handled)
+	}
+
+	if nId == 0 {
+		return fuse.ENOENT
+	}
+	if c.server == nil {
+		return fuse.ENOSYS
+	}
+	return c.server.InodeRetrieveCache(nId, off, size, func(offset int64, data []byte) {
+		fn(data)
+	})
+}
+
+// NotifyPoll wakes up poll(2) calls waiting on the poll handle kh,
+// which was passed to File.Poll. No filesystem related locks should
+// be held when calling this.
+func (c *FileSystemConnector) NotifyPoll(kh uint64) fuse.Status {
+	if c.server == nil {
+		return fuse.ENOSYS
+	}
+	return c.server.PollNotify(kh)
+}
+
+// Prefetch tells the files open for reading on node that size bytes
+// at off are expected to be read soon, through File.Prefetch.
+func (c *FileSystemConnector) Prefetch(node *Inode, off int64, size int64) {
+	for _, f := range node.Files(0) {
+		if f.File == nil || f.OpenFlags&syscall.O_ACCMODE == syscall.O_WRONLY {
+			continue
+		}
+		f.File.Prefetch(off, size)
+	}
+}
+
+// EntryNotify makes the kernel forget the entry data from the given
+// name from a directory.  After this call, the kernel will issue a
+// new lookup request for the given name when necessary. No filesystem
+// related locks should be held when calling this.
+func (c *FileSystemConnector) EntryNotify(node *Inode, name string) fuse.Status {
+	nId := c.notifyID(node, name)
+	if nId == 0 {
+		return fuse.OK
+	}
+	if c.server == nil {
+		return fuse.ENOSYS
+	}
 	return c.server.EntryNotify(nId, name)
 }
 
-// DeleteNotify signals to the kernel that the named entry in dir for
-// the child disappeared. No filesystem related locks should be held
+// BatchEntryNotify is like EntryNotify for each of names in the
+// directory node, but it looks up the kernel's ID for node only once,
+// and skips duplicate names.
+func (c *FileSystemConnector) BatchEntryNotify(node *Inode, names []string) fuse.Status {
+	for _, name := range names {
+		if ch := node.GetChild(name); ch != nil {
+			ch.invalidateLink()
+			ch.invalidateAttr()
+		}
+	}
+	nId := c.kernelID(node)
+	if nId == 0 || len(names) == 0 {
+		return fuse.OK
+	}
+	if c.server == nil {
+		return fuse.ENOSYS
+	}
+	return c.server.BatchEntryNotify(nId, names)
+}
+
+// ExpiryNotify marks the entry for name in a directory as expired:
+// the kernel looks the name up again on the next access, but does
+// not drop the entry beforehand. Kernels that do not support this get
+// an EntryNotify instead. No filesystem related locks should be held
 // when calling this.
+func (c *FileSystemConnector) ExpiryNotify(node *Inode, name string) fuse.Status {
+	nId := c.notifyID(node, name)
+	if nId == 0 {
+		return fuse.OK
+	}
+	if c.server == nil {
+		return fuse.ENOSYS
+	}
+	return c.server.ExpiryNotify(nId, name)
+}
+
+// notifyID drops cached data for name in node, and returns the node
+// ID of node, or 0 if the kernel does not know it.
+func (c *FileSystemConnector) notifyID(node *Inode, name string) uint64 {
+	if ch := node.GetChild(name); ch != nil {
+		ch.invalidateLink()
+		ch.invalidateAttr()
+	}
+	return c.kernelID(node)
+}
+
+// kernelID returns the node ID of node, or 0 if the kernel does not
+// know it.
+func (c *FileSystemConnector) kernelID(node *Inode) uint64 {
+	if node == c.rootNode {
+		return fuse.FUSE_ROOT_ID
+	}
+	return c.inodeMap.Handle(&node.handled)
+}
+
+// DeleteNotify signals to the kernel that the named entry in dir for
+// the child disappeared. Unlike EntryNotify, the kernel then treats
+// the child as unlinked, rather than looking up the name again. If
+// child is still known under name, it is removed from dir. No filesystem related locks
+// should be held when calling this.
 func (c *FileSystemConnector) DeleteNotify(dir *Inode, child *Inode, name string) fuse.Status {
+	child.invalidateLink()
+	child.invalidateAttr()
+
+	dir.mount.treeLock.Lock()
+	if dir.children[dir.childKey(name)] == child {
+		dir.rmChild(name)
+	}
+	dir.mount.treeLock.Unlock()
+
 	var nId uint64
 
 	if dir == c.rootNode {
@@ -420,5 +912,8 @@ func (c *FileSystemConnector) DeleteNotify(dir *Inode, child *Inode, name string
 
 	chId := c.inodeMap.Handle(&child.handled)
 
+	if c.server == nil {
+		return fuse.ENOSYS
+	}
 	return c.server.DeleteNotify(nId, chId, name)
 }
@@ -0,0 +1,1627 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fusetest"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// forgetCountNode counts OnForget calls.
+type forgetCountNode struct {
+	Node
+	forgotten *int
+}
+
+func (n *forgetCountNode) OnForget() {
+	*n.forgotten++
+}
+
+func TestForgetDeepTree(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), nil)
+
+	const depth = 100000
+	forgotten := 0
+	var ids []uint64
+	parent := c.rootNode
+	for i := 0; i < depth; i++ {
+		ch := parent.NewChild(fmt.Sprintf("d%d", i), true, &forgetCountNode{
+			Node:      NewDefaultNode(),
+			forgotten: &forgotten,
+		})
+		id, _ := c.lookupUpdate(ch)
+		ids = append(ids, id)
+		parent = ch
+	}
+
+	// Forget the directories first; they are kept alive by their
+	// children.
+	for _, id := range ids[:depth-1] {
+		c.forgetUpdate(id, 1)
+	}
+	if forgotten != 0 {
+		t.Fatalf("got %d OnForget calls before forgetting the leaf, want 0", forgotten)
+	}
+	if got := c.InodeHandleCount(); got != 2 {
+		t.Errorf("got %d handles, want 2", got)
+	}
+
+	c.forgetUpdate(ids[depth-1], 1)
+	if forgotten != depth {
+		t.Errorf("got %d OnForget calls, want %d", forgotten, depth)
+	}
+	if got := len(c.rootNode.Children()); got != 0 {
+		t.Errorf("root has %d children, want 0", got)
+	}
+	if got := c.InodeHandleCount(); got != 1 {
+		t.Errorf("got %d handles, want 1", got)
+	}
+}
+
+func TestCaseInsensitiveChildren(t *testing.T) {
+	opts := NewOptions()
+	opts.CaseInsensitive = true
+	c := NewFileSystemConnector(NewDefaultNode(), opts)
+
+	// A memnode is not Deletable, so Mount does not drop it as a
+	// cached child.
+	ch := c.rootNode.NewChild("Foo", true, NewMemNodeFSRoot(""))
+	for _, name := range []string{"Foo", "foo", "FOO"} {
+		if got := c.rootNode.GetChild(name); got != ch {
+			t.Errorf("GetChild(%q): got %v, want %v", name, got, ch)
+		}
+	}
+	if got, rest := c.Node(nil, "fOo"); got != ch || len(rest) != 0 {
+		t.Errorf("Node: got %v %v, want %v", got, rest, ch)
+	}
+	if p, name := ch.Parent(); p != c.rootNode || name != "Foo" {
+		t.Errorf("Parent: got %v %q, want root \"Foo\"", p, name)
+	}
+	children := c.rootNode.Children()
+	if len(children) != 1 || children["Foo"] != ch {
+		t.Errorf("Children: got %v, want only \"Foo\"", children)
+	}
+
+	if code := c.Mount(c.rootNode, "FOO", NewDefaultNode(), nil); code != fuse.EBUSY {
+		t.Errorf("Mount: got %v, want EBUSY", code)
+	}
+	if code := c.Mount(c.rootNode, "Mnt", NewDefaultNode(), nil); !code.Ok() {
+		t.Fatalf("Mount: %v", code)
+	}
+	if mnt := c.rootNode.GetChild("mnt"); mnt == nil || mnt.mountPoint.mountName() != "Mnt" {
+		t.Errorf("mount point %v not found as \"Mnt\"", mnt)
+	}
+
+	if got := c.rootNode.RmChild("fOO"); got != ch {
+		t.Errorf("RmChild: got %v, want %v", got, ch)
+	}
+	if c.rootNode.GetChild("foo") != nil || len(ch.parents) != 0 {
+		t.Errorf("child not removed: %v %v", c.rootNode.Children(), ch.parents)
+	}
+}
+
+// TestMountOnCached checks that Mount only replaces children that
+// the connector could drop.
+func TestMountOnCached(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), nil)
+	cached := c.rootNode.NewChild("cached", true, NewDefaultNode())
+	looked := c.rootNode.NewChild("looked", true, NewDefaultNode())
+	c.lookupUpdate(looked)
+	c.rootNode.NewChild("mem", true, NewMemNodeFSRoot(""))
+
+	if code := c.Mount(c.rootNode, "cached", NewDefaultNode(), nil); !code.Ok() {
+		t.Errorf("Mount over cached child: %v", code)
+	} else if mnt := c.rootNode.GetChild("cached"); mnt == cached || mnt.mountPoint == nil {
+		t.Errorf("mount point %v, want a new mount in place of %v", mnt, cached)
+	}
+	for _, name := range []string{"looked", "mem"} {
+		if code := c.Mount(c.rootNode, name, NewDefaultNode(), nil); code != fuse.EBUSY {
+			t.Errorf("Mount over %s: got %v, want EBUSY", name, code)
+		}
+	}
+}
+
+func TestCaseSensitiveChildren(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), nil)
+	c.rootNode.NewChild("Foo", true, NewDefaultNode())
+	if got := c.rootNode.GetChild("foo"); got != nil {
+		t.Errorf("GetChild(\"foo\"): got %v, want nil", got)
+	}
+}
+
+// negativeNode caches the absence of names starting with "cache".
+type negativeNode struct {
+	Node
+}
+
+func (n *negativeNode) NegativeTimeout(name string) (time.Duration, bool) {
+	if strings.HasPrefix(name, "cache") {
+		return 3 * time.Second, true
+	}
+	return 0, false
+}
+
+func TestNegativeTimeoutNode(t *testing.T) {
+	opts := NewOptions()
+	opts.NegativeTimeout = time.Second
+	c := NewFileSystemConnector(&negativeNode{NewDefaultNode()}, opts)
+
+	for name, want := range map[string]uint64{
+		"cache-miss": 3,
+		"other":      1,
+	} {
+		var out fuse.EntryOut
+		code := c.RawFS().Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, name, &out)
+		if !code.Ok() || out.NodeId != 0 || out.EntryValid != want {
+			t.Errorf("Lookup(%q): got %v, node %d, entry_valid %d, want negative entry valid for %d",
+				name, code, out.NodeId, out.EntryValid, want)
+		}
+	}
+
+	opts.NegativeTimeout = 0
+	var out fuse.EntryOut
+	if code := c.RawFS().Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "other", &out); code != fuse.ENOENT {
+		t.Errorf("Lookup without NegativeTimeout: got %v, want ENOENT", code)
+	}
+}
+
+// attrTimeoutNode has its own attribute timeout.
+type attrTimeoutNode struct {
+	Node
+	timeout time.Duration
+}
+
+func (n *attrTimeoutNode) AttrTimeout() (time.Duration, bool) {
+	return n.timeout, true
+}
+
+func TestAttrTimeoutNode(t *testing.T) {
+	opts := NewOptions()
+	opts.AttrTimeout = time.Second
+	c := NewFileSystemConnector(NewDefaultNode(), opts)
+	c.rootNode.NewChild("live", false, &attrTimeoutNode{NewDefaultNode(), 0})
+	c.rootNode.NewChild("static", false, &attrTimeoutNode{NewDefaultNode(), time.Hour})
+	c.rootNode.NewChild("plain", false, NewDefaultNode())
+
+	for name, want := range map[string]uint64{
+		"live":   0,
+		"static": 3600,
+		"plain":  1,
+	} {
+		var entry fuse.EntryOut
+		if code := c.RawFS().Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, name, &entry); !code.Ok() {
+			t.Fatalf("Lookup(%q): %v", name, code)
+		}
+		if entry.AttrValid != want || entry.EntryValid != 1 {
+			t.Errorf("Lookup(%q): got attr_valid %d entry_valid %d, want %d, 1",
+				name, entry.AttrValid, entry.EntryValid, want)
+		}
+
+		var attr fuse.AttrOut
+		in := fuse.GetAttrIn{InHeader: fuse.InHeader{NodeId: entry.NodeId}}
+		if code := c.RawFS().GetAttr(nil, &in, &attr); !code.Ok() {
+			t.Fatalf("GetAttr(%q): %v", name, code)
+		}
+		if attr.AttrValid != want {
+			t.Errorf("GetAttr(%q): got attr_valid %d, want %d", name, attr.AttrValid, want)
+		}
+	}
+}
+
+// timesNode reports the given mtime and ctime.
+type timesNode struct {
+	Node
+	mtime, ctime uint64
+}
+
+func (n *timesNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Mtime = n.mtime
+	out.Ctime = n.ctime
+	return fuse.OK
+}
+
+func TestCtime(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), nil)
+	c.rootNode.NewChild("synthetic", false, &timesNode{NewDefaultNode(), 1000, 0})
+	c.rootNode.NewChild("explicit", false, &timesNode{NewDefaultNode(), 1000, 2000})
+
+	for name, want := range map[string]uint64{
+		"synthetic": 1000,
+		"explicit":  2000,
+	} {
+		var entry fuse.EntryOut
+		if code := c.RawFS().Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, name, &entry); !code.Ok() {
+			t.Fatalf("Lookup(%q): %v", name, code)
+		}
+		if entry.Ctime != want {
+			t.Errorf("Lookup(%q): got ctime %d, want %d", name, entry.Ctime, want)
+		}
+
+		var attr fuse.AttrOut
+		in := fuse.GetAttrIn{InHeader: fuse.InHeader{NodeId: entry.NodeId}}
+		if code := c.RawFS().GetAttr(nil, &in, &attr); !code.Ok() {
+			t.Fatalf("GetAttr(%q): %v", name, code)
+		}
+		if attr.Ctime != want {
+			t.Errorf("GetAttr(%q): got ctime %d, want %d", name, attr.Ctime, want)
+		}
+	}
+}
+
+func TestFractionalTimeouts(t *testing.T) {
+	opts := NewOptions()
+	opts.EntryTimeout = 1500 * time.Millisecond
+	opts.AttrTimeout = 100 * time.Millisecond
+	c := NewFileSystemConnector(NewDefaultNode(), opts)
+	c.rootNode.NewChild("file", false, NewDefaultNode())
+
+	var entry fuse.EntryOut
+	if code := c.RawFS().Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", &entry); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if entry.EntryValid != 1 || entry.EntryValidNsec != 5e8 {
+		t.Errorf("got entry_valid %d.%09d, want 1.5", entry.EntryValid, entry.EntryValidNsec)
+	}
+	if entry.AttrValid != 0 || entry.AttrValidNsec != 1e8 {
+		t.Errorf("got attr_valid %d.%09d, want 0.1", entry.AttrValid, entry.AttrValidNsec)
+	}
+
+	var attr fuse.AttrOut
+	in := fuse.GetAttrIn{InHeader: fuse.InHeader{NodeId: entry.NodeId}}
+	if code := c.RawFS().GetAttr(nil, &in, &attr); !code.Ok() {
+		t.Fatalf("GetAttr: %v", code)
+	}
+	if attr.AttrValid != 0 || attr.AttrValidNsec != 1e8 {
+		t.Errorf("GetAttr: got attr_valid %d.%09d, want 0.1", attr.AttrValid, attr.AttrValidNsec)
+	}
+
+	var secs uint64
+	var nsecs uint32
+	splitDuration(-time.Second, &secs, &nsecs)
+	if secs != 0 || nsecs != 0 {
+		t.Errorf("negative duration: got %d.%09d, want 0", secs, nsecs)
+	}
+}
+
+// retryFile asks for an 8 byte buffer at arg before serving the
+// ioctl.
+type retryFile struct {
+	File
+}
+
+func (f *retryFile) IoctlRetry(input []byte, cmd uint32, arg uint64, flags uint32) (in, out []fuse.IoctlIovec, retry bool) {
+	if flags&fuse.FUSE_IOCTL_RETRY != 0 {
+		return nil, nil, false
+	}
+	iov := []fuse.IoctlIovec{{Base: arg, Len: 8}}
+	return iov, iov, true
+}
+
+func (f *retryFile) Ioctl(input []byte, cmd uint32, arg uint64, flags uint32) ([]byte, int32, fuse.Status) {
+	return input, int32(len(input)), fuse.OK
+}
+
+type retryNode struct {
+	Node
+}
+
+func (n *retryNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return &retryFile{NewDefaultFile()}, fuse.OK
+}
+
+func TestIoctlUnrestricted(t *testing.T) {
+	opts := NewOptions()
+	c := NewFileSystemConnector(NewDefaultNode(), opts)
+	ch := c.rootNode.NewChild("dev", false, &retryNode{NewDefaultNode()})
+	id, _ := c.lookupUpdate(ch)
+
+	var open fuse.OpenOut
+	if code := c.RawFS().Open(nil, &fuse.OpenIn{InHeader: fuse.InHeader{NodeId: id}}, &open); !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+
+	in := &fuse.IoctlIn{
+		InHeader: fuse.InHeader{NodeId: id},
+		Fh:       open.Fh,
+		Flags:    fuse.FUSE_IOCTL_UNRESTRICTED,
+		Arg:      0x1000,
+	}
+	var out fuse.IoctlOut
+	if _, code := c.RawFS().Ioctl(nil, in, nil, &out); code != fuse.Status(syscall.ENOTTY) {
+		t.Errorf("Ioctl without UnrestrictedIoctl: got %v, want ENOTTY", code)
+	}
+
+	opts.UnrestrictedIoctl = true
+	out = fuse.IoctlOut{}
+	data, code := c.RawFS().Ioctl(nil, in, nil, &out)
+	if !code.Ok() || out.Flags&fuse.FUSE_IOCTL_RETRY == 0 || out.InIovs != 1 || out.OutIovs != 1 || len(data) != 32 {
+		t.Fatalf("Ioctl: got %v, %+v, %d bytes, want a retry with 2 iovecs", code, out, len(data))
+	}
+	if base := binary.LittleEndian.Uint64(data); base != in.Arg {
+		t.Errorf("retry base: got 0x%x, want 0x%x", base, in.Arg)
+	}
+
+	in.Flags |= fuse.FUSE_IOCTL_RETRY
+	out = fuse.IoctlOut{}
+	data, code = c.RawFS().Ioctl(nil, in, []byte("12345678"), &out)
+	if !code.Ok() || out.Flags != 0 || out.Result != 8 || string(data) != "12345678" {
+		t.Errorf("retried Ioctl: got %v, %+v, %q", code, out, data)
+	}
+}
+
+type inoNode struct {
+	Node
+	ino uint64
+}
+
+func (n *inoNode) Ino() uint64 {
+	return n.ino
+}
+
+func (n *inoNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func TestInoNode(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), NewOptions())
+	c.rootNode.NewChild("stable", false, &inoNode{NewDefaultNode(), 1 << 40})
+	c.rootNode.NewChild("plain", false, &inoNode{NewDefaultNode(), 0})
+
+	var out fuse.EntryOut
+	if code := c.RawFS().Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "stable", &out); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if out.Ino != 1<<40 || out.NodeId == out.Ino {
+		t.Errorf("Lookup: got ino %d node %d, want ino %d", out.Ino, out.NodeId, uint64(1<<40))
+	}
+
+	var attr fuse.AttrOut
+	if code := c.RawFS().GetAttr(nil, &fuse.GetAttrIn{InHeader: fuse.InHeader{NodeId: out.NodeId}}, &attr); !code.Ok() {
+		t.Fatalf("GetAttr: %v", code)
+	}
+	if attr.Ino != 1<<40 {
+		t.Errorf("GetAttr: got ino %d, want %d", attr.Ino, uint64(1<<40))
+	}
+
+	out = fuse.EntryOut{}
+	if code := c.RawFS().Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "plain", &out); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if out.Ino != out.NodeId {
+		t.Errorf("Lookup without stable ino: got ino %d, want node ID %d", out.Ino, out.NodeId)
+	}
+}
+
+type prefetchFile struct {
+	File
+	hints [][2]int64
+}
+
+func (f *prefetchFile) Prefetch(off int64, size int64) {
+	f.hints = append(f.hints, [2]int64{off, size})
+}
+
+type prefetchNode struct {
+	Node
+	file *prefetchFile
+}
+
+func (n *prefetchNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return n.file, fuse.OK
+}
+
+func TestPrefetchSequential(t *testing.T) {
+	opts := NewOptions()
+	opts.PrefetchSequential = true
+	c := NewFileSystemConnector(NewDefaultNode(), opts)
+	file := &prefetchFile{File: NewDefaultFile()}
+	ch := c.rootNode.NewChild("file", false, &prefetchNode{NewDefaultNode(), file})
+	id, _ := c.lookupUpdate(ch)
+
+	var open fuse.OpenOut
+	if code := c.RawFS().Open(nil, &fuse.OpenIn{InHeader: fuse.InHeader{NodeId: id}}, &open); !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	for _, off := range []uint64{0, 4096, 8192, 0} {
+		in := &fuse.ReadIn{InHeader: fuse.InHeader{NodeId: id}, Fh: open.Fh, Offset: off, Size: 4096}
+		c.RawFS().Read(nil, in, make([]byte, 4096))
+	}
+	c.Prefetch(ch, 1<<20, 100)
+
+	want := [][2]int64{{8192, 4096}, {12288, 4096}, {1 << 20, 100}}
+	if fmt.Sprint(file.hints) != fmt.Sprint(want) {
+		t.Errorf("got prefetch hints %v, want %v", file.hints, want)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	opts := NewOptions()
+	opts.ReadOnly = true
+	c := NewFileSystemConnector(NewDefaultNode(), opts)
+	ch := c.rootNode.NewChild("file", false, NewDefaultNode())
+	id, _ := c.lookupUpdate(ch)
+	root := fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}
+	file := fuse.InHeader{NodeId: id}
+	fs := c.RawFS()
+
+	for op, f := range map[string]func() fuse.Status{
+		"WRITE": func() fuse.Status {
+			_, code := fs.Write(nil, &fuse.WriteIn{InHeader: file}, []byte("x"))
+			return code
+		},
+		"SETATTR": func() fuse.Status {
+			in := fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{InHeader: file, Valid: fuse.FATTR_SIZE}}
+			return fs.SetAttr(nil, &in, &fuse.AttrOut{})
+		},
+		"OPEN": func() fuse.Status {
+			return fs.Open(nil, &fuse.OpenIn{InHeader: file, Flags: syscall.O_WRONLY}, &fuse.OpenOut{})
+		},
+		"CREATE": func() fuse.Status {
+			return fs.Create(nil, &fuse.CreateIn{InHeader: root}, "new", &fuse.CreateOut{})
+		},
+		"MKNOD": func() fuse.Status {
+			return fs.Mknod(nil, &fuse.MknodIn{InHeader: root}, "new", &fuse.EntryOut{})
+		},
+		"MKDIR": func() fuse.Status {
+			return fs.Mkdir(nil, &fuse.MkdirIn{InHeader: root}, "new", &fuse.EntryOut{})
+		},
+		"UNLINK": func() fuse.Status {
+			return fs.Unlink(nil, &root, "file")
+		},
+		"RMDIR": func() fuse.Status {
+			return fs.Rmdir(nil, &root, "file")
+		},
+		"RENAME": func() fuse.Status {
+			return fs.Rename(nil, &fuse.RenameIn{InHeader: root, Newdir: fuse.FUSE_ROOT_ID}, "file", "new")
+		},
+		"LINK": func() fuse.Status {
+			return fs.Link(nil, &fuse.LinkIn{InHeader: root, Oldnodeid: id}, "new", &fuse.EntryOut{})
+		},
+		"SYMLINK": func() fuse.Status {
+			return fs.Symlink(nil, &root, "file", "new", &fuse.EntryOut{})
+		},
+		"SETXATTR": func() fuse.Status {
+			return fs.SetXAttr(nil, &fuse.SetXAttrIn{InHeader: file}, "user.attr", []byte("x"))
+		},
+		"REMOVEXATTR": func() fuse.Status {
+			return fs.RemoveXAttr(nil, &file, "user.attr")
+		},
+	} {
+		if code := f(); code != fuse.EROFS {
+			t.Errorf("%s: got %v, want EROFS", op, code)
+		}
+	}
+
+	if code := fs.Open(nil, &fuse.OpenIn{InHeader: file, Flags: syscall.O_RDONLY}, &fuse.OpenOut{}); code == fuse.EROFS {
+		t.Errorf("OPEN for reading: got EROFS")
+	}
+}
+
+// handleFile records the operations it receives.
+type handleFile struct {
+	File
+	ops []string
+}
+
+func (f *handleFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.ops = append(f.ops, "read")
+	return fuse.ReadResultData(nil), fuse.OK
+}
+
+func (f *handleFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	f.ops = append(f.ops, "write")
+	return uint32(len(data)), fuse.OK
+}
+
+func (f *handleFile) Flush() fuse.Status {
+	f.ops = append(f.ops, "flush")
+	return fuse.OK
+}
+
+func (f *handleFile) Fsync(flags int) fuse.Status {
+	f.ops = append(f.ops, "fsync")
+	return fuse.OK
+}
+
+func (f *handleFile) Release() {
+	f.ops = append(f.ops, "release")
+}
+
+// handleNode returns a fresh handleFile for each Open.
+type handleNode struct {
+	Node
+	files []*handleFile
+}
+
+func (n *handleNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	f := &handleFile{File: NewDefaultFile()}
+	n.files = append(n.files, f)
+	return f, fuse.OK
+}
+
+// nilCreateNode is a directory whose Create returns no File.
+type nilCreateNode struct {
+	Node
+}
+
+func (n *nilCreateNode) Create(name string, flags uint32, mode uint32, context *fuse.Context) (File, *Inode, fuse.Status) {
+	return nil, n.Inode().NewChild(name, false, NewDefaultNode()), fuse.OK
+}
+
+func TestFileHandles(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), NewOptions())
+	node := &handleNode{Node: NewDefaultNode()}
+	ch := c.rootNode.NewChild("file", false, node)
+	id, _ := c.lookupUpdate(ch)
+	hdr := fuse.InHeader{NodeId: id}
+	fs := c.RawFS()
+
+	var fhs []uint64
+	for i := 0; i < 2; i++ {
+		var out fuse.OpenOut
+		if code := fs.Open(nil, &fuse.OpenIn{InHeader: hdr, Flags: syscall.O_RDWR}, &out); !code.Ok() {
+			t.Fatalf("Open: %v", code)
+		}
+		fhs = append(fhs, out.Fh)
+	}
+	if fhs[0] == fhs[1] {
+		t.Fatalf("got the same handle %d twice", fhs[0])
+	}
+
+	// Use the handles in reverse order, so each File sees a
+	// different sequence.
+	fs.Read(nil, &fuse.ReadIn{InHeader: hdr, Fh: fhs[1], Size: 10}, make([]byte, 10))
+	fs.Write(nil, &fuse.WriteIn{InHeader: hdr, Fh: fhs[1]}, []byte("x"))
+	fs.Read(nil, &fuse.ReadIn{InHeader: hdr, Fh: fhs[0], Size: 10}, make([]byte, 10))
+	fs.Fsync(nil, &fuse.FsyncIn{InHeader: hdr, Fh: fhs[0]})
+	for _, fh := range fhs {
+		fs.Flush(nil, &fuse.FlushIn{InHeader: hdr, Fh: fh})
+		fs.Release(nil, &fuse.ReleaseIn{InHeader: hdr, Fh: fh})
+	}
+
+	want := []string{
+		"[read fsync flush release]",
+		"[read write flush release]",
+	}
+	for i, f := range node.files {
+		if got := fmt.Sprint(f.ops); got != want[i] {
+			t.Errorf("file %d: got %s, want %s", i, got, want[i])
+		}
+	}
+	if len(ch.Files(0)) != 0 {
+		t.Errorf("got %d open files after Release, want 0", len(ch.Files(0)))
+	}
+
+	fs = NewFileSystemConnector(&nilCreateNode{NewDefaultNode()}, NewOptions()).RawFS()
+	var out fuse.CreateOut
+	in := fuse.CreateIn{InHeader: fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}}
+	if code := fs.Create(nil, &in, "new", &out); !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	if out.Fh != 0 {
+		t.Errorf("Create without File: got Fh %d, want 0", out.Fh)
+	}
+	fs.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: out.NodeId}, Fh: out.Fh})
+}
+
+func TestExportSupport(t *testing.T) {
+	opts := NewOptions()
+	opts.ExportSupport = true
+	c := NewFileSystemConnector(NewDefaultNode(), opts)
+	ch := c.rootNode.NewChild("file", false, NewDefaultNode())
+	fs := c.RawFS()
+	root := fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}
+
+	var out fuse.EntryOut
+	if code := fs.Lookup(nil, &root, "file", &out); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	id, gen := out.NodeId, out.Generation
+	fs.Forget(id, 1)
+	if got := c.LookupHandle(id, gen); got != ch {
+		t.Fatalf("LookupHandle after Forget: got %v, want %v", got, ch)
+	}
+	if c.LookupHandle(id, gen+1) != nil {
+		t.Errorf("LookupHandle with wrong generation: got an Inode")
+	}
+
+	// The kernel resolves file handles by looking up "." and "..".
+	hdr := fuse.InHeader{NodeId: id}
+	out = fuse.EntryOut{}
+	if code := fs.Lookup(nil, &hdr, ".", &out); !code.Ok() || out.NodeId != id || out.Generation != gen {
+		t.Fatalf("Lookup(.): got %v, node %d gen %d, want node %d gen %d", code, out.NodeId, out.Generation, id, gen)
+	}
+	fs.Forget(id, 1)
+	out = fuse.EntryOut{}
+	if code := fs.Lookup(nil, &hdr, "..", &out); !code.Ok() || out.NodeId != fuse.FUSE_ROOT_ID {
+		t.Errorf("Lookup(..): got %v, node %d, want root", code, out.NodeId)
+	}
+	bogus := fuse.InHeader{NodeId: 1 << 40}
+	if code := fs.Lookup(nil, &bogus, ".", &out); code != fuse.Status(syscall.ESTALE) {
+		t.Errorf("Lookup(.) of unknown node: got %v, want ESTALE", code)
+	}
+
+	c.Expire(ch)
+	if c.LookupHandle(id, gen) != nil {
+		t.Errorf("LookupHandle after Expire: got an Inode")
+	}
+	if c.rootNode.GetChild("file") != nil {
+		t.Errorf("child still present after Expire")
+	}
+}
+
+func TestLookupDotWithoutExport(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), NewOptions())
+	fs := c.RawFS()
+	root := fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}
+
+	// Without export support, "." is an ordinary name for the
+	// file system to look up.
+	var out fuse.EntryOut
+	if code := fs.Lookup(nil, &root, ".", &out); code != fuse.ENOENT {
+		t.Errorf("Lookup(.): got %v, want ENOENT", code)
+	}
+}
+
+// fileDirNode has a "file" child, created on lookup.
+type fileDirNode struct {
+	Node
+}
+
+func (n *fileDirNode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*Inode, fuse.Status) {
+	if name != "file" {
+		return nil, fuse.ENOENT
+	}
+	ch := n.Inode().GetChild(name)
+	if ch == nil {
+		ch = n.Inode().NewChild(name, false, NewDefaultNode())
+	}
+	return ch, ch.Node().GetAttr(out, nil, context)
+}
+
+func TestGenerationOnReuse(t *testing.T) {
+	c := NewFileSystemConnector(&fileDirNode{NewDefaultNode()}, nil)
+	fs := c.RawFS()
+	root := fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}
+
+	var first fuse.EntryOut
+	if code := fs.Lookup(nil, &root, "file", &first); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if first.Generation == 0 {
+		t.Errorf("Lookup: got generation 0")
+	}
+
+	// Once forgotten, the node ID is free, and the next lookup
+	// reuses it with a new generation.
+	fs.Forget(first.NodeId, 1)
+	var second fuse.EntryOut
+	if code := fs.Lookup(nil, &root, "file", &second); !code.Ok() {
+		t.Fatalf("Lookup after Forget: %v", code)
+	}
+	if second.NodeId != first.NodeId {
+		t.Fatalf("got node %d, want reused node %d", second.NodeId, first.NodeId)
+	}
+	if second.Generation <= first.Generation {
+		t.Errorf("got generation %d, want more than %d", second.Generation, first.Generation)
+	}
+}
+
+// typeNode reports the given mode, and reads as a symlink to "target".
+type typeNode struct {
+	Node
+	mode uint32
+}
+
+func (n *typeNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	out.Mode = n.mode
+	return fuse.OK
+}
+
+func (n *typeNode) Readlink(context *fuse.Context) ([]byte, fuse.Status) {
+	return []byte("target"), fuse.OK
+}
+
+func TestFileType(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), NewOptions())
+	link := c.rootNode.NewChild("link", false, &typeNode{NewDefaultNode(), syscall.S_IFLNK | 0777})
+	file := c.rootNode.NewChild("file", false, &typeNode{NewDefaultNode(), syscall.S_IFREG | 0644})
+	fs := c.RawFS()
+
+	if got := c.rootNode.FileType(); got != syscall.S_IFDIR {
+		t.Errorf("root: got type %o, want S_IFDIR", got)
+	}
+	if got := file.FileType(); got != 0 {
+		t.Errorf("before Lookup: got type %o, want 0", got)
+	}
+
+	ids := map[string]uint64{}
+	for _, name := range []string{"link", "file"} {
+		var out fuse.EntryOut
+		if code := fs.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, name, &out); !code.Ok() {
+			t.Fatalf("Lookup(%s): %v", name, code)
+		}
+		ids[name] = out.NodeId
+	}
+	if got := link.FileType(); got != syscall.S_IFLNK {
+		t.Errorf("link: got type %o, want S_IFLNK", got)
+	}
+	if got := file.FileType(); got != syscall.S_IFREG {
+		t.Errorf("file: got type %o, want S_IFREG", got)
+	}
+
+	if _, code := fs.Readlink(nil, &fuse.InHeader{NodeId: ids["link"]}); !code.Ok() {
+		t.Errorf("Readlink(link): %v", code)
+	}
+	if _, code := fs.Readlink(nil, &fuse.InHeader{NodeId: ids["file"]}); code != fuse.EINVAL {
+		t.Errorf("Readlink(file): got %v, want EINVAL", code)
+	}
+	in := fuse.OpenIn{InHeader: fuse.InHeader{NodeId: ids["file"]}}
+	if code := fs.OpenDir(nil, &in, &fuse.OpenOut{}); code != fuse.ENOTDIR {
+		t.Errorf("OpenDir(file): got %v, want ENOTDIR", code)
+	}
+}
+
+func TestStaleNodeID(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), NewOptions())
+	c.rootNode.NewChild("file", false, &typeNode{NewDefaultNode(), syscall.S_IFREG | 0644})
+	fs := c.RawFS()
+
+	var out fuse.EntryOut
+	if code := fs.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", &out); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	id := out.NodeId
+	fs.Forget(id, 1)
+
+	for _, nodeID := range []uint64{id, 1 << 40} {
+		in := fuse.GetAttrIn{InHeader: fuse.InHeader{NodeId: nodeID}}
+		if code := fs.GetAttr(nil, &in, &fuse.AttrOut{}); code != fuse.ESTALE {
+			t.Errorf("GetAttr(%d): got %v, want ESTALE", nodeID, code)
+		}
+		if _, code := fs.Readlink(nil, &in.InHeader); code != fuse.ESTALE {
+			t.Errorf("Readlink(%d): got %v, want ESTALE", nodeID, code)
+		}
+		// Must not crash.
+		fs.Forget(nodeID, 1)
+	}
+}
+
+// TestStaleFileHandle checks that unknown file handles fail with
+// EBADF rather than crashing the connector.
+func TestStaleFileHandle(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), NewOptions())
+	fs := c.RawFS()
+
+	for _, fh := range []uint64{1, 1 << 40} {
+		read := fuse.ReadIn{InHeader: fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, Fh: fh}
+		if code := fs.ReadDir(nil, &read, fuse.NewDirEntryList(make([]byte, 4096), 0)); code != fuse.EBADF {
+			t.Errorf("ReadDir(fh %d): got %v, want EBADF", fh, code)
+		}
+		if code := fs.ReadDirPlus(nil, &read, fuse.NewDirEntryList(make([]byte, 4096), 0)); code != fuse.EBADF {
+			t.Errorf("ReadDirPlus(fh %d): got %v, want EBADF", fh, code)
+		}
+		set := fuse.SetAttrIn{}
+		set.NodeId = fuse.FUSE_ROOT_ID
+		set.Valid = fuse.FATTR_FH | fuse.FATTR_MODE
+		set.Fh = fh
+		if code := fs.SetAttr(nil, &set, &fuse.AttrOut{}); code != fuse.EBADF {
+			t.Errorf("SetAttr(fh %d): got %v, want EBADF", fh, code)
+		}
+		// Must not crash.
+		release := fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, Fh: fh}
+		fs.Release(nil, &release)
+		fs.ReleaseDir(nil, &release)
+	}
+}
+
+func TestInodePath(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), NewOptions())
+	a := c.rootNode.NewChild("a", true, NewDefaultNode())
+	file := a.NewChild("file", false, NewDefaultNode())
+	other := c.rootNode.NewChild("other", true, NewDefaultNode())
+
+	sub := NewDefaultNode()
+	if code := c.Mount(a, "mnt", sub, nil); !code.Ok() {
+		t.Fatalf("Mount: %v", code)
+	}
+	inMount := sub.Inode().NewChild("x", false, NewDefaultNode())
+
+	for _, tc := range []struct {
+		node *Inode
+		want string
+	}{
+		{c.rootNode, ""},
+		{a, "a"},
+		{file, "a/file"},
+		{sub.Inode(), "a/mnt"},
+		{inMount, "a/mnt/x"},
+	} {
+		if got, ok := tc.node.Path(); !ok || got != tc.want {
+			t.Errorf("Path: got %q, %v, want %q", got, ok, tc.want)
+		}
+	}
+
+	// With hard links, the smallest name wins.
+	other.AddChild("link", file)
+	if got, _ := file.Path(); got != "a/file" {
+		t.Errorf("got %q, want a/file", got)
+	}
+	other.AddChild("alias", file)
+	if got, _ := file.Path(); got != "other/alias" {
+		t.Errorf("got %q, want other/alias", got)
+	}
+
+	a.RmChild("file")
+	other.RmChild("link")
+	other.RmChild("alias")
+	if got, ok := file.Path(); ok {
+		t.Errorf("Path after removal: got %q, want not ok", got)
+	}
+}
+
+// TestRawFSWithoutServer drives the connector directly, as a bridge
+// to another platform would.
+func TestRawFSWithoutServer(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), nil)
+	raw := c.RawFS()
+	raw.Init(nil)
+	ch := c.rootNode.NewChild("dir", true, NewDefaultNode())
+
+	var out fuse.EntryOut
+	if code := raw.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "dir", &out); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	if out.NodeId == 0 || out.Attr.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		t.Errorf("Lookup: got node %d, mode %o, want a directory", out.NodeId, out.Attr.Mode)
+	}
+
+	if code := c.EntryNotify(c.rootNode, "dir"); code != fuse.ENOSYS {
+		t.Errorf("EntryNotify: got %v, want ENOSYS", code)
+	}
+	if code := c.FileNotify(ch, 0, 0); code != fuse.ENOSYS {
+		t.Errorf("FileNotify: got %v, want ENOSYS", code)
+	}
+	if major, minor := c.ProtocolVersion(); major != 0 || minor != 0 {
+		t.Errorf("ProtocolVersion: got %d.%d, want 0.0", major, minor)
+	}
+
+	raw.Forget(out.NodeId, 1)
+	if c.LookupHandle(out.NodeId, out.Generation) != nil {
+		t.Errorf("node %d still known after Forget", out.NodeId)
+	}
+}
+
+// setAttrNode records the SETATTR calls it gets.
+type setAttrNode struct {
+	Node
+	valid uint32
+}
+
+func (n *setAttrNode) SetAttr(file File, input *fuse.SetAttrIn, context *fuse.Context) fuse.Status {
+	n.valid = input.Valid
+	return fuse.OK
+}
+
+// utimensNode records Utimens calls.
+type utimensNode struct {
+	Node
+	calls        int
+	atime, mtime *time.Time
+}
+
+func (n *utimensNode) Utimens(file File, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	n.calls++
+	n.atime, n.mtime = atime, mtime
+	return fuse.OK
+}
+
+func (n *utimensNode) Chmod(file File, perms uint32, context *fuse.Context) fuse.Status {
+	return fuse.EPERM
+}
+
+func TestSetAttrNode(t *testing.T) {
+	node := &setAttrNode{Node: NewDefaultNode()}
+	c := NewFileSystemConnector(NewDefaultNode(), nil)
+	ch := c.rootNode.NewChild("file", false, node)
+	id, _ := c.lookupUpdate(ch)
+
+	valid := uint32(fuse.FATTR_MODE | fuse.FATTR_MTIME | fuse.FATTR_MTIME_NOW)
+	in := fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{InHeader: fuse.InHeader{NodeId: id}, Valid: valid, Mode: 0600}}
+	if code := c.RawFS().SetAttr(nil, &in, &fuse.AttrOut{}); !code.Ok() {
+		t.Fatalf("SetAttr: %v", code)
+	}
+	if node.valid != valid {
+		t.Errorf("got Valid %x, want %x", node.valid, valid)
+	}
+}
+
+func TestSetAttrTimes(t *testing.T) {
+	node := &utimensNode{Node: NewDefaultNode()}
+	c := NewFileSystemConnector(NewDefaultNode(), nil)
+	ch := c.rootNode.NewChild("file", false, node)
+	id, _ := c.lookupUpdate(ch)
+	setattr := func(in fuse.SetAttrInCommon) fuse.Status {
+		in.NodeId = id
+		return c.RawFS().SetAttr(nil, &fuse.SetAttrIn{SetAttrInCommon: in}, &fuse.AttrOut{})
+	}
+
+	// An explicit atime leaves mtime alone.
+	if code := setattr(fuse.SetAttrInCommon{Valid: fuse.FATTR_ATIME, Atime: 1500000000}); !code.Ok() {
+		t.Fatalf("SetAttr: %v", code)
+	}
+	if node.atime == nil || node.atime.Unix() != 1500000000 || node.mtime != nil {
+		t.Errorf("explicit atime: got %v, %v", node.atime, node.mtime)
+	}
+
+	// _NOW uses the current time, not the timestamp in the request.
+	before := time.Now()
+	if code := setattr(fuse.SetAttrInCommon{Valid: fuse.FATTR_MTIME | fuse.FATTR_MTIME_NOW, Mtime: 1500000000}); !code.Ok() {
+		t.Fatalf("SetAttr: %v", code)
+	}
+	if node.atime != nil || node.mtime == nil || node.mtime.Before(before) {
+		t.Errorf("mtime now: got %v, %v, want nil, >= %v", node.atime, node.mtime, before)
+	}
+
+	// Attributes other than the times do not call Utimens.
+	node.calls = 0
+	if code := setattr(fuse.SetAttrInCommon{Valid: fuse.FATTR_MODE, Mode: 0600}); code != fuse.EPERM {
+		t.Errorf("chmod: got %v, want EPERM", code)
+	}
+	if node.calls != 0 {
+		t.Errorf("chmod called Utimens %d times", node.calls)
+	}
+}
+
+func TestRetrieveAfterForget(t *testing.T) {
+	c := NewFileSystemConnector(&fileDirNode{NewDefaultNode()}, nil)
+	k, err := fusetest.NewKernel(c.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("NewKernel: %v", err)
+	}
+	defer k.Close()
+
+	entry, code, err := k.Lookup(fuse.FUSE_ROOT_ID, "file")
+	if err != nil || !code.Ok() {
+		t.Fatalf("Lookup: %v, %v", code, err)
+	}
+	ch := c.rootNode.GetChild("file")
+	got := make(chan []byte, 1)
+	if code := c.RetrieveData(ch, 0, 5, func(data []byte) {
+		got <- append([]byte{}, data...)
+	}); !code.Ok() {
+		t.Fatalf("RetrieveData: %v", code)
+	}
+	msg := <-k.Messages()
+	if code := fusetest.Status(msg); code != fuse.NOTIFY_RETRIEVE {
+		t.Fatalf("got message %v, want NOTIFY_RETRIEVE", code)
+	}
+	retrieve := *(*fuse.NotifyRetrieveOut)(unsafe.Pointer(&msg[unsafe.Sizeof(fuse.OutHeader{})]))
+
+	// The kernel forgets the inode before it answers.
+	if err := k.Forget(entry.NodeId, 1); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.InodeHandleCount() > 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := c.InodeHandleCount(); n > 1 {
+		t.Fatalf("got %d inodes after Forget, want 1", n)
+	}
+
+	// Replies for unknown retrieves are dropped.
+	if err := k.NotifyReply(retrieve.NotifyUnique+1, retrieve.Nodeid, 0, []byte("bogus")); err != nil {
+		t.Fatalf("NotifyReply: %v", err)
+	}
+	if err := k.NotifyReply(retrieve.NotifyUnique, retrieve.Nodeid, 0, []byte("hello")); err != nil {
+		t.Fatalf("NotifyReply: %v", err)
+	}
+	select {
+	case data := <-got:
+		if string(data) != "hello" {
+			t.Errorf("got %q, want %q", data, "hello")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RetrieveData callback not called")
+	}
+}
+
+func TestBatchEntryNotify(t *testing.T) {
+	c := NewFileSystemConnector(NewDefaultNode(), nil)
+	k, err := fusetest.NewKernel(c.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("NewKernel: %v", err)
+	}
+	defer k.Close()
+
+	// The kernel does not know dir, so it needs no notifications.
+	dir := c.rootNode.NewChild("dir", true, NewDefaultNode())
+	if code := c.BatchEntryNotify(dir, []string{"x"}); !code.Ok() {
+		t.Fatalf("BatchEntryNotify: %v", code)
+	}
+	if code := c.BatchEntryNotify(c.rootNode, []string{"a", "b", "a", "c"}); !code.Ok() {
+		t.Fatalf("BatchEntryNotify: %v", code)
+	}
+	// Marks the end of the batch.
+	if code := c.EntryNotify(c.rootNode, "end"); !code.Ok() {
+		t.Fatalf("EntryNotify: %v", code)
+	}
+
+	var got []string
+	for len(got) == 0 || got[len(got)-1] != "end" {
+		msg := <-k.Messages()
+		if code := fusetest.Status(msg); code != fuse.NOTIFY_INVAL_ENTRY {
+			t.Fatalf("got message %v, want NOTIFY_INVAL_ENTRY", code)
+		}
+		off := unsafe.Sizeof(fuse.OutHeader{})
+		entry := (*fuse.NotifyInvalEntryOut)(unsafe.Pointer(&msg[off]))
+		if entry.Parent != fuse.FUSE_ROOT_ID {
+			t.Errorf("got parent %d, want %d", entry.Parent, fuse.FUSE_ROOT_ID)
+		}
+		off += unsafe.Sizeof(fuse.NotifyInvalEntryOut{})
+		got = append(got, string(msg[off:off+uintptr(entry.NameLen)]))
+	}
+	if want := []string{"a", "b", "c", "end"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got notifications for %v, want %v", got, want)
+	}
+}
+
+// benchmarkDisjointDirs runs op from parallel goroutines, each on
+// its own directory of a single mount, so they only share the
+// mount's locks.
+func benchmarkDisjointDirs(b *testing.B, op func(c *FileSystemConnector, dirID uint64, i int) fuse.Status) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	root := NewMemNodeFSRoot(dir + "/")
+	c := NewFileSystemConnector(root, nil)
+	ctx := &fuse.Context{}
+
+	const dirs = 64
+	var ids []uint64
+	for i := 0; i < dirs; i++ {
+		d, _ := root.Mkdir(fmt.Sprintf("d%d", i), 0755, ctx)
+		f, _, _ := d.Node().Create("file", uint32(os.O_WRONLY), 0644, ctx)
+		f.Release()
+		id, _ := c.lookupUpdate(d)
+		ids = append(ids, id)
+	}
+
+	var next int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		dirID := ids[int(atomic.AddInt64(&next, 1))%dirs]
+		for i := 0; pb.Next(); i++ {
+			if code := op(c, dirID, i); !code.Ok() {
+				b.Error(code)
+				return
+			}
+		}
+	})
+}
+
+// Lookups only take the tree lock for reading.
+func BenchmarkLookupDisjointDirs(b *testing.B) {
+	benchmarkDisjointDirs(b, func(c *FileSystemConnector, dirID uint64, i int) fuse.Status {
+		out := &fuse.EntryOut{}
+		code := c.RawFS().Lookup(nil, &fuse.InHeader{NodeId: dirID}, "file", out)
+		c.RawFS().Forget(out.NodeId, 1)
+		return code
+	})
+}
+
+// Mknod and Unlink add and remove a single child; they only lock
+// the directory they change.
+func BenchmarkMknodUnlinkDisjointDirs(b *testing.B) {
+	benchmarkDisjointDirs(b, func(c *FileSystemConnector, dirID uint64, i int) fuse.Status {
+		name := fmt.Sprintf("n%d", i)
+		out := &fuse.EntryOut{}
+		in := &fuse.MknodIn{InHeader: fuse.InHeader{NodeId: dirID}, Mode: syscall.S_IFIFO | 0644}
+		if code := c.RawFS().Mknod(nil, in, name, out); !code.Ok() {
+			return code
+		}
+		code := c.RawFS().Unlink(nil, &fuse.InHeader{NodeId: dirID}, name)
+		c.RawFS().Forget(out.NodeId, 1)
+		return code
+	})
+}
+
+func TestConcurrentDisjointDirs(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	root := NewMemNodeFSRoot(dir + "/")
+	c := NewFileSystemConnector(root, nil)
+	ctx := &fuse.Context{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		d, _ := root.Mkdir(fmt.Sprintf("d%d", i), 0755, ctx)
+		id, _ := c.lookupUpdate(d)
+		wg.Add(1)
+		go func(d *Inode, id uint64) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("n%d", j)
+				out := &fuse.EntryOut{}
+				in := &fuse.MknodIn{InHeader: fuse.InHeader{NodeId: id}, Mode: syscall.S_IFIFO | 0644}
+				if code := c.RawFS().Mknod(nil, in, name, out); !code.Ok() {
+					t.Errorf("Mknod: %v", code)
+					return
+				}
+				if ch := d.GetChild(name); ch == nil {
+					t.Errorf("GetChild(%q) = nil", name)
+				} else if _, ok := ch.Path(); !ok {
+					t.Errorf("Path(%q) failed", name)
+				}
+				d.Children()
+				if code := c.RawFS().Unlink(nil, &fuse.InHeader{NodeId: id}, name); !code.Ok() {
+					t.Errorf("Unlink: %v", code)
+				}
+				c.RawFS().Forget(out.NodeId, 1)
+			}
+		}(d, id)
+	}
+	wg.Wait()
+}
+
+// getAttrCountNode counts GetAttr calls.
+type getAttrCountNode struct {
+	Node
+	calls int
+}
+
+func (n *getAttrCountNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	n.calls++
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func (n *getAttrCountNode) Chmod(file File, perms uint32, context *fuse.Context) fuse.Status {
+	return fuse.OK
+}
+
+func TestCacheAttributes(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		opts  Options
+		calls []int
+	}{
+		{"cached", Options{CacheAttributes: true, AttrTimeout: time.Hour}, []int{1, 1, 3, 3, 4}},
+		{"uncached", Options{AttrTimeout: time.Hour}, []int{1, 2, 4, 5, 6}},
+		{"zero timeout", Options{CacheAttributes: true}, []int{1, 2, 4, 5, 6}},
+	} {
+		root := NewDefaultNode()
+		c := NewFileSystemConnector(root, &tc.opts)
+		node := &getAttrCountNode{Node: NewDefaultNode()}
+		root.Inode().NewChild("file", false, node)
+
+		entry := &fuse.EntryOut{}
+		if code := c.RawFS().Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", entry); !code.Ok() {
+			t.Fatalf("%s: Lookup: %v", tc.name, code)
+		}
+		getAttr := func() {
+			if code := c.RawFS().GetAttr(nil, &fuse.GetAttrIn{InHeader: fuse.InHeader{NodeId: entry.NodeId}}, &fuse.AttrOut{}); !code.Ok() {
+				t.Fatalf("%s: GetAttr: %v", tc.name, code)
+			}
+		}
+		var calls []int
+		calls = append(calls, node.calls)
+
+		getAttr()
+		calls = append(calls, node.calls)
+
+		// SetAttr calls GetAttr for its reply, and drops the
+		// cache.
+		in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{InHeader: fuse.InHeader{NodeId: entry.NodeId}, Valid: fuse.FATTR_MODE, Mode: 0600}}
+		if code := c.RawFS().SetAttr(nil, in, &fuse.AttrOut{}); !code.Ok() {
+			t.Fatalf("%s: SetAttr: %v", tc.name, code)
+		}
+		getAttr()
+		calls = append(calls, node.calls)
+
+		getAttr()
+		calls = append(calls, node.calls)
+
+		c.FileNotify(node.Inode(), -1, 0)
+		getAttr()
+		calls = append(calls, node.calls)
+
+		if !reflect.DeepEqual(calls, tc.calls) {
+			t.Errorf("%s: got GetAttr calls %v, want %v", tc.name, calls, tc.calls)
+		}
+	}
+}
+
+// writeLogNode records the writes that reach it.
+type writeLogNode struct {
+	Node
+	writes []string
+	fail   fuse.Status
+}
+
+func (n *writeLogNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return NewDevNullFile(), fuse.OK
+}
+
+func (n *writeLogNode) Write(file File, data []byte, off int64, context *fuse.Context) (uint32, fuse.Status) {
+	if !n.fail.Ok() {
+		return 0, n.fail
+	}
+	n.writes = append(n.writes, fmt.Sprintf("%d:%s", off, data))
+	return uint32(len(data)), fuse.OK
+}
+
+func (n *writeLogNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func TestWriteBuffer(t *testing.T) {
+	root := NewDefaultNode()
+	c := NewFileSystemConnector(root, &Options{WriteBufferSize: 10})
+	node := &writeLogNode{Node: NewDefaultNode()}
+	root.Inode().NewChild("file", false, node)
+	fs := c.RawFS()
+
+	entry := &fuse.EntryOut{}
+	if code := fs.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", entry); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	header := fuse.InHeader{NodeId: entry.NodeId}
+	open := &fuse.OpenOut{}
+	if code := fs.Open(nil, &fuse.OpenIn{InHeader: header, Flags: uint32(os.O_WRONLY)}, open); !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	write := func(off uint64, data string) fuse.Status {
+		n, code := fs.Write(nil, &fuse.WriteIn{InHeader: header, Fh: open.Fh, Offset: off, Size: uint32(len(data))}, []byte(data))
+		if code.Ok() && int(n) != len(data) {
+			t.Errorf("Write(%d, %q): wrote %d", off, data, n)
+		}
+		return code
+	}
+	check := func(what string, want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(node.writes, want) {
+			t.Errorf("%s: got writes %q, want %q", what, node.writes, want)
+		}
+		node.writes = nil
+	}
+
+	write(0, "ab")
+	write(2, "cd")
+	check("contiguous")
+	if code := fs.Flush(nil, &fuse.FlushIn{InHeader: header, Fh: open.Fh}); !code.Ok() {
+		t.Fatalf("Flush: %v", code)
+	}
+	check("flush", "0:abcd")
+
+	write(10, "x")
+	write(20, "y")
+	check("discontiguous", "10:x")
+
+	write(21, "0123456789")
+	check("large", "20:y", "21:0123456789")
+
+	write(40, "0123456")
+	write(47, "7890")
+	check("overflow", "40:0123456")
+	if code := fs.Fsync(nil, &fuse.FsyncIn{InHeader: header, Fh: open.Fh}); !code.Ok() {
+		t.Fatalf("Fsync: %v", code)
+	}
+	check("fsync", "47:7890")
+
+	write(50, "q")
+	if code := fs.GetAttr(nil, &fuse.GetAttrIn{InHeader: header}, &fuse.AttrOut{}); !code.Ok() {
+		t.Fatalf("GetAttr: %v", code)
+	}
+	check("getattr", "50:q")
+
+	write(60, "z")
+	node.fail = fuse.EIO
+	if code := fs.Flush(nil, &fuse.FlushIn{InHeader: header, Fh: open.Fh}); code != fuse.EIO {
+		t.Errorf("Flush after failed write: got %v, want EIO", code)
+	}
+	node.fail = fuse.OK
+	if code := fs.Flush(nil, &fuse.FlushIn{InHeader: header, Fh: open.Fh}); !code.Ok() {
+		t.Errorf("second Flush: %v", code)
+	}
+
+	write(70, "a")
+	node.fail = fuse.EIO
+	fs.Read(nil, &fuse.ReadIn{InHeader: header, Fh: open.Fh, Size: 1}, make([]byte, 1))
+	node.fail = fuse.OK
+	if code := write(80, "b"); code != fuse.EIO {
+		t.Errorf("Write after failed flush: got %v, want EIO", code)
+	}
+	if code := write(80, "b"); !code.Ok() {
+		t.Errorf("second Write: %v", code)
+	}
+	check("errors")
+
+	fs.Release(nil, &fuse.ReleaseIn{InHeader: header, Fh: open.Fh})
+	check("release", "80:b")
+}
+
+// sizeNode grows with the writes that reach it.
+type sizeNode struct {
+	writeLogNode
+	size uint64
+}
+
+func (n *sizeNode) Write(file File, data []byte, off int64, context *fuse.Context) (uint32, fuse.Status) {
+	if end := uint64(off) + uint64(len(data)); end > n.size {
+		n.size = end
+	}
+	return n.writeLogNode.Write(file, data, off, context)
+}
+
+func (n *sizeNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = n.size
+	return fuse.OK
+}
+
+// TestWriteBufferCachedAttr checks that buffered writes count in
+// the cached size, also if a LOOKUP fetches the attributes.
+func TestWriteBufferCachedAttr(t *testing.T) {
+	root := NewDefaultNode()
+	c := NewFileSystemConnector(root, &Options{
+		WriteBufferSize: 10,
+		CacheAttributes: true,
+		AttrTimeout:     time.Hour,
+	})
+	node := &sizeNode{writeLogNode: writeLogNode{Node: NewDefaultNode()}}
+	root.Inode().NewChild("file", false, node)
+	fs := c.RawFS()
+
+	entry := &fuse.EntryOut{}
+	lookup := func() uint64 {
+		t.Helper()
+		if code := fs.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", entry); !code.Ok() {
+			t.Fatalf("Lookup: %v", code)
+		}
+		return entry.Size
+	}
+	lookup()
+	header := fuse.InHeader{NodeId: entry.NodeId}
+	getAttr := func() uint64 {
+		t.Helper()
+		out := &fuse.AttrOut{}
+		if code := fs.GetAttr(nil, &fuse.GetAttrIn{InHeader: header}, out); !code.Ok() {
+			t.Fatalf("GetAttr: %v", code)
+		}
+		return out.Size
+	}
+	open := &fuse.OpenOut{}
+	if code := fs.Open(nil, &fuse.OpenIn{InHeader: header, Flags: uint32(os.O_WRONLY)}, open); !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+	write := func(off uint64, data string) {
+		t.Helper()
+		if _, code := fs.Write(nil, &fuse.WriteIn{InHeader: header, Fh: open.Fh, Offset: off, Size: uint32(len(data))}, []byte(data)); !code.Ok() {
+			t.Fatalf("Write: %v", code)
+		}
+	}
+
+	write(0, "ab")
+	if got := lookup(); got != 2 {
+		t.Errorf("Lookup after buffered write: got size %d, want 2", got)
+	}
+	if got := getAttr(); got != 2 {
+		t.Errorf("GetAttr after Lookup: got size %d, want 2", got)
+	}
+
+	write(2, "cd")
+	lookup()
+	if got := getAttr(); got != 4 {
+		t.Errorf("GetAttr after second write: got size %d, want 4", got)
+	}
+}
+
+// truncateFile records Truncate calls.
+type truncateFile struct {
+	File
+	size int64
+}
+
+func (f *truncateFile) Truncate(size uint64) fuse.Status {
+	f.size = int64(size)
+	return fuse.OK
+}
+
+type truncateFileNode struct {
+	Node
+	file *truncateFile
+}
+
+func (n *truncateFileNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return n.file, fuse.OK
+}
+
+func TestSetAttrFileHandle(t *testing.T) {
+	root := NewDefaultNode()
+	c := NewFileSystemConnector(root, nil)
+	node := &truncateFileNode{Node: NewDefaultNode(), file: &truncateFile{File: NewDefaultFile(), size: -1}}
+	root.Inode().NewChild("file", false, node)
+	fs := c.RawFS()
+
+	entry := &fuse.EntryOut{}
+	if code := fs.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", entry); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	header := fuse.InHeader{NodeId: entry.NodeId}
+	open := &fuse.OpenOut{}
+	if code := fs.Open(nil, &fuse.OpenIn{InHeader: header, Flags: uint32(os.O_RDWR)}, open); !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+
+	in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{InHeader: header, Valid: fuse.FATTR_SIZE, Size: 3}}
+	if code := fs.SetAttr(nil, in, &fuse.AttrOut{}); code != fuse.ENOSYS {
+		t.Errorf("SetAttr without handle: got %v, want ENOSYS", code)
+	}
+	if node.file.size != -1 {
+		t.Errorf("SetAttr without handle truncated the file to %d", node.file.size)
+	}
+
+	in.Valid |= fuse.FATTR_FH
+	in.Fh = open.Fh
+	if code := fs.SetAttr(nil, in, &fuse.AttrOut{}); !code.Ok() {
+		t.Errorf("SetAttr with handle: %v", code)
+	}
+	if node.file.size != 3 {
+		t.Errorf("got size %d, want 3", node.file.size)
+	}
+}
+
+// truncateNode opens without a File, and records Truncate calls.
+type truncateNode struct {
+	Node
+	size     int64
+	withFile bool
+}
+
+func (n *truncateNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return nil, fuse.OK
+}
+
+func (n *truncateNode) Truncate(file File, size uint64, context *fuse.Context) fuse.Status {
+	n.size = int64(size)
+	n.withFile = file != nil
+	return fuse.OK
+}
+
+func TestSetAttrNilFile(t *testing.T) {
+	root := NewDefaultNode()
+	c := NewFileSystemConnector(root, nil)
+	node := &truncateNode{Node: NewDefaultNode(), size: -1}
+	root.Inode().NewChild("file", false, node)
+	fs := c.RawFS()
+
+	entry := &fuse.EntryOut{}
+	if code := fs.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", entry); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	header := fuse.InHeader{NodeId: entry.NodeId}
+	open := &fuse.OpenOut{}
+	if code := fs.Open(nil, &fuse.OpenIn{InHeader: header, Flags: uint32(os.O_RDWR)}, open); !code.Ok() {
+		t.Fatalf("Open: %v", code)
+	}
+
+	in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{
+		InHeader: header,
+		Valid:    fuse.FATTR_SIZE | fuse.FATTR_FH,
+		Fh:       open.Fh,
+		Size:     3,
+	}}
+	if code := fs.SetAttr(nil, in, &fuse.AttrOut{}); !code.Ok() {
+		t.Errorf("SetAttr: %v", code)
+	}
+	if node.size != 3 || node.withFile {
+		t.Errorf("got size %d, file %v, want size 3 without file", node.size, node.withFile)
+	}
+}
+
+// failingStream yields its entries, and then fails.
+type failingStream struct {
+	names []string
+}
+
+func (s *failingStream) HasNext() bool {
+	return true
+}
+
+func (s *failingStream) Next() (fuse.DirEntry, fuse.Status) {
+	if len(s.names) == 0 {
+		return fuse.DirEntry{}, fuse.EIO
+	}
+	e := fuse.DirEntry{Name: s.names[0], Mode: fuse.S_IFREG}
+	s.names = s.names[1:]
+	return e, fuse.OK
+}
+
+func (s *failingStream) Close() {}
+
+type failingStreamNode struct {
+	Node
+	lookups []string
+}
+
+func (n *failingStreamNode) OpenDirStream(context *fuse.Context) (DirStream, fuse.Status) {
+	return &failingStream{[]string{"a", "", "b"}}, fuse.OK
+}
+
+func (n *failingStreamNode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*Inode, fuse.Status) {
+	n.lookups = append(n.lookups, name)
+	out.Mode = fuse.S_IFREG | 0644
+	return n.Inode().NewChild(name, false, NewDefaultNode()), fuse.OK
+}
+
+// TestReadDirPlusError checks that an error after the first entry
+// still returns the entries, whose lookups the kernel then holds.
+func TestReadDirPlusError(t *testing.T) {
+	root := &failingStreamNode{Node: NewDefaultNode()}
+	c := NewFileSystemConnector(root, NewOptions())
+	fs := c.RawFS()
+
+	open := fuse.OpenIn{InHeader: fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}}
+	var opened fuse.OpenOut
+	if code := fs.OpenDir(nil, &open, &opened); !code.Ok() {
+		t.Fatalf("OpenDir: %v", code)
+	}
+	read := fuse.ReadIn{InHeader: open.InHeader, Fh: opened.Fh, Size: 4096}
+	if code := fs.ReadDirPlus(nil, &read, fuse.NewDirEntryList(make([]byte, 4096), 0)); !code.Ok() {
+		t.Fatalf("ReadDirPlus: got %v, want OK", code)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(root.lookups, want) {
+		t.Errorf("looked up %q, want %q", root.lookups, want)
+	}
+	read.Offset = 2
+	if code := fs.ReadDirPlus(nil, &read, fuse.NewDirEntryList(make([]byte, 4096), 2)); code != fuse.EIO {
+		t.Errorf("second ReadDirPlus: got %v, want EIO", code)
+	}
+}
+
+// fileRootNode is a file that looks at its Inode in GetAttr.
+type fileRootNode struct {
+	Node
+}
+
+func (n *fileRootNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	n.Inode().FsChildren()
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+// TestFileRootSetup checks that the root type is taken from GetAttr
+// once the Inode is mounted.
+func TestFileRootSetup(t *testing.T) {
+	c := NewFileSystemConnector(&fileRootNode{NewDefaultNode()}, NewOptions())
+	if got := c.rootNode.FileType(); got != syscall.S_IFREG {
+		t.Errorf("root: got type %o, want S_IFREG", got)
+	}
+
+	c = NewFileSystemConnector(NewDefaultNode(), NewOptions())
+	if code := c.Mount(c.rootNode, "file", &fileRootNode{NewDefaultNode()}, nil); !code.Ok() {
+		t.Fatalf("Mount: %v", code)
+	}
+	if got := c.rootNode.GetChild("file").FileType(); got != syscall.S_IFREG {
+		t.Errorf("mount: got type %o, want S_IFREG", got)
+	}
+}
@@ -5,8 +5,8 @@
 package nodefs
 
 import (
-	"log"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/hanwen/go-fuse/fuse"
@@ -19,6 +19,101 @@ type openedFile struct {
 	WithFlags
 
 	dir *connectorDir
+
+	// readEnd is where the last read ended, for
+	// Options.PrefetchSequential.
+	readMu  sync.Mutex
+	readEnd int64
+
+	// writeBuf holds contiguous writes starting at writeOff that
+	// have not been passed to Node.Write yet, for
+	// Options.WriteBufferSize. writeErr is the failure of the
+	// last flush, kept for the next write or flush.
+	writeMu  sync.Mutex
+	writeBuf []byte
+	writeOff int64
+	writeErr fuse.Status
+}
+
+// sequentialRead records a read of size bytes at off, and reports
+// whether it continues the previous read.
+func (o *openedFile) sequentialRead(off int64, size int64) bool {
+	o.readMu.Lock()
+	defer o.readMu.Unlock()
+	seq := off > 0 && off == o.readEnd
+	o.readEnd = off + size
+	return seq
+}
+
+// bufferWrite adds a write of data at off to the write buffer, which
+// holds up to size bytes, flushing it first if the write does not
+// continue it. Writes that are too large for the buffer go to
+// Node.Write directly, after the buffer.
+func (o *openedFile) bufferWrite(n Node, data []byte, off int64, size int, context *fuse.Context) (uint32, fuse.Status) {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+	if code := o.writeErr; !code.Ok() {
+		o.writeErr = fuse.OK
+		return 0, code
+	}
+	if len(o.writeBuf) > 0 && (off != o.writeOff+int64(len(o.writeBuf)) || len(o.writeBuf)+len(data) > size) {
+		if code := o.flushWritesLocked(n, context); !code.Ok() {
+			o.writeErr = fuse.OK
+			return 0, code
+		}
+	}
+	if len(data) >= size {
+		return n.Write(o.WithFlags.File, data, off, context)
+	}
+	if len(o.writeBuf) == 0 {
+		o.writeOff = off
+		if o.writeBuf == nil {
+			o.writeBuf = make([]byte, 0, size)
+		}
+	}
+	o.writeBuf = append(o.writeBuf, data...)
+	return uint32(len(data)), fuse.OK
+}
+
+// flushWrites passes the write buffer to Node.Write, and returns
+// whether there was anything to write. A failure is kept for the
+// next write or syncWrites.
+func (o *openedFile) flushWrites(n Node, context *fuse.Context) bool {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+	if len(o.writeBuf) == 0 {
+		return false
+	}
+	o.flushWritesLocked(n, context)
+	return true
+}
+
+// syncWrites flushes the write buffer, and returns the failure of
+// this or an earlier flush.
+func (o *openedFile) syncWrites(n Node, context *fuse.Context) fuse.Status {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+	o.flushWritesLocked(n, context)
+	code := o.writeErr
+	o.writeErr = fuse.OK
+	return code
+}
+
+func (o *openedFile) flushWritesLocked(n Node, context *fuse.Context) fuse.Status {
+	if len(o.writeBuf) == 0 {
+		return fuse.OK
+	}
+	written, code := n.Write(o.WithFlags.File, o.writeBuf, o.writeOff, context)
+	if code.Ok() && int(written) < len(o.writeBuf) {
+		// The kernel has been told that all of it was
+		// written, so there is no way to retry the rest.
+		code = fuse.EIO
+	}
+	o.writeBuf = o.writeBuf[:0]
+	if !code.Ok() {
+		o.writeErr = code
+	}
+	return code
 }
 
 type fileSystemMount struct {
@@ -32,7 +127,9 @@ type fileSystemMount struct {
 	options *Options
 
 	// Protects the "children" and "parents" hashmaps of the inodes
-	// within the mount.
+	// within the mount, together with Inode.treeMu: adding and
+	// removing a single child takes it for reading, and changes
+	// to several directories at once take it for writing.
 	// treeLock should be acquired before openFilesLock.
 	//
 	// If multiple treeLocks must be acquired, the treeLocks
@@ -45,16 +142,22 @@ type fileSystemMount struct {
 	Debug bool
 
 	connector *FileSystemConnector
+
+	// Run once the last open file is released, after a lazy
+	// Unmount.
+	idleMu sync.Mutex
+	onIdle func()
 }
 
-// Must called with lock for parent held.
+// mountName returns the name of the mount point in its parent, or ""
+// if it was unmounted. Must called with lock for parent held.
 func (m *fileSystemMount) mountName() string {
 	for k, v := range m.parentInode.children {
 		if m.mountInode == v {
-			return k
+			return m.parentInode.childName(k, v)
 		}
 	}
-	panic("not found")
+	return ""
 }
 
 func (m *fileSystemMount) setOwner(attr *fuse.Attr) {
@@ -63,20 +166,58 @@ func (m *fileSystemMount) setOwner(attr *fuse.Attr) {
 	}
 }
 
-func (m *fileSystemMount) fillEntry(out *fuse.EntryOut) {
+// attrTimeout returns how long the kernel may cache the attributes
+// of n.
+func (m *fileSystemMount) attrTimeout(n *Inode) time.Duration {
+	if an, ok := n.Node().(AttrTimeoutNode); ok {
+		if t, ok := an.AttrTimeout(); ok {
+			return t
+		}
+	}
+	return m.options.AttrTimeout
+}
+
+func (m *fileSystemMount) fillEntry(out *fuse.EntryOut, n *Inode) {
 	splitDuration(m.options.EntryTimeout, &out.EntryValid, &out.EntryValidNsec)
-	splitDuration(m.options.AttrTimeout, &out.AttrValid, &out.AttrValidNsec)
+	splitDuration(m.attrTimeout(n), &out.AttrValid, &out.AttrValidNsec)
 	m.setOwner(&out.Attr)
+	defaultCtime(&out.Attr)
+	n.setFileType(out.Mode)
 	if out.Mode&fuse.S_IFDIR == 0 && out.Nlink == 0 {
 		out.Nlink = 1
 	}
 }
 
-func (m *fileSystemMount) fillAttr(out *fuse.AttrOut, nodeId uint64) {
-	splitDuration(m.options.AttrTimeout, &out.AttrValid, &out.AttrValidNsec)
+func (m *fileSystemMount) fillAttr(out *fuse.AttrOut, n *Inode, nodeId uint64) {
+	splitDuration(m.attrTimeout(n), &out.AttrValid, &out.AttrValidNsec)
 	m.setOwner(&out.Attr)
-	if out.Ino == 0 {
-		out.Ino = nodeId
+	defaultCtime(&out.Attr)
+	n.setFileType(out.Mode)
+	setIno(&out.Attr, n, nodeId)
+}
+
+// defaultCtime reports the mtime as ctime for Nodes that leave ctime
+// unset in GetAttr, eg. because their backend does not track it.
+// Nodes that know better set Attr.Ctime themselves.
+func defaultCtime(attr *fuse.Attr) {
+	if attr.Ctime == 0 && attr.Ctimensec == 0 {
+		attr.Ctime = attr.Mtime
+		attr.Ctimensec = attr.Mtimensec
+	}
+}
+
+// setIno fills in the inode number of n, which the kernel knows as
+// nodeId. An InoNode decides; otherwise a number set by GetAttr is
+// kept, and the node ID is used if there is none.
+func setIno(attr *fuse.Attr, n *Inode, nodeId uint64) {
+	if in, ok := n.Node().(InoNode); ok {
+		if ino := in.Ino(); ino != 0 {
+			attr.Ino = ino
+			return
+		}
+	}
+	if attr.Ino == 0 {
+		attr.Ino = nodeId
 	}
 }
 
@@ -87,7 +228,7 @@ func (m *fileSystemMount) getOpenedFile(h uint64) *openedFile {
 	}
 
 	if b != nil && m.connector.debug && b.WithFlags.Description != "" {
-		log.Printf("File %d = %q", h, b.WithFlags.Description)
+		m.connector.logf("File %d = %q", h, b.WithFlags.Description)
 	}
 	return b
 }
@@ -133,7 +274,7 @@ func (m *fileSystemMount) registerFileHandle(node *Inode, dir *connectorDir, f F
 		}
 
 		b.WithFlags.File = withFlags.File
-		b.WithFlags.FuseFlags |= withFlags.FuseFlags
+		b.WithFlags.FuseFlags |= withFlags.fuseFlags()
 		b.WithFlags.Description += withFlags.Description
 		f = withFlags.File
 	}
@@ -147,11 +288,41 @@ func (m *fileSystemMount) registerFileHandle(node *Inode, dir *connectorDir, f F
 	return handle, b
 }
 
-// Creates a return entry for a non-existent path.
-func (m *fileSystemMount) negativeEntry(out *fuse.EntryOut) bool {
-	if m.options.NegativeTimeout > 0.0 {
+// setOnIdle arranges for f to be called once there are no open files
+// in the mount, which may be right away.
+func (m *fileSystemMount) setOnIdle(f func()) {
+	m.idleMu.Lock()
+	m.onIdle = f
+	m.idleMu.Unlock()
+	m.checkIdle()
+}
+
+// checkIdle runs the onIdle callback if there are no open files.
+func (m *fileSystemMount) checkIdle() {
+	if m.openFiles.Count() > 0 {
+		return
+	}
+	m.idleMu.Lock()
+	f := m.onIdle
+	m.onIdle = nil
+	m.idleMu.Unlock()
+	if f != nil {
+		f()
+	}
+}
+
+// Creates a return entry for a non-existent name in parent.
+func (m *fileSystemMount) negativeEntry(out *fuse.EntryOut, parent *Inode, name string) bool {
+	timeout := m.options.NegativeTimeout
+	if n, ok := parent.Node().(NegativeTimeoutNode); ok {
+		if t, ok := n.NegativeTimeout(name); ok {
+			timeout = t
+		}
+	}
+	if timeout > 0.0 {
 		out.NodeId = 0
-		splitDuration(m.options.NegativeTimeout, &out.EntryValid, &out.EntryValidNsec)
+		splitDuration(timeout, &out.EntryValid, &out.EntryValidNsec)
+		m.connector.addNegative(parent, name, timeout)
 		return true
 	}
 	return false
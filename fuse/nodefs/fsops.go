@@ -10,25 +10,39 @@ package nodefs
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 )
 
 // Returns the RawFileSystem so it can be mounted.
+//
+// A bridge that speaks the FUSE wire protocol can serve it with
+// fuse.NewServerTransport. The RawFileSystem takes decoded requests,
+// so it can also be driven by something other than a fuse.Server,
+// such as a bridge to another platform's user space file system
+// API. Such a driver must call
+// Lookup and Forget in pairs as the kernel does, and pass nil to Init.
+// Notifications then return ENOSYS.
 func (c *FileSystemConnector) RawFS() fuse.RawFileSystem {
 	return (*rawBridge)(c)
 }
 
 type rawBridge FileSystemConnector
 
-func (c *rawBridge) Fsync(input *fuse.FsyncIn) fuse.Status {
+func (c *rawBridge) Fsync(cancel <-chan struct{}, input *fuse.FsyncIn) fuse.Status {
 	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
 	opened := node.mount.getOpenedFile(input.Fh)
 
 	if opened != nil {
+		if code := opened.syncWrites(node.fsInode, &fuse.Context{Caller: input.Caller, Cancel: cancel}); !code.Ok() {
+			return code
+		}
 		return opened.WithFlags.File.Fsync(int(input.FsyncFlags))
 	}
 
@@ -39,8 +53,12 @@ func (c *rawBridge) SetDebug(debug bool) {
 	c.fsConn().SetDebug(debug)
 }
 
-func (c *rawBridge) FsyncDir(input *fuse.FsyncIn) fuse.Status {
-	return fuse.ENOSYS
+func (c *rawBridge) FsyncDir(cancel <-chan struct{}, input *fuse.FsyncIn) fuse.Status {
+	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	return node.fsInode.FsyncDir(input.FsyncFlags, &fuse.Context{Caller: input.Caller, Cancel: cancel})
 }
 
 func (c *rawBridge) fsConn() *FileSystemConnector {
@@ -57,15 +75,24 @@ func (c *rawBridge) String() string {
 	return name
 }
 
+// NodePath implements fuse.NodePathFileSystem, for debug output.
+func (c *rawBridge) NodePath(nodeID uint64) (string, bool) {
+	n := c.toInode(nodeID)
+	if n == nil {
+		return "", false
+	}
+	return n.Path()
+}
+
 func (c *rawBridge) Init(s *fuse.Server) {
 	c.server = s
 	c.rootNode.Node().OnMount((*FileSystemConnector)(c))
 }
 
-func (c *FileSystemConnector) lookupMountUpdate(out *fuse.Attr, mount *fileSystemMount) (node *Inode, code fuse.Status) {
-	code = mount.mountInode.Node().GetAttr(out, nil, nil)
+func (c *FileSystemConnector) lookupMountUpdate(out *fuse.Attr, mount *fileSystemMount, context *fuse.Context) (node *Inode, code fuse.Status) {
+	code = mount.mountInode.Node().GetAttr(out, nil, context)
 	if !code.Ok() {
-		log.Println("Root getattr should not return error", code)
+		c.logf("Root getattr should not return error: %v", code)
 		out.Mode = fuse.S_IFDIR | 0755
 		return mount.mountInode, fuse.OK
 	}
@@ -74,7 +101,7 @@ func (c *FileSystemConnector) lookupMountUpdate(out *fuse.Attr, mount *fileSyste
 }
 
 // internalLookup executes a lookup without affecting NodeId reference counts.
-func (c *FileSystemConnector) internalLookup(out *fuse.Attr, parent *Inode, name string, header *fuse.InHeader) (node *Inode, code fuse.Status) {
+func (c *FileSystemConnector) internalLookup(out *fuse.Attr, parent *Inode, name string, context *fuse.Context) (node *Inode, code fuse.Status) {
 
 	// We may already know the child because it was created using Create or Mkdir,
 	// from an earlier lookup, or because the nodes were created in advance
@@ -82,40 +109,94 @@ func (c *FileSystemConnector) internalLookup(out *fuse.Attr, parent *Inode, name
 	child := parent.GetChild(name)
 
 	if child != nil && child.mountPoint != nil {
-		return c.lookupMountUpdate(out, child.mountPoint)
+		return c.lookupMountUpdate(out, child.mountPoint, context)
 	}
 
 	if child != nil && !parent.mount.options.LookupKnownChildren {
-		code = child.fsInode.GetAttr(out, nil, &header.Context)
+		code = child.fsInode.GetAttr(out, nil, context)
 	} else {
-		child, code = parent.fsInode.Lookup(out, name, &header.Context)
+		child, code = parent.fsInode.Lookup(out, name, context)
 	}
 
 	return child, code
 }
 
-func (c *rawBridge) Lookup(header *fuse.InHeader, name string, out *fuse.EntryOut) (code fuse.Status) {
+// lookupHandle answers the lookups of "." and ".." that the kernel
+// uses to resolve NFS file handles, see Options.ExportSupport. It is
+// only used when export support is on. The node ID may be one the
+// kernel has forgotten, or that was never given out.
+func (c *rawBridge) lookupHandle(cancel <-chan struct{}, header *fuse.InHeader, name string, out *fuse.EntryOut) fuse.Status {
+	var node *Inode
+	if header.NodeId == fuse.FUSE_ROOT_ID {
+		node = c.rootNode
+	} else if c.inodeMap.Has(header.NodeId) {
+		node = c.toInode(header.NodeId)
+	}
+	if node == nil {
+		return fuse.ESTALE
+	}
+	if name == ".." && node != c.rootNode {
+		node, _ = node.Parent()
+		if node == nil {
+			return fuse.ESTALE
+		}
+	}
+
+	ctx := &fuse.Context{Caller: header.Caller, Cancel: cancel}
+	if node == c.rootNode {
+		// The root has a fixed ID, and no lookup count.
+		node.Node().GetAttr((*fuse.Attr)(&out.Attr), nil, ctx)
+		node.mount.fillEntry(out, node)
+		out.NodeId = fuse.FUSE_ROOT_ID
+		setIno((*fuse.Attr)(&out.Attr), node, out.NodeId)
+		return fuse.OK
+	}
+	c.childLookup(out, node, ctx)
+	return fuse.OK
+}
+
+func (c *rawBridge) Lookup(cancel <-chan struct{}, header *fuse.InHeader, name string, out *fuse.EntryOut) (code fuse.Status) {
+	if (name == "." || name == "..") && c.rootNode.mount.options.ExportSupport {
+		return c.lookupHandle(cancel, header, name, out)
+	}
 	parent := c.toInode(header.NodeId)
+	if parent == nil {
+		return fuse.ESTALE
+	}
 	if !parent.IsDir() {
-		log.Printf("Lookup %q called on non-Directory node %d", name, header.NodeId)
+		c.fsConn().logf("Lookup %q called on non-Directory node %d", name, header.NodeId)
 		return fuse.ENOTDIR
 	}
 	outAttr := (*fuse.Attr)(&out.Attr)
-	child, code := c.fsConn().internalLookup(outAttr, parent, name, header)
-	if code == fuse.ENOENT && parent.mount.negativeEntry(out) {
+	context := &fuse.Context{Caller: header.Caller, Cancel: cancel}
+	var gen uint64
+	known := parent.GetChild(name)
+	if known != nil {
+		// As for GetAttr, the size must include buffered
+		// writes.
+		known.flushWrites(context)
+		gen = known.attrGeneration()
+	}
+	child, code := c.fsConn().internalLookup(outAttr, parent, name, context)
+	if code == fuse.ENOENT && parent.mount.negativeEntry(out, parent, name) {
 		return fuse.OK
 	}
 	if !code.Ok() {
 		return code
 	}
 	if child == nil {
-		log.Println("Lookup returned fuse.OK with nil child", name)
+		c.fsConn().logf("Lookup returned fuse.OK with nil child %q", name)
 	}
 
-	child.mount.fillEntry(out)
+	c.fsConn().dropNegative(parent, name)
+	child.mount.fillEntry(out, child)
 	out.NodeId, out.Generation = c.fsConn().lookupUpdate(child)
-	if out.Ino == 0 {
-		out.Ino = out.NodeId
+	setIno(outAttr, child, out.NodeId)
+	if child.mount.options.CacheAttributes {
+		if child != known {
+			gen = 0
+		}
+		child.setCachedAttr(outAttr, child.mount.attrTimeout(child), gen)
 	}
 
 	return fuse.OK
@@ -125,8 +206,11 @@ func (c *rawBridge) Forget(nodeID, nlookup uint64) {
 	c.fsConn().forgetUpdate(nodeID, int(nlookup))
 }
 
-func (c *rawBridge) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) (code fuse.Status) {
+func (c *rawBridge) GetAttr(cancel <-chan struct{}, input *fuse.GetAttrIn, out *fuse.AttrOut) (code fuse.Status) {
 	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
 
 	var f File
 	if input.Flags()&fuse.FUSE_GETATTR_FH != 0 {
@@ -135,57 +219,113 @@ func (c *rawBridge) GetAttr(input *fuse.GetAttrIn, out *fuse.AttrOut) (code fuse
 		}
 	}
 
+	node.flushWrites(&fuse.Context{Caller: input.Caller, Cancel: cancel})
 	dest := (*fuse.Attr)(&out.Attr)
-	code = node.fsInode.GetAttr(dest, f, &input.Context)
-	if !code.Ok() {
-		return code
+	cache := node.mount.options.CacheAttributes
+	var cached bool
+	var gen uint64
+	if cache {
+		cached, gen = node.cachedAttr(dest)
 	}
+	if !cached {
+		code = node.fsInode.GetAttr(dest, f, &fuse.Context{Caller: input.Caller, Cancel: cancel})
+		if !code.Ok() {
+			return code
+		}
 
-	if out.Nlink == 0 {
-		// With Nlink == 0, newer kernels will refuse link
-		// operations.
-		out.Nlink = 1
+		if out.Nlink == 0 {
+			// With Nlink == 0, newer kernels will refuse link
+			// operations.
+			out.Nlink = 1
+		}
+		if cache {
+			node.setCachedAttr(dest, node.mount.attrTimeout(node), gen)
+		}
 	}
 
-	node.mount.fillAttr(out, input.NodeId)
+	node.mount.fillAttr(out, node, input.NodeId)
+	c.fsConn().touch(node)
 	return fuse.OK
 }
 
-func (c *rawBridge) OpenDir(input *fuse.OpenIn, out *fuse.OpenOut) (code fuse.Status) {
+func (c *rawBridge) OpenDir(cancel <-chan struct{}, input *fuse.OpenIn, out *fuse.OpenOut) (code fuse.Status) {
 	node := c.toInode(input.NodeId)
-	stream, err := node.fsInode.OpenDir(&input.Context)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	if !node.IsDir() {
+		return fuse.ENOTDIR
+	}
+	stream, err := openDirStream(node, &fuse.Context{Caller: input.Caller, Cancel: cancel})
 	if err != fuse.OK {
 		return err
 	}
-	stream = append(stream, node.getMountDirEntries()...)
 	de := &connectorDir{
-		node: node.Node(),
-		stream: append(stream,
-			fuse.DirEntry{Mode: fuse.S_IFDIR, Name: "."},
-			fuse.DirEntry{Mode: fuse.S_IFDIR, Name: ".."}),
-		rawFS: c,
+		inode:  node,
+		stream: stream,
+		rawFS:  c,
 	}
 	h, opened := node.mount.registerFileHandle(node, de, nil, input.Flags)
 	out.OpenFlags = opened.FuseFlags
+	if cn, ok := node.Node().(CacheDirNode); ok {
+		if cache, keep := cn.CacheDir(); cache {
+			out.OpenFlags |= fuse.FOPEN_CACHE_DIR
+			if keep {
+				out.OpenFlags |= fuse.FOPEN_KEEP_CACHE
+			}
+		}
+	}
 	out.Fh = h
 	return fuse.OK
 }
 
-func (c *rawBridge) ReadDir(input *fuse.ReadIn, out *fuse.DirEntryList) fuse.Status {
+func (c *rawBridge) ReadDir(cancel <-chan struct{}, input *fuse.ReadIn, out *fuse.DirEntryList) fuse.Status {
 	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
 	opened := node.mount.getOpenedFile(input.Fh)
-	return opened.dir.ReadDir(input, out)
+	if opened == nil || opened.dir == nil {
+		return fuse.EBADF
+	}
+	return opened.dir.ReadDir(cancel, input, out)
 }
 
-func (c *rawBridge) ReadDirPlus(input *fuse.ReadIn, out *fuse.DirEntryList) fuse.Status {
+func (c *rawBridge) ReadDirPlus(cancel <-chan struct{}, input *fuse.ReadIn, out *fuse.DirEntryList) fuse.Status {
 	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
 	opened := node.mount.getOpenedFile(input.Fh)
-	return opened.dir.ReadDirPlus(input, out)
+	if opened == nil || opened.dir == nil {
+		return fuse.EBADF
+	}
+	return opened.dir.ReadDirPlus(cancel, input, out)
+}
+
+// openFlags adjusts open flags for the writeback cache: the kernel
+// may then read pages of files that are opened for writing only, and
+// it implements O_APPEND itself.
+func (c *rawBridge) openFlags(flags uint32) uint32 {
+	if c.server == nil || c.server.KernelSettings().Flags&fuse.CAP_WRITEBACK_CACHE == 0 {
+		return flags
+	}
+	if flags&syscall.O_ACCMODE == syscall.O_WRONLY {
+		flags = flags&^syscall.O_ACCMODE | syscall.O_RDWR
+	}
+	return flags &^ syscall.O_APPEND
 }
 
-func (c *rawBridge) Open(input *fuse.OpenIn, out *fuse.OpenOut) (status fuse.Status) {
+func (c *rawBridge) Open(cancel <-chan struct{}, input *fuse.OpenIn, out *fuse.OpenOut) (status fuse.Status) {
 	node := c.toInode(input.NodeId)
-	f, code := node.fsInode.Open(input.Flags, &input.Context)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	if node.mount.options.ReadOnly && input.Flags&(syscall.O_ACCMODE|syscall.O_TRUNC) != syscall.O_RDONLY {
+		return fuse.EROFS
+	}
+	input.Flags = c.openFlags(input.Flags)
+	f, code := node.fsInode.Open(input.Flags, &fuse.Context{Caller: input.Caller, Cancel: cancel})
 	if !code.Ok() || f == nil {
 		return code
 	}
@@ -195,18 +335,55 @@ func (c *rawBridge) Open(input *fuse.OpenIn, out *fuse.OpenOut) (status fuse.Sta
 	return fuse.OK
 }
 
-func (c *rawBridge) SetAttr(input *fuse.SetAttrIn, out *fuse.AttrOut) (code fuse.Status) {
+func (c *rawBridge) SetAttr(cancel <-chan struct{}, input *fuse.SetAttrIn, out *fuse.AttrOut) (code fuse.Status) {
 	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	if node.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
 
 	var f File
 	if input.Valid&fuse.FATTR_FH != 0 {
-		opened := node.mount.getOpenedFile(input.Fh)
-		f = opened.WithFlags.File
+		// Nodes that open without a File get handle 0, and no
+		// File is registered for it; the Node then gets a nil
+		// File.
+		if opened := node.mount.getOpenedFile(input.Fh); opened != nil {
+			f = opened.WithFlags.File
+		} else if input.Fh != 0 {
+			return fuse.EBADF
+		}
+	}
+
+	node.flushWrites(&fuse.Context{Caller: input.Caller, Cancel: cancel})
+	if sn, ok := node.fsInode.(SetAttrNode); ok {
+		code = sn.SetAttr(f, input, &fuse.Context{Caller: input.Caller, Cancel: cancel})
+	} else {
+		code = setAttrFields(node.fsInode, f, input, cancel)
 	}
+	node.invalidateAttr()
+	if !code.Ok() {
+		return code
+	}
+
+	// Must call GetAttr(); the filesystem may override some of
+	// the changes we effect here.
+	attr := (*fuse.Attr)(&out.Attr)
+	code = node.fsInode.GetAttr(attr, nil, &fuse.Context{Caller: input.Caller, Cancel: cancel})
+	if code.Ok() {
+		node.mount.fillAttr(out, node, input.NodeId)
+	}
+	return code
+}
 
-	if code.Ok() && input.Valid&fuse.FATTR_MODE != 0 {
+// setAttrFields applies the attributes selected by input.Valid to n
+// with the Node methods for each of them. Attributes that are not
+// selected are left alone.
+func setAttrFields(n Node, f File, input *fuse.SetAttrIn, cancel <-chan struct{}) (code fuse.Status) {
+	if input.Valid&fuse.FATTR_MODE != 0 {
 		permissions := uint32(07777) & input.Mode
-		code = node.fsInode.Chmod(f, permissions, &input.Context)
+		code = n.Chmod(f, permissions, &fuse.Context{Caller: input.Caller, Cancel: cancel})
 	}
 	if code.Ok() && (input.Valid&(fuse.FATTR_UID|fuse.FATTR_GID) != 0) {
 		var uid uint32 = ^uint32(0) // means "do not change" in chown(2)
@@ -217,108 +394,173 @@ func (c *rawBridge) SetAttr(input *fuse.SetAttrIn, out *fuse.AttrOut) (code fuse
 		if input.Valid&fuse.FATTR_GID != 0 {
 			gid = input.Gid
 		}
-		code = node.fsInode.Chown(f, uid, gid, &input.Context)
+		code = n.Chown(f, uid, gid, &fuse.Context{Caller: input.Caller, Cancel: cancel})
 	}
 	if code.Ok() && input.Valid&fuse.FATTR_SIZE != 0 {
-		code = node.fsInode.Truncate(f, input.Size, &input.Context)
+		code = n.Truncate(f, input.Size, &fuse.Context{Caller: input.Caller, Cancel: cancel})
 	}
 	if code.Ok() && (input.Valid&(fuse.FATTR_ATIME|fuse.FATTR_MTIME|fuse.FATTR_ATIME_NOW|fuse.FATTR_MTIME_NOW) != 0) {
 		now := time.Now()
 		var atime *time.Time
 		var mtime *time.Time
 
-		if input.Valid&fuse.FATTR_ATIME != 0 {
-			if input.Valid&fuse.FATTR_ATIME_NOW != 0 {
-				atime = &now
-			} else {
-				t := time.Unix(int64(input.Atime), int64(input.Atimensec))
-				atime = &t
-			}
+		// The _NOW bits come with or without the plain ones;
+		// they take precedence over the timestamps in input.
+		if input.Valid&fuse.FATTR_ATIME_NOW != 0 {
+			atime = &now
+		} else if input.Valid&fuse.FATTR_ATIME != 0 {
+			t := time.Unix(int64(input.Atime), int64(input.Atimensec))
+			atime = &t
 		}
 
-		if input.Valid&fuse.FATTR_MTIME != 0 {
-			if input.Valid&fuse.FATTR_MTIME_NOW != 0 {
-				mtime = &now
-			} else {
-				t := time.Unix(int64(input.Mtime), int64(input.Mtimensec))
-				mtime = &t
-			}
+		if input.Valid&fuse.FATTR_MTIME_NOW != 0 {
+			mtime = &now
+		} else if input.Valid&fuse.FATTR_MTIME != 0 {
+			t := time.Unix(int64(input.Mtime), int64(input.Mtimensec))
+			mtime = &t
 		}
 
-		code = node.fsInode.Utimens(f, atime, mtime, &input.Context)
-	}
-
-	if !code.Ok() {
-		return code
-	}
-
-	// Must call GetAttr(); the filesystem may override some of
-	// the changes we effect here.
-	attr := (*fuse.Attr)(&out.Attr)
-	code = node.fsInode.GetAttr(attr, nil, &input.Context)
-	if code.Ok() {
-		node.mount.fillAttr(out, input.NodeId)
+		code = n.Utimens(f, atime, mtime, &fuse.Context{Caller: input.Caller, Cancel: cancel})
 	}
 	return code
 }
 
-func (c *rawBridge) Fallocate(input *fuse.FallocateIn) (code fuse.Status) {
+func (c *rawBridge) Fallocate(cancel <-chan struct{}, input *fuse.FallocateIn) (code fuse.Status) {
 	n := c.toInode(input.NodeId)
-	opened := n.mount.getOpenedFile(input.Fh)
-
-	return n.fsInode.Fallocate(opened, input.Offset, input.Length, input.Mode, &input.Context)
+	if n == nil {
+		return fuse.ESTALE
+	}
+	if n.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	var f File
+	if opened := n.mount.getOpenedFile(input.Fh); opened != nil {
+		f = opened.WithFlags.File
+	}
+	n.flushWrites(&fuse.Context{Caller: input.Caller, Cancel: cancel})
+	defer n.invalidateAttr()
+	return n.fsInode.Fallocate(f, input.Offset, input.Length, input.Mode, &fuse.Context{Caller: input.Caller, Cancel: cancel})
 }
 
-func (c *rawBridge) Readlink(header *fuse.InHeader) (out []byte, code fuse.Status) {
+func (c *rawBridge) Readlink(cancel <-chan struct{}, header *fuse.InHeader) (out []byte, code fuse.Status) {
 	n := c.toInode(header.NodeId)
-	return n.fsInode.Readlink(&header.Context)
+	if n == nil {
+		return nil, fuse.ESTALE
+	}
+	if t := n.FileType(); t != 0 && t != syscall.S_IFLNK {
+		return nil, fuse.EINVAL
+	}
+	timeout := n.mount.options.SymlinkCacheTimeout
+	if timeout > 0 {
+		if link := n.cachedLink(); link != nil {
+			return link, fuse.OK
+		}
+	}
+	out, code = n.fsInode.Readlink(&fuse.Context{Caller: header.Caller, Cancel: cancel})
+	if code.Ok() && timeout > 0 {
+		n.setCachedLink(out, timeout)
+	}
+	return out, code
 }
 
-func (c *rawBridge) Mknod(input *fuse.MknodIn, name string, out *fuse.EntryOut) (code fuse.Status) {
+func (c *rawBridge) Mknod(cancel <-chan struct{}, input *fuse.MknodIn, name string, out *fuse.EntryOut) (code fuse.Status) {
 	parent := c.toInode(input.NodeId)
+	if parent == nil {
+		return fuse.ESTALE
+	}
+	if parent.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	ctx := &fuse.Context{Caller: input.Caller, Cancel: cancel}
 
-	child, code := parent.fsInode.Mknod(name, input.Mode, uint32(input.Rdev), &input.Context)
+	parent.dirMu.Lock()
+	child, code := parent.fsInode.Mknod(name, input.Mode, uint32(input.Rdev), ctx)
+	parent.dirMu.Unlock()
+	parent.invalidateAttr()
 	if code.Ok() {
-		c.childLookup(out, child, &input.Context)
-		code = child.fsInode.GetAttr((*fuse.Attr)(&out.Attr), nil, &input.Context)
+		c.fsConn().dropNegative(parent, name)
+		c.childLookup(out, child, ctx)
+		code = child.fsInode.GetAttr((*fuse.Attr)(&out.Attr), nil, ctx)
 	}
 	return code
 }
 
-func (c *rawBridge) Mkdir(input *fuse.MkdirIn, name string, out *fuse.EntryOut) (code fuse.Status) {
+func (c *rawBridge) Mkdir(cancel <-chan struct{}, input *fuse.MkdirIn, name string, out *fuse.EntryOut) (code fuse.Status) {
 	parent := c.toInode(input.NodeId)
+	if parent == nil {
+		return fuse.ESTALE
+	}
+	if parent.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	ctx := &fuse.Context{Caller: input.Caller, Cancel: cancel}
 
-	child, code := parent.fsInode.Mkdir(name, input.Mode, &input.Context)
+	parent.dirMu.Lock()
+	child, code := parent.fsInode.Mkdir(name, input.Mode, ctx)
+	parent.dirMu.Unlock()
+	parent.invalidateAttr()
 	if code.Ok() {
-		c.childLookup(out, child, &input.Context)
-		code = child.fsInode.GetAttr((*fuse.Attr)(&out.Attr), nil, &input.Context)
+		c.fsConn().dropNegative(parent, name)
+		c.childLookup(out, child, ctx)
+		code = child.fsInode.GetAttr((*fuse.Attr)(&out.Attr), nil, ctx)
 	}
 	return code
 }
 
-func (c *rawBridge) Unlink(header *fuse.InHeader, name string) (code fuse.Status) {
+func (c *rawBridge) Unlink(cancel <-chan struct{}, header *fuse.InHeader, name string) (code fuse.Status) {
 	parent := c.toInode(header.NodeId)
-	return parent.fsInode.Unlink(name, &header.Context)
+	if parent == nil {
+		return fuse.ESTALE
+	}
+	if parent.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	defer attrChanged(parent, parent.GetChild(name))
+	return parent.fsInode.Unlink(name, &fuse.Context{Caller: header.Caller, Cancel: cancel})
 }
 
-func (c *rawBridge) Rmdir(header *fuse.InHeader, name string) (code fuse.Status) {
+func (c *rawBridge) Rmdir(cancel <-chan struct{}, header *fuse.InHeader, name string) (code fuse.Status) {
 	parent := c.toInode(header.NodeId)
-	return parent.fsInode.Rmdir(name, &header.Context)
+	if parent == nil {
+		return fuse.ESTALE
+	}
+	if parent.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	defer attrChanged(parent, parent.GetChild(name))
+	return parent.fsInode.Rmdir(name, &fuse.Context{Caller: header.Caller, Cancel: cancel})
 }
 
-func (c *rawBridge) Symlink(header *fuse.InHeader, pointedTo string, linkName string, out *fuse.EntryOut) (code fuse.Status) {
+func (c *rawBridge) Symlink(cancel <-chan struct{}, header *fuse.InHeader, pointedTo string, linkName string, out *fuse.EntryOut) (code fuse.Status) {
 	parent := c.toInode(header.NodeId)
+	if parent == nil {
+		return fuse.ESTALE
+	}
+	if parent.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	ctx := &fuse.Context{Caller: header.Caller, Cancel: cancel}
 
-	child, code := parent.fsInode.Symlink(linkName, pointedTo, &header.Context)
+	parent.dirMu.Lock()
+	child, code := parent.fsInode.Symlink(linkName, pointedTo, ctx)
+	parent.dirMu.Unlock()
+	parent.invalidateAttr()
 	if code.Ok() {
-		c.childLookup(out, child, &header.Context)
-		code = child.fsInode.GetAttr((*fuse.Attr)(&out.Attr), nil, &header.Context)
+		c.fsConn().dropNegative(parent, linkName)
+		c.childLookup(out, child, ctx)
+		code = child.fsInode.GetAttr((*fuse.Attr)(&out.Attr), nil, ctx)
 	}
 	return code
 }
 
-func (c *rawBridge) Rename(input *fuse.RenameIn, oldName string, newName string) (code fuse.Status) {
+func (c *rawBridge) Rename(cancel <-chan struct{}, input *fuse.RenameIn, oldName string, newName string) (code fuse.Status) {
 	oldParent := c.toInode(input.NodeId)
+	if oldParent == nil {
+		return fuse.ESTALE
+	}
+	if oldParent.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
 
 	child := oldParent.GetChild(oldName)
 	if child == nil {
@@ -329,43 +571,133 @@ func (c *rawBridge) Rename(input *fuse.RenameIn, oldName string, newName string)
 	}
 
 	newParent := c.toInode(input.Newdir)
+	if newParent == nil {
+		return fuse.ESTALE
+	}
+	if dest := newParent.GetChild(newName); dest != nil && dest.mountPoint != nil {
+		return fuse.EBUSY
+	}
+	defer attrChanged(oldParent, newParent, child, newParent.GetChild(newName))
+	defer func() {
+		if code.Ok() {
+			c.fsConn().dropNegative(newParent, newName)
+		}
+	}()
+	ctx := &fuse.Context{Caller: input.Caller, Cancel: cancel}
+
+	// Nothing can create newName through the connector while we
+	// check for it. The backing store may still change.
+	newParent.dirMu.Lock()
+	defer newParent.dirMu.Unlock()
 	if oldParent.mount != newParent.mount {
-		return fuse.EXDEV
+		if !oldParent.mount.options.CrossMountRename || !newParent.mount.options.CrossMountRename {
+			return fuse.EXDEV
+		}
+		if newParent.mount.options.ReadOnly {
+			return fuse.EROFS
+		}
+		if input.Flags&^fuse.RENAME_NOREPLACE != 0 {
+			// The copy cannot swap entries atomically.
+			return fuse.EXDEV
+		}
+		return c.fsConn().crossMountRename(oldParent, oldName, newParent, newName, input.Flags, ctx)
 	}
 
-	return oldParent.fsInode.Rename(oldName, newParent.fsInode, newName, &input.Context)
+	if input.Flags != 0 {
+		if r2, ok := oldParent.fsInode.(Rename2Node); ok {
+			return r2.Rename2(oldName, newParent.fsInode, newName, input.Flags, ctx)
+		}
+		if input.Flags != fuse.RENAME_NOREPLACE {
+			return fuse.EINVAL
+		}
+		if c.fsConn().exists(newParent, newName, ctx) {
+			return fuse.Status(syscall.EEXIST)
+		}
+	}
+	return oldParent.fsInode.Rename(oldName, newParent.fsInode, newName, ctx)
+}
+
+// exists returns whether parent has a child called name.
+func (c *FileSystemConnector) exists(parent *Inode, name string, context *fuse.Context) bool {
+	var attr fuse.Attr
+	child, code := c.internalLookup(&attr, parent, name, context)
+	return code.Ok() && child != nil
+}
+
+// attrChanged drops the cached attributes of the given nodes, which
+// may be nil.
+func attrChanged(nodes ...*Inode) {
+	for _, n := range nodes {
+		if n != nil {
+			n.invalidateAttr()
+		}
+	}
 }
 
-func (c *rawBridge) Link(input *fuse.LinkIn, name string, out *fuse.EntryOut) (code fuse.Status) {
+func (c *rawBridge) Link(cancel <-chan struct{}, input *fuse.LinkIn, name string, out *fuse.EntryOut) (code fuse.Status) {
 	existing := c.toInode(input.Oldnodeid)
+	if existing == nil {
+		return fuse.ESTALE
+	}
 	parent := c.toInode(input.NodeId)
+	if parent == nil {
+		return fuse.ESTALE
+	}
+	ctx := &fuse.Context{Caller: input.Caller, Cancel: cancel}
 
 	if existing.mount != parent.mount {
 		return fuse.EXDEV
 	}
+	if parent.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
 
-	child, code := parent.fsInode.Link(name, existing.fsInode, &input.Context)
+	parent.dirMu.Lock()
+	child, code := parent.fsInode.Link(name, existing.fsInode, ctx)
+	parent.dirMu.Unlock()
+	attrChanged(parent, existing)
 	if code.Ok() {
-		c.childLookup(out, child, &input.Context)
-		code = child.fsInode.GetAttr((*fuse.Attr)(&out.Attr), nil, &input.Context)
+		c.fsConn().dropNegative(parent, name)
+		// This registers another lookup of the existing
+		// inode, which the kernel forgets separately.
+		c.childLookup(out, child, ctx)
 	}
 
 	return code
 }
 
-func (c *rawBridge) Access(input *fuse.AccessIn) (code fuse.Status) {
+func (c *rawBridge) Access(cancel <-chan struct{}, input *fuse.AccessIn) (code fuse.Status) {
 	n := c.toInode(input.NodeId)
-	return n.fsInode.Access(input.Mask, &input.Context)
+	if n == nil {
+		return fuse.ESTALE
+	}
+	return n.fsInode.Access(input.Mask, &fuse.Context{Caller: input.Caller, Cancel: cancel})
 }
 
-func (c *rawBridge) Create(input *fuse.CreateIn, name string, out *fuse.CreateOut) (code fuse.Status) {
+func (c *rawBridge) Create(cancel <-chan struct{}, input *fuse.CreateIn, name string, out *fuse.CreateOut) (code fuse.Status) {
 	parent := c.toInode(input.NodeId)
-	f, child, code := parent.fsInode.Create(name, uint32(input.Flags), input.Mode, &input.Context)
+	if parent == nil {
+		return fuse.ESTALE
+	}
+	if parent.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	input.Flags = c.openFlags(input.Flags)
+	ctx := &fuse.Context{Caller: input.Caller, Cancel: cancel}
+	parent.dirMu.Lock()
+	f, child, code := parent.fsInode.Create(name, uint32(input.Flags), input.Mode, ctx)
+	parent.dirMu.Unlock()
+	parent.invalidateAttr()
 	if !code.Ok() {
 		return code
 	}
 
-	c.childLookup(&out.EntryOut, child, &input.Context)
+	c.fsConn().dropNegative(parent, name)
+	c.childLookup(&out.EntryOut, child, ctx)
+	if f == nil {
+		// As in Open, leave Fh zero so Release has nothing to do.
+		return fuse.OK
+	}
 	handle, opened := parent.mount.registerFileHandle(child, nil, f, input.Flags)
 
 	out.OpenOut.OpenFlags = opened.FuseFlags
@@ -373,45 +705,113 @@ func (c *rawBridge) Create(input *fuse.CreateIn, name string, out *fuse.CreateOu
 	return code
 }
 
-func (c *rawBridge) Release(input *fuse.ReleaseIn) {
+func (c *rawBridge) Tmpfile(cancel <-chan struct{}, input *fuse.CreateIn, out *fuse.CreateOut) (code fuse.Status) {
+	parent := c.toInode(input.NodeId)
+	if parent == nil {
+		return fuse.ESTALE
+	}
+	tn, ok := parent.fsInode.(TmpfileNode)
+	if !ok {
+		return fuse.ENOSYS
+	}
+	if parent.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	input.Flags = c.openFlags(input.Flags)
+	ctx := &fuse.Context{Caller: input.Caller, Cancel: cancel}
+	f, node, code := tn.Tmpfile(uint32(input.Flags), input.Mode, ctx)
+	if !code.Ok() {
+		return code
+	}
+
+	child := newInode(syscall.S_IFREG, node)
+	child.mount = parent.mount
+	c.childLookup(&out.EntryOut, child, ctx)
+	if f == nil {
+		return fuse.OK
+	}
+	handle, opened := parent.mount.registerFileHandle(child, nil, f, input.Flags)
+	out.OpenOut.OpenFlags = opened.FuseFlags
+	out.OpenOut.Fh = handle
+	return fuse.OK
+}
+
+func (c *rawBridge) Release(cancel <-chan struct{}, input *fuse.ReleaseIn) {
 	if input.Fh != 0 {
 		node := c.toInode(input.NodeId)
+		if node == nil || node.mount.getOpenedFile(input.Fh) == nil {
+			c.fsConn().logf("Release: unknown handle %d for node %d", input.Fh, input.NodeId)
+			return
+		}
 		opened := node.mount.unregisterFileHandle(input.Fh, node)
+		if code := opened.syncWrites(node.fsInode, &fuse.Context{Caller: input.Caller, Cancel: cancel}); !code.Ok() {
+			c.fsConn().logf("Release: writing buffered data for node %d: %v", input.NodeId, code)
+		}
 		opened.WithFlags.File.Release()
+		node.mount.checkIdle()
 	}
 }
 
-func (c *rawBridge) ReleaseDir(input *fuse.ReleaseIn) {
+func (c *rawBridge) ReleaseDir(cancel <-chan struct{}, input *fuse.ReleaseIn) {
 	if input.Fh != 0 {
 		node := c.toInode(input.NodeId)
-		node.mount.unregisterFileHandle(input.Fh, node)
+		if node == nil || node.mount.getOpenedFile(input.Fh) == nil {
+			c.fsConn().logf("ReleaseDir: unknown handle %d for node %d", input.Fh, input.NodeId)
+			return
+		}
+		opened := node.mount.unregisterFileHandle(input.Fh, node)
+		opened.dir.release()
+		node.mount.checkIdle()
 	}
 }
 
-func (c *rawBridge) GetXAttrSize(header *fuse.InHeader, attribute string) (sz int, code fuse.Status) {
+func (c *rawBridge) GetXAttrSize(cancel <-chan struct{}, header *fuse.InHeader, attribute string) (sz int, code fuse.Status) {
 	node := c.toInode(header.NodeId)
-	data, errno := node.fsInode.GetXAttr(attribute, &header.Context)
+	if node == nil {
+		return 0, fuse.ESTALE
+	}
+	data, errno := node.fsInode.GetXAttr(attribute, &fuse.Context{Caller: header.Caller, Cancel: cancel})
 	return len(data), errno
 }
 
-func (c *rawBridge) GetXAttrData(header *fuse.InHeader, attribute string) (data []byte, code fuse.Status) {
+func (c *rawBridge) GetXAttrData(cancel <-chan struct{}, header *fuse.InHeader, attribute string) (data []byte, code fuse.Status) {
 	node := c.toInode(header.NodeId)
-	return node.fsInode.GetXAttr(attribute, &header.Context)
+	if node == nil {
+		return nil, fuse.ESTALE
+	}
+	return node.fsInode.GetXAttr(attribute, &fuse.Context{Caller: header.Caller, Cancel: cancel})
 }
 
-func (c *rawBridge) RemoveXAttr(header *fuse.InHeader, attr string) fuse.Status {
+func (c *rawBridge) RemoveXAttr(cancel <-chan struct{}, header *fuse.InHeader, attr string) fuse.Status {
 	node := c.toInode(header.NodeId)
-	return node.fsInode.RemoveXAttr(attr, &header.Context)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	if node.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	defer node.invalidateAttr()
+	return node.fsInode.RemoveXAttr(attr, &fuse.Context{Caller: header.Caller, Cancel: cancel})
 }
 
-func (c *rawBridge) SetXAttr(input *fuse.SetXAttrIn, attr string, data []byte) fuse.Status {
+func (c *rawBridge) SetXAttr(cancel <-chan struct{}, input *fuse.SetXAttrIn, attr string, data []byte) fuse.Status {
 	node := c.toInode(input.NodeId)
-	return node.fsInode.SetXAttr(attr, data, int(input.Flags), &input.Context)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	if node.mount.options.ReadOnly {
+		return fuse.EROFS
+	}
+	defer node.invalidateAttr()
+	return node.fsInode.SetXAttr(attr, data, int(input.Flags), &fuse.Context{Caller: input.Caller, Cancel: cancel})
 }
 
-func (c *rawBridge) ListXAttr(header *fuse.InHeader) (data []byte, code fuse.Status) {
+func (c *rawBridge) ListXAttr(cancel <-chan struct{}, header *fuse.InHeader) (data []byte, code fuse.Status) {
 	node := c.toInode(header.NodeId)
-	attrs, code := node.fsInode.ListXAttr(&header.Context)
+	if node == nil {
+		return nil, fuse.ESTALE
+	}
+	attrs, code := node.fsInode.ListXAttr(&fuse.Context{Caller: header.Caller, Cancel: cancel})
 	if code != fuse.OK {
 		return nil, code
 	}
@@ -428,8 +828,14 @@ func (c *rawBridge) ListXAttr(header *fuse.InHeader) (data []byte, code fuse.Sta
 ////////////////
 // files.
 
-func (c *rawBridge) Write(input *fuse.WriteIn, data []byte) (written uint32, code fuse.Status) {
+func (c *rawBridge) Write(cancel <-chan struct{}, input *fuse.WriteIn, data []byte) (written uint32, code fuse.Status) {
 	node := c.toInode(input.NodeId)
+	if node == nil {
+		return 0, fuse.ESTALE
+	}
+	if node.mount.options.ReadOnly {
+		return 0, fuse.EROFS
+	}
 	opened := node.mount.getOpenedFile(input.Fh)
 
 	var f File
@@ -437,23 +843,62 @@ func (c *rawBridge) Write(input *fuse.WriteIn, data []byte) (written uint32, cod
 		f = opened.WithFlags.File
 	}
 
-	return node.Node().Write(f, data, int64(input.Offset), &input.Context)
+	defer node.invalidateAttr()
+	ctx := &fuse.Context{Caller: input.Caller, Cancel: cancel}
+	if size := node.mount.options.WriteBufferSize; opened != nil && size > 0 {
+		return opened.bufferWrite(node.fsInode, data, int64(input.Offset), size, ctx)
+	}
+	return node.Node().Write(f, data, int64(input.Offset), ctx)
 }
 
-func (c *rawBridge) Read(input *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
+func (c *rawBridge) WriteFd(cancel <-chan struct{}, input *fuse.WriteIn) (uintptr, bool) {
 	node := c.toInode(input.NodeId)
+	if node == nil {
+		return 0, false
+	}
+	if node.mount.options.ReadOnly {
+		return 0, false
+	}
+	opened := node.mount.getOpenedFile(input.Fh)
+	if opened == nil {
+		return 0, false
+	}
+	if node.mount.options.WriteBufferSize > 0 {
+		// Let Write order the data with the write buffer.
+		return 0, false
+	}
+	if f, ok := opened.WithFlags.File.(WriteFdFile); ok {
+		node.invalidateAttr()
+		return f.WriteFd()
+	}
+	return 0, false
+}
+
+func (c *rawBridge) Read(cancel <-chan struct{}, input *fuse.ReadIn, buf []byte) (fuse.ReadResult, fuse.Status) {
+	node := c.toInode(input.NodeId)
+	if node == nil {
+		return nil, fuse.ESTALE
+	}
 	opened := node.mount.getOpenedFile(input.Fh)
 
+	node.flushWrites(&fuse.Context{Caller: input.Caller, Cancel: cancel})
 	var f File
 	if opened != nil {
 		f = opened.WithFlags.File
+		off, size := int64(input.Offset), int64(input.Size)
+		if f != nil && node.mount.options.PrefetchSequential && opened.sequentialRead(off, size) {
+			f.Prefetch(off+size, size)
+		}
 	}
 
-	return node.Node().Read(f, buf, int64(input.Offset), &input.Context)
+	return node.Node().Read(f, buf, int64(input.Offset), &fuse.Context{Caller: input.Caller, Cancel: cancel})
 }
 
-func (c *rawBridge) Flock(input *fuse.FlockIn, flags int) fuse.Status {
+func (c *rawBridge) Flock(cancel <-chan struct{}, input *fuse.FlockIn, flags int) fuse.Status {
 	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
 	opened := node.mount.getOpenedFile(input.Fh)
 
 	if opened != nil {
@@ -463,21 +908,189 @@ func (c *rawBridge) Flock(input *fuse.FlockIn, flags int) fuse.Status {
 	return fuse.EBADF
 }
 
-func (c *rawBridge) StatFs(header *fuse.InHeader, out *fuse.StatfsOut) fuse.Status {
+func (c *rawBridge) Lseek(cancel <-chan struct{}, input *fuse.LseekIn, out *fuse.LseekOut) (code fuse.Status) {
+	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	opened := node.mount.getOpenedFile(input.Fh)
+
+	if opened == nil {
+		return fuse.EBADF
+	}
+	node.flushWrites(&fuse.Context{Caller: input.Caller, Cancel: cancel})
+	off, code := opened.WithFlags.File.Lseek(int64(input.Offset), input.Whence)
+	if code.Ok() {
+		out.Offset = uint64(off)
+	}
+	return code
+}
+
+func (c *rawBridge) Poll(cancel <-chan struct{}, input *fuse.PollIn, out *fuse.PollOut) (code fuse.Status) {
+	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	opened := node.mount.getOpenedFile(input.Fh)
+
+	if opened == nil {
+		return fuse.EBADF
+	}
+	out.Revents, code = opened.WithFlags.File.Poll(input.Kh, input.Flags, input.Events)
+	return code
+}
+
+func (c *rawBridge) Bmap(cancel <-chan struct{}, input *fuse.BmapIn, out *fuse.BmapOut) (code fuse.Status) {
+	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	out.Block, code = node.fsInode.Bmap(input.Block, input.Blocksize, &fuse.Context{Caller: input.Caller, Cancel: cancel})
+	return code
+}
+
+func (c *rawBridge) Ioctl(cancel <-chan struct{}, input *fuse.IoctlIn, inbuf []byte, out *fuse.IoctlOut) (data []byte, code fuse.Status) {
+	node := c.toInode(input.NodeId)
+	if node == nil {
+		return nil, fuse.ESTALE
+	}
+	opened := node.mount.getOpenedFile(input.Fh)
+
+	if opened == nil {
+		return nil, fuse.EBADF
+	}
+	f := opened.WithFlags.File
+	if input.Flags&fuse.FUSE_IOCTL_UNRESTRICTED != 0 {
+		if !node.mount.options.UnrestrictedIoctl {
+			return nil, fuse.Status(syscall.ENOTTY)
+		}
+		for r := f; r != nil; r = r.InnerFile() {
+			if rf, ok := r.(IoctlRetryFile); ok {
+				if in, outs, retry := rf.IoctlRetry(inbuf, input.Cmd, input.Arg, input.Flags); retry {
+					return out.Retry(in, outs), fuse.OK
+				}
+				break
+			}
+		}
+	}
+	data, out.Result, code = f.Ioctl(inbuf, input.Cmd, input.Arg, input.Flags)
+	return data, code
+}
+
+func (c *rawBridge) CopyFileRange(cancel <-chan struct{}, input *fuse.CopyFileRangeIn) (written uint32, code fuse.Status) {
+	srcNode := c.toInode(input.NodeId)
+	if srcNode == nil {
+		return 0, fuse.ESTALE
+	}
+	src := srcNode.mount.getOpenedFile(input.FhIn)
+	destNode := c.toInode(input.NodeIdOut)
+	if destNode == nil {
+		return 0, fuse.ESTALE
+	}
+	if destNode.mount.options.ReadOnly {
+		return 0, fuse.EROFS
+	}
+	dest := destNode.mount.getOpenedFile(input.FhOut)
+	if src == nil || dest == nil {
+		return 0, fuse.EBADF
+	}
+
+	ctx := &fuse.Context{Caller: input.Caller, Cancel: cancel}
+	srcNode.flushWrites(ctx)
+	destNode.flushWrites(ctx)
+	defer destNode.invalidateAttr()
+	return src.WithFlags.File.CopyFileRange(int64(input.OffIn),
+		dest.WithFlags.File, int64(input.OffOut), input.Len, input.Flags)
+}
+
+func (c *rawBridge) GetLk(cancel <-chan struct{}, input *fuse.LkIn, out *fuse.LkOut) (code fuse.Status) {
+	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	opened := node.mount.getOpenedFile(input.Fh)
+
+	if opened != nil {
+		return node.Node().GetLk(opened.WithFlags.File, input.Owner, &input.Lk, input.LkFlags, &out.Lk, &fuse.Context{Caller: input.Caller, Cancel: cancel})
+	}
+
+	return fuse.EBADF
+}
+
+func (c *rawBridge) SetLk(cancel <-chan struct{}, input *fuse.LkIn) (code fuse.Status) {
+	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	opened := node.mount.getOpenedFile(input.Fh)
+
+	if opened != nil {
+		return node.Node().SetLk(opened.WithFlags.File, input.Owner, &input.Lk, input.LkFlags, &fuse.Context{Caller: input.Caller, Cancel: cancel})
+	}
+
+	return fuse.EBADF
+}
+
+func (c *rawBridge) SetLkw(cancel <-chan struct{}, input *fuse.LkIn) (code fuse.Status) {
+	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
+	opened := node.mount.getOpenedFile(input.Fh)
+
+	if opened != nil {
+		return node.Node().SetLkw(opened.WithFlags.File, input.Owner, &input.Lk, input.LkFlags, &fuse.Context{Caller: input.Caller, Cancel: cancel})
+	}
+
+	return fuse.EBADF
+}
+
+// Sizes reported by StatFs if the file system leaves them out.
+const (
+	_DEFAULT_BLOCK_SIZE = 4096
+	_DEFAULT_NAME_LEN   = 255
+)
+
+func (c *rawBridge) StatFs(cancel <-chan struct{}, header *fuse.InHeader, out *fuse.StatfsOut) fuse.Status {
 	node := c.toInode(header.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
 	s := node.Node().StatFs()
-	if s == nil {
-		return fuse.ENOSYS
+	if s == nil && node != node.mount.mountInode {
+		// Let the root of the file system answer for its
+		// children.
+		s = node.mount.mountInode.Node().StatFs()
+	}
+	if s != nil {
+		*out = *s
+	}
+
+	// Report an empty file system rather than failing, and
+	// avoid zero block sizes, which tools like df divide by.
+	if out.Bsize == 0 {
+		out.Bsize = _DEFAULT_BLOCK_SIZE
+	}
+	if out.Frsize == 0 {
+		out.Frsize = out.Bsize
+	}
+	if out.NameLen == 0 {
+		out.NameLen = _DEFAULT_NAME_LEN
 	}
-	*out = *s
 	return fuse.OK
 }
 
-func (c *rawBridge) Flush(input *fuse.FlushIn) fuse.Status {
+func (c *rawBridge) Flush(cancel <-chan struct{}, input *fuse.FlushIn) fuse.Status {
 	node := c.toInode(input.NodeId)
+	if node == nil {
+		return fuse.ESTALE
+	}
 	opened := node.mount.getOpenedFile(input.Fh)
 
 	if opened != nil {
+		if code := opened.syncWrites(node.fsInode, &fuse.Context{Caller: input.Caller, Cancel: cancel}); !code.Ok() {
+			return code
+		}
 		return opened.WithFlags.File.Flush()
 	}
 	return fuse.OK
@@ -8,7 +8,8 @@ import (
 	"github.com/hanwen/go-fuse/fuse"
 )
 
-// Mounts a filesystem with the given root node on the given directory
+// Mounts a filesystem with the given root node on the given directory.
+// If GetAttr on root reports a regular file, mountpoint must be a file.
 func MountRoot(mountpoint string, root Node, opts *Options) (*fuse.Server, *FileSystemConnector, error) {
 	conn := NewFileSystemConnector(root, opts)
 
@@ -16,6 +17,14 @@ func MountRoot(mountpoint string, root Node, opts *Options) (*fuse.Server, *File
 	if opts != nil && opts.Debug {
 		mountOpts.Debug = opts.Debug
 	}
+	if opts != nil {
+		mountOpts.DefaultPermissions = opts.DefaultPermissions
+		mountOpts.ExportSupport = opts.ExportSupport
+		mountOpts.Logger = opts.Logger
+		if opts.ReadOnly {
+			mountOpts.Options = append(mountOpts.Options, "ro")
+		}
+	}
 	s, err := fuse.NewServer(conn.RawFS(), mountpoint, &mountOpts)
 	if err != nil {
 		return nil, nil, err
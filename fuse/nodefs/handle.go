@@ -12,7 +12,9 @@ import (
 // HandleMap translates objects in Go space to 64-bit handles that can
 // be given out to -say- the linux kernel as NodeIds.
 //
-// The 32 bits version of this is a threadsafe wrapper around a map.
+// Handles are indices into a table rather than object addresses, so
+// decoding is a lookup, and works the same on 32- and 64-bit
+// platforms.
 //
 // To use it, include "handled" as first member of the structure
 // you wish to export.
@@ -22,7 +24,8 @@ type handleMap interface {
 	// Register stores "obj" and returns a unique (NodeId, generation) tuple.
 	Register(obj *handled) (handle, generation uint64)
 	Count() int
-	// Decode retrieves a stored object from its 64-bit handle.
+	// Decode retrieves a stored object from its 64-bit handle, or
+	// returns nil if the handle is not in use.
 	Decode(uint64) *handled
 	// Forget decrements the reference counter for "handle" by "count" and drops
 	// the object if the refcount reaches zero.
@@ -32,6 +35,10 @@ type handleMap interface {
 	Handle(obj *handled) uint64
 	// Has checks if NodeId is stored.
 	Has(uint64) bool
+	// DecodeGeneration retrieves a stored object if it was
+	// registered with the given generation, and returns nil
+	// otherwise, also for handles that were never given out.
+	DecodeGeneration(handle, generation uint64) *handled
 }
 
 type handled struct {
@@ -121,9 +128,11 @@ func (m *portableHandleMap) Count() int {
 
 func (m *portableHandleMap) Decode(h uint64) *handled {
 	m.RLock()
-	v := m.handles[h]
-	m.RUnlock()
-	return v
+	defer m.RUnlock()
+	if h >= uint64(len(m.handles)) {
+		return nil
+	}
+	return m.handles[h]
 }
 
 func (m *portableHandleMap) Forget(h uint64, count int) (forgotten bool, obj *handled) {
@@ -145,7 +154,20 @@ func (m *portableHandleMap) Forget(h uint64, count int) (forgotten bool, obj *ha
 
 func (m *portableHandleMap) Has(h uint64) bool {
 	m.RLock()
-	ok := m.handles[h] != nil
+	ok := h < uint64(len(m.handles)) && m.handles[h] != nil
 	m.RUnlock()
 	return ok
 }
+
+func (m *portableHandleMap) DecodeGeneration(h, generation uint64) *handled {
+	m.RLock()
+	defer m.RUnlock()
+	if h >= uint64(len(m.handles)) {
+		return nil
+	}
+	v := m.handles[h]
+	if v == nil || v.generation != generation {
+		return nil
+	}
+	return v
+}
@@ -159,3 +159,30 @@ func TestHandleMapGenerationKnown(t *testing.T) {
 		t.Fatalf("register known should reuse generation: got %d want %d.", g3, g1)
 	}
 }
+
+// Handles are small table indices, not addresses, so they fit the
+// kernel's NodeIds on any platform, and freed ones are reused.
+func TestHandleMapIndices(t *testing.T) {
+	hm := newPortableHandleMap()
+	var objs []*handled
+	for i := 0; i < 3; i++ {
+		v := &handled{}
+		h, _ := hm.Register(v)
+		if want := uint64(i + 2); h != want {
+			t.Errorf("got handle %d, want %d", h, want)
+		}
+		objs = append(objs, v)
+	}
+	h := hm.Handle(objs[1])
+	hm.Forget(h, 1)
+	if hm.Decode(h) != nil {
+		t.Errorf("Decode(%d) after Forget: got %p, want nil", h, hm.Decode(h))
+	}
+	v := &handled{}
+	if got, _ := hm.Register(v); got != h {
+		t.Errorf("got handle %d, want reused %d", got, h)
+	}
+	if hm.Decode(h) != v {
+		t.Errorf("Decode(%d): got %p, want %p", h, hm.Decode(h), v)
+	}
+}
@@ -5,8 +5,13 @@
 package nodefs
 
 import (
+	"container/list"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 )
@@ -30,12 +35,53 @@ type Inode struct {
 
 	fsInode Node
 
+	// The S_IFMT bits of the mode, or 0 if not known yet.
+	// Accessed atomically.
+	fileType uint32
+
+	// 1 if the connector holds a lookup count for
+	// Options.ExportSupport. Accessed atomically.
+	exportPin int32
+
+	// Position in the connector's inodeLRU, protected by its
+	// mutex.
+	lruElem *list.Element
+
+	// Cached Readlink result, see Options.SymlinkCacheTimeout.
+	linkMu     sync.Mutex
+	link       []byte
+	linkExpiry time.Time
+
+	// Cached attributes, see Options.CacheAttributes. attrGen
+	// counts invalidations, so attributes read before one are not
+	// cached after it.
+	attrMu     sync.Mutex
+	attr       fuse.Attr
+	attrExpiry time.Time
+	attrGen    uint64
+
+	// Held while the connector adds an entry to this directory,
+	// so the emulation of fuse.RENAME_NOREPLACE can check for the
+	// destination and rename without another entry appearing in
+	// between.
+	dirMu sync.Mutex
+
 	// Each inode belongs to exactly one fileSystemMount. This
 	// pointer is constant during the lifetime, except upon
 	// Unmount() when it is set to nil.
 	mount *fileSystemMount
 
-	// All data below is protected by treeLock.
+	// The data below is protected by the treeLock of the mount
+	// that has the inode as a child, except that the children of
+	// a mount point belong to its own mount. Holders of a read
+	// lock also need treeMu to look at the maps of an inode, and
+	// lock a parent before its child; holders of the write lock
+	// do not. This lets the children of different directories
+	// change in parallel, while changes that involve several
+	// directories take the write lock.
+	treeMu sync.Mutex
+
+	// Children keyed by childKey() of their name.
 	children map[string]*Inode
 	// Due to hard links, an Inode can have many parents.
 	parents map[parentData]struct{}
@@ -45,10 +91,13 @@ type Inode struct {
 	mountPoint *fileSystemMount
 }
 
-func newInode(isDir bool, fsNode Node) *Inode {
+// newInode creates an Inode of the given file type, which may be 0
+// if it is not known yet.
+func newInode(fileType uint32, fsNode Node) *Inode {
 	me := new(Inode)
 	me.parents = map[parentData]struct{}{}
-	if isDir {
+	me.fileType = fileType & syscall.S_IFMT
+	if me.fileType == syscall.S_IFDIR {
 		me.children = make(map[string]*Inode, initDirSize)
 	}
 	me.fsInode = fsNode
@@ -74,10 +123,12 @@ func (n *Inode) AnyFile() (file File) {
 // Children returns all children of this inode.
 func (n *Inode) Children() (out map[string]*Inode) {
 	n.mount.treeLock.RLock()
+	n.treeMu.Lock()
 	out = make(map[string]*Inode, len(n.children))
 	for k, v := range n.children {
-		out[k] = v
+		out[n.sharedChildName(k, v)] = v
 	}
+	n.treeMu.Unlock()
 	n.mount.treeLock.RUnlock()
 
 	return out
@@ -92,22 +143,90 @@ func (n *Inode) Parent() (parent *Inode, name string) {
 	}
 	n.mount.treeLock.RLock()
 	defer n.mount.treeLock.RUnlock()
+	n.treeMu.Lock()
+	defer n.treeMu.Unlock()
 	for k := range n.parents {
 		return k.parent, k.name
 	}
 	return nil, ""
 }
 
+// Path returns the path of the inode from the root of the
+// FileSystemConnector, crossing mounts, and "" for the root itself.
+// If the inode is reachable by several names because of hard links,
+// the lexically smallest name is used at each level. It returns false
+// if the inode is no longer in the tree. The path is only a snapshot:
+// a concurrent Rename may change it right after it is returned. Like
+// Parent, it must not be called from Deletable or OnForget.
+func (n *Inode) Path() (path string, ok bool) {
+	var segments []string
+	for {
+		m := n.mount
+		if m == nil {
+			return "", false
+		}
+		// Walk up to the root of this mount. Names may be
+		// added and removed while we walk, so a concurrent
+		// rename may give a mix of old and new names.
+		m.treeLock.RLock()
+		for n.mountPoint == nil {
+			p, ok := n.firstParent()
+			if !ok {
+				m.treeLock.RUnlock()
+				return "", false
+			}
+			segments = append(segments, p.name)
+			n = p.parent
+		}
+		m.treeLock.RUnlock()
+
+		parentInode := n.mountPoint.parentInode
+		if parentInode == nil {
+			// The root of the connector.
+			break
+		}
+		// The mount point itself is a child in the parent mount.
+		parentInode.mount.treeLock.RLock()
+		p, ok := n.firstParent()
+		parentInode.mount.treeLock.RUnlock()
+		if !ok {
+			return "", false
+		}
+		segments = append(segments, p.name)
+		n = p.parent
+	}
+	for i, j := 0, len(segments)-1; i < j; i, j = i+1, j-1 {
+		segments[i], segments[j] = segments[j], segments[i]
+	}
+	return strings.Join(segments, "/"), true
+}
+
+// firstParent returns the parent entry with the smallest name. The
+// caller must hold the treeLock of the mount that has n as a child,
+// for reading.
+func (n *Inode) firstParent() (p parentData, ok bool) {
+	n.treeMu.Lock()
+	defer n.treeMu.Unlock()
+	for k := range n.parents {
+		if !ok || k.name < p.name {
+			p, ok = k, true
+		}
+	}
+	return p, ok
+}
+
 // FsChildren returns all the children from the same filesystem.  It
 // will skip mountpoints.
 func (n *Inode) FsChildren() (out map[string]*Inode) {
 	n.mount.treeLock.RLock()
+	n.treeMu.Lock()
 	out = map[string]*Inode{}
 	for k, v := range n.children {
 		if v.mount == n.mount {
-			out[k] = v
+			out[n.sharedChildName(k, v)] = v
 		}
 	}
+	n.treeMu.Unlock()
 	n.mount.treeLock.RUnlock()
 
 	return out
@@ -118,6 +237,27 @@ func (n *Inode) Node() Node {
 	return n.fsInode
 }
 
+// flushWrites passes the write buffers of all open files to
+// Node.Write, for Options.WriteBufferSize. Cached attributes are
+// dropped if anything was written, as the size may have changed.
+func (n *Inode) flushWrites(context *fuse.Context) {
+	if n.mount.options.WriteBufferSize <= 0 {
+		return
+	}
+	n.openFilesMutex.Lock()
+	files := append([]*openedFile(nil), n.openFiles...)
+	n.openFilesMutex.Unlock()
+	flushed := false
+	for _, f := range files {
+		if f.flushWrites(n.fsInode, context) {
+			flushed = true
+		}
+	}
+	if flushed {
+		n.invalidateAttr()
+	}
+}
+
 // Files() returns an opens file that have bits in common with the
 // give mask.  Use mask==0 to return all files.
 func (n *Inode) Files(mask uint32) (files []WithFlags) {
@@ -136,19 +276,61 @@ func (n *Inode) IsDir() bool {
 	return n.children != nil
 }
 
+// FileType returns the file type bits (S_IFMT) of the mode. For
+// directories, this is S_IFDIR. For other nodes, it is the type
+// last reported to the kernel through GetAttr or Lookup, or 0 if the
+// kernel has not seen the node yet.
+func (n *Inode) FileType() uint32 {
+	return atomic.LoadUint32(&n.fileType)
+}
+
+// setFileType records the type from attributes sent to the kernel.
+// Directories stay directories, as they have children.
+func (n *Inode) setFileType(mode uint32) {
+	t := mode & syscall.S_IFMT
+	if n.IsDir() || t == 0 || t == syscall.S_IFDIR {
+		return
+	}
+	atomic.StoreUint32(&n.fileType, t)
+}
+
 // NewChild adds a new child inode to this inode.
 func (n *Inode) NewChild(name string, isDir bool, fsi Node) *Inode {
-	ch := newInode(isDir, fsi)
+	var fileType uint32
+	if isDir {
+		fileType = syscall.S_IFDIR
+	}
+	ch := newInode(fileType, fsi)
 	ch.mount = n.mount
 	n.AddChild(name, ch)
 	return ch
 }
 
+// newChildExcl is like NewChild, but leaves the tree alone if name
+// already exists, and returns the existing child instead.
+func (n *Inode) newChildExcl(name string, fsi Node) (existing *Inode) {
+	ch := newInode(0, fsi)
+	ch.mount = n.mount
+	n.mount.treeLock.RLock()
+	defer n.mount.treeLock.RUnlock()
+	n.treeMu.Lock()
+	defer n.treeMu.Unlock()
+	if existing = n.children[n.childKey(name)]; existing != nil {
+		return existing
+	}
+	ch.treeMu.Lock()
+	n.addChild(name, ch)
+	ch.treeMu.Unlock()
+	return nil
+}
+
 // GetChild returns a child inode with the given name, or nil if it
 // does not exist.
 func (n *Inode) GetChild(name string) (child *Inode) {
 	n.mount.treeLock.RLock()
-	child = n.children[name]
+	n.treeMu.Lock()
+	child = n.children[n.childKey(name)]
+	n.treeMu.Unlock()
 	n.mount.treeLock.RUnlock()
 
 	return child
@@ -160,15 +342,21 @@ func (n *Inode) AddChild(name string, child *Inode) {
 	if child == nil {
 		log.Panicf("adding nil child as %q", name)
 	}
-	n.mount.treeLock.Lock()
+	n.mount.treeLock.RLock()
+	n.treeMu.Lock()
+	child.treeMu.Lock()
 	n.addChild(name, child)
-	n.mount.treeLock.Unlock()
+	child.treeMu.Unlock()
+	n.treeMu.Unlock()
+	n.mount.treeLock.RUnlock()
 }
 
 // TreeWatcher is an additional interface that Nodes can implement.
 // If they do, the OnAdd and OnRemove are called for operations on the
 // file system tree. These functions run under a lock, so they should
-// not do blocking operations.
+// not do blocking operations. Only the directory that changes is
+// locked, so the callbacks may run concurrently for different
+// directories, and must protect any state they share.
 type TreeWatcher interface {
 	OnAdd(parent *Inode, name string)
 	OnRemove(parent *Inode, name string)
@@ -177,25 +365,52 @@ type TreeWatcher interface {
 // RmChild removes an inode by name, and returns it. It returns nil if
 // child does not exist.
 func (n *Inode) RmChild(name string) (ch *Inode) {
+	n.mount.treeLock.RLock()
+	defer n.mount.treeLock.RUnlock()
+	n.treeMu.Lock()
+	defer n.treeMu.Unlock()
+	ch = n.children[n.childKey(name)]
+	if ch == nil {
+		return nil
+	}
+	ch.treeMu.Lock()
+	defer ch.treeMu.Unlock()
+	return n.rmChild(name)
+}
+
+// ExchangeChild swaps the child called name with the child called
+// newName of newParent, as needed for fuse.RENAME_EXCHANGE. Both
+// parents must be in the same mount. The swap is atomic for
+// observers of the Inode tree. If one of the children does not
+// exist, the other one is moved.
+func (n *Inode) ExchangeChild(name string, newParent *Inode, newName string) {
 	n.mount.treeLock.Lock()
-	ch = n.rmChild(name)
-	n.mount.treeLock.Unlock()
-	return
+	defer n.mount.treeLock.Unlock()
+	src := n.rmChild(name)
+	dest := newParent.rmChild(newName)
+	if src != nil {
+		newParent.addChild(newName, src)
+	}
+	if dest != nil {
+		n.addChild(name, dest)
+	}
 }
 
 //////////////////////////////////////////////////////////////
 // private
 
 // addChild adds "child" to our children under name "name".
-// Must be called with treeLock for the mount held.
+// Must be called with treeLock for the mount held for writing, or
+// for reading with the treeMu of n and child.
 func (n *Inode) addChild(name string, child *Inode) {
+	key := n.childKey(name)
 	if paranoia {
-		ch := n.children[name]
+		ch := n.children[key]
 		if ch != nil {
 			log.Panicf("Already have an Inode with same name: %v: %v", name, ch)
 		}
 	}
-	n.children[name] = child
+	n.children[key] = child
 	child.parents[parentData{n, name}] = struct{}{}
 	if w, ok := child.Node().(TreeWatcher); ok && child.mountPoint == nil {
 		w.OnAdd(n, name)
@@ -204,11 +419,13 @@ func (n *Inode) addChild(name string, child *Inode) {
 
 // rmChild throws out child "name". This means (1) deleting "name" from our
 // "children" map and (2) deleting ourself from the child's "parents" map.
-// Must be called with treeLock for the mount held.
+// Must be called with treeLock for the mount held, as for addChild.
 func (n *Inode) rmChild(name string) *Inode {
-	ch := n.children[name]
+	key := n.childKey(name)
+	ch := n.children[key]
 	if ch != nil {
-		delete(n.children, name)
+		name = n.childName(key, ch)
+		delete(n.children, key)
 		delete(ch.parents, parentData{n, name})
 		if w, ok := ch.Node().(TreeWatcher); ok && ch.mountPoint == nil {
 			w.OnRemove(n, name)
@@ -217,6 +434,124 @@ func (n *Inode) rmChild(name string) *Inode {
 	return ch
 }
 
+// cachedLink returns the cached Readlink result, or nil.
+func (n *Inode) cachedLink() []byte {
+	n.linkMu.Lock()
+	defer n.linkMu.Unlock()
+	if n.link != nil && time.Now().Before(n.linkExpiry) {
+		return n.link
+	}
+	n.link = nil
+	return nil
+}
+
+func (n *Inode) setCachedLink(link []byte, timeout time.Duration) {
+	n.linkMu.Lock()
+	n.link = link
+	n.linkExpiry = time.Now().Add(timeout)
+	n.linkMu.Unlock()
+}
+
+func (n *Inode) invalidateLink() {
+	n.linkMu.Lock()
+	n.link = nil
+	n.linkMu.Unlock()
+}
+
+// cachedAttr copies the cached attributes into out, and returns
+// whether there were any. Otherwise, it returns the generation to
+// pass to setCachedAttr.
+func (n *Inode) cachedAttr(out *fuse.Attr) (ok bool, gen uint64) {
+	n.attrMu.Lock()
+	defer n.attrMu.Unlock()
+	if !time.Now().Before(n.attrExpiry) {
+		return false, n.attrGen
+	}
+	*out = n.attr
+	return true, n.attrGen
+}
+
+func (n *Inode) attrGeneration() uint64 {
+	n.attrMu.Lock()
+	defer n.attrMu.Unlock()
+	return n.attrGen
+}
+
+// setCachedAttr caches attr, unless the cache was invalidated since
+// generation gen.
+func (n *Inode) setCachedAttr(attr *fuse.Attr, timeout time.Duration, gen uint64) {
+	n.attrMu.Lock()
+	if n.attrGen == gen {
+		n.attr = *attr
+		n.attrExpiry = time.Now().Add(timeout)
+	}
+	n.attrMu.Unlock()
+}
+
+func (n *Inode) invalidateAttr() {
+	n.attrMu.Lock()
+	n.attrExpiry = time.Time{}
+	n.attrGen++
+	n.attrMu.Unlock()
+}
+
+// childKey returns the key for name in the children map.
+func (n *Inode) childKey(name string) string {
+	if n.caseInsensitive() {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// childName returns the name under which child was added as key.
+// Must be called with treeLock for the mount held for writing, or
+// for reading with the treeMu of child.
+func (n *Inode) childName(key string, child *Inode) string {
+	if !n.caseInsensitive() {
+		return key
+	}
+	for p := range child.parents {
+		if p.parent == n && strings.ToLower(p.name) == key {
+			return p.name
+		}
+	}
+	return key
+}
+
+// sharedChildName is childName for callers that hold treeLock for
+// reading and the treeMu of n.
+func (n *Inode) sharedChildName(key string, child *Inode) string {
+	if !n.caseInsensitive() {
+		return key
+	}
+	child.treeMu.Lock()
+	defer child.treeMu.Unlock()
+	return n.childName(key, child)
+}
+
+func (n *Inode) caseInsensitive() bool {
+	return n.mount != nil && n.mount.options != nil && n.mount.options.CaseInsensitive
+}
+
+// dropFromParents removes the inode from all of its parents, and
+// returns them. Must be called with treeLock for the mount held.
+func (n *Inode) dropFromParents() []*Inode {
+	// Create a copy of n.parents so we can safely iterate over it
+	// while modifying the original.
+	parents := make([]parentData, 0, len(n.parents))
+	for k := range n.parents {
+		parents = append(parents, k)
+	}
+
+	out := make([]*Inode, 0, len(parents))
+	for _, p := range parents {
+		// This also modifies n.parents
+		p.parent.rmChild(p.name)
+		out = append(out, p.parent)
+	}
+	return out
+}
+
 // Can only be called on untouched root inodes.
 func (n *Inode) mountFs(opts *Options) {
 	n.mountPoint = &fileSystemMount{
@@ -227,35 +562,61 @@ func (n *Inode) mountFs(opts *Options) {
 	n.mount = n.mountPoint
 }
 
-// Must be called with treeLock held.
-func (n *Inode) canUnmount() bool {
+// canUnmount returns false if there are submounts below the inode,
+// or, if checkOpen is set, open files. Must be called with treeLock
+// held.
+func (n *Inode) canUnmount(checkOpen bool) bool {
 	for _, v := range n.children {
 		if v.mountPoint != nil {
 			// This access may be out of date, but it is no
 			// problem to err on the safe side.
 			return false
 		}
-		if !v.canUnmount() {
+		if !v.canUnmount(checkOpen) {
 			return false
 		}
 	}
 
+	if !checkOpen {
+		return true
+	}
 	n.openFilesMutex.Lock()
 	ok := len(n.openFiles) == 0
 	n.openFilesMutex.Unlock()
 	return ok
 }
 
+// subMounts returns the mount points directly below the inode in
+// its file system. Must be called with treeLock held for reading.
+func (n *Inode) subMounts() (out []*Inode) {
+	n.treeMu.Lock()
+	children := make([]*Inode, 0, len(n.children))
+	for _, v := range n.children {
+		children = append(children, v)
+	}
+	n.treeMu.Unlock()
+	for _, v := range children {
+		if v.mountPoint != nil {
+			out = append(out, v)
+		} else {
+			out = append(out, v.subMounts()...)
+		}
+	}
+	return out
+}
+
 func (n *Inode) getMountDirEntries() (out []fuse.DirEntry) {
 	n.mount.treeLock.RLock()
+	n.treeMu.Lock()
 	for k, v := range n.children {
 		if v.mountPoint != nil {
 			out = append(out, fuse.DirEntry{
-				Name: k,
+				Name: n.sharedChildName(k, v),
 				Mode: fuse.S_IFDIR,
 			})
 		}
 	}
+	n.treeMu.Unlock()
 	n.mount.treeLock.RUnlock()
 
 	return out
@@ -7,6 +7,7 @@ package nodefs
 import (
 	"fmt"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
@@ -55,11 +56,33 @@ func (f *lockingFile) Flush() fuse.Status {
 }
 
 func (f *lockingFile) Flock(flags int) fuse.Status {
+	if flags&syscall.LOCK_NB == 0 {
+		// May block; see SetLkw.
+		return f.file.Flock(flags)
+	}
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	return f.file.Flock(flags)
 }
 
+func (f *lockingFile) GetLk(owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (code fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.GetLk(owner, lk, flags, out)
+}
+
+func (f *lockingFile) SetLk(owner uint64, lk *fuse.FileLock, flags uint32) (code fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.SetLk(owner, lk, flags)
+}
+
+// SetLkw does not hold the mutex while blocking, so the current lock
+// holder can still release its lock.
+func (f *lockingFile) SetLkw(owner uint64, lk *fuse.FileLock, flags uint32) (code fuse.Status) {
+	return f.file.SetLkw(owner, lk, flags)
+}
+
 func (f *lockingFile) Release() {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -107,3 +130,33 @@ func (f *lockingFile) Allocate(off uint64, size uint64, mode uint32) (code fuse.
 	defer f.mu.Unlock()
 	return f.file.Allocate(off, size, mode)
 }
+
+func (f *lockingFile) Lseek(off int64, whence uint32) (int64, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Lseek(off, whence)
+}
+
+func (f *lockingFile) CopyFileRange(off int64, dest File, destOff int64, len uint64, flags uint64) (uint32, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.CopyFileRange(off, dest, destOff, len, flags)
+}
+
+func (f *lockingFile) Poll(kh uint64, flags uint32, events uint32) (uint32, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Poll(kh, flags, events)
+}
+
+func (f *lockingFile) Prefetch(off int64, size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.file.Prefetch(off, size)
+}
+
+func (f *lockingFile) Ioctl(input []byte, cmd uint32, arg uint64, flags uint32) ([]byte, int32, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Ioctl(input, cmd, arg, flags)
+}
@@ -0,0 +1,318 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// NewLoopbackNodeFSRoot returns the root of a node-based filesystem
+// that mirrors the host directory root. Operations are passed on to
+// the host files, and the inode tree follows lookups, creations,
+// renames and removals done through the mount.
+//
+// If asCaller is set, operations that create, remove or inspect
+// files run with the file system uid and gid of the caller, so the
+// host kernel does permission checks and new files are owned by the
+// caller. This only has effect on Linux, and requires the process to
+// run as root. Supplementary groups of the caller are not taken into
+// account.
+func NewLoopbackNodeFSRoot(root string, asCaller bool) Node {
+	// Make sure the root path is absolute to avoid problems when
+	// the application changes working directory.
+	root, err := filepath.Abs(root)
+	if err != nil {
+		panic(err)
+	}
+	fs := &loopbackNodeFs{
+		root:     root,
+		asCaller: asCaller,
+	}
+	fs.rootNode = fs.newNode(0)
+	return fs.rootNode
+}
+
+type loopbackNodeFs struct {
+	root     string
+	asCaller bool
+	rootNode *loopbackNode
+}
+
+func (fs *loopbackNodeFs) newNode(ino uint64) *loopbackNode {
+	return &loopbackNode{
+		Node: NewDefaultNode(),
+		fs:   fs,
+		ino:  ino,
+	}
+}
+
+type loopbackNode struct {
+	Node
+	fs *loopbackNodeFs
+
+	// ino is the host inode number, used to detect names that
+	// were replaced on the host behind our back.
+	ino uint64
+}
+
+func (n *loopbackNode) String() string {
+	return fmt.Sprintf("LoopbackNode(%s)", n.path())
+}
+
+// path returns the host path of the node.
+func (n *loopbackNode) path() string {
+	var segments []string
+	walkUp := n.Inode()
+	for walkUp != n.fs.rootNode.Inode() {
+		parent, name := walkUp.Parent()
+		if parent == nil {
+			break
+		}
+		segments = append(segments, name)
+		walkUp = parent
+	}
+	p := n.fs.root
+	for i := len(segments) - 1; i >= 0; i-- {
+		p = filepath.Join(p, segments[i])
+	}
+	return p
+}
+
+func (n *loopbackNode) childPath(name string) string {
+	return filepath.Join(n.path(), name)
+}
+
+func (n *loopbackNode) Ino() uint64 {
+	return n.ino
+}
+
+func (n *loopbackNode) StatFs() *fuse.StatfsOut {
+	s := syscall.Statfs_t{}
+	if err := syscall.Statfs(n.path(), &s); err != nil {
+		return nil
+	}
+	out := &fuse.StatfsOut{}
+	out.FromStatfsT(&s)
+	return out
+}
+
+// addChild returns the inode for name, given its host attributes.
+// A known child is reused, unless the host file was replaced.
+func (n *loopbackNode) addChild(name string, st *syscall.Stat_t) *Inode {
+	if ch := n.Inode().GetChild(name); ch != nil {
+		if ln, ok := ch.Node().(*loopbackNode); ok && ln.ino == st.Ino {
+			return ch
+		}
+		n.Inode().RmChild(name)
+	}
+	isDir := st.Mode&syscall.S_IFMT == syscall.S_IFDIR
+	return n.Inode().NewChild(name, isDir, n.fs.newNode(st.Ino))
+}
+
+// lookup stats name on the host and returns its inode.
+func (n *loopbackNode) lookup(out *fuse.Attr, name string) (*Inode, fuse.Status) {
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(n.childPath(name), &st); err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	out.FromStat(&st)
+	return n.addChild(name, &st), fuse.OK
+}
+
+func (n *loopbackNode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*Inode, fuse.Status) {
+	defer n.fs.become(context)()
+	return n.lookup(out, name)
+}
+
+func (n *loopbackNode) Access(mode uint32, context *fuse.Context) fuse.Status {
+	defer n.fs.become(context)()
+	return fuse.ToStatus(syscall.Access(n.path(), mode))
+}
+
+func (n *loopbackNode) Readlink(context *fuse.Context) ([]byte, fuse.Status) {
+	defer n.fs.become(context)()
+	l, err := os.Readlink(n.path())
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	return []byte(l), fuse.OK
+}
+
+func (n *loopbackNode) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) (*Inode, fuse.Status) {
+	defer n.fs.become(context)()
+	if err := mknod(n.childPath(name), mode, dev); err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	var attr fuse.Attr
+	return n.lookup(&attr, name)
+}
+
+func (n *loopbackNode) Mkdir(name string, mode uint32, context *fuse.Context) (*Inode, fuse.Status) {
+	defer n.fs.become(context)()
+	if err := os.Mkdir(n.childPath(name), os.FileMode(mode)); err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	var attr fuse.Attr
+	return n.lookup(&attr, name)
+}
+
+// Don't use os.Remove, it removes twice (unlink followed by rmdir).
+func (n *loopbackNode) Unlink(name string, context *fuse.Context) fuse.Status {
+	defer n.fs.become(context)()
+	if err := syscall.Unlink(n.childPath(name)); err != nil {
+		return fuse.ToStatus(err)
+	}
+	n.Inode().RmChild(name)
+	return fuse.OK
+}
+
+func (n *loopbackNode) Rmdir(name string, context *fuse.Context) fuse.Status {
+	defer n.fs.become(context)()
+	if err := syscall.Rmdir(n.childPath(name)); err != nil {
+		return fuse.ToStatus(err)
+	}
+	n.Inode().RmChild(name)
+	return fuse.OK
+}
+
+func (n *loopbackNode) Symlink(name string, content string, context *fuse.Context) (*Inode, fuse.Status) {
+	defer n.fs.become(context)()
+	if err := os.Symlink(content, n.childPath(name)); err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	var attr fuse.Attr
+	return n.lookup(&attr, name)
+}
+
+func (n *loopbackNode) Rename(oldName string, newParent Node, newName string, context *fuse.Context) fuse.Status {
+	p, ok := newParent.(*loopbackNode)
+	if !ok || p.fs != n.fs {
+		return fuse.EXDEV
+	}
+	defer n.fs.become(context)()
+	if err := os.Rename(n.childPath(oldName), p.childPath(newName)); err != nil {
+		return fuse.ToStatus(err)
+	}
+	ch := n.Inode().RmChild(oldName)
+	p.Inode().RmChild(newName)
+	if ch != nil {
+		p.Inode().AddChild(newName, ch)
+	}
+	return fuse.OK
+}
+
+func (n *loopbackNode) Link(name string, existing Node, context *fuse.Context) (*Inode, fuse.Status) {
+	e, ok := existing.(*loopbackNode)
+	if !ok || e.fs != n.fs {
+		return nil, fuse.EXDEV
+	}
+	defer n.fs.become(context)()
+	if err := os.Link(e.path(), n.childPath(name)); err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	n.Inode().AddChild(name, e.Inode())
+	return e.Inode(), fuse.OK
+}
+
+func (n *loopbackNode) Create(name string, flags uint32, mode uint32, context *fuse.Context) (File, *Inode, fuse.Status) {
+	defer n.fs.become(context)()
+	f, err := os.OpenFile(n.childPath(name), int(flags)|os.O_CREATE, os.FileMode(mode))
+	if err != nil {
+		return nil, nil, fuse.ToStatus(err)
+	}
+	st := syscall.Stat_t{}
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		f.Close()
+		return nil, nil, fuse.ToStatus(err)
+	}
+	return NewLoopbackFile(f), n.addChild(name, &st), fuse.OK
+}
+
+func (n *loopbackNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	defer n.fs.become(context)()
+	f, err := os.OpenFile(n.path(), int(flags), 0)
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	return NewLoopbackFile(f), fuse.OK
+}
+
+func (n *loopbackNode) OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	defer n.fs.become(context)()
+	f, err := os.Open(n.path())
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	out := make([]fuse.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		d := fuse.DirEntry{Name: info.Name()}
+		if s := fuse.ToStatT(info); s != nil {
+			d.Mode = uint32(s.Mode)
+			d.Ino = s.Ino
+		}
+		out = append(out, d)
+	}
+	return out, fuse.OK
+}
+
+func (n *loopbackNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	if file != nil {
+		return file.GetAttr(out)
+	}
+	st := syscall.Stat_t{}
+	var err error
+	if n == n.fs.rootNode {
+		// Look through a symlink at the top.
+		err = syscall.Stat(n.path(), &st)
+	} else {
+		err = syscall.Lstat(n.path(), &st)
+	}
+	if err != nil {
+		return fuse.ToStatus(err)
+	}
+	out.FromStat(&st)
+	return fuse.OK
+}
+
+func (n *loopbackNode) Chmod(file File, perms uint32, context *fuse.Context) fuse.Status {
+	defer n.fs.become(context)()
+	if file != nil {
+		return file.Chmod(perms)
+	}
+	return fuse.ToStatus(os.Chmod(n.path(), os.FileMode(perms)))
+}
+
+func (n *loopbackNode) Chown(file File, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	defer n.fs.become(context)()
+	if file != nil {
+		return file.Chown(uid, gid)
+	}
+	return fuse.ToStatus(os.Lchown(n.path(), int(uid), int(gid)))
+}
+
+func (n *loopbackNode) Truncate(file File, size uint64, context *fuse.Context) fuse.Status {
+	defer n.fs.become(context)()
+	if file != nil {
+		return file.Truncate(size)
+	}
+	return fuse.ToStatus(os.Truncate(n.path(), int64(size)))
+}
+
+func (n *loopbackNode) Fallocate(file File, off uint64, size uint64, mode uint32, context *fuse.Context) fuse.Status {
+	if file != nil {
+		return file.Allocate(off, size, mode)
+	}
+	return fuse.ENOSYS
+}
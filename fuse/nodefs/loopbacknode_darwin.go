@@ -0,0 +1,39 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/internal/utimens"
+)
+
+// become is a no-op: there is no setfsuid on OSX.
+func (fs *loopbackNodeFs) become(context *fuse.Context) func() {
+	return func() {}
+}
+
+func (n *loopbackNode) Utimens(file File, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	if file != nil {
+		return file.Utimens(atime, mtime)
+	}
+	// MacOS before High Sierra lacks utimensat() and UTIME_OMIT.
+	// We emulate using utimes() and extra GetAttr() calls.
+	var attr *fuse.Attr
+	if atime == nil || mtime == nil {
+		attr = &fuse.Attr{}
+		if code := n.GetAttr(attr, nil, context); !code.Ok() {
+			return code
+		}
+	}
+	tv := utimens.Fill(atime, mtime, attr)
+	return fuse.ToStatus(syscall.Utimes(n.path(), tv))
+}
+
+func mknod(path string, mode uint32, dev uint32) error {
+	return syscall.Mknod(path, mode, int(dev))
+}
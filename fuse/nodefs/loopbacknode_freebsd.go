@@ -0,0 +1,32 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// become is a no-op: there is no setfsuid on FreeBSD.
+func (fs *loopbackNodeFs) become(context *fuse.Context) func() {
+	return func() {}
+}
+
+func (n *loopbackNode) Utimens(file File, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	if file != nil {
+		return file.Utimens(atime, mtime)
+	}
+	ts := []syscall.Timespec{
+		fuse.UtimeToTimespec(atime),
+		fuse.UtimeToTimespec(mtime),
+	}
+	return fuse.ToStatus(syscall.UtimesNano(n.path(), ts))
+}
+
+func mknod(path string, mode uint32, dev uint32) error {
+	return syscall.Mknod(path, mode, uint64(dev))
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// become switches the file system ids of the current thread to the
+// caller, if the file system runs as the caller. The returned
+// function switches back.
+func (fs *loopbackNodeFs) become(context *fuse.Context) func() {
+	if !fs.asCaller || context == nil {
+		return func() {}
+	}
+	// setfsuid only affects the calling thread.
+	runtime.LockOSThread()
+	syscall.Setfsgid(int(context.Gid))
+	syscall.Setfsuid(int(context.Uid))
+	return func() {
+		syscall.Setfsuid(os.Geteuid())
+		syscall.Setfsgid(os.Getegid())
+		runtime.UnlockOSThread()
+	}
+}
+
+func (n *loopbackNode) Utimens(file File, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	defer n.fs.become(context)()
+	if file != nil {
+		return file.Utimens(atime, mtime)
+	}
+	ts := []syscall.Timespec{
+		fuse.UtimeToTimespec(atime),
+		fuse.UtimeToTimespec(mtime),
+	}
+	return fuse.ToStatus(syscall.UtimesNano(n.path(), ts))
+}
+
+func (n *loopbackNode) GetXAttr(attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	defer n.fs.become(context)()
+	for {
+		sz, err := syscall.Getxattr(n.path(), attribute, nil)
+		if err != nil {
+			return nil, fuse.ToStatus(err)
+		}
+		data := make([]byte, sz)
+		sz, err = syscall.Getxattr(n.path(), attribute, data)
+		if err == syscall.ERANGE {
+			// The value grew in between; try again.
+			continue
+		}
+		if err != nil {
+			return nil, fuse.ToStatus(err)
+		}
+		return data[:sz], fuse.OK
+	}
+}
+
+func (n *loopbackNode) ListXAttr(context *fuse.Context) ([]string, fuse.Status) {
+	defer n.fs.become(context)()
+	sz, err := syscall.Listxattr(n.path(), nil)
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	data := make([]byte, sz)
+	sz, err = syscall.Listxattr(n.path(), data)
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	var attrs []string
+	for _, a := range bytes.Split(data[:sz], []byte{0}) {
+		if len(a) > 0 {
+			attrs = append(attrs, string(a))
+		}
+	}
+	return attrs, fuse.OK
+}
+
+func (n *loopbackNode) SetXAttr(attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	defer n.fs.become(context)()
+	return fuse.ToStatus(syscall.Setxattr(n.path(), attr, data, flags))
+}
+
+func (n *loopbackNode) RemoveXAttr(attr string, context *fuse.Context) fuse.Status {
+	defer n.fs.become(context)()
+	return fuse.ToStatus(syscall.Removexattr(n.path(), attr))
+}
+
+func mknod(path string, mode uint32, dev uint32) error {
+	return syscall.Mknod(path, mode, int(dev))
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"container/list"
+	"sync"
+)
+
+// inodeLRU orders the Inodes known to the kernel by last use, for
+// Options.MaxCachedInodes.
+//
+// Inodes are not removed when the kernel forgets them, as FORGET is
+// processed under the tree lock, and the LRU lock must be taken
+// before tree locks. Forgotten Inodes are dropped when evict passes
+// them instead.
+type inodeLRU struct {
+	max int
+
+	mu sync.Mutex
+	// Inodes, most recently used at the front.
+	list list.List
+	// Set while evict runs.
+	evicting bool
+}
+
+// touch marks n as used, and starts evicting Inodes if there are too
+// many. Must run outside treeLock.
+func (c *FileSystemConnector) touch(n *Inode) {
+	if c.lru == nil || n == c.rootNode {
+		return
+	}
+	l := c.lru
+	l.mu.Lock()
+	if n.lruElem != nil {
+		l.list.MoveToFront(n.lruElem)
+	} else {
+		n.lruElem = l.list.PushFront(n)
+	}
+	start := l.list.Len() > l.max && !l.evicting
+	if start {
+		l.evicting = true
+	}
+	l.mu.Unlock()
+
+	if start {
+		// Notifications must not be sent from the goroutine
+		// serving a request: the kernel may hold locks that
+		// the notification needs.
+		go c.evict()
+	}
+}
+
+// pinned returns whether n must stay in the kernel's cache: the
+// mount points and Inodes with open files.
+func (n *Inode) pinned() bool {
+	n.mount.treeLock.RLock()
+	mountPoint := n.mountPoint
+	n.mount.treeLock.RUnlock()
+	if mountPoint != nil {
+		return true
+	}
+	n.openFilesMutex.Lock()
+	defer n.openFilesMutex.Unlock()
+	return len(n.openFiles) > 0
+}
+
+// evict asks the kernel to forget the least recently used Inodes,
+// until at most MaxCachedInodes are left. Inodes touched meanwhile
+// are handled in the next round.
+func (c *FileSystemConnector) evict() {
+	l := c.lru
+	for {
+		l.mu.Lock()
+		victims := c.lruVictims()
+		if len(victims) == 0 {
+			l.evicting = false
+			l.mu.Unlock()
+			return
+		}
+		l.mu.Unlock()
+
+		if c.server == nil {
+			continue
+		}
+		for _, n := range victims {
+			if parent, name := n.Parent(); parent != nil {
+				c.EntryNotify(parent, name)
+			}
+		}
+	}
+}
+
+// lruVictims removes Inodes from the back of the LRU until at most
+// MaxCachedInodes are left, and returns the ones the kernel should
+// forget. Forgotten Inodes are dropped, and pinned ones are moved to
+// the front. Must be called with the LRU mutex held.
+func (c *FileSystemConnector) lruVictims() []*Inode {
+	l := c.lru
+	var victims []*Inode
+	e := l.list.Back()
+	for steps := l.list.Len(); steps > 0 && e != nil && l.list.Len() > l.max; steps-- {
+		prev := e.Prev()
+		n := e.Value.(*Inode)
+		switch {
+		case c.inodeMap.Handle(&n.handled) == 0:
+			// Already forgotten.
+			l.list.Remove(e)
+			n.lruElem = nil
+		case n.pinned():
+			l.list.MoveToFront(e)
+		default:
+			l.list.Remove(e)
+			n.lruElem = nil
+			victims = append(victims, n)
+		}
+		e = prev
+	}
+	return victims
+}
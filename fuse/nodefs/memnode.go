@@ -62,6 +62,7 @@ func (fs *memNodeFs) newNode() *memNode {
 	now := time.Now()
 	n.info.SetTimes(&now, &now, &now)
 	n.info.Mode = fuse.S_IFDIR | 0777
+	n.info.Nlink = 1
 	return n
 }
 
@@ -79,6 +80,20 @@ type memNode struct {
 	info fuse.Attr
 }
 
+// changed sets the ctime of n to now, after a change of its
+// attributes.
+func (n *memNode) changed() {
+	now := time.Now()
+	n.info.SetTimes(nil, nil, &now)
+}
+
+// modified sets the mtime and ctime of n to now, after a change of
+// its content or entries.
+func (n *memNode) modified() {
+	now := time.Now()
+	n.info.SetTimes(nil, &now, &now)
+}
+
 func (n *memNode) filename() string {
 	return fmt.Sprintf("%s%d", n.fs.backingStorePrefix, n.id)
 }
@@ -95,10 +110,36 @@ func (n *memNode) StatFs() *fuse.StatfsOut {
 	return &fuse.StatfsOut{}
 }
 
+func (n *memNode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*Inode, fuse.Status) {
+	ch := n.Inode().GetChild(name)
+	if ch == nil {
+		return nil, fuse.ENOENT
+	}
+	return ch, ch.Node().GetAttr(out, nil, context)
+}
+
 func (n *memNode) Mkdir(name string, mode uint32, context *fuse.Context) (newNode *Inode, code fuse.Status) {
 	ch := n.fs.newNode()
 	ch.info.Mode = mode | fuse.S_IFDIR
 	n.Inode().NewChild(name, true, ch)
+	n.modified()
+	return ch.Inode(), fuse.OK
+}
+
+func (n *memNode) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) (newNode *Inode, code fuse.Status) {
+	if mode&syscall.S_IFMT == 0 || mode&syscall.S_IFMT == syscall.S_IFREG {
+		// Regular files need a backing file.
+		f, ch, code := n.Create(name, uint32(os.O_WRONLY), mode&^syscall.S_IFMT, context)
+		if code.Ok() {
+			f.Release()
+		}
+		return ch, code
+	}
+	ch := n.fs.newNode()
+	ch.info.Mode = mode
+	ch.info.Rdev = dev
+	n.Inode().NewChild(name, false, ch)
+	n.modified()
 	return ch.Inode(), fuse.OK
 }
 
@@ -107,6 +148,11 @@ func (n *memNode) Unlink(name string, context *fuse.Context) (code fuse.Status)
 	if ch == nil {
 		return fuse.ENOENT
 	}
+	if mn, ok := ch.Node().(*memNode); ok && mn.info.Nlink > 0 {
+		mn.info.Nlink--
+		mn.changed()
+	}
+	n.modified()
 	return fuse.OK
 }
 
@@ -119,6 +165,7 @@ func (n *memNode) Symlink(name string, content string, context *fuse.Context) (n
 	ch.info.Mode = fuse.S_IFLNK | 0777
 	ch.link = content
 	n.Inode().NewChild(name, false, ch)
+	n.modified()
 	return ch.Inode(), fuse.OK
 }
 
@@ -126,11 +173,47 @@ func (n *memNode) Rename(oldName string, newParent Node, newName string, context
 	ch := n.Inode().RmChild(oldName)
 	newParent.Inode().RmChild(newName)
 	newParent.Inode().AddChild(newName, ch)
+	if mn, ok := ch.Node().(*memNode); ok {
+		mn.changed()
+	}
+	n.modified()
+	if p, ok := newParent.(*memNode); ok {
+		p.modified()
+	}
 	return fuse.OK
 }
 
+func (n *memNode) Rename2(oldName string, newParent Node, newName string, flags uint32, context *fuse.Context) (code fuse.Status) {
+	switch flags {
+	case fuse.RENAME_NOREPLACE:
+		if newParent.Inode().GetChild(newName) != nil {
+			return fuse.Status(syscall.EEXIST)
+		}
+		return n.Rename(oldName, newParent, newName, context)
+	case fuse.RENAME_EXCHANGE:
+		if n.Inode().GetChild(oldName) == nil || newParent.Inode().GetChild(newName) == nil {
+			return fuse.ENOENT
+		}
+		n.Inode().ExchangeChild(oldName, newParent.Inode(), newName)
+		n.modified()
+		if p, ok := newParent.(*memNode); ok {
+			p.modified()
+		}
+		return fuse.OK
+	}
+	return fuse.EINVAL
+}
+
 func (n *memNode) Link(name string, existing Node, context *fuse.Context) (*Inode, fuse.Status) {
+	if n.Inode().GetChild(name) != nil {
+		return nil, fuse.Status(syscall.EEXIST)
+	}
 	n.Inode().AddChild(name, existing.Inode())
+	if mn, ok := existing.(*memNode); ok {
+		mn.info.Nlink++
+		mn.changed()
+	}
+	n.modified()
 	return existing.Inode(), fuse.OK
 }
 
@@ -142,10 +225,35 @@ func (n *memNode) Create(name string, flags uint32, mode uint32, context *fuse.C
 	if err != nil {
 		return nil, nil, fuse.ToStatus(err)
 	}
-	n.Inode().NewChild(name, false, ch)
+	// The kernel only sends CREATE for names it does not know,
+	// but its dentries may be stale, and a racing create may have
+	// won. Check and add the name in one step, so only one
+	// O_EXCL create succeeds.
+	if existing := n.Inode().newChildExcl(name, ch); existing != nil {
+		f.Close()
+		os.Remove(ch.filename())
+		if flags&syscall.O_EXCL != 0 {
+			return nil, nil, fuse.Status(syscall.EEXIST)
+		}
+		file, code := existing.Node().Open(flags&^syscall.O_CREAT, context)
+		return file, existing, code
+	}
+	n.modified()
 	return ch.newFile(f), ch.Inode(), fuse.OK
 }
 
+func (n *memNode) Tmpfile(flags uint32, mode uint32, context *fuse.Context) (file File, node Node, code fuse.Status) {
+	ch := n.fs.newNode()
+	ch.info.Mode = mode | fuse.S_IFREG
+	ch.info.Nlink = 0
+
+	f, err := os.Create(ch.filename())
+	if err != nil {
+		return nil, nil, fuse.ToStatus(err)
+	}
+	return ch.newFile(f), ch, fuse.OK
+}
+
 type memNodeFile struct {
 	File
 	node *memNode
@@ -159,6 +267,19 @@ func (n *memNodeFile) InnerFile() File {
 	return n.File
 }
 
+func (n *memNodeFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	written, code := n.File.Write(data, off)
+	if code.Ok() {
+		n.node.modified()
+		// The kernel may ask for the size before Flush, for
+		// example after an unlink changed the attributes.
+		if end := uint64(off) + uint64(written); end > n.node.info.Size {
+			n.node.info.Size = end
+		}
+	}
+	return written, code
+}
+
 func (n *memNodeFile) Flush() fuse.Status {
 	code := n.File.Flush()
 
@@ -202,9 +323,7 @@ func (n *memNode) Truncate(file File, size uint64, context *fuse.Context) (code
 		code = fuse.ToStatus(err)
 	}
 	if code.Ok() {
-		now := time.Now()
-		n.info.SetTimes(nil, nil, &now)
-		// TODO - should update mtime too?
+		n.modified()
 		n.info.Size = size
 	}
 	return code
@@ -218,15 +337,18 @@ func (n *memNode) Utimens(file File, atime *time.Time, mtime *time.Time, context
 
 func (n *memNode) Chmod(file File, perms uint32, context *fuse.Context) (code fuse.Status) {
 	n.info.Mode = (n.info.Mode &^ 07777) | perms
-	now := time.Now()
-	n.info.SetTimes(nil, nil, &now)
+	n.changed()
 	return fuse.OK
 }
 
 func (n *memNode) Chown(file File, uid uint32, gid uint32, context *fuse.Context) (code fuse.Status) {
-	n.info.Uid = uid
-	n.info.Gid = gid
-	now := time.Now()
-	n.info.SetTimes(nil, nil, &now)
+	// ^uint32(0) leaves the ID alone, as in chown(2).
+	if uid != ^uint32(0) {
+		n.info.Uid = uid
+	}
+	if gid != ^uint32(0) {
+		n.info.Gid = gid
+	}
+	n.changed()
 	return fuse.OK
 }
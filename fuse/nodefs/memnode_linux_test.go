@@ -0,0 +1,48 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// oTmpfile is O_TMPFILE, which package syscall lacks.
+const oTmpfile = 020000000 | syscall.O_DIRECTORY
+
+func TestMemNodeTmpfile(t *testing.T) {
+	wd, root, clean := setupMemNodeTest(t)
+	defer clean()
+
+	fd, err := syscall.Open(wd, oTmpfile|syscall.O_RDWR, 0600)
+	if err == syscall.EOPNOTSUPP || err == syscall.EISDIR {
+		t.Skipf("kernel does not send TMPFILE: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Open(O_TMPFILE): %v", err)
+	}
+	f := os.NewFile(uintptr(fd), "tmpfile")
+	defer f.Close()
+
+	if _, err := f.WriteString("hello"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 10)
+	n, err := f.ReadAt(buf, 0)
+	if got := string(buf[:n]); got != "hello" {
+		t.Errorf("ReadAt: got %q (%v), want %q", got, err, "hello")
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		t.Fatalf("Fstat: %v", err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		t.Errorf("got mode %o, want a regular file", st.Mode)
+	}
+	if names := root.Inode().Children(); len(names) != 0 {
+		t.Errorf("tmpfile has a name: %v", names)
+	}
+}
@@ -5,12 +5,16 @@
 package nodefs
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fusetest"
 	"github.com/hanwen/go-fuse/internal/testutil"
 )
 
@@ -114,3 +118,728 @@ func TestMemNodeSetattr(t *testing.T) {
 		t.Errorf("Size should be 4096 after Truncate: %d", fi.Size())
 	}
 }
+
+// TestMemNodeSetattrMask checks that each SETATTR only changes the
+// attributes it is for.
+func TestMemNodeSetattrMask(t *testing.T) {
+	wd, _, clean := setupMemNodeTest(t)
+	defer clean()
+
+	p := wd + "/file"
+	if err := ioutil.WriteFile(p, []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	t0 := time.Unix(1500000000, 0)
+	if err := os.Chtimes(p, t0, t0); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	stat := func() *fuse.Attr {
+		var st syscall.Stat_t
+		if err := syscall.Lstat(p, &st); err != nil {
+			t.Fatalf("Lstat: %v", err)
+		}
+		a := &fuse.Attr{}
+		a.FromStat(&st)
+		return a
+	}
+	before := stat()
+
+	// chmod leaves the times alone.
+	if err := os.Chmod(p, 0600); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	a := stat()
+	if a.Mode&07777 != 0600 {
+		t.Errorf("chmod: got mode %o, want 0600", a.Mode&07777)
+	}
+	if !a.ModTime().Equal(t0) || a.Size != 5 || a.Uid != before.Uid {
+		t.Errorf("chmod changed other attributes: got %v, want mtime %v, size 5, uid %d", a, t0, before.Uid)
+	}
+
+	// chown of the group only keeps the owner.
+	if err := os.Chown(p, -1, int(before.Gid)); err != nil {
+		t.Fatalf("Chown: %v", err)
+	}
+	a = stat()
+	if a.Uid != before.Uid || a.Gid != before.Gid {
+		t.Errorf("chown: got uid %d gid %d, want %d %d", a.Uid, a.Gid, before.Uid, before.Gid)
+	}
+	if a.Mode&07777 != 0600 || !a.ModTime().Equal(t0) {
+		t.Errorf("chown changed other attributes: %v", a)
+	}
+
+	// truncate updates mtime, but not the mode or owner.
+	if err := os.Truncate(p, 2); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	a = stat()
+	if a.Size != 2 {
+		t.Errorf("truncate: got size %d, want 2", a.Size)
+	}
+	if a.Mode&07777 != 0600 || a.Uid != before.Uid || !a.AccessTime().Equal(t0) {
+		t.Errorf("truncate changed other attributes: %v", a)
+	}
+	mtime := a.ModTime()
+
+	// Setting only atime keeps mtime.
+	t1 := time.Unix(1600000000, 0)
+	ts := []syscall.Timespec{fuse.UtimeToTimespec(&t1), fuse.UtimeToTimespec(nil)}
+	if err := syscall.UtimesNano(p, ts); err != nil {
+		t.Fatalf("UtimesNano: %v", err)
+	}
+	a = stat()
+	if !a.AccessTime().Equal(t1) || !a.ModTime().Equal(mtime) {
+		t.Errorf("utimes: got atime %v mtime %v, want %v %v", a.AccessTime(), a.ModTime(), t1, mtime)
+	}
+	if a.Mode&07777 != 0600 || a.Size != 2 {
+		t.Errorf("utimes changed other attributes: %v", a)
+	}
+}
+
+func TestMemNodeCtime(t *testing.T) {
+	wd, _, clean := setupMemNodeTest(t)
+	defer clean()
+
+	p := wd + "/file"
+	if err := ioutil.WriteFile(p, []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	t0 := time.Unix(1500000000, 0)
+	if err := os.Chtimes(p, t0, t0); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Lstat(p, &st); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+	var before fuse.Attr
+	before.FromStat(&st)
+
+	time.Sleep(10 * time.Millisecond)
+	if err := os.Chmod(p, 0600); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if err := syscall.Lstat(p, &st); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+	var after fuse.Attr
+	after.FromStat(&st)
+	if !after.ChangeTime().After(before.ChangeTime()) {
+		t.Errorf("chmod: ctime %v not after %v", after.ChangeTime(), before.ChangeTime())
+	}
+	if !after.ModTime().Equal(t0) {
+		t.Errorf("chmod: got mtime %v, want %v", after.ModTime(), t0)
+	}
+}
+
+func TestMemNodeLink(t *testing.T) {
+	wd, _, clean := setupMemNodeTest(t)
+	defer clean()
+
+	if err := ioutil.WriteFile(wd+"/file", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.Link(wd+"/file", wd+"/link"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if err := os.Link(wd+"/file", wd+"/link"); !os.IsExist(err) {
+		t.Errorf("Link onto existing name: got %v, want EEXIST", err)
+	}
+
+	var st1, st2 syscall.Stat_t
+	if err := syscall.Lstat(wd+"/file", &st1); err != nil {
+		t.Fatalf("Lstat failed: %v", err)
+	}
+	if err := syscall.Lstat(wd+"/link", &st2); err != nil {
+		t.Fatalf("Lstat failed: %v", err)
+	}
+	if st1.Ino != st2.Ino {
+		t.Errorf("got inodes %d and %d, want the same", st1.Ino, st2.Ino)
+	}
+	if st2.Nlink != 2 {
+		t.Errorf("got nlink %d, want 2", st2.Nlink)
+	}
+
+	content, err := ioutil.ReadFile(wd + "/link")
+	if err != nil || string(content) != "hello" {
+		t.Errorf("ReadFile: got %q, %v, want \"hello\"", content, err)
+	}
+
+	if err := os.Remove(wd + "/file"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	// The kernel caches attributes, so wait for them to expire.
+	time.Sleep(testTtl)
+	if err := syscall.Lstat(wd+"/link", &st2); err != nil {
+		t.Fatalf("Lstat failed: %v", err)
+	}
+	if st2.Nlink != 1 {
+		t.Errorf("got nlink %d after unlink, want 1", st2.Nlink)
+	}
+}
+
+func TestMemNodeMknod(t *testing.T) {
+	wd, _, clean := setupMemNodeTest(t)
+	defer clean()
+
+	for _, tc := range []struct {
+		name string
+		mode uint32
+		dev  uint32
+	}{
+		{"fifo", syscall.S_IFIFO | 0644, 0},
+		{"socket", syscall.S_IFSOCK | 0644, 0},
+		{"null", syscall.S_IFCHR | 0644, 1<<8 | 3},
+		{"file", syscall.S_IFREG | 0644, 0},
+	} {
+		p := wd + "/" + tc.name
+		if err := mknod(p, tc.mode, tc.dev); err == syscall.EPERM && tc.dev != 0 {
+			t.Logf("Mknod(%s): %v, skipping", tc.name, err)
+			continue
+		} else if err != nil {
+			t.Fatalf("Mknod(%s): %v", tc.name, err)
+		}
+		var st syscall.Stat_t
+		if err := syscall.Lstat(p, &st); err != nil {
+			t.Fatalf("Lstat(%s): %v", tc.name, err)
+		}
+		if uint32(st.Mode)&syscall.S_IFMT != tc.mode&syscall.S_IFMT {
+			t.Errorf("%s: got mode %o, want type %o", tc.name, st.Mode, tc.mode&syscall.S_IFMT)
+		}
+		if uint64(st.Rdev) != uint64(tc.dev) {
+			t.Errorf("%s: got rdev %x, want %x", tc.name, st.Rdev, tc.dev)
+		}
+	}
+
+	if err := ioutil.WriteFile(wd+"/file", []byte("x"), 0644); err != nil {
+		t.Errorf("WriteFile on mknod'ed file: %v", err)
+	}
+}
+
+// TestMemNodeRenameParents checks that the parent links of an Inode
+// follow renames and removals.
+func TestMemNodeRenameParents(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	root := NewMemNodeFSRoot(dir + "/")
+	c := NewFileSystemConnector(root, nil)
+	ctx := &fuse.Context{}
+
+	a, _ := root.Mkdir("a", 0755, ctx)
+	b, _ := root.Mkdir("b", 0755, ctx)
+	f, file, code := a.Node().Create("f", uint32(os.O_WRONLY), 0644, ctx)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	f.Release()
+	aID, _ := c.lookupUpdate(a)
+	bID, _ := c.lookupUpdate(b)
+
+	in := &fuse.RenameIn{InHeader: fuse.InHeader{NodeId: aID}, Newdir: bID}
+	if code := c.RawFS().Rename(nil, in, "f", "g"); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	if parent, name := file.Parent(); parent != b || name != "g" {
+		t.Errorf("after Rename: got parent %v name %q, want b, g", parent, name)
+	}
+	if p, _ := file.Path(); p != "b/g" {
+		t.Errorf("after Rename: got path %q, want b/g", p)
+	}
+	if a.GetChild("f") != nil {
+		t.Error("old name still present")
+	}
+
+	if code := c.RawFS().Unlink(nil, &fuse.InHeader{NodeId: bID}, "g"); !code.Ok() {
+		t.Fatalf("Unlink: %v", code)
+	}
+	if parent, name := file.Parent(); parent != nil {
+		t.Errorf("after Unlink: got parent %v name %q, want none", parent, name)
+	}
+}
+
+// TestMemNodeCreateDropsNegative checks that names created by any
+// operation leave the cache of negative entries.
+func TestMemNodeCreateDropsNegative(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	opts := NewOptions()
+	opts.NegativeTimeout = time.Hour
+	opts.MaxNegativeEntries = 100
+	c := NewFileSystemConnector(NewMemNodeFSRoot(dir+"/"), opts)
+	fs := c.RawFS()
+	root := fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}
+
+	var file fuse.CreateOut
+	for _, op := range []struct {
+		name   string
+		create func() fuse.Status
+	}{
+		{"file", func() fuse.Status {
+			return fs.Create(nil, &fuse.CreateIn{InHeader: root, Flags: uint32(os.O_WRONLY), Mode: fuse.S_IFREG | 0644}, "file", &file)
+		}},
+		{"node", func() fuse.Status {
+			return fs.Mknod(nil, &fuse.MknodIn{InHeader: root, Mode: fuse.S_IFREG | 0644}, "node", &fuse.EntryOut{})
+		}},
+		{"dir", func() fuse.Status {
+			return fs.Mkdir(nil, &fuse.MkdirIn{InHeader: root, Mode: 0755}, "dir", &fuse.EntryOut{})
+		}},
+		{"symlink", func() fuse.Status {
+			return fs.Symlink(nil, &root, "file", "symlink", &fuse.EntryOut{})
+		}},
+		{"link", func() fuse.Status {
+			return fs.Link(nil, &fuse.LinkIn{InHeader: root, Oldnodeid: file.NodeId}, "link", &fuse.EntryOut{})
+		}},
+		{"renamed", func() fuse.Status {
+			return fs.Rename(nil, &fuse.RenameIn{InHeader: root, Newdir: fuse.FUSE_ROOT_ID}, "node", "renamed")
+		}},
+	} {
+		var out fuse.EntryOut
+		if code := fs.Lookup(nil, &root, op.name, &out); !code.Ok() || out.NodeId != 0 {
+			t.Fatalf("Lookup(%q): got %v, node %d, want a negative entry", op.name, code, out.NodeId)
+		}
+		if code := op.create(); !code.Ok() {
+			t.Fatalf("creating %q: %v", op.name, code)
+		}
+		if n := c.negative.list.Len(); n != 0 {
+			t.Errorf("after creating %q: got %d negative entries, want 0", op.name, n)
+		}
+	}
+	fs.Release(nil, &fuse.ReleaseIn{InHeader: fuse.InHeader{NodeId: file.NodeId}, Fh: file.Fh})
+}
+
+func TestMemNodeCrossMountRename(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(dir+"/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir+"/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.CrossMountRename = true
+	root := NewMemNodeFSRoot(dir + "/a/")
+	c := NewFileSystemConnector(root, opts)
+	sub := NewMemNodeFSRoot(dir + "/b/")
+	if code := c.Mount(c.rootNode, "mnt", sub, opts); !code.Ok() {
+		t.Fatalf("Mount: %v", code)
+	}
+	ctx := &fuse.Context{}
+
+	d, _ := root.Mkdir("dir", 0750, ctx)
+	f, file, code := d.Node().Create("file", uint32(os.O_WRONLY), 0640, ctx)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	f.Write([]byte("hello"), 0)
+	f.Flush()
+	f.Release()
+	mtime := time.Unix(1000, 0)
+	file.Node().Utimens(nil, &mtime, &mtime, ctx)
+	d.Node().Symlink("link", "file", ctx)
+
+	rootID, _ := c.lookupUpdate(c.rootNode)
+	subID, _ := c.lookupUpdate(sub.Inode())
+	in := &fuse.RenameIn{InHeader: fuse.InHeader{NodeId: rootID}, Newdir: subID}
+	if code := c.RawFS().Rename(nil, in, "dir", "moved"); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+
+	if root.Inode().GetChild("dir") != nil {
+		t.Error("source still present")
+	}
+	moved := sub.Inode().GetChild("moved")
+	if moved == nil {
+		t.Fatal("destination missing")
+	}
+	var a fuse.Attr
+	if moved.Node().GetAttr(&a, nil, ctx); a.Mode != syscall.S_IFDIR|0750 {
+		t.Errorf("got dir mode %o, want %o", a.Mode, syscall.S_IFDIR|0750)
+	}
+	movedFile := moved.GetChild("file")
+	if movedFile == nil {
+		t.Fatal("file missing")
+	}
+	a = fuse.Attr{}
+	movedFile.Node().GetAttr(&a, nil, ctx)
+	if a.Mode != syscall.S_IFREG|0640 || a.Size != 5 || a.Mtime != 1000 {
+		t.Errorf("got file mode %o size %d mtime %d, want %o, 5, 1000", a.Mode, a.Size, a.Mtime, syscall.S_IFREG|0640)
+	}
+	content, err := ioutil.ReadFile(movedFile.Node().(*memNode).filename())
+	if err != nil || string(content) != "hello" {
+		t.Errorf("got content %q, %v, want %q", content, err, "hello")
+	}
+	if l := moved.GetChild("link"); l == nil {
+		t.Error("link missing")
+	} else if target, _ := l.Node().Readlink(ctx); string(target) != "file" {
+		t.Errorf("got link %q, want %q", target, "file")
+	}
+
+	// Without the option, the rename fails.
+	c.rootNode.mount.options.CrossMountRename = false
+	in = &fuse.RenameIn{InHeader: fuse.InHeader{NodeId: subID}, Newdir: rootID}
+	if code := c.RawFS().Rename(nil, in, "moved", "back"); code != fuse.EXDEV {
+		t.Errorf("Rename without CrossMountRename: got %v, want EXDEV", code)
+	}
+}
+
+// filelessNode is a file without a File, whose reads fail if
+// fail is set.
+type filelessNode struct {
+	Node
+	data string
+	fail bool
+}
+
+func (n *filelessNode) GetAttr(out *fuse.Attr, file File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = uint64(len(n.data))
+	return fuse.OK
+}
+
+func (n *filelessNode) Open(flags uint32, context *fuse.Context) (File, fuse.Status) {
+	return nil, fuse.OK
+}
+
+func (n *filelessNode) Read(file File, dest []byte, off int64, context *fuse.Context) (fuse.ReadResult, fuse.Status) {
+	if n.fail {
+		return nil, fuse.EIO
+	}
+	if off >= int64(len(n.data)) {
+		return fuse.ReadResultData(nil), fuse.OK
+	}
+	return fuse.ReadResultData([]byte(n.data[off:])), fuse.OK
+}
+
+func TestMemNodeCrossMountRenameReplace(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	opts := NewOptions()
+	opts.CrossMountRename = true
+	root := NewMemNodeFSRoot(dir + "/a")
+	c := NewFileSystemConnector(root, opts)
+	src := &filelessNode{Node: NewDefaultNode(), data: "new", fail: true}
+	root.Inode().NewChild("file", false, src)
+	sub := NewMemNodeFSRoot(dir + "/b")
+	if code := c.Mount(c.rootNode, "mnt", sub, opts); !code.Ok() {
+		t.Fatalf("Mount: %v", code)
+	}
+	ctx := &fuse.Context{}
+	f, target, code := sub.Create("target", uint32(os.O_WRONLY), 0644, ctx)
+	if !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	f.Write([]byte("old"), 0)
+	f.Flush()
+	f.Release()
+
+	content := func() string {
+		t.Helper()
+		ch := sub.Inode().GetChild("target")
+		if ch == nil {
+			t.Fatal("target missing")
+		}
+		data, err := ioutil.ReadFile(ch.Node().(*memNode).filename())
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		return string(data)
+	}
+
+	rootID, _ := c.lookupUpdate(c.rootNode)
+	subID, _ := c.lookupUpdate(sub.Inode())
+	in := &fuse.RenameIn{InHeader: fuse.InHeader{NodeId: rootID}, Newdir: subID}
+	if code := c.RawFS().Rename(nil, in, "file", "target"); code != fuse.EIO {
+		t.Fatalf("Rename with failing copy: got %v, want EIO", code)
+	}
+	if got := content(); got != "old" {
+		t.Errorf("failed rename changed the destination to %q", got)
+	}
+	if names := sub.Inode().Children(); len(names) != 1 || names["target"] != target {
+		t.Errorf("got children %v, want only the target", names)
+	}
+
+	src.fail = false
+	noReplace := *in
+	noReplace.Flags = fuse.RENAME_NOREPLACE
+	if code := c.RawFS().Rename(nil, &noReplace, "file", "target"); code != fuse.Status(syscall.EEXIST) {
+		t.Errorf("NOREPLACE onto existing: got %v, want EEXIST", code)
+	}
+	if got := content(); got != "old" {
+		t.Errorf("NOREPLACE rename changed the destination to %q", got)
+	}
+	if code := c.RawFS().Rename(nil, in, "file", "target"); !code.Ok() {
+		t.Fatalf("Rename: %v", code)
+	}
+	if got := content(); got != "new" {
+		t.Errorf("got content %q, want %q", got, "new")
+	}
+	if names := sub.Inode().Children(); len(names) != 1 {
+		t.Errorf("got children %v, want only the target", names)
+	}
+	if root.Inode().GetChild("file") != nil {
+		t.Error("source still present")
+	}
+}
+
+// einvalRename2Node is a directory whose Rename2 fails with EINVAL.
+type einvalRename2Node struct {
+	Node
+}
+
+func (n *einvalRename2Node) Rename2(oldName string, newParent Node, newName string, flags uint32, context *fuse.Context) fuse.Status {
+	return fuse.EINVAL
+}
+
+// TestMemNodeCrossMountRenameNoReplaceFallback checks that a failing
+// Rename2 is not retried with Rename.
+func TestMemNodeCrossMountRenameNoReplaceFallback(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	opts := NewOptions()
+	opts.CrossMountRename = true
+	root := NewMemNodeFSRoot(dir + "/a")
+	c := NewFileSystemConnector(root, opts)
+	root.Inode().NewChild("file", false, &filelessNode{Node: NewDefaultNode(), data: "new"})
+	sub := &einvalRename2Node{NewMemNodeFSRoot(dir + "/b")}
+	if code := c.Mount(c.rootNode, "mnt", sub, opts); !code.Ok() {
+		t.Fatalf("Mount: %v", code)
+	}
+
+	rootID, _ := c.lookupUpdate(c.rootNode)
+	subID, _ := c.lookupUpdate(sub.Inode())
+	in := &fuse.RenameIn{InHeader: fuse.InHeader{NodeId: rootID}, Newdir: subID, Flags: fuse.RENAME_NOREPLACE}
+	if code := c.RawFS().Rename(nil, in, "file", "target"); code != fuse.EINVAL {
+		t.Errorf("Rename: got %v, want EINVAL", code)
+	}
+	if names := sub.Inode().Children(); len(names) != 0 {
+		t.Errorf("got children %v, want none", names)
+	}
+	if root.Inode().GetChild("file") == nil {
+		t.Error("source removed")
+	}
+}
+
+func TestMemNodeRenameFlags(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	root := NewMemNodeFSRoot(dir + "/")
+	c := NewFileSystemConnector(root, nil)
+	ctx := &fuse.Context{}
+
+	a, _ := root.Mkdir("a", 0755, ctx)
+	b, _ := root.Symlink("b", "target", ctx)
+	rootID, _ := c.lookupUpdate(c.rootNode)
+	rename := func(oldName, newName string, flags uint32) fuse.Status {
+		in := &fuse.RenameIn{InHeader: fuse.InHeader{NodeId: rootID}, Newdir: rootID, Flags: flags}
+		return c.RawFS().Rename(nil, in, oldName, newName)
+	}
+
+	if code := rename("a", "b", fuse.RENAME_NOREPLACE); code != fuse.Status(syscall.EEXIST) {
+		t.Errorf("NOREPLACE onto existing: got %v, want EEXIST", code)
+	}
+	if root.Inode().GetChild("a") != a || root.Inode().GetChild("b") != b {
+		t.Error("failed NOREPLACE changed the tree")
+	}
+
+	if code := rename("a", "b", fuse.RENAME_EXCHANGE); !code.Ok() {
+		t.Fatalf("EXCHANGE: %v", code)
+	}
+	if root.Inode().GetChild("a") != b || root.Inode().GetChild("b") != a {
+		t.Error("EXCHANGE did not swap the entries")
+	}
+	if p, _ := a.Path(); p != "b" {
+		t.Errorf("after EXCHANGE: got path %q, want b", p)
+	}
+
+	if code := rename("b", "c", fuse.RENAME_NOREPLACE); !code.Ok() {
+		t.Fatalf("NOREPLACE: %v", code)
+	}
+	if root.Inode().GetChild("c") != a || root.Inode().GetChild("b") != nil {
+		t.Error("NOREPLACE did not move the entry")
+	}
+
+	if code := rename("a", "d", fuse.RENAME_EXCHANGE); code != fuse.ENOENT {
+		t.Errorf("EXCHANGE with missing destination: got %v, want ENOENT", code)
+	}
+	if code := rename("a", "d", fuse.RENAME_WHITEOUT); code != fuse.EINVAL {
+		t.Errorf("WHITEOUT: got %v, want EINVAL", code)
+	}
+}
+
+// slowRenameNode hides Rename2 from a memnode, so the connector
+// emulates RENAME_NOREPLACE, and is slow to rename.
+type slowRenameNode struct {
+	Node
+}
+
+func (n *slowRenameNode) Rename(oldName string, newParent Node, newName string, context *fuse.Context) fuse.Status {
+	time.Sleep(time.Millisecond)
+	return n.Node.Rename(oldName, newParent, newName, context)
+}
+
+func TestRenameNoReplaceEmulated(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	root := &slowRenameNode{NewMemNodeFSRoot(dir + "/")}
+	c := NewFileSystemConnector(root, nil)
+	ctx := &fuse.Context{}
+	rootID, _ := c.lookupUpdate(c.rootNode)
+
+	const N = 10
+	for i := 0; i < N; i++ {
+		root.Mkdir(fmt.Sprintf("d%d", i), 0755, ctx)
+	}
+	// Call the bridge directly: through a mount, the kernel would
+	// serialize the renames on the directory lock.
+	codes := make(chan fuse.Status, N)
+	var wg sync.WaitGroup
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			in := &fuse.RenameIn{InHeader: fuse.InHeader{NodeId: rootID}, Newdir: rootID, Flags: fuse.RENAME_NOREPLACE}
+			codes <- c.RawFS().Rename(nil, in, fmt.Sprintf("d%d", i), "dest")
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+	ok := 0
+	for code := range codes {
+		if code.Ok() {
+			ok++
+		} else if code != fuse.Status(syscall.EEXIST) {
+			t.Errorf("Rename: got %v, want EEXIST", code)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d renames succeeded, want 1", ok)
+	}
+	if got := len(root.Inode().Children()); got != N {
+		t.Errorf("got %d children, want %d", got, N)
+	}
+}
+
+func TestMemNodeCreateExclusive(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	root := NewMemNodeFSRoot(dir + "/")
+	c := NewFileSystemConnector(root, nil)
+	rootID, _ := c.lookupUpdate(c.rootNode)
+
+	// Call the bridge directly: through a mount, the kernel would
+	// serialize the creates on the directory lock.
+	const N = 20
+	codes := make(chan fuse.Status, N)
+	var wg sync.WaitGroup
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in := &fuse.CreateIn{
+				InHeader: fuse.InHeader{NodeId: rootID},
+				Flags:    uint32(os.O_WRONLY | os.O_CREATE | os.O_EXCL),
+				Mode:     0644,
+			}
+			codes <- c.RawFS().Create(nil, in, "file", &fuse.CreateOut{})
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	won := 0
+	for code := range codes {
+		switch code {
+		case fuse.OK:
+			won++
+		case fuse.Status(syscall.EEXIST):
+		default:
+			t.Errorf("Create: got %v, want OK or EEXIST", code)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d O_EXCL creates succeeded, want 1", won)
+	}
+
+	// Without O_EXCL, Create opens the existing file.
+	in := &fuse.CreateIn{
+		InHeader: fuse.InHeader{NodeId: rootID},
+		Flags:    uint32(os.O_WRONLY | os.O_CREATE),
+		Mode:     0644,
+	}
+	out := &fuse.CreateOut{}
+	if code := c.RawFS().Create(nil, in, "file", out); !code.Ok() {
+		t.Fatalf("Create: %v", code)
+	}
+	if got := (*rawBridge)(c).toInode(out.NodeId); got != root.Inode().GetChild("file") {
+		t.Errorf("Create without O_EXCL made a new inode")
+	}
+}
+
+func TestMemNodeUnlinkedOpen(t *testing.T) {
+	wd, root, clean := setupMemNodeTest(t)
+	defer clean()
+
+	f, err := os.OpenFile(wd+"/file", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("hello"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := os.Remove(wd + "/file"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if ch := root.Inode().GetChild("file"); ch != nil {
+		t.Errorf("unlinked file is still in the tree")
+	}
+
+	if _, err := f.WriteString(" world"); err != nil {
+		t.Fatalf("Write after unlink: %v", err)
+	}
+	buf := make([]byte, 20)
+	n, err := f.ReadAt(buf, 0)
+	if got, want := string(buf[:n]), "hello world"; got != want {
+		t.Errorf("ReadAt after unlink: got %q (%v), want %q", got, err, want)
+	}
+}
+
+// TestMemNodeTmpfileWrapped checks that the RawFileSystem wrappers
+// pass TMPFILE on; SingleThreaded wraps the file system in a locking
+// one.
+func TestMemNodeTmpfileWrapped(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		opts  *fuse.MountOptions
+		trace bool
+	}{
+		{"locking", &fuse.MountOptions{SingleThreaded: true}, false},
+		{"trace", nil, true},
+	} {
+		dir := testutil.TempDir()
+		defer os.RemoveAll(dir)
+		c := NewFileSystemConnector(NewMemNodeFSRoot(dir+"/"), nil)
+		fs := c.RawFS()
+		if tc.trace {
+			fs = fuse.NewTraceRawFileSystem(fs, ioutil.Discard)
+		}
+		k, err := fusetest.NewKernel(fs, tc.opts)
+		if err != nil {
+			t.Fatalf("NewKernel: %v", err)
+		}
+		defer k.Close()
+
+		in := &fuse.CreateIn{Flags: uint32(os.O_RDWR), Mode: 0600}
+		reply, err := k.Call(fusetest.Request(fusetest.OpTmpfile, fuse.FUSE_ROOT_ID, in, ""))
+		if err != nil {
+			t.Fatalf("Call: %v", err)
+		}
+		if code := fusetest.Status(reply); !code.Ok() {
+			t.Errorf("%s: TMPFILE: %v", tc.name, code)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nodefs
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// negativeCache remembers the names for which the kernel caches a
+// negative entry, for Options.MaxNegativeEntries.
+type negativeCache struct {
+	max int
+
+	mu sync.Mutex
+	// negativeEntry values, most recently returned at the front.
+	list    list.List
+	entries map[parentData]*list.Element
+	// Set while evictNegative runs.
+	evicting bool
+}
+
+type negativeEntry struct {
+	key     parentData
+	expires time.Time
+}
+
+// addNegative records that the kernel caches name in parent as
+// nonexistent for timeout, and starts purging the oldest entries if
+// there are too many.
+func (c *FileSystemConnector) addNegative(parent *Inode, name string, timeout time.Duration) {
+	nc := c.negative
+	if nc == nil {
+		return
+	}
+	key := parentData{parent, name}
+	now := time.Now()
+
+	nc.mu.Lock()
+	if e := nc.entries[key]; e != nil {
+		e.Value.(*negativeEntry).expires = now.Add(timeout)
+		nc.list.MoveToFront(e)
+	} else {
+		nc.entries[key] = nc.list.PushFront(&negativeEntry{key, now.Add(timeout)})
+	}
+	// The kernel drops expired entries by itself.
+	for e := nc.list.Back(); e != nil && now.After(e.Value.(*negativeEntry).expires); e = nc.list.Back() {
+		nc.remove(e)
+	}
+	start := nc.list.Len() > nc.max && !nc.evicting
+	if start {
+		nc.evicting = true
+	}
+	nc.mu.Unlock()
+
+	if start {
+		// As in touch, don't notify from the goroutine serving
+		// the request.
+		go c.evictNegative()
+	}
+}
+
+// dropNegative forgets about name in parent, which exists now. It
+// must be called for every operation that creates a name, since the
+// kernel replaces its negative entry with the new one.
+func (c *FileSystemConnector) dropNegative(parent *Inode, name string) {
+	nc := c.negative
+	if nc == nil {
+		return
+	}
+	nc.mu.Lock()
+	if e := nc.entries[parentData{parent, name}]; e != nil {
+		nc.remove(e)
+	}
+	nc.mu.Unlock()
+}
+
+// remove drops e. Must be called with the mutex held.
+func (nc *negativeCache) remove(e *list.Element) {
+	nc.list.Remove(e)
+	delete(nc.entries, e.Value.(*negativeEntry).key)
+}
+
+// evictNegative asks the kernel to drop the oldest negative entries,
+// until at most MaxNegativeEntries are left.
+func (c *FileSystemConnector) evictNegative() {
+	nc := c.negative
+	for {
+		var victims []parentData
+		nc.mu.Lock()
+		for nc.list.Len() > nc.max {
+			e := nc.list.Back()
+			victims = append(victims, e.Value.(*negativeEntry).key)
+			nc.remove(e)
+		}
+		if len(victims) == 0 {
+			nc.evicting = false
+			nc.mu.Unlock()
+			return
+		}
+		nc.mu.Unlock()
+
+		if c.server == nil {
+			continue
+		}
+		for _, v := range victims {
+			c.EntryNotify(v.parent, v.name)
+		}
+	}
+}
@@ -6,8 +6,13 @@ package nodefs
 
 import "time"
 
+// splitDuration splits dt into the seconds and nanoseconds of the
+// *_valid fields of FUSE replies. Negative durations mean no caching.
 func splitDuration(dt time.Duration, secs *uint64, nsecs *uint32) {
 	ns := int64(dt)
+	if ns < 0 {
+		ns = 0
+	}
 	*nsecs = uint32(ns % 1e9)
 	*secs = uint64(ns / 1e9)
 }
@@ -7,106 +7,230 @@ package fuse
 import (
 	"bytes"
 	"log"
+	"math"
 	"reflect"
 	"runtime"
+	"syscall"
+	"time"
 	"unsafe"
 )
 
 const (
-	_OP_LOOKUP       = int32(1)
-	_OP_FORGET       = int32(2)
-	_OP_GETATTR      = int32(3)
-	_OP_SETATTR      = int32(4)
-	_OP_READLINK     = int32(5)
-	_OP_SYMLINK      = int32(6)
-	_OP_MKNOD        = int32(8)
-	_OP_MKDIR        = int32(9)
-	_OP_UNLINK       = int32(10)
-	_OP_RMDIR        = int32(11)
-	_OP_RENAME       = int32(12)
-	_OP_LINK         = int32(13)
-	_OP_OPEN         = int32(14)
-	_OP_READ         = int32(15)
-	_OP_WRITE        = int32(16)
-	_OP_STATFS       = int32(17)
-	_OP_RELEASE      = int32(18)
-	_OP_FSYNC        = int32(20)
-	_OP_SETXATTR     = int32(21)
-	_OP_GETXATTR     = int32(22)
-	_OP_LISTXATTR    = int32(23)
-	_OP_REMOVEXATTR  = int32(24)
-	_OP_FLUSH        = int32(25)
-	_OP_INIT         = int32(26)
-	_OP_OPENDIR      = int32(27)
-	_OP_READDIR      = int32(28)
-	_OP_RELEASEDIR   = int32(29)
-	_OP_FSYNCDIR     = int32(30)
-	_OP_GETLK        = int32(31)
-	_OP_SETLK        = int32(32)
-	_OP_SETLKW       = int32(33)
-	_OP_ACCESS       = int32(34)
-	_OP_CREATE       = int32(35)
-	_OP_INTERRUPT    = int32(36)
-	_OP_BMAP         = int32(37)
-	_OP_DESTROY      = int32(38)
-	_OP_IOCTL        = int32(39)
-	_OP_POLL         = int32(40)
-	_OP_NOTIFY_REPLY = int32(41)
-	_OP_BATCH_FORGET = int32(42)
-	_OP_FALLOCATE    = int32(43) // protocol version 19.
-	_OP_READDIRPLUS  = int32(44) // protocol version 21.
-	_OP_FUSE_RENAME2 = int32(45) // protocol version 23.
+	_OP_LOOKUP          = int32(1)
+	_OP_FORGET          = int32(2)
+	_OP_GETATTR         = int32(3)
+	_OP_SETATTR         = int32(4)
+	_OP_READLINK        = int32(5)
+	_OP_SYMLINK         = int32(6)
+	_OP_MKNOD           = int32(8)
+	_OP_MKDIR           = int32(9)
+	_OP_UNLINK          = int32(10)
+	_OP_RMDIR           = int32(11)
+	_OP_RENAME          = int32(12)
+	_OP_LINK            = int32(13)
+	_OP_OPEN            = int32(14)
+	_OP_READ            = int32(15)
+	_OP_WRITE           = int32(16)
+	_OP_STATFS          = int32(17)
+	_OP_RELEASE         = int32(18)
+	_OP_FSYNC           = int32(20)
+	_OP_SETXATTR        = int32(21)
+	_OP_GETXATTR        = int32(22)
+	_OP_LISTXATTR       = int32(23)
+	_OP_REMOVEXATTR     = int32(24)
+	_OP_FLUSH           = int32(25)
+	_OP_INIT            = int32(26)
+	_OP_OPENDIR         = int32(27)
+	_OP_READDIR         = int32(28)
+	_OP_RELEASEDIR      = int32(29)
+	_OP_FSYNCDIR        = int32(30)
+	_OP_GETLK           = int32(31)
+	_OP_SETLK           = int32(32)
+	_OP_SETLKW          = int32(33)
+	_OP_ACCESS          = int32(34)
+	_OP_CREATE          = int32(35)
+	_OP_INTERRUPT       = int32(36)
+	_OP_BMAP            = int32(37)
+	_OP_DESTROY         = int32(38)
+	_OP_IOCTL           = int32(39)
+	_OP_POLL            = int32(40)
+	_OP_NOTIFY_REPLY    = int32(41)
+	_OP_BATCH_FORGET    = int32(42)
+	_OP_FALLOCATE       = int32(43) // protocol version 19.
+	_OP_READDIRPLUS     = int32(44) // protocol version 21.
+	_OP_FUSE_RENAME2    = int32(45) // protocol version 23.
+	_OP_LSEEK           = int32(46) // protocol version 24.
+	_OP_COPY_FILE_RANGE = int32(47) // protocol version 28.
+	_OP_TMPFILE         = int32(51) // protocol version 37.
 
 	// The following entries don't have to be compatible across Go-FUSE versions.
-	_OP_NOTIFY_ENTRY  = int32(100)
-	_OP_NOTIFY_INODE  = int32(101)
-	_OP_NOTIFY_DELETE = int32(102) // protocol version 18
-
-	_OPCODE_COUNT = int32(103)
+	_OP_NOTIFY_ENTRY    = int32(100)
+	_OP_NOTIFY_INODE    = int32(101)
+	_OP_NOTIFY_DELETE   = int32(102) // protocol version 18
+	_OP_NOTIFY_POLL     = int32(103)
+	_OP_NOTIFY_STORE    = int32(104) // protocol version 15
+	_OP_NOTIFY_RETRIEVE = int32(105) // protocol version 15
+
+	_OPCODE_COUNT = int32(106)
 )
 
 ////////////////////////////////////////////////////////////////
 
+// capMinor is the protocol minor version that introduced each INIT
+// flag.
+var capMinor = map[uint32]uint32{
+	CAP_ASYNC_READ:       6,
+	CAP_POSIX_LOCKS:      7,
+	CAP_FILE_OPS:         8,
+	CAP_ATOMIC_O_TRUNC:   9,
+	CAP_BIG_WRITES:       9,
+	CAP_EXPORT_SUPPORT:   10,
+	CAP_DONT_MASK:        12,
+	CAP_SPLICE_WRITE:     14,
+	CAP_SPLICE_MOVE:      14,
+	CAP_SPLICE_READ:      14,
+	CAP_FLOCK_LOCKS:      17,
+	CAP_IOCTL_DIR:        18,
+	CAP_AUTO_INVAL_DATA:  20,
+	CAP_READDIRPLUS:      21,
+	CAP_READDIRPLUS_AUTO: 21,
+	CAP_ASYNC_DIO:        22,
+	CAP_WRITEBACK_CACHE:  23,
+	CAP_NO_OPEN_SUPPORT:  23,
+	CAP_PARALLEL_DIROPS:  25,
+	CAP_HANDLE_KILLPRIV:  26,
+	CAP_POSIX_ACL:        26,
+	CAP_ABORT_ERROR:      27,
+	CAP_MAX_PAGES:        28,
+	CAP_CACHE_SYMLINKS:   28,
+}
+
+// capsForMinor returns the INIT flags defined in the given protocol
+// minor version.
+func capsForMinor(minor uint32) (caps uint32) {
+	for c, m := range capMinor {
+		if minor >= m {
+			caps |= c
+		}
+	}
+	return caps
+}
+
 func doInit(server *Server, req *request) {
 	input := (*InitIn)(req.inData)
+	if input.Major > _FUSE_KERNEL_VERSION {
+		// Tell the kernel our major version; it will retry
+		// INIT with that, if it can.
+		out := (*InitOut)(req.outData())
+		*out = InitOut{
+			Major: _FUSE_KERNEL_VERSION,
+			Minor: _OUR_MINOR_VERSION,
+		}
+		req.status = OK
+		return
+	}
 	if input.Major != _FUSE_KERNEL_VERSION {
-		log.Printf("Major versions does not match. Given %d, want %d\n", input.Major, _FUSE_KERNEL_VERSION)
+		server.logf("Major versions does not match. Given %d, want %d\n", input.Major, _FUSE_KERNEL_VERSION)
 		req.status = EIO
 		return
 	}
 	if input.Minor < _MINIMUM_MINOR_VERSION {
-		log.Printf("Minor version is less than we support. Given %d, want at least %d\n", input.Minor, _MINIMUM_MINOR_VERSION)
+		server.logf("Minor version is less than we support. Given %d, want at least %d\n", input.Minor, _MINIMUM_MINOR_VERSION)
 		req.status = EIO
 		return
 	}
 
+	// Speak the highest version that both sides know.
+	minor := uint32(_OUR_MINOR_VERSION)
+	if input.Minor < minor {
+		minor = input.Minor
+	}
+
+	// Ignore flags that the negotiated version does not define.
+	offered := input.Flags & capsForMinor(minor)
+
 	server.reqMu.Lock()
+	server.protoMinor = minor
 	server.kernelSettings = *input
-	server.kernelSettings.Flags = input.Flags & (CAP_ASYNC_READ | CAP_BIG_WRITES | CAP_FILE_OPS |
+	server.kernelSettings.Flags = offered & (CAP_ASYNC_READ | CAP_BIG_WRITES | CAP_FILE_OPS |
 		CAP_AUTO_INVAL_DATA | CAP_READDIRPLUS | CAP_NO_OPEN_SUPPORT)
 
-	if input.Minor >= 13 {
-		server.setSplice()
+	if server.opts.SyncRead {
+		server.kernelSettings.Flags &^= CAP_ASYNC_READ
+	}
+	if server.opts.DisableReadDirPlus {
+		server.kernelSettings.Flags &^= CAP_READDIRPLUS
+	}
+	if server.opts.WritebackCache {
+		server.kernelSettings.Flags |= offered & CAP_WRITEBACK_CACHE
+	}
+	if server.opts.EnableLocks {
+		server.kernelSettings.Flags |= offered & (CAP_POSIX_LOCKS | CAP_FLOCK_LOCKS)
+	}
+	if server.opts.ParallelDirOps {
+		server.kernelSettings.Flags |= offered & CAP_PARALLEL_DIROPS
+	}
+	if server.opts.AtomicTruncate {
+		server.kernelSettings.Flags |= offered & CAP_ATOMIC_O_TRUNC
+	}
+	if server.opts.ExportSupport {
+		server.kernelSettings.Flags |= offered & CAP_EXPORT_SUPPORT
+	}
+	if server.spliceWriter != nil {
+		// We read requests from the device with splice.
+		server.kernelSettings.Flags |= offered & CAP_SPLICE_READ
 	}
-	server.reqMu.Unlock()
 
-	out := (*InitOut)(req.outData())
-	*out = InitOut{
-		Major:               _FUSE_KERNEL_VERSION,
-		Minor:               _OUR_MINOR_VERSION,
-		MaxReadAhead:        input.MaxReadAhead,
-		Flags:               server.kernelSettings.Flags,
-		MaxWrite:            uint32(server.opts.MaxWrite),
-		CongestionThreshold: uint16(server.opts.MaxBackground * 3 / 4),
-		MaxBackground:       uint16(server.opts.MaxBackground),
+	// Writes beyond MAX_KERNEL_WRITE need the kernel to allow
+	// more pages per request.
+	var maxPages uint16
+	server.maxWrite = server.opts.MaxWrite
+	if server.maxWrite > MAX_KERNEL_WRITE {
+		if offered&CAP_MAX_PAGES != 0 {
+			server.kernelSettings.Flags |= CAP_MAX_PAGES
+			maxPages = uint16((server.maxWrite + pageSize - 1) / pageSize)
+		} else {
+			server.maxWrite = MAX_KERNEL_WRITE
+		}
+	}
+
+	// The kernel offers a read ahead; we may only lower it.
+	if server.opts.MaxReadAhead != 0 && uint32(server.opts.MaxReadAhead) < input.MaxReadAhead {
+		server.kernelSettings.MaxReadAhead = uint32(server.opts.MaxReadAhead)
+	}
+
+	server.maxBackground = server.opts.MaxBackground
+	if server.maxBackground <= 0 {
+		server.maxBackground = _DEFAULT_BACKGROUND_TASKS
+	}
+	if server.maxBackground > math.MaxUint16 {
+		server.maxBackground = math.MaxUint16
+	}
+	congestion := server.opts.CongestionThreshold
+	if congestion <= 0 || congestion > server.maxBackground {
+		congestion = server.maxBackground * 3 / 4
 	}
 
-	if server.opts.MaxReadAhead != 0 && uint32(server.opts.MaxReadAhead) < out.MaxReadAhead {
-		out.MaxReadAhead = uint32(server.opts.MaxReadAhead)
+	if minor >= 13 {
+		server.setSplice()
 	}
-	if out.Minor > input.Minor {
-		out.Minor = input.Minor
+	server.initOut = InitOut{
+		Major:               _FUSE_KERNEL_VERSION,
+		Minor:               minor,
+		MaxReadAhead:        server.kernelSettings.MaxReadAhead,
+		Flags:               server.kernelSettings.Flags,
+		MaxWrite:            uint32(server.maxWrite),
+		CongestionThreshold: uint16(congestion),
+		MaxBackground:       uint16(server.maxBackground),
+		MaxPages:            maxPages,
+		// Our timestamps have nanosecond precision, so the
+		// kernel need not round the times that it sets itself,
+		// eg. with the writeback cache.
+		TimeGran: 1,
 	}
+	out := (*InitOut)(req.outData())
+	*out = server.initOut
+	server.reqMu.Unlock()
 
 	if out.Minor <= 22 {
 		tweaked := *req.handler
@@ -121,7 +245,7 @@ func doInit(server *Server, req *request) {
 
 func doOpen(server *Server, req *request) {
 	out := (*OpenOut)(req.outData())
-	status := server.fileSystem.Open((*OpenIn)(req.inData), out)
+	status := server.fileSystem.Open(req.cancel, (*OpenIn)(req.inData), out)
 	req.status = status
 	if status != OK {
 		return
@@ -130,8 +254,23 @@ func doOpen(server *Server, req *request) {
 
 func doCreate(server *Server, req *request) {
 	out := (*CreateOut)(req.outData())
-	status := server.fileSystem.Create((*CreateIn)(req.inData), req.filenames[0], out)
+	status := server.fileSystem.Create(req.cancel, (*CreateIn)(req.inData), req.filenames[0], out)
 	req.status = status
+	if entrySize := req.handler.OutputSize - unsafe.Sizeof(OpenOut{}); entrySize < unsafe.Sizeof(EntryOut{}) {
+		// Older kernels expect the OpenOut right after their
+		// shorter EntryOut.
+		buf := (*[unsafe.Sizeof(CreateOut{})]byte)(req.outData())
+		copy(buf[entrySize:], buf[unsafe.Sizeof(EntryOut{}):])
+	}
+}
+
+func doTmpfile(server *Server, req *request) {
+	if server.tmpfiles == nil {
+		req.status = ENOSYS
+		return
+	}
+	out := (*CreateOut)(req.outData())
+	req.status = server.tmpfiles.Tmpfile(req.cancel, (*CreateIn)(req.inData), out)
 }
 
 func doReadDir(server *Server, req *request) {
@@ -139,7 +278,7 @@ func doReadDir(server *Server, req *request) {
 	buf := server.allocOut(req, in.Size)
 	out := NewDirEntryList(buf, uint64(in.Offset))
 
-	code := server.fileSystem.ReadDir(in, out)
+	code := server.fileSystem.ReadDir(req.cancel, in, out)
 	req.flatData = out.bytes()
 	req.status = code
 }
@@ -149,24 +288,38 @@ func doReadDirPlus(server *Server, req *request) {
 	buf := server.allocOut(req, in.Size)
 	out := NewDirEntryList(buf, uint64(in.Offset))
 
-	code := server.fileSystem.ReadDirPlus(in, out)
+	code := server.fileSystem.ReadDirPlus(req.cancel, in, out)
+	if code == ENOSYS {
+		// The file system only knows plain READDIR. Serve
+		// that, but with empty lookup entries, which the kernel
+		// skips.
+		out = NewDirEntryList(buf, uint64(in.Offset))
+		out.emptyLookups = true
+		code = server.fileSystem.ReadDir(req.cancel, in, out)
+	}
 	req.flatData = out.bytes()
 	req.status = code
 }
 
 func doOpenDir(server *Server, req *request) {
 	out := (*OpenOut)(req.outData())
-	status := server.fileSystem.OpenDir((*OpenIn)(req.inData), out)
+	status := server.fileSystem.OpenDir(req.cancel, (*OpenIn)(req.inData), out)
 	req.status = status
 }
 
 func doSetattr(server *Server, req *request) {
 	out := (*AttrOut)(req.outData())
-	req.status = server.fileSystem.SetAttr((*SetAttrIn)(req.inData), out)
+	req.status = server.fileSystem.SetAttr(req.cancel, (*SetAttrIn)(req.inData), out)
 }
 
 func doWrite(server *Server, req *request) {
-	n, status := server.fileSystem.Write((*WriteIn)(req.inData), req.arg)
+	var n uint32
+	var status Status
+	if req.writePipe != nil {
+		n, status = server.spliceWrite(req)
+	} else {
+		n, status = server.fileSystem.Write(req.cancel, (*WriteIn)(req.inData), req.arg)
+	}
 	o := (*WriteOut)(req.outData())
 	o.Size = n
 	req.status = status
@@ -197,17 +350,16 @@ func doGetXAttr(server *Server, req *request) {
 		out := (*GetXAttrOut)(req.outData())
 		switch req.inHeader.Opcode {
 		case _OP_GETXATTR:
-			// TODO(hanwen): double check this. For getxattr, input.Size
-			// field refers to the size of the attribute, so it usually
-			// is not 0.
-			sz, code := server.fileSystem.GetXAttrSize(req.inHeader, req.filenames[0])
+			// A zero size is the kernel probing for the buffer
+			// size it needs; only report the length.
+			sz, code := server.fileSystem.GetXAttrSize(req.cancel, req.inHeader, req.filenames[0])
 			if code.Ok() {
 				out.Size = uint32(sz)
 			}
 			req.status = code
 			return
 		case _OP_LISTXATTR:
-			data, code := server.fileSystem.ListXAttr(req.inHeader)
+			data, code := server.fileSystem.ListXAttr(req.cancel, req.inHeader)
 			if code.Ok() {
 				out.Size = uint32(len(data))
 			}
@@ -218,9 +370,9 @@ func doGetXAttr(server *Server, req *request) {
 	var data []byte
 	switch req.inHeader.Opcode {
 	case _OP_GETXATTR:
-		data, req.status = server.fileSystem.GetXAttrData(req.inHeader, req.filenames[0])
+		data, req.status = server.fileSystem.GetXAttrData(req.cancel, req.inHeader, req.filenames[0])
 	case _OP_LISTXATTR:
-		data, req.status = server.fileSystem.ListXAttr(req.inHeader)
+		data, req.status = server.fileSystem.ListXAttr(req.cancel, req.inHeader)
 	default:
 		log.Panicf("xattr opcode %v", req.inHeader.Opcode)
 		req.status = ENOSYS
@@ -239,7 +391,7 @@ func doGetXAttr(server *Server, req *request) {
 
 func doGetAttr(server *Server, req *request) {
 	out := (*AttrOut)(req.outData())
-	s := server.fileSystem.GetAttr((*GetAttrIn)(req.inData), out)
+	s := server.fileSystem.GetAttr(req.cancel, (*GetAttrIn)(req.inData), out)
 	req.status = s
 }
 
@@ -250,26 +402,62 @@ func doForget(server *Server, req *request) {
 	}
 }
 
+// doInterrupt - signal cancellation to an in-flight request
+func doInterrupt(server *Server, req *request) {
+	input := (*InterruptIn)(req.inData)
+	server.reqMu.Lock()
+	// This is slow, but INTERRUPT is rare.
+	for _, inflight := range server.reqInflight {
+		if inflight.inHeader.Unique == input.Unique && !inflight.interrupted {
+			close(inflight.cancel)
+			inflight.interrupted = true
+			server.reqMu.Unlock()
+			req.status = OK
+			return
+		}
+	}
+	server.reqMu.Unlock()
+
+	// The request may still be on its way from another reader,
+	// so have the kernel send the INTERRUPT again. If the
+	// request was already answered, the kernel drops it. Back
+	// off briefly, without holding reqMu, which the readers
+	// need to register the request.
+	time.Sleep(10 * time.Microsecond)
+	req.status = EAGAIN
+}
+
 // doBatchForget - forget a list of NodeIds
 func doBatchForget(server *Server, req *request) {
+	// The kernel sends BATCH_FORGET from protocol version 7.16
+	// on; there is no INIT flag to negotiate it.
+	if server.opts.RememberInodes {
+		return
+	}
+
 	in := (*_BatchForgetIn)(req.inData)
-	wantBytes := uintptr(in.Count) * unsafe.Sizeof(_ForgetOne{})
+	count := int(in.Count)
+	wantBytes := uintptr(count) * unsafe.Sizeof(_ForgetOne{})
 	if uintptr(len(req.arg)) < wantBytes {
 		// We have no return value to complain, so log an error.
-		log.Printf("Too few bytes for batch forget. Got %d bytes, want %d (%d entries)",
+		server.logf("Too few bytes for batch forget. Got %d bytes, want %d (%d entries)",
 			len(req.arg), wantBytes, in.Count)
+		count = len(req.arg) / int(unsafe.Sizeof(_ForgetOne{}))
+	}
+	if count == 0 {
+		return
 	}
 
 	h := &reflect.SliceHeader{
 		Data: uintptr(unsafe.Pointer(&req.arg[0])),
-		Len:  int(in.Count),
-		Cap:  int(in.Count),
+		Len:  count,
+		Cap:  count,
 	}
 
 	forgets := *(*[]_ForgetOne)(unsafe.Pointer(h))
 	for i, f := range forgets {
 		if server.opts.Debug {
-			log.Printf("doBatchForget: forgetting %d of %d: NodeId: %d, Nlookup: %d", i+1, len(forgets), f.NodeId, f.Nlookup)
+			server.logf("doBatchForget: forgetting %d of %d: NodeId: %d, Nlookup: %d", i+1, len(forgets), f.NodeId, f.Nlookup)
 		}
 		if f.NodeId == pollHackInode {
 			continue
@@ -279,44 +467,44 @@ func doBatchForget(server *Server, req *request) {
 }
 
 func doReadlink(server *Server, req *request) {
-	req.flatData, req.status = server.fileSystem.Readlink(req.inHeader)
+	req.flatData, req.status = server.fileSystem.Readlink(req.cancel, req.inHeader)
 }
 
 func doLookup(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
-	s := server.fileSystem.Lookup(req.inHeader, req.filenames[0], out)
+	s := server.fileSystem.Lookup(req.cancel, req.inHeader, req.filenames[0], out)
 	req.status = s
 }
 
 func doMknod(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
 
-	req.status = server.fileSystem.Mknod((*MknodIn)(req.inData), req.filenames[0], out)
+	req.status = server.fileSystem.Mknod(req.cancel, (*MknodIn)(req.inData), req.filenames[0], out)
 }
 
 func doMkdir(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
-	req.status = server.fileSystem.Mkdir((*MkdirIn)(req.inData), req.filenames[0], out)
+	req.status = server.fileSystem.Mkdir(req.cancel, (*MkdirIn)(req.inData), req.filenames[0], out)
 }
 
 func doUnlink(server *Server, req *request) {
-	req.status = server.fileSystem.Unlink(req.inHeader, req.filenames[0])
+	req.status = server.fileSystem.Unlink(req.cancel, req.inHeader, req.filenames[0])
 }
 
 func doRmdir(server *Server, req *request) {
-	req.status = server.fileSystem.Rmdir(req.inHeader, req.filenames[0])
+	req.status = server.fileSystem.Rmdir(req.cancel, req.inHeader, req.filenames[0])
 }
 
 func doLink(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
-	req.status = server.fileSystem.Link((*LinkIn)(req.inData), req.filenames[0], out)
+	req.status = server.fileSystem.Link(req.cancel, (*LinkIn)(req.inData), req.filenames[0], out)
 }
 
 func doRead(server *Server, req *request) {
 	in := (*ReadIn)(req.inData)
 	buf := server.allocOut(req, in.Size)
 
-	req.readResult, req.status = server.fileSystem.Read(in, buf)
+	req.readResult, req.status = server.fileSystem.Read(req.cancel, in, buf)
 	if fd, ok := req.readResult.(*readResultFd); ok {
 		req.fdData = fd
 		req.flatData = nil
@@ -326,50 +514,59 @@ func doRead(server *Server, req *request) {
 }
 
 func doFlush(server *Server, req *request) {
-	req.status = server.fileSystem.Flush((*FlushIn)(req.inData))
+	req.status = server.fileSystem.Flush(req.cancel, (*FlushIn)(req.inData))
 }
 
 func doRelease(server *Server, req *request) {
-	server.fileSystem.Release((*ReleaseIn)(req.inData))
+	server.fileSystem.Release(req.cancel, (*ReleaseIn)(req.inData))
 }
 
 func doFsync(server *Server, req *request) {
-	req.status = server.fileSystem.Fsync((*FsyncIn)(req.inData))
+	req.status = server.fileSystem.Fsync(req.cancel, (*FsyncIn)(req.inData))
 }
 
 func doReleaseDir(server *Server, req *request) {
-	server.fileSystem.ReleaseDir((*ReleaseIn)(req.inData))
+	server.fileSystem.ReleaseDir(req.cancel, (*ReleaseIn)(req.inData))
 }
 
 func doFsyncDir(server *Server, req *request) {
-	req.status = server.fileSystem.FsyncDir((*FsyncIn)(req.inData))
+	req.status = server.fileSystem.FsyncDir(req.cancel, (*FsyncIn)(req.inData))
 }
 
 func doSetXAttr(server *Server, req *request) {
 	splits := bytes.SplitN(req.arg, []byte{0}, 2)
-	req.status = server.fileSystem.SetXAttr((*SetXAttrIn)(req.inData), string(splits[0]), splits[1])
+	req.status = server.fileSystem.SetXAttr(req.cancel, (*SetXAttrIn)(req.inData), string(splits[0]), splits[1])
 }
 
 func doRemoveXAttr(server *Server, req *request) {
-	req.status = server.fileSystem.RemoveXAttr(req.inHeader, req.filenames[0])
+	req.status = server.fileSystem.RemoveXAttr(req.cancel, req.inHeader, req.filenames[0])
 }
 
 func doAccess(server *Server, req *request) {
-	req.status = server.fileSystem.Access((*AccessIn)(req.inData))
+	req.status = server.fileSystem.Access(req.cancel, (*AccessIn)(req.inData))
 }
 
 func doSymlink(server *Server, req *request) {
 	out := (*EntryOut)(req.outData())
-	req.status = server.fileSystem.Symlink(req.inHeader, req.filenames[1], req.filenames[0], out)
+	req.status = server.fileSystem.Symlink(req.cancel, req.inHeader, req.filenames[1], req.filenames[0], out)
 }
 
 func doRename(server *Server, req *request) {
-	req.status = server.fileSystem.Rename((*RenameIn)(req.inData), req.filenames[0], req.filenames[1])
+	in1 := (*Rename1In)(req.inData)
+	in := RenameIn{
+		InHeader: in1.InHeader,
+		Newdir:   in1.Newdir,
+	}
+	req.status = server.fileSystem.Rename(req.cancel, &in, req.filenames[0], req.filenames[1])
+}
+
+func doRename2(server *Server, req *request) {
+	req.status = server.fileSystem.Rename(req.cancel, (*RenameIn)(req.inData), req.filenames[0], req.filenames[1])
 }
 
 func doStatFs(server *Server, req *request) {
 	out := (*StatfsOut)(req.outData())
-	req.status = server.fileSystem.StatFs(req.inHeader, out)
+	req.status = server.fileSystem.StatFs(req.cancel, req.inHeader, out)
 	if req.status == ENOSYS && runtime.GOOS == "darwin" {
 		// OSX FUSE requires Statfs to be implemented for the
 		// mount to succeed.
@@ -378,8 +575,20 @@ func doStatFs(server *Server, req *request) {
 	}
 }
 
+func doBmap(server *Server, req *request) {
+	req.status = server.fileSystem.Bmap(req.cancel, (*BmapIn)(req.inData), (*BmapOut)(req.outData()))
+}
+
 func doIoctl(server *Server, req *request) {
-	req.status = ENOSYS
+	in := (*IoctlIn)(req.inData)
+	out := (*IoctlOut)(req.outData())
+	data, status := server.fileSystem.Ioctl(req.cancel, in, req.arg, out)
+	if status.Ok() && out.Flags&FUSE_IOCTL_RETRY == 0 && len(data) > int(in.OutSize) {
+		server.logf("ioctl 0x%x: reply of %d bytes exceeds OutSize %d", in.Cmd, len(data), in.OutSize)
+		status = EIO
+	}
+	req.flatData = data
+	req.status = status
 }
 
 func doDestroy(server *Server, req *request) {
@@ -387,7 +596,83 @@ func doDestroy(server *Server, req *request) {
 }
 
 func doFallocate(server *Server, req *request) {
-	req.status = server.fileSystem.Fallocate((*FallocateIn)(req.inData))
+	req.status = server.fileSystem.Fallocate(req.cancel, (*FallocateIn)(req.inData))
+}
+
+func doLseek(server *Server, req *request) {
+	req.status = server.fileSystem.Lseek(req.cancel, (*LseekIn)(req.inData), (*LseekOut)(req.outData()))
+}
+
+func doCopyFileRange(server *Server, req *request) {
+	out := (*WriteOut)(req.outData())
+	out.Size, req.status = server.fileSystem.CopyFileRange(req.cancel, (*CopyFileRangeIn)(req.inData))
+}
+
+func doPoll(server *Server, req *request) {
+	req.status = server.fileSystem.Poll(req.cancel, (*PollIn)(req.inData), (*PollOut)(req.outData()))
+}
+
+// doNotifyReply - the kernel's answer to a NOTIFY_RETRIEVE
+func doNotifyReply(server *Server, req *request) {
+	in := (*NotifyRetrieveIn)(req.inData)
+	server.reqMu.Lock()
+	fn := server.retrieveTab[in.Unique]
+	delete(server.retrieveTab, in.Unique)
+	server.reqMu.Unlock()
+
+	if fn == nil {
+		server.logf("NOTIFY_REPLY for unknown retrieve %d", in.Unique)
+		return
+	}
+	data := req.arg
+	if len(data) > int(in.Size) {
+		data = data[:in.Size]
+	}
+	fn(int64(in.Offset), data)
+}
+
+func doGetLk(server *Server, req *request) {
+	req.status = server.fileSystem.GetLk(req.cancel, (*LkIn)(req.inData), (*LkOut)(req.outData()))
+}
+
+func doSetLk(server *Server, req *request) {
+	in := (*LkIn)(req.inData)
+	if in.LkFlags&FUSE_LK_FLOCK != 0 {
+		req.status = doFlock(server, req, syscall.LOCK_NB)
+		return
+	}
+	req.status = server.fileSystem.SetLk(req.cancel, in)
+}
+
+func doSetLkw(server *Server, req *request) {
+	in := (*LkIn)(req.inData)
+	if in.LkFlags&FUSE_LK_FLOCK != 0 {
+		req.status = doFlock(server, req, 0)
+		return
+	}
+	req.status = server.fileSystem.SetLkw(req.cancel, in)
+}
+
+// doFlock translates a SETLK or SETLKW for a flock(2) lock into a
+// Flock call.
+func doFlock(server *Server, req *request, flags int) Status {
+	in := (*LkIn)(req.inData)
+	switch in.Lk.Typ {
+	case syscall.F_RDLCK:
+		flags |= syscall.LOCK_SH
+	case syscall.F_WRLCK:
+		flags |= syscall.LOCK_EX
+	case syscall.F_UNLCK:
+		flags |= syscall.LOCK_UN
+	default:
+		return EINVAL
+	}
+	flockIn := FlockIn{
+		InHeader: in.InHeader,
+		Fh:       in.Fh,
+		Owner:    in.Owner,
+	}
+	return server.fileSystem.Flock(req.cancel, &flockIn, flags)
 }
 
 ////////////////////////////////////////////////////////////////
@@ -435,218 +720,275 @@ func init() {
 	}
 
 	for op, sz := range map[int32]uintptr{
-		_OP_FORGET:       unsafe.Sizeof(ForgetIn{}),
-		_OP_BATCH_FORGET: unsafe.Sizeof(_BatchForgetIn{}),
-		_OP_GETATTR:      unsafe.Sizeof(GetAttrIn{}),
-		_OP_SETATTR:      unsafe.Sizeof(SetAttrIn{}),
-		_OP_MKNOD:        unsafe.Sizeof(MknodIn{}),
-		_OP_MKDIR:        unsafe.Sizeof(MkdirIn{}),
-		_OP_RENAME:       unsafe.Sizeof(RenameIn{}),
-		_OP_LINK:         unsafe.Sizeof(LinkIn{}),
-		_OP_OPEN:         unsafe.Sizeof(OpenIn{}),
-		_OP_READ:         unsafe.Sizeof(ReadIn{}),
-		_OP_WRITE:        unsafe.Sizeof(WriteIn{}),
-		_OP_RELEASE:      unsafe.Sizeof(ReleaseIn{}),
-		_OP_FSYNC:        unsafe.Sizeof(FsyncIn{}),
-		_OP_SETXATTR:     unsafe.Sizeof(SetXAttrIn{}),
-		_OP_GETXATTR:     unsafe.Sizeof(GetXAttrIn{}),
-		_OP_LISTXATTR:    unsafe.Sizeof(GetXAttrIn{}),
-		_OP_FLUSH:        unsafe.Sizeof(FlushIn{}),
-		_OP_INIT:         unsafe.Sizeof(InitIn{}),
-		_OP_OPENDIR:      unsafe.Sizeof(OpenIn{}),
-		_OP_READDIR:      unsafe.Sizeof(ReadIn{}),
-		_OP_RELEASEDIR:   unsafe.Sizeof(ReleaseIn{}),
-		_OP_FSYNCDIR:     unsafe.Sizeof(FsyncIn{}),
-		_OP_ACCESS:       unsafe.Sizeof(AccessIn{}),
-		_OP_CREATE:       unsafe.Sizeof(CreateIn{}),
-		_OP_INTERRUPT:    unsafe.Sizeof(InterruptIn{}),
-		_OP_BMAP:         unsafe.Sizeof(_BmapIn{}),
-		_OP_IOCTL:        unsafe.Sizeof(_IoctlIn{}),
-		_OP_POLL:         unsafe.Sizeof(_PollIn{}),
-		_OP_FALLOCATE:    unsafe.Sizeof(FallocateIn{}),
-		_OP_READDIRPLUS:  unsafe.Sizeof(ReadIn{}),
+		_OP_FORGET:          unsafe.Sizeof(ForgetIn{}),
+		_OP_BATCH_FORGET:    unsafe.Sizeof(_BatchForgetIn{}),
+		_OP_GETATTR:         unsafe.Sizeof(GetAttrIn{}),
+		_OP_SETATTR:         unsafe.Sizeof(SetAttrIn{}),
+		_OP_MKNOD:           unsafe.Sizeof(MknodIn{}),
+		_OP_MKDIR:           unsafe.Sizeof(MkdirIn{}),
+		_OP_RENAME:          unsafe.Sizeof(Rename1In{}),
+		_OP_FUSE_RENAME2:    unsafe.Sizeof(RenameIn{}),
+		_OP_LINK:            unsafe.Sizeof(LinkIn{}),
+		_OP_OPEN:            unsafe.Sizeof(OpenIn{}),
+		_OP_READ:            unsafe.Sizeof(ReadIn{}),
+		_OP_WRITE:           unsafe.Sizeof(WriteIn{}),
+		_OP_RELEASE:         unsafe.Sizeof(ReleaseIn{}),
+		_OP_FSYNC:           unsafe.Sizeof(FsyncIn{}),
+		_OP_SETXATTR:        unsafe.Sizeof(SetXAttrIn{}),
+		_OP_GETXATTR:        unsafe.Sizeof(GetXAttrIn{}),
+		_OP_LISTXATTR:       unsafe.Sizeof(GetXAttrIn{}),
+		_OP_FLUSH:           unsafe.Sizeof(FlushIn{}),
+		_OP_INIT:            unsafe.Sizeof(InitIn{}),
+		_OP_OPENDIR:         unsafe.Sizeof(OpenIn{}),
+		_OP_READDIR:         unsafe.Sizeof(ReadIn{}),
+		_OP_RELEASEDIR:      unsafe.Sizeof(ReleaseIn{}),
+		_OP_FSYNCDIR:        unsafe.Sizeof(FsyncIn{}),
+		_OP_ACCESS:          unsafe.Sizeof(AccessIn{}),
+		_OP_CREATE:          unsafe.Sizeof(CreateIn{}),
+		_OP_INTERRUPT:       unsafe.Sizeof(InterruptIn{}),
+		_OP_BMAP:            unsafe.Sizeof(BmapIn{}),
+		_OP_IOCTL:           unsafe.Sizeof(IoctlIn{}),
+		_OP_POLL:            unsafe.Sizeof(PollIn{}),
+		_OP_NOTIFY_REPLY:    unsafe.Sizeof(NotifyRetrieveIn{}),
+		_OP_FALLOCATE:       unsafe.Sizeof(FallocateIn{}),
+		_OP_READDIRPLUS:     unsafe.Sizeof(ReadIn{}),
+		_OP_GETLK:           unsafe.Sizeof(LkIn{}),
+		_OP_SETLK:           unsafe.Sizeof(LkIn{}),
+		_OP_SETLKW:          unsafe.Sizeof(LkIn{}),
+		_OP_LSEEK:           unsafe.Sizeof(LseekIn{}),
+		_OP_COPY_FILE_RANGE: unsafe.Sizeof(CopyFileRangeIn{}),
+		_OP_TMPFILE:         unsafe.Sizeof(CreateIn{}),
 	} {
 		operationHandlers[op].InputSize = sz
 	}
 
 	for op, sz := range map[int32]uintptr{
-		_OP_LOOKUP:        unsafe.Sizeof(EntryOut{}),
-		_OP_GETATTR:       unsafe.Sizeof(AttrOut{}),
-		_OP_SETATTR:       unsafe.Sizeof(AttrOut{}),
-		_OP_SYMLINK:       unsafe.Sizeof(EntryOut{}),
-		_OP_MKNOD:         unsafe.Sizeof(EntryOut{}),
-		_OP_MKDIR:         unsafe.Sizeof(EntryOut{}),
-		_OP_LINK:          unsafe.Sizeof(EntryOut{}),
-		_OP_OPEN:          unsafe.Sizeof(OpenOut{}),
-		_OP_WRITE:         unsafe.Sizeof(WriteOut{}),
-		_OP_STATFS:        unsafe.Sizeof(StatfsOut{}),
-		_OP_GETXATTR:      unsafe.Sizeof(GetXAttrOut{}),
-		_OP_LISTXATTR:     unsafe.Sizeof(GetXAttrOut{}),
-		_OP_INIT:          unsafe.Sizeof(InitOut{}),
-		_OP_OPENDIR:       unsafe.Sizeof(OpenOut{}),
-		_OP_CREATE:        unsafe.Sizeof(CreateOut{}),
-		_OP_BMAP:          unsafe.Sizeof(_BmapOut{}),
-		_OP_IOCTL:         unsafe.Sizeof(_IoctlOut{}),
-		_OP_POLL:          unsafe.Sizeof(_PollOut{}),
-		_OP_NOTIFY_ENTRY:  unsafe.Sizeof(NotifyInvalEntryOut{}),
-		_OP_NOTIFY_INODE:  unsafe.Sizeof(NotifyInvalInodeOut{}),
-		_OP_NOTIFY_DELETE: unsafe.Sizeof(NotifyInvalDeleteOut{}),
+		_OP_LOOKUP:          unsafe.Sizeof(EntryOut{}),
+		_OP_GETATTR:         unsafe.Sizeof(AttrOut{}),
+		_OP_SETATTR:         unsafe.Sizeof(AttrOut{}),
+		_OP_SYMLINK:         unsafe.Sizeof(EntryOut{}),
+		_OP_MKNOD:           unsafe.Sizeof(EntryOut{}),
+		_OP_MKDIR:           unsafe.Sizeof(EntryOut{}),
+		_OP_LINK:            unsafe.Sizeof(EntryOut{}),
+		_OP_OPEN:            unsafe.Sizeof(OpenOut{}),
+		_OP_WRITE:           unsafe.Sizeof(WriteOut{}),
+		_OP_STATFS:          unsafe.Sizeof(StatfsOut{}),
+		_OP_GETXATTR:        unsafe.Sizeof(GetXAttrOut{}),
+		_OP_LISTXATTR:       unsafe.Sizeof(GetXAttrOut{}),
+		_OP_INIT:            unsafe.Sizeof(InitOut{}),
+		_OP_OPENDIR:         unsafe.Sizeof(OpenOut{}),
+		_OP_CREATE:          unsafe.Sizeof(CreateOut{}),
+		_OP_BMAP:            unsafe.Sizeof(BmapOut{}),
+		_OP_IOCTL:           unsafe.Sizeof(IoctlOut{}),
+		_OP_POLL:            unsafe.Sizeof(PollOut{}),
+		_OP_NOTIFY_ENTRY:    unsafe.Sizeof(NotifyInvalEntryOut{}),
+		_OP_NOTIFY_INODE:    unsafe.Sizeof(NotifyInvalInodeOut{}),
+		_OP_NOTIFY_DELETE:   unsafe.Sizeof(NotifyInvalDeleteOut{}),
+		_OP_NOTIFY_POLL:     unsafe.Sizeof(_NotifyPollWakeupOut{}),
+		_OP_NOTIFY_STORE:    unsafe.Sizeof(NotifyStoreOut{}),
+		_OP_NOTIFY_RETRIEVE: unsafe.Sizeof(NotifyRetrieveOut{}),
+		_OP_GETLK:           unsafe.Sizeof(LkOut{}),
+		_OP_LSEEK:           unsafe.Sizeof(LseekOut{}),
+		_OP_COPY_FILE_RANGE: unsafe.Sizeof(WriteOut{}),
+		_OP_TMPFILE:         unsafe.Sizeof(CreateOut{}),
 	} {
 		operationHandlers[op].OutputSize = sz
 	}
 
 	for op, v := range map[int32]string{
-		_OP_LOOKUP:        "LOOKUP",
-		_OP_FORGET:        "FORGET",
-		_OP_BATCH_FORGET:  "BATCH_FORGET",
-		_OP_GETATTR:       "GETATTR",
-		_OP_SETATTR:       "SETATTR",
-		_OP_READLINK:      "READLINK",
-		_OP_SYMLINK:       "SYMLINK",
-		_OP_MKNOD:         "MKNOD",
-		_OP_MKDIR:         "MKDIR",
-		_OP_UNLINK:        "UNLINK",
-		_OP_RMDIR:         "RMDIR",
-		_OP_RENAME:        "RENAME",
-		_OP_LINK:          "LINK",
-		_OP_OPEN:          "OPEN",
-		_OP_READ:          "READ",
-		_OP_WRITE:         "WRITE",
-		_OP_STATFS:        "STATFS",
-		_OP_RELEASE:       "RELEASE",
-		_OP_FSYNC:         "FSYNC",
-		_OP_SETXATTR:      "SETXATTR",
-		_OP_GETXATTR:      "GETXATTR",
-		_OP_LISTXATTR:     "LISTXATTR",
-		_OP_REMOVEXATTR:   "REMOVEXATTR",
-		_OP_FLUSH:         "FLUSH",
-		_OP_INIT:          "INIT",
-		_OP_OPENDIR:       "OPENDIR",
-		_OP_READDIR:       "READDIR",
-		_OP_RELEASEDIR:    "RELEASEDIR",
-		_OP_FSYNCDIR:      "FSYNCDIR",
-		_OP_GETLK:         "GETLK",
-		_OP_SETLK:         "SETLK",
-		_OP_SETLKW:        "SETLKW",
-		_OP_ACCESS:        "ACCESS",
-		_OP_CREATE:        "CREATE",
-		_OP_INTERRUPT:     "INTERRUPT",
-		_OP_BMAP:          "BMAP",
-		_OP_DESTROY:       "DESTROY",
-		_OP_IOCTL:         "IOCTL",
-		_OP_POLL:          "POLL",
-		_OP_NOTIFY_ENTRY:  "NOTIFY_ENTRY",
-		_OP_NOTIFY_INODE:  "NOTIFY_INODE",
-		_OP_NOTIFY_DELETE: "NOTIFY_DELETE",
-		_OP_FALLOCATE:     "FALLOCATE",
-		_OP_READDIRPLUS:   "READDIRPLUS",
+		_OP_LOOKUP:          "LOOKUP",
+		_OP_FORGET:          "FORGET",
+		_OP_BATCH_FORGET:    "BATCH_FORGET",
+		_OP_GETATTR:         "GETATTR",
+		_OP_SETATTR:         "SETATTR",
+		_OP_READLINK:        "READLINK",
+		_OP_SYMLINK:         "SYMLINK",
+		_OP_MKNOD:           "MKNOD",
+		_OP_MKDIR:           "MKDIR",
+		_OP_UNLINK:          "UNLINK",
+		_OP_RMDIR:           "RMDIR",
+		_OP_RENAME:          "RENAME",
+		_OP_FUSE_RENAME2:    "RENAME2",
+		_OP_LINK:            "LINK",
+		_OP_OPEN:            "OPEN",
+		_OP_READ:            "READ",
+		_OP_WRITE:           "WRITE",
+		_OP_STATFS:          "STATFS",
+		_OP_RELEASE:         "RELEASE",
+		_OP_FSYNC:           "FSYNC",
+		_OP_SETXATTR:        "SETXATTR",
+		_OP_GETXATTR:        "GETXATTR",
+		_OP_LISTXATTR:       "LISTXATTR",
+		_OP_REMOVEXATTR:     "REMOVEXATTR",
+		_OP_FLUSH:           "FLUSH",
+		_OP_INIT:            "INIT",
+		_OP_OPENDIR:         "OPENDIR",
+		_OP_READDIR:         "READDIR",
+		_OP_RELEASEDIR:      "RELEASEDIR",
+		_OP_FSYNCDIR:        "FSYNCDIR",
+		_OP_GETLK:           "GETLK",
+		_OP_SETLK:           "SETLK",
+		_OP_SETLKW:          "SETLKW",
+		_OP_ACCESS:          "ACCESS",
+		_OP_CREATE:          "CREATE",
+		_OP_INTERRUPT:       "INTERRUPT",
+		_OP_BMAP:            "BMAP",
+		_OP_DESTROY:         "DESTROY",
+		_OP_IOCTL:           "IOCTL",
+		_OP_POLL:            "POLL",
+		_OP_NOTIFY_REPLY:    "NOTIFY_REPLY",
+		_OP_NOTIFY_ENTRY:    "NOTIFY_ENTRY",
+		_OP_NOTIFY_INODE:    "NOTIFY_INODE",
+		_OP_NOTIFY_DELETE:   "NOTIFY_DELETE",
+		_OP_NOTIFY_POLL:     "NOTIFY_POLL",
+		_OP_NOTIFY_STORE:    "NOTIFY_STORE",
+		_OP_NOTIFY_RETRIEVE: "NOTIFY_RETRIEVE",
+		_OP_FALLOCATE:       "FALLOCATE",
+		_OP_READDIRPLUS:     "READDIRPLUS",
+		_OP_LSEEK:           "LSEEK",
+		_OP_COPY_FILE_RANGE: "COPY_FILE_RANGE",
+		_OP_TMPFILE:         "TMPFILE",
 	} {
 		operationHandlers[op].Name = v
 	}
 
 	for op, v := range map[int32]operationFunc{
-		_OP_OPEN:         doOpen,
-		_OP_READDIR:      doReadDir,
-		_OP_WRITE:        doWrite,
-		_OP_OPENDIR:      doOpenDir,
-		_OP_CREATE:       doCreate,
-		_OP_SETATTR:      doSetattr,
-		_OP_GETXATTR:     doGetXAttr,
-		_OP_LISTXATTR:    doGetXAttr,
-		_OP_GETATTR:      doGetAttr,
-		_OP_FORGET:       doForget,
-		_OP_BATCH_FORGET: doBatchForget,
-		_OP_READLINK:     doReadlink,
-		_OP_INIT:         doInit,
-		_OP_LOOKUP:       doLookup,
-		_OP_MKNOD:        doMknod,
-		_OP_MKDIR:        doMkdir,
-		_OP_UNLINK:       doUnlink,
-		_OP_RMDIR:        doRmdir,
-		_OP_LINK:         doLink,
-		_OP_READ:         doRead,
-		_OP_FLUSH:        doFlush,
-		_OP_RELEASE:      doRelease,
-		_OP_FSYNC:        doFsync,
-		_OP_RELEASEDIR:   doReleaseDir,
-		_OP_FSYNCDIR:     doFsyncDir,
-		_OP_SETXATTR:     doSetXAttr,
-		_OP_REMOVEXATTR:  doRemoveXAttr,
-		_OP_ACCESS:       doAccess,
-		_OP_SYMLINK:      doSymlink,
-		_OP_RENAME:       doRename,
-		_OP_STATFS:       doStatFs,
-		_OP_IOCTL:        doIoctl,
-		_OP_DESTROY:      doDestroy,
-		_OP_FALLOCATE:    doFallocate,
-		_OP_READDIRPLUS:  doReadDirPlus,
+		_OP_OPEN:            doOpen,
+		_OP_READDIR:         doReadDir,
+		_OP_WRITE:           doWrite,
+		_OP_OPENDIR:         doOpenDir,
+		_OP_CREATE:          doCreate,
+		_OP_SETATTR:         doSetattr,
+		_OP_GETXATTR:        doGetXAttr,
+		_OP_LISTXATTR:       doGetXAttr,
+		_OP_GETATTR:         doGetAttr,
+		_OP_FORGET:          doForget,
+		_OP_BATCH_FORGET:    doBatchForget,
+		_OP_READLINK:        doReadlink,
+		_OP_INIT:            doInit,
+		_OP_LOOKUP:          doLookup,
+		_OP_MKNOD:           doMknod,
+		_OP_MKDIR:           doMkdir,
+		_OP_UNLINK:          doUnlink,
+		_OP_RMDIR:           doRmdir,
+		_OP_LINK:            doLink,
+		_OP_READ:            doRead,
+		_OP_FLUSH:           doFlush,
+		_OP_INTERRUPT:       doInterrupt,
+		_OP_RELEASE:         doRelease,
+		_OP_FSYNC:           doFsync,
+		_OP_RELEASEDIR:      doReleaseDir,
+		_OP_FSYNCDIR:        doFsyncDir,
+		_OP_SETXATTR:        doSetXAttr,
+		_OP_REMOVEXATTR:     doRemoveXAttr,
+		_OP_ACCESS:          doAccess,
+		_OP_SYMLINK:         doSymlink,
+		_OP_RENAME:          doRename,
+		_OP_FUSE_RENAME2:    doRename2,
+		_OP_STATFS:          doStatFs,
+		_OP_BMAP:            doBmap,
+		_OP_IOCTL:           doIoctl,
+		_OP_DESTROY:         doDestroy,
+		_OP_FALLOCATE:       doFallocate,
+		_OP_READDIRPLUS:     doReadDirPlus,
+		_OP_GETLK:           doGetLk,
+		_OP_SETLK:           doSetLk,
+		_OP_SETLKW:          doSetLkw,
+		_OP_LSEEK:           doLseek,
+		_OP_POLL:            doPoll,
+		_OP_NOTIFY_REPLY:    doNotifyReply,
+		_OP_COPY_FILE_RANGE: doCopyFileRange,
+		_OP_TMPFILE:         doTmpfile,
 	} {
 		operationHandlers[op].Func = v
 	}
 
 	// Outputs.
 	for op, f := range map[int32]castPointerFunc{
-		_OP_LOOKUP:        func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
-		_OP_OPEN:          func(ptr unsafe.Pointer) interface{} { return (*OpenOut)(ptr) },
-		_OP_OPENDIR:       func(ptr unsafe.Pointer) interface{} { return (*OpenOut)(ptr) },
-		_OP_GETATTR:       func(ptr unsafe.Pointer) interface{} { return (*AttrOut)(ptr) },
-		_OP_CREATE:        func(ptr unsafe.Pointer) interface{} { return (*CreateOut)(ptr) },
-		_OP_LINK:          func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
-		_OP_SETATTR:       func(ptr unsafe.Pointer) interface{} { return (*AttrOut)(ptr) },
-		_OP_INIT:          func(ptr unsafe.Pointer) interface{} { return (*InitOut)(ptr) },
-		_OP_MKDIR:         func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
-		_OP_NOTIFY_ENTRY:  func(ptr unsafe.Pointer) interface{} { return (*NotifyInvalEntryOut)(ptr) },
-		_OP_NOTIFY_INODE:  func(ptr unsafe.Pointer) interface{} { return (*NotifyInvalInodeOut)(ptr) },
-		_OP_NOTIFY_DELETE: func(ptr unsafe.Pointer) interface{} { return (*NotifyInvalDeleteOut)(ptr) },
-		_OP_STATFS:        func(ptr unsafe.Pointer) interface{} { return (*StatfsOut)(ptr) },
-		_OP_SYMLINK:       func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
+		_OP_LOOKUP:          func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
+		_OP_OPEN:            func(ptr unsafe.Pointer) interface{} { return (*OpenOut)(ptr) },
+		_OP_OPENDIR:         func(ptr unsafe.Pointer) interface{} { return (*OpenOut)(ptr) },
+		_OP_GETATTR:         func(ptr unsafe.Pointer) interface{} { return (*AttrOut)(ptr) },
+		_OP_CREATE:          func(ptr unsafe.Pointer) interface{} { return (*CreateOut)(ptr) },
+		_OP_LINK:            func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
+		_OP_SETATTR:         func(ptr unsafe.Pointer) interface{} { return (*AttrOut)(ptr) },
+		_OP_INIT:            func(ptr unsafe.Pointer) interface{} { return (*InitOut)(ptr) },
+		_OP_MKDIR:           func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
+		_OP_NOTIFY_ENTRY:    func(ptr unsafe.Pointer) interface{} { return (*NotifyInvalEntryOut)(ptr) },
+		_OP_NOTIFY_INODE:    func(ptr unsafe.Pointer) interface{} { return (*NotifyInvalInodeOut)(ptr) },
+		_OP_NOTIFY_DELETE:   func(ptr unsafe.Pointer) interface{} { return (*NotifyInvalDeleteOut)(ptr) },
+		_OP_NOTIFY_POLL:     func(ptr unsafe.Pointer) interface{} { return (*_NotifyPollWakeupOut)(ptr) },
+		_OP_NOTIFY_STORE:    func(ptr unsafe.Pointer) interface{} { return (*NotifyStoreOut)(ptr) },
+		_OP_NOTIFY_RETRIEVE: func(ptr unsafe.Pointer) interface{} { return (*NotifyRetrieveOut)(ptr) },
+		_OP_STATFS:          func(ptr unsafe.Pointer) interface{} { return (*StatfsOut)(ptr) },
+		_OP_SYMLINK:         func(ptr unsafe.Pointer) interface{} { return (*EntryOut)(ptr) },
+		_OP_GETLK:           func(ptr unsafe.Pointer) interface{} { return (*LkOut)(ptr) },
+		_OP_LSEEK:           func(ptr unsafe.Pointer) interface{} { return (*LseekOut)(ptr) },
+		_OP_BMAP:            func(ptr unsafe.Pointer) interface{} { return (*BmapOut)(ptr) },
+		_OP_IOCTL:           func(ptr unsafe.Pointer) interface{} { return (*IoctlOut)(ptr) },
+		_OP_POLL:            func(ptr unsafe.Pointer) interface{} { return (*PollOut)(ptr) },
+		_OP_COPY_FILE_RANGE: func(ptr unsafe.Pointer) interface{} { return (*WriteOut)(ptr) },
+		_OP_TMPFILE:         func(ptr unsafe.Pointer) interface{} { return (*CreateOut)(ptr) },
 	} {
 		operationHandlers[op].DecodeOut = f
 	}
 
 	// Inputs.
 	for op, f := range map[int32]castPointerFunc{
-		_OP_FLUSH:        func(ptr unsafe.Pointer) interface{} { return (*FlushIn)(ptr) },
-		_OP_GETATTR:      func(ptr unsafe.Pointer) interface{} { return (*GetAttrIn)(ptr) },
-		_OP_SETXATTR:     func(ptr unsafe.Pointer) interface{} { return (*SetXAttrIn)(ptr) },
-		_OP_GETXATTR:     func(ptr unsafe.Pointer) interface{} { return (*GetXAttrIn)(ptr) },
-		_OP_LISTXATTR:    func(ptr unsafe.Pointer) interface{} { return (*GetXAttrIn)(ptr) },
-		_OP_SETATTR:      func(ptr unsafe.Pointer) interface{} { return (*SetAttrIn)(ptr) },
-		_OP_INIT:         func(ptr unsafe.Pointer) interface{} { return (*InitIn)(ptr) },
-		_OP_IOCTL:        func(ptr unsafe.Pointer) interface{} { return (*_IoctlIn)(ptr) },
-		_OP_OPEN:         func(ptr unsafe.Pointer) interface{} { return (*OpenIn)(ptr) },
-		_OP_MKNOD:        func(ptr unsafe.Pointer) interface{} { return (*MknodIn)(ptr) },
-		_OP_CREATE:       func(ptr unsafe.Pointer) interface{} { return (*CreateIn)(ptr) },
-		_OP_READ:         func(ptr unsafe.Pointer) interface{} { return (*ReadIn)(ptr) },
-		_OP_READDIR:      func(ptr unsafe.Pointer) interface{} { return (*ReadIn)(ptr) },
-		_OP_ACCESS:       func(ptr unsafe.Pointer) interface{} { return (*AccessIn)(ptr) },
-		_OP_FORGET:       func(ptr unsafe.Pointer) interface{} { return (*ForgetIn)(ptr) },
-		_OP_BATCH_FORGET: func(ptr unsafe.Pointer) interface{} { return (*_BatchForgetIn)(ptr) },
-		_OP_LINK:         func(ptr unsafe.Pointer) interface{} { return (*LinkIn)(ptr) },
-		_OP_MKDIR:        func(ptr unsafe.Pointer) interface{} { return (*MkdirIn)(ptr) },
-		_OP_RELEASE:      func(ptr unsafe.Pointer) interface{} { return (*ReleaseIn)(ptr) },
-		_OP_RELEASEDIR:   func(ptr unsafe.Pointer) interface{} { return (*ReleaseIn)(ptr) },
-		_OP_FALLOCATE:    func(ptr unsafe.Pointer) interface{} { return (*FallocateIn)(ptr) },
-		_OP_READDIRPLUS:  func(ptr unsafe.Pointer) interface{} { return (*ReadIn)(ptr) },
-		_OP_RENAME:       func(ptr unsafe.Pointer) interface{} { return (*RenameIn)(ptr) },
+		_OP_FLUSH:           func(ptr unsafe.Pointer) interface{} { return (*FlushIn)(ptr) },
+		_OP_INTERRUPT:       func(ptr unsafe.Pointer) interface{} { return (*InterruptIn)(ptr) },
+		_OP_GETATTR:         func(ptr unsafe.Pointer) interface{} { return (*GetAttrIn)(ptr) },
+		_OP_SETXATTR:        func(ptr unsafe.Pointer) interface{} { return (*SetXAttrIn)(ptr) },
+		_OP_GETXATTR:        func(ptr unsafe.Pointer) interface{} { return (*GetXAttrIn)(ptr) },
+		_OP_LISTXATTR:       func(ptr unsafe.Pointer) interface{} { return (*GetXAttrIn)(ptr) },
+		_OP_SETATTR:         func(ptr unsafe.Pointer) interface{} { return (*SetAttrIn)(ptr) },
+		_OP_INIT:            func(ptr unsafe.Pointer) interface{} { return (*InitIn)(ptr) },
+		_OP_BMAP:            func(ptr unsafe.Pointer) interface{} { return (*BmapIn)(ptr) },
+		_OP_IOCTL:           func(ptr unsafe.Pointer) interface{} { return (*IoctlIn)(ptr) },
+		_OP_OPEN:            func(ptr unsafe.Pointer) interface{} { return (*OpenIn)(ptr) },
+		_OP_MKNOD:           func(ptr unsafe.Pointer) interface{} { return (*MknodIn)(ptr) },
+		_OP_CREATE:          func(ptr unsafe.Pointer) interface{} { return (*CreateIn)(ptr) },
+		_OP_READ:            func(ptr unsafe.Pointer) interface{} { return (*ReadIn)(ptr) },
+		_OP_READDIR:         func(ptr unsafe.Pointer) interface{} { return (*ReadIn)(ptr) },
+		_OP_ACCESS:          func(ptr unsafe.Pointer) interface{} { return (*AccessIn)(ptr) },
+		_OP_FORGET:          func(ptr unsafe.Pointer) interface{} { return (*ForgetIn)(ptr) },
+		_OP_BATCH_FORGET:    func(ptr unsafe.Pointer) interface{} { return (*_BatchForgetIn)(ptr) },
+		_OP_LINK:            func(ptr unsafe.Pointer) interface{} { return (*LinkIn)(ptr) },
+		_OP_MKDIR:           func(ptr unsafe.Pointer) interface{} { return (*MkdirIn)(ptr) },
+		_OP_RELEASE:         func(ptr unsafe.Pointer) interface{} { return (*ReleaseIn)(ptr) },
+		_OP_RELEASEDIR:      func(ptr unsafe.Pointer) interface{} { return (*ReleaseIn)(ptr) },
+		_OP_FALLOCATE:       func(ptr unsafe.Pointer) interface{} { return (*FallocateIn)(ptr) },
+		_OP_READDIRPLUS:     func(ptr unsafe.Pointer) interface{} { return (*ReadIn)(ptr) },
+		_OP_RENAME:          func(ptr unsafe.Pointer) interface{} { return (*Rename1In)(ptr) },
+		_OP_FUSE_RENAME2:    func(ptr unsafe.Pointer) interface{} { return (*RenameIn)(ptr) },
+		_OP_GETLK:           func(ptr unsafe.Pointer) interface{} { return (*LkIn)(ptr) },
+		_OP_SETLK:           func(ptr unsafe.Pointer) interface{} { return (*LkIn)(ptr) },
+		_OP_SETLKW:          func(ptr unsafe.Pointer) interface{} { return (*LkIn)(ptr) },
+		_OP_LSEEK:           func(ptr unsafe.Pointer) interface{} { return (*LseekIn)(ptr) },
+		_OP_POLL:            func(ptr unsafe.Pointer) interface{} { return (*PollIn)(ptr) },
+		_OP_NOTIFY_REPLY:    func(ptr unsafe.Pointer) interface{} { return (*NotifyRetrieveIn)(ptr) },
+		_OP_COPY_FILE_RANGE: func(ptr unsafe.Pointer) interface{} { return (*CopyFileRangeIn)(ptr) },
+		_OP_TMPFILE:         func(ptr unsafe.Pointer) interface{} { return (*CreateIn)(ptr) },
 	} {
 		operationHandlers[op].DecodeIn = f
 	}
 
 	// File name args.
 	for op, count := range map[int32]int{
-		_OP_CREATE:      1,
-		_OP_SETXATTR:    1,
-		_OP_GETXATTR:    1,
-		_OP_LINK:        1,
-		_OP_LOOKUP:      1,
-		_OP_MKDIR:       1,
-		_OP_MKNOD:       1,
-		_OP_REMOVEXATTR: 1,
-		_OP_RENAME:      2,
-		_OP_RMDIR:       1,
-		_OP_SYMLINK:     2,
-		_OP_UNLINK:      1,
+		_OP_CREATE:       1,
+		_OP_TMPFILE:      1,
+		_OP_SETXATTR:     1,
+		_OP_GETXATTR:     1,
+		_OP_LINK:         1,
+		_OP_LOOKUP:       1,
+		_OP_MKDIR:        1,
+		_OP_MKNOD:        1,
+		_OP_REMOVEXATTR:  1,
+		_OP_RENAME:       2,
+		_OP_FUSE_RENAME2: 2,
+		_OP_RMDIR:        1,
+		_OP_SYMLINK:      2,
+		_OP_UNLINK:       1,
 	} {
 		operationHandlers[op].FileNames = count
 	}
@@ -0,0 +1,193 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func testInit(opts *MountOptions, in *InitIn) (*Server, *request) {
+	ms := &Server{opts: opts}
+	req := &request{
+		inHeader: &InHeader{Opcode: _OP_INIT},
+		inData:   unsafe.Pointer(in),
+		handler:  operationHandlers[_OP_INIT],
+	}
+	doInit(ms, req)
+	return ms, req
+}
+
+func TestInitOldMinor(t *testing.T) {
+	ms, req := testInit(&MountOptions{
+		MaxWrite:       MAX_KERNEL_WRITE,
+		MaxBackground:  12,
+		EnableLocks:    true,
+		WritebackCache: true,
+	}, &InitIn{
+		Major: _FUSE_KERNEL_VERSION,
+		Minor: _MINIMUM_MINOR_VERSION,
+		// A kernel would not offer flags it does not know.
+		Flags: ^uint32(0),
+	})
+	if req.status != OK {
+		t.Fatalf("INIT: %v", req.status)
+	}
+
+	out := (*InitOut)(req.outData())
+	if out.Minor != _MINIMUM_MINOR_VERSION {
+		t.Errorf("got minor %d, want %d", out.Minor, _MINIMUM_MINOR_VERSION)
+	}
+	if extra := out.Flags &^ capsForMinor(_MINIMUM_MINOR_VERSION); extra != 0 {
+		t.Errorf("reply has flags %s newer than 7.%d", FlagString(initFlagNames, int64(extra), ""), _MINIMUM_MINOR_VERSION)
+	}
+	if out.Flags&CAP_POSIX_LOCKS == 0 || out.Flags&CAP_ASYNC_READ == 0 {
+		t.Errorf("reply flags %s, want POSIX_LOCKS and ASYNC_READ", FlagString(initFlagNames, int64(out.Flags), ""))
+	}
+	if req.handler.OutputSize != 24 {
+		t.Errorf("got reply size %d, want 24", req.handler.OutputSize)
+	}
+	if major, minor := ms.ProtocolVersion(); major != _FUSE_KERNEL_VERSION || minor != _MINIMUM_MINOR_VERSION {
+		t.Errorf("ProtocolVersion: got %d.%d", major, minor)
+	}
+}
+
+func TestInitNewerMinor(t *testing.T) {
+	ms, req := testInit(&MountOptions{MaxWrite: MAX_KERNEL_WRITE}, &InitIn{
+		Major: _FUSE_KERNEL_VERSION,
+		Minor: _OUR_MINOR_VERSION + 10,
+		Flags: CAP_READDIRPLUS,
+	})
+	out := (*InitOut)(req.outData())
+	if req.status != OK || out.Minor != _OUR_MINOR_VERSION {
+		t.Errorf("got %v minor %d, want our minor %d", req.status, out.Minor, _OUR_MINOR_VERSION)
+	}
+	if _, minor := ms.ProtocolVersion(); minor != _OUR_MINOR_VERSION {
+		t.Errorf("ProtocolVersion: got minor %d, want %d", minor, _OUR_MINOR_VERSION)
+	}
+	if got := ms.InitOut(); *got != *out {
+		t.Errorf("InitOut: got %+v, want %+v", *got, *out)
+	}
+}
+
+func TestInitNewerMajor(t *testing.T) {
+	_, req := testInit(&MountOptions{}, &InitIn{
+		Major: _FUSE_KERNEL_VERSION + 1,
+	})
+	out := (*InitOut)(req.outData())
+	if req.status != OK || out.Major != _FUSE_KERNEL_VERSION {
+		t.Errorf("got %v major %d, want OK with major %d", req.status, out.Major, _FUSE_KERNEL_VERSION)
+	}
+}
+
+func TestInitBackground(t *testing.T) {
+	in := &InitIn{
+		Major: _FUSE_KERNEL_VERSION,
+		Minor: _OUR_MINOR_VERSION,
+		Flags: CAP_ASYNC_READ,
+	}
+	ms, req := testInit(&MountOptions{}, in)
+	out := (*InitOut)(req.outData())
+	if out.Flags&CAP_ASYNC_READ == 0 {
+		t.Errorf("reply flags %s, want ASYNC_READ", FlagString(initFlagNames, int64(out.Flags), ""))
+	}
+	if out.MaxBackground != _DEFAULT_BACKGROUND_TASKS || out.CongestionThreshold != _DEFAULT_BACKGROUND_TASKS*3/4 {
+		t.Errorf("got max_background %d congestion_threshold %d, want defaults", out.MaxBackground, out.CongestionThreshold)
+	}
+	if got := ms.MaxBackground(); got != _DEFAULT_BACKGROUND_TASKS {
+		t.Errorf("MaxBackground: got %d, want %d", got, _DEFAULT_BACKGROUND_TASKS)
+	}
+
+	ms, req = testInit(&MountOptions{
+		MaxBackground:       64,
+		CongestionThreshold: 10,
+		SyncRead:            true,
+	}, in)
+	out = (*InitOut)(req.outData())
+	if out.Flags&CAP_ASYNC_READ != 0 {
+		t.Errorf("reply flags %s, want no ASYNC_READ", FlagString(initFlagNames, int64(out.Flags), ""))
+	}
+	if out.MaxBackground != 64 || out.CongestionThreshold != 10 {
+		t.Errorf("got max_background %d congestion_threshold %d, want 64 10", out.MaxBackground, out.CongestionThreshold)
+	}
+	if got := ms.MaxBackground(); got != 64 {
+		t.Errorf("MaxBackground: got %d, want 64", got)
+	}
+}
+
+type bmapFS struct {
+	RawFileSystem
+}
+
+func (fs *bmapFS) Bmap(cancel <-chan struct{}, input *BmapIn, out *BmapOut) Status {
+	out.Block = input.Block*uint64(input.Blocksize)/512 + 100
+	return OK
+}
+
+func TestBmap(t *testing.T) {
+	ms := &Server{
+		opts:       &MountOptions{},
+		fileSystem: &bmapFS{NewDefaultRawFileSystem()},
+	}
+	in := &BmapIn{Block: 3, Blocksize: 4096}
+	req := &request{
+		inHeader: &in.InHeader,
+		inData:   unsafe.Pointer(in),
+		handler:  operationHandlers[_OP_BMAP],
+	}
+	req.handler.Func(ms, req)
+	if out := (*BmapOut)(req.outData()); req.status != OK || out.Block != 124 {
+		t.Errorf("BMAP: got %v, block %d, want block 124", req.status, out.Block)
+	}
+}
+
+type renameFS struct {
+	RawFileSystem
+	in      RenameIn
+	oldName string
+	newName string
+}
+
+func (fs *renameFS) Rename(cancel <-chan struct{}, input *RenameIn, oldName string, newName string) Status {
+	fs.in = *input
+	fs.oldName, fs.newName = oldName, newName
+	return OK
+}
+
+// renameRequest parses a request for op with the given input struct
+// and names a and b.
+func renameRequest(t *testing.T, op int32, in unsafe.Pointer, size uintptr) *request {
+	buf := make([]byte, size)
+	copy(buf, (*[unsafe.Sizeof(RenameIn{})]byte)(in)[:size])
+	buf = append(buf, "a\x00b\x00"...)
+	hdr := (*InHeader)(unsafe.Pointer(&buf[0]))
+	hdr.Opcode = op
+	hdr.Length = uint32(len(buf))
+	req := &request{inputBuf: buf}
+	req.parse(t.Logf, _OUR_MINOR_VERSION)
+	if req.status != OK {
+		t.Fatalf("parse %s: %v", operationName(op), req.status)
+	}
+	return req
+}
+
+func TestRename2(t *testing.T) {
+	fs := &renameFS{RawFileSystem: NewDefaultRawFileSystem()}
+	ms := &Server{opts: &MountOptions{}, fileSystem: fs}
+
+	in1 := Rename1In{Newdir: 7}
+	req := renameRequest(t, _OP_RENAME, unsafe.Pointer(&in1), unsafe.Sizeof(in1))
+	req.handler.Func(ms, req)
+	if fs.in.Newdir != 7 || fs.in.Flags != 0 || fs.oldName != "a" || fs.newName != "b" {
+		t.Errorf("RENAME: got %+v %q %q", fs.in, fs.oldName, fs.newName)
+	}
+
+	in2 := RenameIn{Newdir: 8, Flags: RENAME_EXCHANGE}
+	req = renameRequest(t, _OP_FUSE_RENAME2, unsafe.Pointer(&in2), unsafe.Sizeof(in2))
+	req.handler.Func(ms, req)
+	if fs.in.Newdir != 8 || fs.in.Flags != RENAME_EXCHANGE || fs.oldName != "a" || fs.newName != "b" {
+		t.Errorf("RENAME2: got %+v %q %q", fs.in, fs.oldName, fs.newName)
+	}
+}
@@ -61,8 +61,12 @@ type FileSystem interface {
 	OnUnmount()
 
 	// File handling.  If opening for writing, the file's mtime
-	// should be updated too.
+	// should be updated too. Open only sees O_TRUNC if
+	// fuse.MountOptions.AtomicTruncate is set.
 	Open(name string, flags uint32, context *fuse.Context) (file nodefs.File, code fuse.Status)
+
+	// Create should fail with EEXIST if flags has O_EXCL and
+	// name exists, as open(2) does.
 	Create(name string, flags uint32, mode uint32, context *fuse.Context) (file nodefs.File, code fuse.Status)
 
 	// Directory handling
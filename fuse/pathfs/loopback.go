@@ -145,7 +145,7 @@ func (fs *loopbackFileSystem) Readlink(name string, context *fuse.Context) (out
 }
 
 func (fs *loopbackFileSystem) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) (code fuse.Status) {
-	return fuse.ToStatus(syscall.Mknod(fs.GetPath(name), mode, int(dev)))
+	return fuse.ToStatus(mknod(fs.GetPath(name), mode, dev))
 }
 
 func (fs *loopbackFileSystem) Mkdir(path string, mode uint32, context *fuse.Context) (code fuse.Status) {
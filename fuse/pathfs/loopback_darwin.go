@@ -27,3 +27,7 @@ func (fs *loopbackFileSystem) Utimens(path string, a *time.Time, m *time.Time, c
 	err := syscall.Utimes(fs.GetPath(path), tv)
 	return fuse.ToStatus(err)
 }
+
+func mknod(path string, mode uint32, dev uint32) error {
+	return syscall.Mknod(path, mode, int(dev))
+}
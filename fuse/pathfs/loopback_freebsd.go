@@ -0,0 +1,33 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func (fs *loopbackFileSystem) String() string {
+	return fmt.Sprintf("LoopbackFs(%s)", fs.Root)
+}
+
+// Utimens - path based version of loopbackFile.Utimens()
+func (fs *loopbackFileSystem) Utimens(path string, a *time.Time, m *time.Time, context *fuse.Context) (code fuse.Status) {
+	ts := []unix.Timespec{
+		unix.Timespec(fuse.UtimeToTimespec(a)),
+		unix.Timespec(fuse.UtimeToTimespec(m)),
+	}
+	err := unix.UtimesNanoAt(unix.AT_FDCWD, fs.GetPath(path), ts, unix.AT_SYMLINK_NOFOLLOW)
+	return fuse.ToStatus(err)
+}
+
+func mknod(path string, mode uint32, dev uint32) error {
+	return syscall.Mknod(path, mode, uint64(dev))
+}
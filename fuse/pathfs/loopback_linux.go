@@ -47,3 +47,7 @@ func (fs *loopbackFileSystem) Utimens(path string, a *time.Time, m *time.Time, c
 	err := sysUtimensat(0, fs.GetPath(path), &ts, _AT_SYMLINK_NOFOLLOW)
 	return fuse.ToStatus(err)
 }
+
+func mknod(path string, mode uint32, dev uint32) error {
+	return syscall.Mknod(path, mode, int(dev))
+}
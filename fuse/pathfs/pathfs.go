@@ -36,7 +36,8 @@ type PathNodeFs struct {
 	root      *pathInode
 	connector *nodefs.FileSystemConnector
 
-	// protects clientInodeMap
+	// protects clientInodeMap, and the clientInode fields of
+	// the pathInodes
 	pathLock sync.RWMutex
 
 	// This map lists all the parent links known for a given inode number.
@@ -52,17 +53,52 @@ func (fs *PathNodeFs) SetDebug(dbg bool) {
 }
 
 // Mount mounts a another node filesystem with the given root on the
-// path. The last component of the path should not exist yet.
+// path. The last component of the path should not exist yet. Use
+// MountErr to find out which component of the path was at fault.
 func (fs *PathNodeFs) Mount(path string, root nodefs.Node, opts *nodefs.Options) fuse.Status {
+	if err := fs.MountErr(path, root, opts); err != nil {
+		return err.(*MountError).Code
+	}
+	return fuse.OK
+}
+
+// MountError describes a failed MountErr or UnmountRecursive.
+type MountError struct {
+	// Path is the path passed to MountErr or UnmountRecursive.
+	Path string
+
+	// Component is the path, relative to the root, that does not
+	// exist (for ENOENT), or that already exists (for EBUSY). For
+	// UnmountRecursive, it is the mount that could not be
+	// unmounted.
+	Component string
+
+	// Code is the status that Mount returns.
+	Code fuse.Status
+}
+
+func (e *MountError) Error() string {
+	return fmt.Sprintf("mount %q: %q: %v", e.Path, e.Component, e.Code)
+}
+
+// MountErr is like Mount, but returns a *MountError on failure.
+func (fs *PathNodeFs) MountErr(path string, root nodefs.Node, opts *nodefs.Options) error {
 	dir, name := filepath.Split(path)
+	parent := fs.Root().Inode()
 	if dir != "" {
 		dir = filepath.Clean(dir)
+		var walked string
+		for _, c := range strings.Split(dir, "/") {
+			walked = filepath.Join(walked, c)
+			if parent = fs.connector.LookupNode(parent, c); parent == nil {
+				return &MountError{Path: path, Component: walked, Code: fuse.ENOENT}
+			}
+		}
 	}
-	parent := fs.LookupNode(dir)
-	if parent == nil {
-		return fuse.ENOENT
+	if code := fs.connector.Mount(parent, name, root, opts); !code.Ok() {
+		return &MountError{Path: path, Component: filepath.Join(dir, name), Code: code}
 	}
-	return fs.connector.Mount(parent, name, root, opts)
+	return nil
 }
 
 // ForgetClientInodes forgets all known information on client inodes.
@@ -70,9 +106,14 @@ func (fs *PathNodeFs) ForgetClientInodes() {
 	if !fs.options.ClientInodes {
 		return
 	}
+	// Walk the tree before taking pathLock: OnRemove takes
+	// pathLock while the tree is locked.
+	nodes := fs.root.allNodes(nil)
 	fs.pathLock.Lock()
 	fs.clientInodeMap = map[uint64]*refCountedInode{}
-	fs.root.forgetClientInodes()
+	for _, n := range nodes {
+		n.clientInode = 0
+	}
 	fs.pathLock.Unlock()
 }
 
@@ -99,6 +140,40 @@ func (fs *PathNodeFs) Unmount(path string) fuse.Status {
 	return fs.connector.Unmount(node)
 }
 
+// UnmountRecursive unmounts the file system mounted on path, after
+// unmounting all file systems mounted below it, deepest first. If
+// one of them cannot be unmounted, eg. because it has open files, it
+// stops and returns a *MountError for that mount; the mounts below
+// it stay unmounted.
+func (fs *PathNodeFs) UnmountRecursive(path string) error {
+	node := fs.Node(path)
+	if node == nil {
+		return &MountError{Path: path, Component: path, Code: fuse.ENOENT}
+	}
+	mounts := fs.connector.ListMounts()
+	top := ""
+	for _, m := range mounts {
+		if m.Root == node {
+			top = m.Path
+		}
+	}
+	if top == "" {
+		return &MountError{Path: path, Component: path, Code: fuse.EINVAL}
+	}
+
+	// ListMounts sorts parents before their children.
+	for i := len(mounts) - 1; i >= 0; i-- {
+		m := mounts[i]
+		if m.Path != top && !strings.HasPrefix(m.Path, top+"/") {
+			continue
+		}
+		if code := fs.connector.Unmount(m.Root); !code.Ok() {
+			return &MountError{Path: path, Component: path + strings.TrimPrefix(m.Path, top), Code: code}
+		}
+	}
+	return nil
+}
+
 // String returns a name for this file system
 func (fs *PathNodeFs) String() string {
 	name := fs.fs.String()
@@ -166,6 +241,43 @@ func (fs *PathNodeFs) EntryNotify(dir string, name string) fuse.Status {
 	return fs.connector.EntryNotify(node, name)
 }
 
+// BatchEntryNotify is like EntryNotify for each of names in dir, but
+// resolves dir only once. Use it to invalidate many entries at once,
+// eg. after a bulk change in the backing store.
+func (fs *PathNodeFs) BatchEntryNotify(dir string, names []string) fuse.Status {
+	node, rest := fs.connector.Node(fs.root.Inode(), dir)
+	if len(rest) > 0 {
+		return fuse.ENOENT
+	}
+	return fs.connector.BatchEntryNotify(node, names)
+}
+
+// ExpiryNotify marks the entry for name in dir as expired, so the
+// kernel looks it up again on the next access. Unlike EntryNotify,
+// the entry is not dropped until then.
+func (fs *PathNodeFs) ExpiryNotify(dir string, name string) fuse.Status {
+	node, rest := fs.connector.Node(fs.root.Inode(), dir)
+	if len(rest) > 0 {
+		return fuse.ENOENT
+	}
+	return fs.connector.ExpiryNotify(node, name)
+}
+
+// DeleteNotify tells the kernel that the name in dir was removed
+// behind its back, and removes the corresponding Inode. If the name
+// is not known, this is the same as EntryNotify.
+func (fs *PathNodeFs) DeleteNotify(dir string, name string) fuse.Status {
+	node, rest := fs.connector.Node(fs.root.Inode(), dir)
+	if len(rest) > 0 {
+		return fuse.ENOENT
+	}
+	child := node.GetChild(name)
+	if child == nil {
+		return fs.connector.EntryNotify(node, name)
+	}
+	return fs.connector.DeleteNotify(node, child, name)
+}
+
 // Notify ensures that the path name is invalidates: if the inode is
 // known, it issues an file content Notify, if not, an entry notify
 // for the path is issued. The latter will clear out non-existence
@@ -221,7 +333,8 @@ type pathInode struct {
 	fs     FileSystem
 
 	// This is to correctly resolve hardlinks of the underlying
-	// real filesystem.
+	// real filesystem. Protected by pathFs.pathLock, as OnRemove
+	// may run concurrently for different directories.
 	clientInode uint64
 	inode       *nodefs.Inode
 }
@@ -234,12 +347,13 @@ func (n *pathInode) OnMount(conn *nodefs.FileSystemConnector) {
 func (n *pathInode) OnUnmount() {
 }
 
-// Drop all known client inodes. Must have the treeLock.
-func (n *pathInode) forgetClientInodes() {
-	n.clientInode = 0
+// allNodes appends n and the pathInodes below it to nodes.
+func (n *pathInode) allNodes(nodes []*pathInode) []*pathInode {
+	nodes = append(nodes, n)
 	for _, ch := range n.Inode().FsChildren() {
-		ch.Node().(*pathInode).forgetClientInodes()
+		nodes = ch.Node().(*pathInode).allNodes(nodes)
 	}
+	return nodes
 }
 
 func (fs *pathInode) Deletable() bool {
@@ -332,11 +446,15 @@ func (n *pathInode) rmChild(name string) *pathInode {
 }
 
 func (n *pathInode) OnRemove(parent *nodefs.Inode, name string) {
-	if n.clientInode == 0 || !n.pathFs.options.ClientInodes || n.Inode().IsDir() {
+	if !n.pathFs.options.ClientInodes || n.Inode().IsDir() {
 		return
 	}
 
 	n.pathFs.pathLock.Lock()
+	defer n.pathFs.pathLock.Unlock()
+	if n.clientInode == 0 {
+		return
+	}
 	r := n.pathFs.clientInodeMap[n.clientInode]
 	if r != nil {
 		r.refCount--
@@ -344,28 +462,40 @@ func (n *pathInode) OnRemove(parent *nodefs.Inode, name string) {
 			delete(n.pathFs.clientInodeMap, n.clientInode)
 		}
 	}
-	n.pathFs.pathLock.Unlock()
 }
 
 // setClientInode sets the inode number if has not been set yet.
 // This function exists to allow lazy-loading of the inode number.
 func (n *pathInode) setClientInode(ino uint64) {
-	if ino == 0 || n.clientInode != 0 || !n.pathFs.options.ClientInodes || n.Inode().IsDir() {
+	if ino == 0 || !n.pathFs.options.ClientInodes || n.Inode().IsDir() {
 		return
 	}
 	n.pathFs.pathLock.Lock()
 	defer n.pathFs.pathLock.Unlock()
+	if n.clientInode != 0 {
+		return
+	}
 	n.clientInode = ino
 	n.pathFs.clientInodeMap[ino] = &refCountedInode{node: n, refCount: 1}
 }
 
+// getClientInode returns the inode number, or 0 if it is not known.
+func (n *pathInode) getClientInode() uint64 {
+	n.pathFs.pathLock.RLock()
+	defer n.pathFs.pathLock.RUnlock()
+	return n.clientInode
+}
+
 func (n *pathInode) OnForget() {
-	if n.clientInode == 0 || !n.pathFs.options.ClientInodes || n.Inode().IsDir() {
+	if !n.pathFs.options.ClientInodes || n.Inode().IsDir() {
 		return
 	}
 	n.pathFs.pathLock.Lock()
+	defer n.pathFs.pathLock.Unlock()
+	if n.clientInode == 0 {
+		return
+	}
 	delete(n.pathFs.clientInodeMap, n.clientInode)
-	n.pathFs.pathLock.Unlock()
 }
 
 ////////////////////////////////////////////////////////////////
@@ -375,6 +505,10 @@ func (n *pathInode) StatFs() *fuse.StatfsOut {
 	return n.fs.StatFs(n.GetPath())
 }
 
+func (n *pathInode) Bmap(block uint64, blocksize uint32, context *fuse.Context) (uint64, fuse.Status) {
+	return 0, fuse.ENOSYS
+}
+
 func (n *pathInode) Readlink(c *fuse.Context) ([]byte, fuse.Status) {
 	path := n.GetPath()
 
@@ -412,6 +546,12 @@ func (n *pathInode) OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status
 	return n.fs.OpenDir(n.GetPath(), context)
 }
 
+func (n *pathInode) FsyncDir(flags uint32, context *fuse.Context) (code fuse.Status) {
+	// FileSystem has no directory sync, so there is nothing to
+	// do.
+	return fuse.OK
+}
+
 func (n *pathInode) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
 	fullPath := filepath.Join(n.GetPath(), name)
 	code := n.fs.Mknod(fullPath, mode, dev, context)
@@ -495,13 +635,15 @@ func (n *pathInode) Link(name string, existingFsnode nodefs.Node, context *fuse.
 
 	var child *nodefs.Inode
 	if code.Ok() {
-		if existing.clientInode != 0 && existing.clientInode == a.Ino {
+		if ino := existing.getClientInode(); ino != 0 && ino == a.Ino {
 			child = existing.Inode()
 			n.Inode().AddChild(name, existing.Inode())
 		} else {
 			pNode := n.createChild(name, false)
 			child = pNode.Inode()
+			n.pathFs.pathLock.Lock()
 			pNode.clientInode = a.Ino
+			n.pathFs.pathLock.Unlock()
 		}
 	}
 	return child, code
@@ -739,6 +881,27 @@ func (n *pathInode) Fallocate(file nodefs.File, off uint64, size uint64, mode ui
 	return code
 }
 
+func (n *pathInode) GetLk(file nodefs.File, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.GetLk(owner, lk, flags, out)
+	}
+	return fuse.ENOSYS
+}
+
+func (n *pathInode) SetLk(file nodefs.File, owner uint64, lk *fuse.FileLock, flags uint32, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.SetLk(owner, lk, flags)
+	}
+	return fuse.ENOSYS
+}
+
+func (n *pathInode) SetLkw(file nodefs.File, owner uint64, lk *fuse.FileLock, flags uint32, context *fuse.Context) (code fuse.Status) {
+	if file != nil {
+		return file.SetLkw(owner, lk, flags)
+	}
+	return fuse.ENOSYS
+}
+
 func (n *pathInode) Read(file nodefs.File, dest []byte, off int64, context *fuse.Context) (fuse.ReadResult, fuse.Status) {
 	if file != nil {
 		return file.Read(dest, off)
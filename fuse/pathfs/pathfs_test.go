@@ -0,0 +1,120 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pathfs
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fusetest"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestMountErr(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(dir+"/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewPathNodeFs(NewLoopbackFileSystem(dir), nil)
+	nodefs.NewFileSystemConnector(fs.Root(), nil).RawFS().Init(nil)
+
+	if err := fs.MountErr("a/b/mnt", nodefs.NewDefaultNode(), nil); err != nil {
+		t.Fatalf("MountErr: %v", err)
+	}
+
+	for _, tc := range []struct {
+		path      string
+		component string
+		code      fuse.Status
+	}{
+		{"a/x/y/mnt", "a/x", fuse.ENOENT},
+		{"a/b/mnt", "a/b/mnt", fuse.EBUSY},
+	} {
+		err := fs.MountErr(tc.path, nodefs.NewDefaultNode(), nil)
+		merr, ok := err.(*MountError)
+		if !ok {
+			t.Errorf("MountErr(%q): got %v, want *MountError", tc.path, err)
+			continue
+		}
+		if merr.Path != tc.path || merr.Component != tc.component || merr.Code != tc.code {
+			t.Errorf("MountErr(%q): got %+v, want component %q, code %v", tc.path, merr, tc.component, tc.code)
+		}
+		if code := fs.Mount(tc.path, nodefs.NewDefaultNode(), nil); code != tc.code {
+			t.Errorf("Mount(%q): got %v, want %v", tc.path, code, tc.code)
+		}
+	}
+}
+
+func TestUnmountRecursive(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	fs := NewPathNodeFs(NewLoopbackFileSystem(dir), nil)
+	conn := nodefs.NewFileSystemConnector(fs.Root(), nil)
+	k, err := fusetest.NewKernel(conn.RawFS(), nil)
+	if err != nil {
+		t.Fatalf("NewKernel: %v", err)
+	}
+	defer k.Close()
+	// Like the kernel, forget the mount points when told that
+	// they are gone. Each was looked up once.
+	go func() {
+		for msg := range k.Messages() {
+			if fusetest.Status(msg) != fuse.NOTIFY_INVAL_DELETE {
+				continue
+			}
+			if del := (*fuse.NotifyInvalDeleteOut)(unsafe.Pointer(&msg[unsafe.Sizeof(fuse.OutHeader{})])); del.Child != 0 {
+				k.Forget(del.Child, 1)
+			}
+		}
+	}()
+
+	for _, p := range []string{"m", "m/x", "m/y"} {
+		root := nodefs.NewMemNodeFSRoot(dir + "/" + strings.Replace(p, "/", "-", -1))
+		if code := fs.Mount(p, root, nil); !code.Ok() {
+			t.Fatalf("Mount(%q): %v", p, code)
+		}
+	}
+
+	// Keep a file open in m/x.
+	var node uint64 = fuse.FUSE_ROOT_ID
+	for _, name := range []string{"m", "x"} {
+		out, code, err := k.Lookup(node, name)
+		if err != nil || !code.Ok() {
+			t.Fatalf("Lookup(%q): %v, %v", name, code, err)
+		}
+		node = out.NodeId
+	}
+	out, code, err := k.Create(node, "file", uint32(os.O_RDWR|os.O_CREATE), 0644)
+	if err != nil || !code.Ok() {
+		t.Fatalf("Create: %v, %v", code, err)
+	}
+
+	err = fs.UnmountRecursive("m")
+	if merr, ok := err.(*MountError); !ok || merr.Component != "m/x" || merr.Code != fuse.EBUSY {
+		t.Fatalf("UnmountRecursive with open file: got %v, want EBUSY for m/x", err)
+	}
+	// m/y sorts after m/x, so it went first.
+	if got := len(conn.ListMounts()); got != 2 {
+		t.Errorf("got %d mounts, want 2", got)
+	}
+
+	if code, err := k.Release(out.NodeId, out.Fh); err != nil || !code.Ok() {
+		t.Fatalf("Release: %v, %v", code, err)
+	}
+	if err := fs.UnmountRecursive("m"); err != nil {
+		t.Fatalf("UnmountRecursive: %v", err)
+	}
+	if mounts := conn.ListMounts(); len(mounts) != 0 {
+		t.Errorf("got mounts %v after UnmountRecursive", mounts)
+	}
+	if err := fs.UnmountRecursive("m"); err == nil {
+		t.Error("UnmountRecursive of a missing mount succeeded")
+	}
+}
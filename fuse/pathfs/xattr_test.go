@@ -200,3 +200,40 @@ func TestXAttrRead(t *testing.T) {
 		t.Error("Data not removed?", err, val)
 	}
 }
+
+func TestXAttrSize(t *testing.T) {
+	nm := xattrFilename
+	mountPoint, clean := xattrTestCase(t, nm, xattrGolden)
+	defer clean()
+
+	mounted := filepath.Join(mountPoint, nm)
+	sz, err := sysGetxattr(mounted, "user.attr1", nil)
+	if err != nil {
+		t.Fatalf("Getxattr size probe: %v", err)
+	}
+	if want := len(xattrGolden["user.attr1"]); sz != want {
+		t.Errorf("got size %d, want %d", sz, want)
+	}
+
+	_, err = sysGetxattr(mounted, "user.attr1", make([]byte, 1))
+	if fuse.ToStatus(err) != fuse.ERANGE {
+		t.Errorf("Getxattr with short buffer: got %v, want ERANGE", err)
+	}
+
+	sz, err = sysListxattr(mounted, nil)
+	if err != nil {
+		t.Fatalf("Listxattr size probe: %v", err)
+	}
+	want := 0
+	for k := range xattrGolden {
+		want += len(k) + 1
+	}
+	if sz != want {
+		t.Errorf("got list size %d, want %d", sz, want)
+	}
+
+	_, err = sysListxattr(mounted, make([]byte, 1))
+	if fuse.ToStatus(err) != fuse.ERANGE {
+		t.Errorf("Listxattr with short buffer: got %v, want ERANGE", err)
+	}
+}
@@ -8,26 +8,45 @@ package fuse
 const pollHackName = ".go-fuse-epoll-hack"
 const pollHackInode = ^uint64(0)
 
+var pollHackAttr = Attr{
+	Ino:   pollHackInode,
+	Mode:  S_IFREG | 0644,
+	Nlink: 1,
+}
+
 func doPollHackLookup(ms *Server, req *request) {
 	switch req.inHeader.Opcode {
 	case _OP_CREATE:
 		out := (*CreateOut)(req.outData())
 		out.EntryOut = EntryOut{
 			NodeId: pollHackInode,
-			Attr: Attr{
-				Ino:   pollHackInode,
-				Mode:  S_IFREG | 0644,
-				Nlink: 1,
-			},
+			Attr:   pollHackAttr,
 		}
 		out.OpenOut = OpenOut{
 			Fh: pollHackInode,
 		}
 		req.status = OK
+	case _OP_GETATTR, _OP_SETATTR:
+		out := (*AttrOut)(req.outData())
+		*out = AttrOut{Attr: pollHackAttr}
+		req.status = OK
 	case _OP_LOOKUP:
+		// Pretend the file exists, so it can be opened on
+		// read-only mounts too.
 		out := (*EntryOut)(req.outData())
-		*out = EntryOut{}
-		req.status = ENOENT
+		*out = EntryOut{
+			NodeId: pollHackInode,
+			Attr:   pollHackAttr,
+		}
+		req.status = OK
+	case _OP_OPEN:
+		out := (*OpenOut)(req.outData())
+		*out = OpenOut{
+			Fh: pollHackInode,
+		}
+		req.status = OK
+	case _OP_RELEASE, _OP_FLUSH:
+		req.status = OK
 	default:
 		req.status = EIO
 	}
@@ -47,3 +47,10 @@ func pollHack(mountPoint string) error {
 	syscall.Close(fd)
 	return nil
 }
+
+// waitReadable blocks until fd has data to read.
+func waitReadable(fd int) error {
+	const POLLIN = 0x1
+	_, err := sysPoll([]pollFd{{Fd: int32(fd), Events: POLLIN}}, -1)
+	return err
+}
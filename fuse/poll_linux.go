@@ -8,7 +8,7 @@ import (
 )
 
 func pollHack(mountPoint string) error {
-	fd, err := syscall.Creat(filepath.Join(mountPoint, pollHackName), syscall.O_CREAT)
+	fd, err := syscall.Open(filepath.Join(mountPoint, pollHackName), syscall.O_RDONLY, 0)
 	if err != nil {
 		return err
 	}
@@ -23,3 +23,9 @@ func pollHack(mountPoint string) error {
 	syscall.Close(fd)
 	return nil
 }
+
+// waitReadable blocks until fd has data to read.
+func waitReadable(fd int) error {
+	_, err := unix.Poll([]unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}, -1)
+	return err
+}
@@ -18,6 +18,7 @@ var FuseOpenFlagNames map[int64]string
 var accessFlagName map[int64]string
 var writeFlagNames map[int64]string
 var readFlagNames map[int64]string
+var renameFlagNames map[int64]string
 
 func init() {
 	writeFlagNames = map[int64]string{
@@ -27,6 +28,11 @@ func init() {
 	readFlagNames = map[int64]string{
 		READ_LOCKOWNER: "LOCKOWNER",
 	}
+	renameFlagNames = map[int64]string{
+		RENAME_NOREPLACE: "NOREPLACE",
+		RENAME_EXCHANGE:  "EXCHANGE",
+		RENAME_WHITEOUT:  "WHITEOUT",
+	}
 	initFlagNames = map[int64]string{
 		CAP_ASYNC_READ:       "ASYNC_READ",
 		CAP_POSIX_LOCKS:      "POSIX_LOCKS",
@@ -46,9 +52,12 @@ func init() {
 		CAP_ASYNC_DIO:        "ASYNC_DIO",
 		CAP_WRITEBACK_CACHE:  "WRITEBACK_CACHE",
 		CAP_NO_OPEN_SUPPORT:  "NO_OPEN_SUPPORT",
-		CAP_PARALLEL_DIROPS:  "CAP_PARALLEL_DIROPS",
-		CAP_HANDLE_KILLPRIV:  "CAP_PARALLEL_DIROPS",
+		CAP_PARALLEL_DIROPS:  "PARALLEL_DIROPS",
+		CAP_HANDLE_KILLPRIV:  "HANDLE_KILLPRIV",
 		CAP_POSIX_ACL:        "CAP_POSIX_ACL",
+		CAP_ABORT_ERROR:      "ABORT_ERROR",
+		CAP_MAX_PAGES:        "MAX_PAGES",
+		CAP_CACHE_SYMLINKS:   "CACHE_SYMLINKS",
 	}
 	releaseFlagNames = map[int64]string{
 		RELEASE_FLUSH: "FLUSH",
@@ -72,6 +81,8 @@ func init() {
 		FOPEN_DIRECT_IO:   "DIRECT",
 		FOPEN_KEEP_CACHE:  "CACHE",
 		FOPEN_NONSEEKABLE: "NONSEEK",
+		FOPEN_CACHE_DIR:   "CACHE_DIR",
+		FOPEN_STREAM:      "STREAM",
 	}
 	accessFlagName = map[int64]string{
 		X_OK: "x",
@@ -112,6 +123,13 @@ func (me *MkdirIn) string() string {
 }
 
 func (me *RenameIn) string() string {
+	if me.Flags != 0 {
+		return fmt.Sprintf("{%d %s}", me.Newdir, FlagString(renameFlagNames, int64(me.Flags), ""))
+	}
+	return fmt.Sprintf("{%d}", me.Newdir)
+}
+
+func (me *Rename1In) string() string {
 	return fmt.Sprintf("{%d}", me.Newdir)
 }
 
@@ -124,21 +142,27 @@ func (me *SetAttrIn) string() string {
 		s = append(s, fmt.Sprintf("uid %d", me.Uid))
 	}
 	if me.Valid&FATTR_GID != 0 {
-		s = append(s, fmt.Sprintf("uid %d", me.Gid))
+		s = append(s, fmt.Sprintf("gid %d", me.Gid))
 	}
 	if me.Valid&FATTR_SIZE != 0 {
 		s = append(s, fmt.Sprintf("size %d", me.Size))
 	}
-	if me.Valid&FATTR_ATIME != 0 {
+	if me.Valid&FATTR_ATIME_NOW != 0 {
+		s = append(s, "atime now")
+	} else if me.Valid&FATTR_ATIME != 0 {
 		s = append(s, fmt.Sprintf("atime %d.%09d", me.Atime, me.Atimensec))
 	}
-	if me.Valid&FATTR_MTIME != 0 {
+	if me.Valid&FATTR_MTIME_NOW != 0 {
+		s = append(s, "mtime now")
+	} else if me.Valid&FATTR_MTIME != 0 {
 		s = append(s, fmt.Sprintf("mtime %d.%09d", me.Mtime, me.Mtimensec))
 	}
 	if me.Valid&FATTR_FH != 0 {
 		s = append(s, fmt.Sprintf("fh %d", me.Fh))
 	}
-	// TODO - FATTR_ATIME_NOW = (1 << 7), FATTR_MTIME_NOW = (1 << 8), FATTR_LOCKOWNER = (1 << 9)
+	if me.Valid&FATTR_LOCKOWNER != 0 {
+		s = append(s, fmt.Sprintf("lockowner %x", me.LockOwner))
+	}
 	return fmt.Sprintf("{%s}", strings.Join(s, ", "))
 }
 
@@ -165,11 +189,11 @@ func (me *InitIn) string() string {
 }
 
 func (me *InitOut) string() string {
-	return fmt.Sprintf("{%d.%d Ra 0x%x %s %d/%d Wr 0x%x Tg 0x%x}",
+	return fmt.Sprintf("{%d.%d Ra 0x%x %s %d/%d Wr 0x%x Tg 0x%x Pg %d}",
 		me.Major, me.Minor, me.MaxReadAhead,
 		FlagString(initFlagNames, int64(me.Flags), ""),
 		me.CongestionThreshold, me.MaxBackground, me.MaxWrite,
-		me.TimeGran)
+		me.TimeGran, me.MaxPages)
 }
 
 func (s *FsyncIn) string() string {
@@ -196,6 +220,10 @@ func (me *FlushIn) string() string {
 	return fmt.Sprintf("{Fh %d}", me.Fh)
 }
 
+func (me *InterruptIn) string() string {
+	return fmt.Sprintf("{ix %d}", me.Unique)
+}
+
 func (me *AttrOut) string() string {
 	return fmt.Sprintf(
 		"{A%d.%09d %v}",
@@ -221,6 +249,9 @@ func (me *StatfsOut) string() string {
 }
 
 func (o *NotifyInvalEntryOut) string() string {
+	if o.Flags&NOTIFY_EXPIRE_ONLY != 0 {
+		return fmt.Sprintf("{parent %d sz %d EXPIRE_ONLY}", o.Parent, o.NameLen)
+	}
 	return fmt.Sprintf("{parent %d sz %d}", o.Parent, o.NameLen)
 }
 
@@ -241,6 +272,73 @@ func (f *LinkIn) string() string {
 	return fmt.Sprintf("{Oldnodeid: %d}", f.Oldnodeid)
 }
 
+func (in *LseekIn) string() string {
+	return fmt.Sprintf("{Fh %d off %d whence %d}", in.Fh, in.Offset, in.Whence)
+}
+
+func (out *LseekOut) string() string {
+	return fmt.Sprintf("{off %d}", out.Offset)
+}
+
+func (in *BmapIn) string() string {
+	return fmt.Sprintf("{block %d blocksize %d}", in.Block, in.Blocksize)
+}
+
+func (out *BmapOut) string() string {
+	return fmt.Sprintf("{block %d}", out.Block)
+}
+
+func (in *IoctlIn) string() string {
+	return fmt.Sprintf("{Fh %d cmd 0x%x arg 0x%x flags 0x%x in %d out %d}",
+		in.Fh, in.Cmd, in.Arg, in.Flags, in.InSize, in.OutSize)
+}
+
+func (out *IoctlOut) string() string {
+	return fmt.Sprintf("{result %d flags 0x%x iovs %d/%d}", out.Result, out.Flags, out.InIovs, out.OutIovs)
+}
+
+func (in *PollIn) string() string {
+	return fmt.Sprintf("{Fh %d kh %d flags 0x%x events 0x%x}", in.Fh, in.Kh, in.Flags, in.Events)
+}
+
+func (out *PollOut) string() string {
+	return fmt.Sprintf("{revents 0x%x}", out.Revents)
+}
+
+func (me *NotifyStoreOut) string() string {
+	return fmt.Sprintf("{i%d off %d sz %d}", me.Nodeid, me.Offset, me.Size)
+}
+
+func (me *NotifyRetrieveOut) string() string {
+	return fmt.Sprintf("{> %d: i%d off %d sz %d}", me.NotifyUnique, me.Nodeid, me.Offset, me.Size)
+}
+
+func (me *NotifyRetrieveIn) string() string {
+	return fmt.Sprintf("{off %d sz %d}", me.Offset, me.Size)
+}
+
+func (me *_NotifyPollWakeupOut) string() string {
+	return fmt.Sprintf("{kh %d}", me.Kh)
+}
+
+func (in *CopyFileRangeIn) string() string {
+	return fmt.Sprintf("{Fh %d off %d => i%d Fh %d off %d sz %d flags 0x%x}",
+		in.FhIn, in.OffIn, in.NodeIdOut, in.FhOut, in.OffOut, in.Len, in.Flags)
+}
+
+func (lk *FileLock) string() string {
+	return fmt.Sprintf("{%d-%d typ %d pid %d}", lk.Start, lk.End, lk.Typ, lk.Pid)
+}
+
+func (in *LkIn) string() string {
+	return fmt.Sprintf("{Fh %d owner 0x%x lk %s flags 0x%x}",
+		in.Fh, in.Owner, in.Lk.string(), in.LkFlags)
+}
+
+func (out *LkOut) string() string {
+	return out.Lk.string()
+}
+
 // Print pretty prints FUSE data types for kernel communication
 func Print(obj interface{}) string {
 	t, ok := obj.(interface {
@@ -26,10 +26,15 @@ func (r *readResultData) Bytes(buf []byte) ([]byte, Status) {
 	return r.Data, OK
 }
 
+// ReadResultData returns b as the result of a read.
 func ReadResultData(b []byte) ReadResult {
 	return &readResultData{b}
 }
 
+// ReadResultFd returns sz bytes from fd, starting at off, as the result
+// of a read. Where the kernel allows it, the server splices the data
+// from fd into the FUSE device, so it is not copied through user
+// space. Otherwise, the data is read with pread(2).
 func ReadResultFd(fd uintptr, off int64, sz int) ReadResult {
 	return &readResultFd{fd, off, sz}
 }
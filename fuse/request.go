@@ -7,7 +7,6 @@ package fuse
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 	"unsafe"
@@ -31,6 +30,10 @@ type request struct {
 	flatData []byte
 	fdData   *readResultFd
 
+	// For a WRITE read with splice, the pipe that holds the
+	// data.
+	writePipe *splicePipe
+
 	// In case of read, keep read result here so we can call
 	// Done() on it.
 	readResult ReadResult
@@ -38,6 +41,14 @@ type request struct {
 	// Start timestamp for timing info.
 	startTime time.Time
 
+	// cancel is closed when the kernel interrupts this
+	// request. It is only replaced once it has been closed.
+	cancel      chan struct{}
+	interrupted bool
+
+	// Position in Server.reqInflight.
+	inflightIndex int
+
 	// All information pertaining to opcode of this request.
 	handler *operationHandler
 
@@ -67,9 +78,17 @@ func (r *request) clear() {
 	r.status = OK
 	r.flatData = nil
 	r.fdData = nil
+	if r.writePipe != nil {
+		r.writePipe.done()
+		r.writePipe = nil
+	}
 	r.startTime = time.Time{}
 	r.handler = nil
 	r.readResult = nil
+	if r.interrupted {
+		r.cancel = make(chan struct{})
+		r.interrupted = false
+	}
 }
 
 func (r *request) InputDebug() string {
@@ -135,10 +154,44 @@ func (r *request) setInput(input []byte) bool {
 	return true
 }
 
-func (r *request) parse() {
+// compatSize gives the input and output size of an operation for
+// kernels that speak a protocol minor version below minor. Zero sizes
+// are unchanged.
+type compatSize struct {
+	minor  uint32
+	opcode int32
+	input  uintptr
+	output uintptr
+}
+
+// compatHandler returns h, with the sizes of opcode shrunk to those
+// of protocol minor version minor.
+func compatHandler(h *operationHandler, opcode int32, minor uint32) *operationHandler {
+	tweaked := h
+	for _, c := range compatSizes {
+		if c.opcode != opcode || minor >= c.minor {
+			continue
+		}
+		if tweaked == h {
+			t := *h
+			tweaked = &t
+		}
+		if c.input != 0 && c.input < tweaked.InputSize {
+			tweaked.InputSize = c.input
+		}
+		if c.output != 0 && c.output < tweaked.OutputSize {
+			tweaked.OutputSize = c.output
+		}
+	}
+	return tweaked
+}
+
+// parse splits up the input of a request from a kernel that speaks
+// protocol minor version minor.
+func (r *request) parse(logf func(format string, args ...interface{}), minor uint32) {
 	inHSize := int(unsafe.Sizeof(InHeader{}))
 	if len(r.inputBuf) < inHSize {
-		log.Printf("Short read for input header: %v", r.inputBuf)
+		logf("Short read for input header: %v", r.inputBuf)
 		return
 	}
 
@@ -147,19 +200,28 @@ func (r *request) parse() {
 
 	r.handler = getHandler(r.inHeader.Opcode)
 	if r.handler == nil {
-		log.Printf("Unknown opcode %d", r.inHeader.Opcode)
+		logf("Unknown opcode %d", r.inHeader.Opcode)
 		r.status = ENOSYS
 		return
 	}
 
+	size := r.handler.InputSize
+	r.handler = compatHandler(r.handler, r.inHeader.Opcode, minor)
 	if len(r.arg) < int(r.handler.InputSize) {
-		log.Printf("Short read for %v: %v", operationName(r.inHeader.Opcode), r.arg)
+		logf("Short read for %v: %v", operationName(r.inHeader.Opcode), r.arg)
 		r.status = EIO
 		return
 	}
 
 	if r.handler.InputSize > 0 {
 		r.inData = unsafe.Pointer(&r.arg[0])
+		if r.handler.InputSize < size {
+			// Pad the older, shorter struct with zeros,
+			// so it reads as the current one.
+			in := make([]byte, size)
+			copy(in, r.arg[:r.handler.InputSize])
+			r.inData = unsafe.Pointer(&in[0])
+		}
 		r.arg = r.arg[r.handler.InputSize:]
 	} else {
 		r.arg = r.arg[inHSize:]
@@ -181,7 +243,7 @@ func (r *request) parse() {
 				r.filenames[i] = string(n)
 			}
 			if len(names) != count {
-				log.Println("filename argument mismatch", names, count)
+				logf("filename argument mismatch %q, %d", names, count)
 				r.status = EIO
 			}
 		}
@@ -191,6 +253,34 @@ func (r *request) parse() {
 		zeroOutBuf[:r.handler.OutputSize+sizeOfOutHeader])
 }
 
+// noWorker returns true for requests that are cheap and send no
+// reply, so the reader can handle them itself.
+func (r *request) noWorker() bool {
+	if r.inHeader == nil {
+		return false
+	}
+	switch r.inHeader.Opcode {
+	case _OP_FORGET, _OP_BATCH_FORGET, _OP_INTERRUPT, _OP_NOTIFY_REPLY:
+		return true
+	}
+	return false
+}
+
+// fromOther returns true if the request comes from a user other
+// than uid and root. Requests that the kernel may issue outside the
+// context of the original caller, such as write back and release,
+// are never attributed to others.
+func (r *request) fromOther(uid uint32) bool {
+	switch r.inHeader.Opcode {
+	case _OP_INIT, _OP_READ, _OP_WRITE, _OP_FSYNC, _OP_RELEASE,
+		_OP_READDIR, _OP_READDIRPLUS, _OP_FSYNCDIR, _OP_RELEASEDIR,
+		_OP_FORGET, _OP_BATCH_FORGET, _OP_INTERRUPT, _OP_NOTIFY_REPLY,
+		_OP_DESTROY:
+		return false
+	}
+	return r.inHeader.Uid != uid && r.inHeader.Uid != 0
+}
+
 func (r *request) outData() unsafe.Pointer {
 	return unsafe.Pointer(&r.outBuf[sizeOfOutHeader])
 }
@@ -11,3 +11,7 @@ const (
 	_MINIMUM_MINOR_VERSION = 8
 	_OUR_MINOR_VERSION     = 8
 )
+
+// compatSizes is empty: the structs in types_darwin.go already have
+// the layout of the protocol version that OSXFUSE speaks.
+var compatSizes []compatSize
@@ -4,10 +4,38 @@
 
 package fuse
 
+import "unsafe"
+
 const outputHeaderSize = 160
 
 const (
 	_FUSE_KERNEL_VERSION   = 7
-	_MINIMUM_MINOR_VERSION = 12
-	_OUR_MINOR_VERSION     = 23
+	_MINIMUM_MINOR_VERSION = 8
+	_OUR_MINOR_VERSION     = 28
 )
+
+var inHeaderSize = unsafe.Sizeof(InHeader{})
+
+// compatSizes are the sizes of the structs that grew since 7.8, as
+// sent and expected by kernels that speak an older minor version.
+// Input sizes include the InHeader.
+var compatSizes = []compatSize{
+	// 7.9 added GetAttrIn, and fuse_attr gained Blksize and
+	// padding.
+	{9, _OP_GETATTR, inHeaderSize, 96},
+	{9, _OP_SETATTR, 0, 96},
+	{9, _OP_LOOKUP, 0, 120},
+	{9, _OP_MKNOD, 0, 120},
+	{9, _OP_MKDIR, 0, 120},
+	{9, _OP_SYMLINK, 0, 120},
+	{9, _OP_LINK, 0, 120},
+	{9, _OP_CREATE, 0, 120 + unsafe.Sizeof(OpenOut{})},
+
+	// 7.9 added the lock owner and flags to ReadIn and WriteIn.
+	{9, _OP_READ, inHeaderSize + 24, 0},
+	{9, _OP_WRITE, inHeaderSize + 24, 0},
+
+	// 7.12 added the umask to MknodIn and CreateIn.
+	{12, _OP_MKNOD, inHeaderSize + 8, 0},
+	{12, _OP_CREATE, inHeaderSize + 8, 0},
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// oldRequest returns a request for op, with the given input struct
+// after the header, in the layout of protocol 7.8.
+func oldRequest(op int32, in []byte, names string) *request {
+	buf := make([]byte, inHeaderSize)
+	buf = append(buf, in...)
+	buf = append(buf, names...)
+	hdr := (*InHeader)(unsafe.Pointer(&buf[0]))
+	hdr.Opcode = op
+	hdr.Length = uint32(len(buf))
+	return &request{inputBuf: buf}
+}
+
+func TestParseCompatGetAttr(t *testing.T) {
+	req := oldRequest(_OP_GETATTR, nil, "")
+	req.parse(t.Logf, 8)
+	if req.status != OK {
+		t.Fatalf("parse: %v", req.status)
+	}
+	if in := (*GetAttrIn)(req.inData); in.Flags_ != 0 || in.Fh_ != 0 {
+		t.Errorf("got input %+v, want zero flags and handle", *in)
+	}
+	if req.handler.OutputSize != 96 {
+		t.Errorf("got reply size %d, want 96", req.handler.OutputSize)
+	}
+
+	req = oldRequest(_OP_GETATTR, nil, "")
+	req.parse(t.Logf, _OUR_MINOR_VERSION)
+	if req.status != EIO {
+		t.Errorf("parse without GetAttrIn at 7.%d: got %v, want EIO", _OUR_MINOR_VERSION, req.status)
+	}
+}
+
+func TestParseCompatMknod(t *testing.T) {
+	in := MknodIn{Mode: S_IFREG | 0644, Rdev: 3}
+	raw := (*[unsafe.Sizeof(MknodIn{})]byte)(unsafe.Pointer(&in))
+	req := oldRequest(_OP_MKNOD, raw[inHeaderSize:inHeaderSize+8], "name\x00")
+	req.parse(t.Logf, 8)
+	if req.status != OK {
+		t.Fatalf("parse: %v", req.status)
+	}
+	if got := (*MknodIn)(req.inData); got.Mode != in.Mode || got.Rdev != in.Rdev || got.Umask != 0 {
+		t.Errorf("got input %+v", *got)
+	}
+	if len(req.filenames) != 1 || req.filenames[0] != "name" {
+		t.Errorf("got names %q, want [\"name\"]", req.filenames)
+	}
+}
+
+type createFS struct {
+	RawFileSystem
+}
+
+func (fs *createFS) Create(cancel <-chan struct{}, input *CreateIn, name string, out *CreateOut) Status {
+	out.NodeId = 5
+	out.Fh = 7
+	return OK
+}
+
+func TestCompatCreateReply(t *testing.T) {
+	ms := &Server{opts: &MountOptions{}, fileSystem: &createFS{NewDefaultRawFileSystem()}}
+	req := oldRequest(_OP_CREATE, make([]byte, 8), "file\x00")
+	req.parse(t.Logf, 8)
+	if req.status != OK {
+		t.Fatalf("parse: %v", req.status)
+	}
+	req.handler.Func(ms, req)
+
+	header := req.serializeHeader(0)
+	if want := sizeOfOutHeader + 120 + unsafe.Sizeof(OpenOut{}); uintptr(len(header)) != want {
+		t.Fatalf("got reply of %d bytes, want %d", len(header), want)
+	}
+	entry := (*EntryOut)(unsafe.Pointer(&header[sizeOfOutHeader]))
+	open := (*OpenOut)(unsafe.Pointer(&header[sizeOfOutHeader+120]))
+	if entry.NodeId != 5 || open.Fh != 7 {
+		t.Errorf("got node %d handle %d, want 5 and 7", entry.NodeId, open.Fh)
+	}
+}
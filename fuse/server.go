@@ -5,20 +5,27 @@
 package fuse
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unsafe"
 )
 
 const (
-	// The kernel caps writes at 128k.
+	// The kernel caps writes at 128k, unless it supports
+	// CAP_MAX_PAGES. Then writes may be up to _MAX_PAGES pages.
 	MAX_KERNEL_WRITE = 128 * 1024
+
+	_MAX_PAGES = 256
 )
 
 // Server contains the logic for reading from the FUSE device and
@@ -32,8 +39,15 @@ type Server struct {
 	writeMu sync.Mutex
 
 	// I/O with kernel and daemon.
+	transport Transport
+
+	// The FUSE device, or -1 if transport is not one.
 	mountFd int
 
+	// With AutoUnmount, the socket that fusermount watches. It is
+	// closed once serving stops.
+	autoUnmount *os.File
+
 	latencies LatencyMap
 
 	opts *MountOptions
@@ -45,13 +59,91 @@ type Server struct {
 	readPool       sync.Pool
 	reqMu          sync.Mutex
 	reqReaders     int
+	reqInflight    []*request
 	kernelSettings InitIn
 
+	// initOut is our reply to INIT.
+	initOut InitOut
+
+	// Callbacks for outstanding NOTIFY_RETRIEVE, keyed by the
+	// unique we sent.
+	retrieveNext uint64
+	retrieveTab  map[uint64]func(offset int64, data []byte)
+
+	// The largest WRITE the kernel will send, as negotiated in
+	// INIT.
+	maxWrite int
+
+	// The protocol minor version negotiated in INIT. It is set
+	// before the request loops start, which read it without
+	// reqMu.
+	protoMinor uint32
+
+	// The MaxBackground sent in INIT.
+	maxBackground int
+
 	singleReader bool
 	canSplice    bool
-	loops        sync.WaitGroup
+
+	// If set, read requests with splice, and move WRITE data
+	// into the descriptors it returns.
+	spliceWriter SpliceWriteFileSystem
+
+	// If set, names nodes in debug output.
+	nodePaths NodePathFileSystem
+
+	// If set, serves TMPFILE.
+	tmpfiles TmpfileFileSystem
+
+	loops sync.WaitGroup
+
+	// If MaxWorkers is set, a token is held by each worker.
+	workers chan struct{}
+
+	// The mounting user, for AllowRoot.
+	uid uint32
 
 	ready chan error
+
+	// Set by Shutdown; new requests then fail with ENOTCONN.
+	// Protected by reqMu.
+	shutdown bool
+
+	// The first error that stopped a read loop, protected by
+	// reqMu.
+	serveErr error
+}
+
+// ErrShutdown is returned by ServeContext after Shutdown.
+var ErrShutdown = errors.New("fuse: server shut down")
+
+// MaxBackground returns the number of background requests we
+// allowed the kernel in INIT. This is MountOptions.MaxBackground,
+// or its default; the kernel may lower it further for unprivileged
+// mounts. It is only valid after INIT.
+func (ms *Server) MaxBackground() int {
+	ms.reqMu.Lock()
+	defer ms.reqMu.Unlock()
+	return ms.maxBackground
+}
+
+// MaxWrite returns the largest write the kernel will send. This
+// is MountOptions.MaxWrite, limited to what the kernel supports. It
+// is only valid after INIT.
+func (ms *Server) MaxWrite() int {
+	ms.reqMu.Lock()
+	defer ms.reqMu.Unlock()
+	return ms.maxWrite
+}
+
+// ProtocolVersion returns the FUSE protocol version negotiated with
+// the kernel: the lower of the kernel's and ours. Features that
+// appeared in later versions are not used. It is only valid after
+// INIT.
+func (ms *Server) ProtocolVersion() (major, minor uint32) {
+	ms.reqMu.Lock()
+	defer ms.reqMu.Unlock()
+	return _FUSE_KERNEL_VERSION, ms.protoMinor
 }
 
 // SetDebug is deprecated. Use MountOptions.Debug instead.
@@ -71,6 +163,17 @@ func (ms *Server) KernelSettings() *InitIn {
 	return &s
 }
 
+// InitOut returns the reply to the kernel's Init message, ie. the
+// capabilities and sizes that were agreed on. Before INIT, it is
+// all zeroes. The message should not be altered.
+func (ms *Server) InitOut() *InitOut {
+	ms.reqMu.Lock()
+	s := ms.initOut
+	ms.reqMu.Unlock()
+
+	return &s
+}
+
 const _MAX_NAME_LEN = 20
 
 // This type may be provided for recording latencies of each FUSE
@@ -79,12 +182,76 @@ type LatencyMap interface {
 	Add(name string, dt time.Duration)
 }
 
+// StatusLatencyMap is an additional interface for LatencyMaps that
+// also want the status of each request. If implemented, AddStatus is
+// called instead of Add.
+type StatusLatencyMap interface {
+	AddStatus(name string, status Status, dt time.Duration)
+}
+
 // RecordLatencies switches on collection of timing for each request
-// coming from the kernel.P assing a nil argument switches off the
+// coming from the kernel. Passing a nil argument switches off the
+// collection. Requests are timed from reading them to returning the
+// request struct, after the reply was written.
 func (ms *Server) RecordLatencies(l LatencyMap) {
 	ms.latencies = l
 }
 
+// InflightRequests returns the number of requests that were read
+// from the kernel, and are not yet done.
+func (ms *Server) InflightRequests() int {
+	ms.reqMu.Lock()
+	defer ms.reqMu.Unlock()
+	return len(ms.reqInflight)
+}
+
+// RequestInfo describes a request that is being served.
+type RequestInfo struct {
+	Unique uint64
+	Opcode string
+	NodeId uint64
+
+	// Names holds the file name arguments, for example of LOOKUP
+	// or RENAME.
+	Names []string
+
+	Caller Caller
+
+	// Age is the time since the request was read.
+	Age time.Duration
+
+	// Interrupted is set if the kernel sent an INTERRUPT for the
+	// request.
+	Interrupted bool
+}
+
+// InflightRequestInfo returns a snapshot of the requests that are
+// being served, oldest first. It is meant for diagnosing a mount that
+// hangs, for example from a SIGQUIT handler.
+func (ms *Server) InflightRequestInfo() []RequestInfo {
+	now := time.Now()
+	ms.reqMu.Lock()
+	out := make([]RequestInfo, 0, len(ms.reqInflight))
+	for _, req := range ms.reqInflight {
+		if req.inHeader == nil {
+			// Too short to parse.
+			continue
+		}
+		out = append(out, RequestInfo{
+			Unique:      req.inHeader.Unique,
+			Opcode:      operationName(req.inHeader.Opcode),
+			NodeId:      req.inHeader.NodeId,
+			Names:       append([]string(nil), req.filenames...),
+			Caller:      req.inHeader.Caller,
+			Age:         now.Sub(req.startTime),
+			Interrupted: req.interrupted,
+		})
+	}
+	ms.reqMu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Age > out[j].Age })
+	return out
+}
+
 // Unmount calls fusermount -u on the mount. This has the effect of
 // shutting down the filesystem. After the Server is unmounted, it
 // should be discarded.
@@ -116,12 +283,125 @@ func (ms *Server) Unmount() (err error) {
 
 // NewServer creates a server and attaches it to the given directory.
 func NewServer(fs RawFileSystem, mountPoint string, opts *MountOptions) (*Server, error) {
+	ms, err := newServer(fs, opts)
+	if err != nil {
+		return nil, err
+	}
+	mountPoint, err = absMountPoint(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	fd, keep, err := mount(mountPoint, ms.opts, ms.ready)
+	if err != nil {
+		return nil, err
+	}
+	ms.mountPoint = mountPoint
+	ms.mountFd = fd
+	ms.autoUnmount = keep
+	if err := ms.attach(&devTransport{fd}); err != nil {
+		if keep != nil {
+			keep.Close()
+		}
+		return nil, err
+	}
+	return ms, nil
+}
+
+// NewServerFd creates a server for a FUSE connection that was
+// mounted elsewhere, for example by a privileged helper that passes
+// fd on over a unix socket, or by running fusermount with
+// _FUSE_COMMFD. The kernel must not have sent INIT on fd yet.
+//
+// The Server takes ownership of fd, and closes it when Serve
+// returns. The options that only affect mounting, such as
+// AllowOther or FsName, are ignored. If mountPoint is empty,
+// WaitMount returns right away and Unmount does nothing: the caller
+// unmounts the file system, which makes Serve return.
+func NewServerFd(fs RawFileSystem, fd int, mountPoint string, opts *MountOptions) (*Server, error) {
+	ms, err := newServer(fs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if mountPoint != "" {
+		if mountPoint, err = absMountPoint(mountPoint); err != nil {
+			return nil, err
+		}
+	}
+	syscall.CloseOnExec(fd)
+	close(ms.ready)
+	ms.mountPoint = mountPoint
+	ms.mountFd = fd
+	if err := ms.attach(&devTransport{fd}); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// NewServerTransport creates a server that exchanges FUSE messages
+// over t instead of a FUSE device. The first message read from t
+// must be INIT; it is answered before NewServerTransport returns.
+//
+// The Server takes ownership of t, and closes it when Serve returns.
+// There is no mount point: WaitMount returns right away, Unmount does
+// nothing, and Serve returns once t reports that the connection is
+// gone. The options that only affect mounting are ignored, and
+// requests are not read with splice(2).
+func NewServerTransport(fs RawFileSystem, t Transport, opts *MountOptions) (*Server, error) {
+	ms, err := newServer(fs, opts)
+	if err != nil {
+		return nil, err
+	}
+	close(ms.ready)
+	if err := ms.attach(t); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// absMountPoint returns the cleaned, absolute form of mountPoint.
+func absMountPoint(mountPoint string) (string, error) {
+	mountPoint = filepath.Clean(mountPoint)
+	if filepath.IsAbs(mountPoint) {
+		return mountPoint, nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Clean(filepath.Join(cwd, mountPoint)), nil
+}
+
+// attach makes the server use the connection t, and answers INIT.
+func (ms *Server) attach(t Transport) error {
+	ms.transport = t
+	if code := ms.handleInit(); !code.Ok() {
+		t.Close()
+		// TODO - unmount as well?
+		return fmt.Errorf("init: %s", code)
+	}
+	return nil
+}
+
+// MountFd returns the file descriptor of the FUSE connection, or -1
+// for a Server from NewServerTransport. It remains owned by the
+// Server, and is closed when Serve returns; use syscall.Dup to hand
+// out a copy that outlives the Server.
+func (ms *Server) MountFd() int {
+	return ms.mountFd
+}
+
+// newServer creates a Server for fs, with the defaults filled in
+// opts, but without a connection.
+func newServer(fs RawFileSystem, opts *MountOptions) (*Server, error) {
 	if opts == nil {
 		opts = &MountOptions{
 			MaxBackground: _DEFAULT_BACKGROUND_TASKS,
 		}
 	}
 	o := *opts
+	if err := o.check(); err != nil {
+		return nil, err
+	}
 	if o.SingleThreaded {
 		fs = NewLockingRawFileSystem(fs)
 	}
@@ -135,10 +415,10 @@ func NewServer(fs RawFileSystem, mountPoint string, opts *MountOptions) (*Server
 	if o.MaxWrite == 0 {
 		o.MaxWrite = 1 << 16
 	}
-	if o.MaxWrite > MAX_KERNEL_WRITE {
-		o.MaxWrite = MAX_KERNEL_WRITE
+	if o.MaxWrite > _MAX_PAGES*pageSize {
+		o.MaxWrite = _MAX_PAGES * pageSize
 	}
-	if o.Name == "" {
+	if o.Name == "" && !o.hasOption(nameOption) {
 		name := fs.String()
 		l := len(name)
 		if l > _MAX_NAME_LEN {
@@ -147,12 +427,6 @@ func NewServer(fs RawFileSystem, mountPoint string, opts *MountOptions) (*Server
 		o.Name = strings.Replace(name[:l], ",", ";", -1)
 	}
 
-	for _, s := range o.optionsStrings() {
-		if strings.Contains(s, ",") {
-			return nil, fmt.Errorf("found ',' in option string %q", s)
-		}
-	}
-
 	ms := &Server{
 		fileSystem: fs,
 		opts:       &o,
@@ -161,50 +435,27 @@ func NewServer(fs RawFileSystem, mountPoint string, opts *MountOptions) (*Server
 		// error-out, meaning that unmount will hang.
 		singleReader: runtime.GOOS == "darwin",
 		ready:        make(chan error, 1),
+		uid:          uint32(os.Getuid()),
+		mountFd:      -1,
 	}
-	ms.reqPool.New = func() interface{} { return new(request) }
-	ms.readPool.New = func() interface{} { return make([]byte, o.MaxWrite+pageSize) }
-
-	mountPoint = filepath.Clean(mountPoint)
-	if !filepath.IsAbs(mountPoint) {
-		cwd, err := os.Getwd()
-		if err != nil {
-			return nil, err
-		}
-		mountPoint = filepath.Clean(filepath.Join(cwd, mountPoint))
+	if w, ok := fs.(SpliceWriteFileSystem); ok && o.SpliceWrite {
+		ms.spliceWriter = w
 	}
-	fd, err := mount(mountPoint, &o, ms.ready)
-	if err != nil {
-		return nil, err
+	if p, ok := fs.(NodePathFileSystem); ok {
+		ms.nodePaths = p
 	}
-
-	ms.mountPoint = mountPoint
-	ms.mountFd = fd
-
-	if code := ms.handleInit(); !code.Ok() {
-		syscall.Close(fd)
-		// TODO - unmount as well?
-		return nil, fmt.Errorf("init: %s", code)
+	if t, ok := fs.(TmpfileFileSystem); ok {
+		ms.tmpfiles = t
 	}
-	return ms, nil
-}
-
-func (o *MountOptions) optionsStrings() []string {
-	var r []string
-	r = append(r, o.Options...)
-
-	if o.AllowOther {
-		r = append(r, "allow_other")
+	if o.MaxWorkers > 0 {
+		ms.singleReader = true
+		ms.workers = make(chan struct{}, o.MaxWorkers)
 	}
-
-	if o.FsName != "" {
-		r = append(r, "fsname="+o.FsName)
+	ms.reqPool.New = func() interface{} {
+		return &request{cancel: make(chan struct{})}
 	}
-	if o.Name != "" {
-		r = append(r, "subtype="+o.Name)
-	}
-
-	return r
+	ms.readPool.New = func() interface{} { return make([]byte, o.MaxWrite+pageSize) }
+	return ms, nil
 }
 
 // DebugData returns internal status information for debugging
@@ -240,6 +491,16 @@ func handleEINTR(fn func() error) (err error) {
 	return
 }
 
+// logf writes diagnostics to MountOptions.Logger, or to the standard
+// logger.
+func (ms *Server) logf(format string, args ...interface{}) {
+	if ms.opts != nil && ms.opts.Logger != nil {
+		ms.opts.Logger.Output(2, fmt.Sprintf(format, args...))
+	} else {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
+
 // Returns a new request, or error. In case exitIdle is given, returns
 // nil, OK if we have too many readers already.
 func (ms *Server) readRequest(exitIdle bool) (req *request, code Status) {
@@ -253,14 +514,10 @@ func (ms *Server) readRequest(exitIdle bool) (req *request, code Status) {
 	ms.reqReaders++
 	ms.reqMu.Unlock()
 
-	var n int
-	err := handleEINTR(func() error {
-		var err error
-		n, err = syscall.Read(ms.mountFd, dest)
-		return err
-	})
+	n, err := ms.readDevice(req, dest)
 	if err != nil {
 		code = ToStatus(err)
+		ms.readPool.Put(dest)
 		ms.reqPool.Put(req)
 		ms.reqMu.Lock()
 		ms.reqReaders--
@@ -268,16 +525,20 @@ func (ms *Server) readRequest(exitIdle bool) (req *request, code Status) {
 		return nil, code
 	}
 
-	if ms.latencies != nil {
-		req.startTime = time.Now()
-	}
+	req.startTime = time.Now()
 	gobbled := req.setInput(dest[:n])
+	req.parse(ms.logf, ms.protoMinor)
 
 	ms.reqMu.Lock()
+	if ms.shutdown && req.status.Ok() && !servedInShutdown(req.inHeader.Opcode) {
+		req.status = Status(syscall.ENOTCONN)
+	}
 	if !gobbled {
 		ms.readPool.Put(dest)
 		dest = nil
 	}
+	req.inflightIndex = len(ms.reqInflight)
+	ms.reqInflight = append(ms.reqInflight, req)
 	ms.reqReaders--
 	if !ms.singleReader && ms.reqReaders <= 0 {
 		ms.loops.Add(1)
@@ -288,10 +549,58 @@ func (ms *Server) readRequest(exitIdle bool) (req *request, code Status) {
 	return req, OK
 }
 
+// sysRead reads from the FUSE device. It is a variable so tests can
+// inject errors.
+var sysRead = syscall.Read
+
+// readDevice reads a request into dest. It retries on EINTR, and
+// waits for input on EAGAIN, which happens if the FUSE device is
+// non-blocking. Reads too short to hold a header are dropped. End of
+// file means the connection is gone, and is reported as ENODEV.
+func (ms *Server) readDevice(req *request, dest []byte) (int, error) {
+	hdrSize := int(unsafe.Sizeof(InHeader{}))
+	for {
+		var n int
+		err := handleEINTR(func() error {
+			var err error
+			if ms.spliceWriter != nil && ms.canSplice {
+				n, err = ms.readSplice(req, dest)
+			} else {
+				n, err = ms.transport.Read(dest)
+			}
+			return err
+		})
+		switch {
+		case err == syscall.EAGAIN && ms.mountFd >= 0:
+			err = handleEINTR(func() error { return waitReadable(ms.mountFd) })
+			if err != nil {
+				return 0, err
+			}
+		case err != nil:
+			return 0, err
+		case n == 0:
+			return 0, syscall.ENODEV
+		case n < hdrSize:
+			ms.logf("Short read from fuse conn: %d bytes", n)
+		default:
+			return n, nil
+		}
+	}
+}
+
 // returnRequest returns a request to the pool of unused requests.
 func (ms *Server) returnRequest(req *request) {
 	ms.recordStats(req)
 
+	ms.reqMu.Lock()
+	last := len(ms.reqInflight) - 1
+	if last != req.inflightIndex {
+		ms.reqInflight[req.inflightIndex] = ms.reqInflight[last]
+		ms.reqInflight[req.inflightIndex].inflightIndex = req.inflightIndex
+	}
+	ms.reqInflight = ms.reqInflight[:last]
+	ms.reqMu.Unlock()
+
 	if req.bufferPoolOutputBuf != nil {
 		ms.opts.Buffers.FreeBuffer(req.bufferPoolOutputBuf)
 		req.bufferPoolOutputBuf = nil
@@ -310,7 +619,11 @@ func (ms *Server) recordStats(req *request) {
 	if ms.latencies != nil {
 		dt := time.Now().Sub(req.startTime)
 		opname := operationName(req.inHeader.Opcode)
-		ms.latencies.Add(opname, dt)
+		if sl, ok := ms.latencies.(StatusLatencyMap); ok {
+			sl.AddStatus(opname, req.status, dt)
+		} else {
+			ms.latencies.Add(opname, dt)
+		}
 	}
 }
 
@@ -325,8 +638,96 @@ func (ms *Server) Serve() {
 	ms.loops.Wait()
 
 	ms.writeMu.Lock()
-	syscall.Close(ms.mountFd)
+	ms.transport.Close()
 	ms.writeMu.Unlock()
+	if ms.autoUnmount != nil {
+		ms.autoUnmount.Close()
+	}
+
+	// No replies can arrive for outstanding retrieves anymore.
+	ms.reqMu.Lock()
+	ms.retrieveTab = nil
+	ms.reqMu.Unlock()
+}
+
+// ServeContext is like Serve, but shuts the server down as with
+// Shutdown when ctx is done, waiting for outstanding requests
+// without a time limit. It returns why serving stopped: nil if the
+// file system was unmounted, ErrShutdown after Shutdown, or the
+// error reading from the FUSE device.
+func (ms *Server) ServeContext(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ms.Shutdown(context.Background())
+		case <-done:
+		}
+	}()
+
+	ms.Serve()
+
+	ms.reqMu.Lock()
+	defer ms.reqMu.Unlock()
+	if ms.serveErr != nil {
+		return ms.serveErr
+	}
+	if ms.shutdown {
+		return ErrShutdown
+	}
+	return nil
+}
+
+// Shutdown stops the server gracefully: requests arriving from now
+// on fail with ENOTCONN, except for those that release resources,
+// such as FORGET and RELEASE. Once the outstanding requests have
+// been answered, or ctx is done, the file system is unmounted. It
+// returns ctx.Err() if the requests did not finish in time, and
+// otherwise the result of Unmount.
+//
+// Servers created with NewServerFd without a mount point are not
+// unmounted; the read loop stops when the caller unmounts.
+func (ms *Server) Shutdown(ctx context.Context) error {
+	ms.reqMu.Lock()
+	ms.shutdown = true
+	ms.reqMu.Unlock()
+
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	var err error
+wait:
+	for {
+		ms.reqMu.Lock()
+		n := len(ms.reqInflight)
+		ms.reqMu.Unlock()
+		if n == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	if uerr := ms.Unmount(); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// servedInShutdown returns whether the opcode is still served after
+// Shutdown. These release kernel references and open files, and
+// refusing them would only leak resources.
+func servedInShutdown(opcode int32) bool {
+	switch opcode {
+	case _OP_FORGET, _OP_BATCH_FORGET, _OP_INTERRUPT, _OP_NOTIFY_REPLY,
+		_OP_FLUSH, _OP_RELEASE, _OP_RELEASEDIR, _OP_DESTROY:
+		return true
+	}
+	return false
 }
 
 func (ms *Server) handleInit() Status {
@@ -361,44 +762,73 @@ exit:
 				break exit
 			}
 		case ENOENT:
+			// The request was interrupted before we read it.
 			continue
 		case ENODEV:
 			// unmount
 			break exit
 		default: // some other error?
-			log.Printf("Failed to read from fuse conn: %v", errNo)
+			ms.logf("Failed to read from fuse conn: %v", errNo)
+			ms.reqMu.Lock()
+			if ms.serveErr == nil {
+				ms.serveErr = fmt.Errorf("read from fuse conn: %v", errNo)
+			}
+			ms.reqMu.Unlock()
 			break exit
 		}
 
-		if ms.singleReader {
-			go ms.handleRequest(req)
-		} else {
+		if !ms.singleReader || (ms.workers != nil && req.noWorker()) {
 			ms.handleRequest(req)
+		} else if ms.workers != nil {
+			// Wait for a worker in the new goroutine, so we
+			// keep reading FORGET, INTERRUPT and
+			// NOTIFY_REPLY while the pool is full.
+			ms.loops.Add(1)
+			go func() {
+				ms.workers <- struct{}{}
+				ms.handleRequest(req)
+				<-ms.workers
+				ms.loops.Done()
+			}()
+		} else {
+			go ms.handleRequest(req)
 		}
 	}
 }
 
+// handleRequest runs req and writes its reply. It returns the error
+// from writing the reply, or else the status of the reply.
 func (ms *Server) handleRequest(req *request) Status {
-	req.parse()
 	if req.handler == nil {
 		req.status = ENOSYS
 	}
 
 	if req.status.Ok() && ms.opts.Debug {
-		log.Println(req.InputDebug())
+		msg := req.InputDebug()
+		if ms.nodePaths != nil {
+			if p, ok := ms.nodePaths.NodePath(req.inHeader.NodeId); ok {
+				msg += fmt.Sprintf(" path: %q", p)
+			}
+		}
+		ms.logf("%s", msg)
 	}
 
 	if req.inHeader.NodeId == pollHackInode {
 		// We want to avoid switching off features through our
 		// poll hack, so don't use ENOSYS
 		req.status = EIO
-		if req.inHeader.Opcode == _OP_POLL {
+		switch req.inHeader.Opcode {
+		case _OP_POLL:
 			req.status = ENOSYS
+		case _OP_GETATTR, _OP_SETATTR, _OP_OPEN, _OP_RELEASE, _OP_FLUSH:
+			doPollHackLookup(ms, req)
 		}
 	} else if req.inHeader.NodeId == FUSE_ROOT_ID && len(req.filenames) > 0 && req.filenames[0] == pollHackName {
 		doPollHackLookup(ms, req)
+	} else if req.status.Ok() && ms.opts.AllowRoot && req.fromOther(ms.uid) {
+		req.status = EACCES
 	} else if req.status.Ok() && req.handler.Func == nil {
-		log.Printf("Unimplemented opcode %v", operationName(req.inHeader.Opcode))
+		ms.logf("Unimplemented opcode %v", operationName(req.inHeader.Opcode))
 		req.status = ENOSYS
 	} else if req.status.Ok() {
 		req.handler.Func(ms, req)
@@ -406,8 +836,10 @@ func (ms *Server) handleRequest(req *request) Status {
 
 	errNo := ms.write(req)
 	if errNo != 0 {
-		log.Printf("writer: Write/Writev failed, err: %v. opcode: %v",
+		ms.logf("writer: Write/Writev failed, err: %v. opcode: %v",
 			errNo, operationName(req.inHeader.Opcode))
+	} else {
+		errNo = req.status
 	}
 	ms.returnRequest(req)
 	return Status(errNo)
@@ -426,20 +858,29 @@ func (ms *Server) allocOut(req *request, size uint32) []byte {
 }
 
 func (ms *Server) write(req *request) Status {
-	// Forget does not wait for reply.
-	if req.inHeader.Opcode == _OP_FORGET || req.inHeader.Opcode == _OP_BATCH_FORGET {
+	// Forget and replies to our notifications do not wait for
+	// a reply.
+	if req.inHeader.Opcode == _OP_FORGET || req.inHeader.Opcode == _OP_BATCH_FORGET ||
+		req.inHeader.Opcode == _OP_NOTIFY_REPLY {
+		return OK
+	}
+	// The kernel only wants to hear about an INTERRUPT if it
+	// should be retried.
+	if req.inHeader.Opcode == _OP_INTERRUPT && req.status.Ok() {
 		return OK
 	}
 
 	header := req.serializeHeader(req.flatDataSize())
 	if ms.opts.Debug {
-		log.Println(req.OutputDebug())
+		ms.logf("%s", req.OutputDebug())
 	}
 
 	if header == nil {
 		return OK
 	}
 
+	// Each reply is a single write to the device, so replies
+	// from concurrent requests do not interleave.
 	s := ms.systemWrite(req, header)
 	return s
 }
@@ -470,7 +911,7 @@ func (ms *Server) InodeNotify(node uint64, off int64, length int64) Status {
 	ms.writeMu.Unlock()
 
 	if ms.opts.Debug {
-		log.Println("Response: INODE_NOTIFY", result)
+		ms.logf("Response: INODE_NOTIFY: %v", result)
 	}
 	return result
 }
@@ -511,7 +952,7 @@ func (ms *Server) DeleteNotify(parent uint64, child uint64, name string) Status
 	ms.writeMu.Unlock()
 
 	if ms.opts.Debug {
-		log.Printf("Response: DELETE_NOTIFY: %v", result)
+		ms.logf("Response: DELETE_NOTIFY: %v", result)
 	}
 	return result
 }
@@ -520,9 +961,62 @@ func (ms *Server) DeleteNotify(parent uint64, child uint64, name string) Status
 // within a directory changes. You should not hold any FUSE filesystem
 // locks, as that can lead to deadlock.
 func (ms *Server) EntryNotify(parent uint64, name string) Status {
+	return ms.entryNotify(parent, name, 0)
+}
+
+// ExpiryNotify marks the entry for name in the directory parent as
+// expired, so the kernel looks it up again on the next access, but
+// keeps using it until then, eg. for open files and cwd. Use it when
+// a change is only suspected. Kernels before protocol 7.38 do not
+// support this, and get a full EntryNotify instead. As with
+// EntryNotify, no FUSE filesystem locks should be held.
+func (ms *Server) ExpiryNotify(parent uint64, name string) Status {
+	if !ms.kernelSettings.SupportsVersion(7, 38) {
+		return ms.EntryNotify(parent, name)
+	}
+	return ms.entryNotify(parent, name, NOTIFY_EXPIRE_ONLY)
+}
+
+func (ms *Server) entryNotify(parent uint64, name string, flags uint32) Status {
 	if !ms.kernelSettings.SupportsNotify(NOTIFY_INVAL_ENTRY) {
 		return ENOSYS
 	}
+	// Protect against concurrent close.
+	ms.writeMu.Lock()
+	result := ms.writeEntryNotify(parent, name, flags)
+	ms.writeMu.Unlock()
+	return result
+}
+
+// BatchEntryNotify is like EntryNotify for each of the names in the
+// directory parent. The protocol has no message for many entries, so
+// this still writes one message per name, but it skips duplicate
+// names, and the writes do not interleave with replies. The kernel
+// answers ENOENT for names it has no entry for; these are skipped.
+// BatchEntryNotify stops at any other failure, and returns it.
+func (ms *Server) BatchEntryNotify(parent uint64, names []string) Status {
+	if !ms.kernelSettings.SupportsNotify(NOTIFY_INVAL_ENTRY) {
+		return ENOSYS
+	}
+	seen := make(map[string]struct{}, len(names))
+
+	ms.writeMu.Lock()
+	defer ms.writeMu.Unlock()
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		if result := ms.writeEntryNotify(parent, name, 0); !result.Ok() && result != ENOENT {
+			return result
+		}
+	}
+	return OK
+}
+
+// writeEntryNotify sends NOTIFY_INVAL_ENTRY for name in parent. It
+// must be called with writeMu held.
+func (ms *Server) writeEntryNotify(parent uint64, name string, flags uint32) Status {
 	req := request{
 		inHeader: &InHeader{
 			Opcode: _OP_NOTIFY_ENTRY,
@@ -533,6 +1027,7 @@ func (ms *Server) EntryNotify(parent uint64, name string) Status {
 	entry := (*NotifyInvalEntryOut)(req.outData())
 	entry.Parent = parent
 	entry.NameLen = uint32(len(name))
+	entry.Flags = flags
 
 	// Many versions of FUSE generate stacktraces if the
 	// terminating null byte is missing.
@@ -541,13 +1036,122 @@ func (ms *Server) EntryNotify(parent uint64, name string) Status {
 	nameBytes[len(nameBytes)-1] = '\000'
 	req.flatData = nameBytes
 
+	result := ms.write(&req)
+	if ms.opts.Debug {
+		if flags&NOTIFY_EXPIRE_ONLY != 0 {
+			ms.logf("Response: EXPIRY_NOTIFY: %v", result)
+		} else {
+			ms.logf("Response: ENTRY_NOTIFY: %v", result)
+		}
+	}
+	return result
+}
+
+// PollNotify wakes up the poll(2) calls waiting on the poll handle
+// kh, which the kernel passed in an earlier Poll call.
+func (ms *Server) PollNotify(kh uint64) Status {
+	if !ms.kernelSettings.SupportsNotify(NOTIFY_POLL) {
+		return ENOSYS
+	}
+	req := request{
+		inHeader: &InHeader{
+			Opcode: _OP_NOTIFY_POLL,
+		},
+		handler: operationHandlers[_OP_NOTIFY_POLL],
+		status:  NOTIFY_POLL,
+	}
+	entry := (*_NotifyPollWakeupOut)(req.outData())
+	entry.Kh = kh
+
 	// Protect against concurrent close.
 	ms.writeMu.Lock()
 	result := ms.write(&req)
 	ms.writeMu.Unlock()
 
 	if ms.opts.Debug {
-		log.Printf("Response: ENTRY_NOTIFY: %v", result)
+		ms.logf("Response: POLL_NOTIFY: %v", result)
+	}
+	return result
+}
+
+// InodeNotifyStoreCache puts data into the kernel's page cache for
+// the inode, at the given offset. If this extends the file, the
+// kernel updates the file size.
+func (ms *Server) InodeNotifyStoreCache(node uint64, offset int64, data []byte) Status {
+	if !ms.kernelSettings.SupportsNotify(NOTIFY_STORE) {
+		return ENOSYS
+	}
+
+	req := request{
+		inHeader: &InHeader{
+			Opcode: _OP_NOTIFY_STORE,
+		},
+		handler: operationHandlers[_OP_NOTIFY_STORE],
+		status:  NOTIFY_STORE,
+	}
+	store := (*NotifyStoreOut)(req.outData())
+	store.Nodeid = node
+	store.Offset = uint64(offset)
+	store.Size = uint32(len(data))
+	req.flatData = data
+
+	// Protect against concurrent close.
+	ms.writeMu.Lock()
+	result := ms.write(&req)
+	ms.writeMu.Unlock()
+
+	if ms.opts.Debug {
+		ms.logf("Response: STORE_NOTIFY: %v", result)
+	}
+	return result
+}
+
+// InodeRetrieveCache asks the kernel for up to size bytes of the
+// inode's page cache, starting at offset. The kernel answers
+// asynchronously: fn is then called with the data that was cached
+// contiguously from the start of the page containing offset. The
+// data is only valid during the call. If the kernel does not answer,
+// for example because the file system is unmounted, fn is never
+// called.
+func (ms *Server) InodeRetrieveCache(node uint64, offset int64, size int, fn func(offset int64, data []byte)) Status {
+	if !ms.kernelSettings.SupportsNotify(NOTIFY_RETRIEVE) {
+		return ENOSYS
+	}
+
+	ms.reqMu.Lock()
+	ms.retrieveNext++
+	unique := ms.retrieveNext
+	if ms.retrieveTab == nil {
+		ms.retrieveTab = make(map[uint64]func(int64, []byte))
+	}
+	ms.retrieveTab[unique] = fn
+	ms.reqMu.Unlock()
+
+	req := request{
+		inHeader: &InHeader{
+			Opcode: _OP_NOTIFY_RETRIEVE,
+		},
+		handler: operationHandlers[_OP_NOTIFY_RETRIEVE],
+		status:  NOTIFY_RETRIEVE,
+	}
+	retrieve := (*NotifyRetrieveOut)(req.outData())
+	retrieve.NotifyUnique = unique
+	retrieve.Nodeid = node
+	retrieve.Offset = uint64(offset)
+	retrieve.Size = uint32(size)
+
+	// Protect against concurrent close.
+	ms.writeMu.Lock()
+	result := ms.write(&req)
+	ms.writeMu.Unlock()
+
+	if ms.opts.Debug {
+		ms.logf("Response: RETRIEVE_NOTIFY: %v", result)
+	}
+	if !result.Ok() {
+		ms.reqMu.Lock()
+		delete(ms.retrieveTab, unique)
+		ms.reqMu.Unlock()
 	}
 	return result
 }
@@ -568,6 +1172,10 @@ func (in *InitIn) SupportsNotify(notifyType int) bool {
 		return in.SupportsVersion(7, 12)
 	case NOTIFY_INVAL_DELETE:
 		return in.SupportsVersion(7, 18)
+	case NOTIFY_POLL:
+		return in.SupportsVersion(7, 11)
+	case NOTIFY_STORE, NOTIFY_RETRIEVE:
+		return in.SupportsVersion(7, 15)
 	}
 	return false
 }
@@ -586,5 +1194,12 @@ func (ms *Server) WaitMount() error {
 	if err != nil {
 		return err
 	}
-	return pollHack(ms.mountPoint)
+	if ms.opts.EnablePoll || ms.mountPoint == "" {
+		return nil
+	}
+	if err := pollHack(ms.mountPoint); err != syscall.ENOTDIR {
+		return err
+	}
+	// Mounted on a file: there is no directory for the hack file.
+	return nil
 }
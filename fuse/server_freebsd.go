@@ -0,0 +1,24 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+func (ms *Server) systemWrite(req *request, header []byte) Status {
+	if req.flatDataSize() == 0 {
+		return ToStatus(ms.transport.Write([][]byte{header}))
+	}
+
+	if req.fdData != nil {
+		sz := req.flatDataSize()
+		buf := ms.allocOut(req, uint32(sz))
+		req.flatData, req.status = req.fdData.Bytes(buf)
+		header = req.serializeHeader(len(req.flatData))
+	}
+
+	err := ms.transport.Write([][]byte{header, req.flatData})
+	if req.readResult != nil {
+		req.readResult.Done()
+	}
+	return ToStatus(err)
+}
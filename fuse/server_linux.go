@@ -4,18 +4,9 @@
 
 package fuse
 
-import (
-	"log"
-	"syscall"
-)
-
 func (ms *Server) systemWrite(req *request, header []byte) Status {
 	if req.flatDataSize() == 0 {
-		err := handleEINTR(func() error {
-			_, err := syscall.Write(ms.mountFd, header)
-			return err
-		})
-		return ToStatus(err)
+		return ToStatus(ms.transport.Write([][]byte{header}))
 	}
 
 	if req.fdData != nil {
@@ -25,7 +16,7 @@ func (ms *Server) systemWrite(req *request, header []byte) Status {
 				req.readResult.Done()
 				return OK
 			}
-			log.Println("trySplice:", err)
+			ms.logf("trySplice: %v", err)
 		}
 
 		sz := req.flatDataSize()
@@ -34,7 +25,7 @@ func (ms *Server) systemWrite(req *request, header []byte) Status {
 		header = req.serializeHeader(len(req.flatData))
 	}
 
-	_, err := writev(ms.mountFd, [][]byte{header, req.flatData})
+	err := ms.transport.Write([][]byte{header, req.flatData})
 	if req.readResult != nil {
 		req.readResult.Done()
 	}
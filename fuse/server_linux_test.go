@@ -0,0 +1,276 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// dirRootFS serves an empty root directory.
+type dirRootFS struct {
+	RawFileSystem
+}
+
+func (fs *dirRootFS) GetAttr(cancel <-chan struct{}, input *GetAttrIn, out *AttrOut) Status {
+	if input.NodeId != FUSE_ROOT_ID {
+		return ENOENT
+	}
+	out.Mode = S_IFDIR | 0755
+	return OK
+}
+
+func TestNewServerFd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestNewServerFd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dir)
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mount as a helper would, and hand over the connection.
+	opts := &MountOptions{}
+	fd, _, err := mount(dir, opts, make(chan error, 1))
+	if err != nil {
+		t.Fatalf("mount: %v", err)
+	}
+	srv, err := NewServerFd(&dirRootFS{NewDefaultRawFileSystem()}, fd, dir, opts)
+	if err != nil {
+		t.Fatalf("NewServerFd: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	if srv.MountFd() != fd {
+		t.Errorf("got MountFd %d, want %d", srv.MountFd(), fd)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if got := fi.Mode().Perm(); got != 0755 {
+		t.Errorf("got mode %o, want 0755 from the file system", got)
+	}
+}
+
+// TestNewServerFdInitRefused checks that NewServerFd fails if it
+// refuses the kernel's INIT, here for a protocol that is too old.
+func TestNewServerFdInitRefused(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kernel := os.NewFile(uintptr(fds[1]), "kernel")
+	defer kernel.Close()
+
+	in := InitIn{
+		InHeader: InHeader{
+			Length: uint32(unsafe.Sizeof(InitIn{})),
+			Opcode: _OP_INIT,
+			Unique: 1,
+		},
+		Major: _FUSE_KERNEL_VERSION,
+		Minor: _MINIMUM_MINOR_VERSION - 1,
+	}
+	if _, err := kernel.Write((*[unsafe.Sizeof(InitIn{})]byte)(unsafe.Pointer(&in))[:]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := NewServerFd(&dirRootFS{NewDefaultRawFileSystem()}, fds[0], "", &MountOptions{}); err == nil {
+		t.Fatal("NewServerFd succeeded after refusing INIT")
+	}
+
+	buf := make([]byte, 1024)
+	n, err := kernel.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if n < int(unsafe.Sizeof(OutHeader{})) {
+		t.Fatalf("short reply: %d bytes", n)
+	}
+	if out := (*OutHeader)(unsafe.Pointer(&buf[0])); out.Status != -int32(syscall.EIO) {
+		t.Errorf("got INIT status %d, want %d", out.Status, -int32(syscall.EIO))
+	}
+}
+
+// slowLookupFS blocks LOOKUPs of "slow" until release is closed.
+type slowLookupFS struct {
+	dirRootFS
+	started chan struct{}
+	release chan struct{}
+}
+
+func (fs *slowLookupFS) Lookup(cancel <-chan struct{}, header *InHeader, name string, out *EntryOut) Status {
+	if name != "slow" {
+		return ENOENT
+	}
+	close(fs.started)
+	<-fs.release
+	return ENOENT
+}
+
+func TestShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestShutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dir)
+
+	fs := &slowLookupFS{
+		dirRootFS: dirRootFS{NewDefaultRawFileSystem()},
+		started:   make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	srv, err := NewServer(fs, dir, &MountOptions{})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ServeContext(context.Background()) }()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+
+	statErr := make(chan error, 1)
+	go func() {
+		_, err := os.Lstat(filepath.Join(dir, "slow"))
+		statErr <- err
+	}()
+	<-fs.started
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- srv.Shutdown(context.Background()) }()
+
+	// Wait for Shutdown to take effect; new requests then fail.
+	// The outstanding LOOKUP holds the directory lock, so use
+	// GETATTR on the root.
+	for {
+		_, err = os.Stat(dir)
+		if err != nil {
+			break
+		}
+	}
+	if err.(*os.PathError).Err != syscall.ENOTCONN {
+		t.Fatalf("Stat after Shutdown: got %v, want ENOTCONN", err)
+	}
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned %v with a request outstanding", err)
+	default:
+	}
+
+	close(fs.release)
+	if err := <-statErr; !os.IsNotExist(err) {
+		t.Errorf("outstanding Lstat: got %v, want ENOENT", err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if err := <-serveErr; err != ErrShutdown {
+		t.Errorf("ServeContext: got %v, want ErrShutdown", err)
+	}
+}
+
+func TestServeContextCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestServeContextCancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dir)
+
+	srv, err := NewServer(&dirRootFS{NewDefaultRawFileSystem()}, dir, &MountOptions{})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ServeContext(ctx) }()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+
+	cancel()
+	if err := <-serveErr; err != ErrShutdown {
+		t.Errorf("ServeContext: got %v, want ErrShutdown", err)
+	}
+}
+
+func TestReadLoopRetries(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kernel := os.NewFile(uintptr(fds[1]), "kernel")
+	defer kernel.Close()
+
+	ms, err := newServer(&dirRootFS{NewDefaultRawFileSystem()}, &MountOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.mountFd = fds[0]
+	ms.transport = &devTransport{fds[0]}
+	// A single reader, so sysRead is not called concurrently.
+	ms.singleReader = true
+	ms.kernelSettings.Major = 7
+	ms.kernelSettings.Minor = 28
+
+	// Fail the first reads as a busy system might.
+	injected := []error{syscall.EINTR, syscall.EAGAIN, syscall.EINTR}
+	sysRead = func(fd int, p []byte) (int, error) {
+		if len(injected) > 0 {
+			err := injected[0]
+			injected = injected[1:]
+			return 0, err
+		}
+		return syscall.Read(fd, p)
+	}
+	defer func() { sysRead = syscall.Read }()
+
+	served := make(chan struct{})
+	go func() {
+		ms.Serve()
+		close(served)
+	}()
+
+	in := GetAttrIn{
+		InHeader: InHeader{
+			Length: uint32(unsafe.Sizeof(GetAttrIn{})),
+			Opcode: _OP_GETATTR,
+			Unique: 2,
+			NodeId: FUSE_ROOT_ID,
+		},
+	}
+	if _, err := kernel.Write((*[unsafe.Sizeof(GetAttrIn{})]byte)(unsafe.Pointer(&in))[:]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := kernel.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if n < int(unsafe.Sizeof(OutHeader{})) {
+		t.Fatalf("short reply: %d bytes", n)
+	}
+	out := (*OutHeader)(unsafe.Pointer(&buf[0]))
+	if out.Unique != 2 || out.Status != 0 {
+		t.Errorf("got reply %+v, want unique 2, status 0", *out)
+	}
+	if len(injected) > 0 {
+		t.Errorf("%d injected errors not seen", len(injected))
+	}
+
+	// Closing the connection stops the loop.
+	kernel.Close()
+	<-served
+}
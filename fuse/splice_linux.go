@@ -7,12 +7,24 @@ package fuse
 import (
 	"fmt"
 	"os"
+	"syscall"
+	"unsafe"
 
 	"github.com/hanwen/go-fuse/splice"
 )
 
+// splicePipe holds the data of a WRITE request, see readSplice.
+type splicePipe struct {
+	pair *splice.Pair
+}
+
+func (p *splicePipe) done() {
+	splice.Done(p.pair)
+}
+
 func (s *Server) setSplice() {
-	s.canSplice = splice.Resizable()
+	// Other transports have no descriptor to splice from.
+	s.canSplice = s.mountFd >= 0 && splice.Resizable()
 }
 
 // trySplice:  Zero-copy read from fdData.Fd into /dev/fuse
@@ -95,3 +107,103 @@ func (ms *Server) trySplice(header []byte, req *request, fdData *readResultFd) e
 
 	return nil
 }
+
+// readFull reads len(buf) bytes from the pipe.
+func readFull(pair *splice.Pair, buf []byte) error {
+	for len(buf) > 0 {
+		n, err := pair.Read(buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("pipe: short read")
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// readSplice reads a request from the device into dest, going
+// through a pipe. For WRITE, only the header and WriteIn are read,
+// and the data stays in the pipe, which is kept in req.writePipe.
+func (ms *Server) readSplice(req *request, dest []byte) (int, error) {
+	pair, err := splice.Get()
+	if err != nil {
+		return syscall.Read(ms.mountFd, dest)
+	}
+	// The kernel fails the splice if the request does not fit.
+	if err := pair.Grow(len(dest) + os.Getpagesize()); err != nil {
+		splice.Done(pair)
+		return syscall.Read(ms.mountFd, dest)
+	}
+
+	m, err := syscall.Splice(ms.mountFd, nil, int(pair.WriteFd()), nil, len(dest), 0)
+	if err != nil {
+		splice.Done(pair)
+		return 0, err
+	}
+	n := int(m)
+
+	hdrSize := int(unsafe.Sizeof(InHeader{}))
+	writeSize := int(getHandler(_OP_WRITE).InputSize)
+	if n > writeSize {
+		if err := readFull(pair, dest[:hdrSize]); err != nil {
+			splice.Done(pair)
+			return 0, err
+		}
+		if (*InHeader)(unsafe.Pointer(&dest[0])).Opcode == _OP_WRITE {
+			if err := readFull(pair, dest[hdrSize:writeSize]); err != nil {
+				splice.Done(pair)
+				return 0, err
+			}
+			req.writePipe = &splicePipe{pair}
+			return writeSize, nil
+		}
+		err = readFull(pair, dest[hdrSize:n])
+	} else {
+		err = readFull(pair, dest[:n])
+	}
+	splice.Done(pair)
+	return n, err
+}
+
+// spliceWrite moves the data of a WRITE from req.writePipe into the
+// descriptor given by the file system. If there is none, or it cannot
+// be spliced into, the data is read from the pipe and passed to
+// Write.
+func (ms *Server) spliceWrite(req *request) (uint32, Status) {
+	input := (*WriteIn)(req.inData)
+	pair := req.writePipe.pair
+	size := int(input.Size)
+
+	if fd, ok := ms.spliceWriter.WriteFd(req.cancel, input); ok {
+		written := 0
+		for written < size {
+			n, err := pair.WriteToAt(fd, size-written, int64(input.Offset)+int64(written))
+			if err == syscall.EINVAL && written == 0 {
+				break
+			}
+			if err != nil && written == 0 {
+				return 0, ToStatus(err)
+			}
+			if err != nil || n == 0 {
+				// Return a short write.
+				return uint32(written), OK
+			}
+			written += n
+		}
+		if written == size {
+			return uint32(written), OK
+		}
+	}
+
+	buf := ms.readPool.Get().([]byte)
+	req.bufferPoolInputBuf = buf
+	if size > len(buf) {
+		return 0, EIO
+	}
+	if err := readFull(pair, buf[:size]); err != nil {
+		return 0, ToStatus(err)
+	}
+	return ms.fileSystem.Write(req.cancel, input, buf[:size])
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// noExecNode denies X_OK, and records the callers of Access.
+type noExecNode struct {
+	nodefs.Node
+
+	mu   sync.Mutex
+	uids []uint32
+}
+
+func (n *noExecNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0755
+	return fuse.OK
+}
+
+func (n *noExecNode) Access(mode uint32, context *fuse.Context) fuse.Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.uids = append(n.uids, context.Uid)
+	if mode&fuse.X_OK != 0 {
+		return fuse.EACCES
+	}
+	return fuse.OK
+}
+
+func TestAccessNode(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	server, _, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+
+	node := &noExecNode{Node: nodefs.NewDefaultNode()}
+	root.Inode().NewChild("noexec", false, node)
+	root.Inode().NewChild("default", false, &blobNode{nodefs.NewDefaultNode(), "x"})
+
+	p := filepath.Join(dir, "noexec")
+	if err := syscall.Access(p, fuse.R_OK); err != nil {
+		t.Errorf("Access(R_OK): %v", err)
+	}
+	if err := syscall.Access(p, fuse.X_OK); err != syscall.EACCES {
+		t.Errorf("Access(X_OK): got %v, want EACCES", err)
+	}
+	node.mu.Lock()
+	if len(node.uids) != 2 || node.uids[0] != uint32(os.Getuid()) {
+		t.Errorf("got Access callers %v, want 2 calls from uid %d", node.uids, os.Getuid())
+	}
+	node.mu.Unlock()
+
+	if err := syscall.Access(filepath.Join(dir, "default"), fuse.R_OK|fuse.W_OK|fuse.X_OK); err != nil {
+		t.Errorf("Access on default node: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// rootOnlyFS serves an empty root directory, with attributes that
+// may not be cached, so each stat reaches the server.
+type rootOnlyFS struct {
+	fuse.RawFileSystem
+}
+
+func (fs *rootOnlyFS) GetAttr(cancel <-chan struct{}, input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	if input.NodeId != fuse.FUSE_ROOT_ID {
+		return fuse.ENOENT
+	}
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+// statAs runs stat(1) on path as another user.
+func statAs(uid uint32, path string) error {
+	cmd := exec.Command("stat", path)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uid, Gid: uid},
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil && strings.Contains(string(out), "Permission denied") {
+		return syscall.EACCES
+	}
+	return err
+}
+
+func testAllowOther(t *testing.T, opts *fuse.MountOptions, want error) {
+	if os.Getuid() != 0 {
+		t.Skip("need root to run as another user")
+	}
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Debug = testutil.VerboseTest()
+	srv, err := fuse.NewServer(&rootOnlyFS{fuse.NewDefaultRawFileSystem()}, dir, opts)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	if err := statAs(0, dir); err != nil {
+		t.Errorf("stat as root: %v", err)
+	}
+	if err := statAs(65534, dir); err != want {
+		t.Errorf("stat as other user: got %v, want %v", err, want)
+	}
+}
+
+func TestAllowOther(t *testing.T) {
+	testAllowOther(t, &fuse.MountOptions{AllowOther: true}, nil)
+}
+
+func TestAllowOtherDisabled(t *testing.T) {
+	testAllowOther(t, &fuse.MountOptions{}, syscall.EACCES)
+}
+
+func TestAllowRoot(t *testing.T) {
+	testAllowOther(t, &fuse.MountOptions{AllowRoot: true}, syscall.EACCES)
+}
+
+func TestAllowOtherAndRoot(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+	srv, err := fuse.NewServer(fuse.NewDefaultRawFileSystem(), dir, &fuse.MountOptions{
+		AllowOther: true,
+		AllowRoot:  true,
+	})
+	if err == nil {
+		srv.Unmount()
+		t.Fatal("NewServer succeeded with AllowOther and AllowRoot")
+	}
+}
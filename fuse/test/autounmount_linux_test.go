@@ -0,0 +1,150 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+const autoUnmountEnv = "GOFUSE_AUTOUNMOUNT_DIR"
+
+func isMounted(dir string) bool {
+	data, err := ioutil.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return false
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		if f := strings.Fields(l); len(f) > 1 && f[1] == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// autoUnmountServer is run in a child process: it mounts dir, and
+// serves until it is killed.
+func autoUnmountServer(dir string) {
+	conn := nodefs.NewFileSystemConnector(nodefs.NewDefaultNode(), nil)
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		AutoUnmount: true,
+	})
+	if err != nil {
+		fmt.Println("NewServer:", err)
+		os.Exit(1)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		fmt.Println("WaitMount:", err)
+		os.Exit(1)
+	}
+	fmt.Println("ready")
+	select {}
+}
+
+func TestAutoUnmount(t *testing.T) {
+	if dir := os.Getenv(autoUnmountEnv); dir != "" {
+		autoUnmountServer(dir)
+		return
+	}
+
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestAutoUnmount$")
+	cmd.Env = append(os.Environ(), autoUnmountEnv+"="+dir)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	line, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil || line != "ready\n" {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatalf("server did not start: %q, %v", line, err)
+	}
+	if !isMounted(dir) {
+		t.Fatalf("%s not in /proc/self/mounts", dir)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for isMounted(dir) {
+		if time.Now().After(deadline) {
+			exec.Command("fusermount", "-u", dir).Run()
+			t.Fatalf("%s still mounted after the server was killed", dir)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func openFds(t *testing.T) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	return len(fds)
+}
+
+func TestAutoUnmountNoFdLeak(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	mountOnce := func() {
+		conn := nodefs.NewFileSystemConnector(nodefs.NewDefaultNode(), nil)
+		srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+			AutoUnmount: true,
+		})
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		served := make(chan struct{})
+		go func() {
+			srv.Serve()
+			close(served)
+		}()
+		if err := srv.WaitMount(); err != nil {
+			t.Fatalf("WaitMount: %v", err)
+		}
+		if err := srv.Unmount(); err != nil {
+			t.Fatalf("Unmount: %v", err)
+		}
+		<-served
+	}
+	// The first mount may set up descriptors that the runtime
+	// keeps, like the poller's.
+	mountOnce()
+	before := openFds(t)
+	for i := 0; i < 3; i++ {
+		mountOnce()
+	}
+	// Earlier tests may still be closing descriptors.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		after := openFds(t)
+		if after <= before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("open descriptors: got %d after 3 mounts, want %d", after, before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
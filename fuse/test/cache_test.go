@@ -6,11 +6,15 @@ package test
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
@@ -29,7 +33,7 @@ func (fs *cacheFs) Open(name string, flags uint32, context *fuse.Context) (fuseF
 	}
 	return &nodefs.WithFlags{
 		File:      f,
-		FuseFlags: fuse.FOPEN_KEEP_CACHE,
+		KeepCache: true,
 	}, c
 
 }
@@ -111,6 +115,149 @@ func TestFopenKeepCache(t *testing.T) {
 	}
 }
 
+// keepCacheNode serves content that changes without changing the
+// file attributes, so only FOPEN_KEEP_CACHE decides whether a new
+// open sees it.
+type keepCacheNode struct {
+	nodefs.Node
+	keep bool
+
+	mu      sync.Mutex
+	content string
+}
+
+func (n *keepCacheNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = 4
+	return fuse.OK
+}
+
+func (n *keepCacheNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return &nodefs.WithFlags{
+		File:      nodefs.NewDataFile([]byte(n.content)),
+		KeepCache: n.keep,
+	}, fuse.OK
+}
+
+func (n *keepCacheNode) set(content string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.content = content
+}
+
+func TestKeepCache(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("FOPEN_KEEP_CACHE is broken on Darwin.")
+	}
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	state, conn, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	defer state.Unmount()
+	go state.Serve()
+	if err := state.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+
+	kept := &keepCacheNode{Node: nodefs.NewDefaultNode(), keep: true, content: "aaaa"}
+	dropped := &keepCacheNode{Node: nodefs.NewDefaultNode(), content: "aaaa"}
+	keptInode := root.Inode().NewChild("kept", false, kept)
+	root.Inode().NewChild("dropped", false, dropped)
+
+	for _, name := range []string{"kept", "dropped"} {
+		if c, err := ioutil.ReadFile(dir + "/" + name); err != nil || string(c) != "aaaa" {
+			t.Fatalf("ReadFile(%s): %q, %v", name, c, err)
+		}
+	}
+	kept.set("bbbb")
+	dropped.set("bbbb")
+
+	if c, err := ioutil.ReadFile(dir + "/kept"); err != nil || string(c) != "aaaa" {
+		t.Errorf("ReadFile(kept): got %q, %v, want cached %q", c, err, "aaaa")
+	}
+	if c, err := ioutil.ReadFile(dir + "/dropped"); err != nil || string(c) != "bbbb" {
+		t.Errorf("ReadFile(dropped): got %q, %v, want %q", c, err, "bbbb")
+	}
+
+	if code := conn.FileNotify(keptInode, 0, 0); !code.Ok() {
+		t.Fatalf("FileNotify: %v", code)
+	}
+	if c, err := ioutil.ReadFile(dir + "/kept"); err != nil || string(c) != "bbbb" {
+		t.Errorf("ReadFile(kept) after FileNotify: got %q, %v, want %q", c, err, "bbbb")
+	}
+}
+
+// sizeNode reports a size that may change behind the kernel's back,
+// and lets the kernel cache it for an hour.
+type sizeNode struct {
+	nodefs.Node
+
+	mu   sync.Mutex
+	size uint64
+}
+
+func (n *sizeNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = n.size
+	return fuse.OK
+}
+
+func (n *sizeNode) AttrTimeout() (time.Duration, bool) {
+	return time.Hour, true
+}
+
+func (n *sizeNode) setSize(size uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.size = size
+}
+
+func TestInvalidateAttr(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	state, conn, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	defer state.Unmount()
+	go state.Serve()
+	if err := state.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+
+	node := &sizeNode{Node: nodefs.NewDefaultNode(), size: 1}
+	inode := root.Inode().NewChild("file", false, node)
+	p := filepath.Join(dir, "file")
+	if fi, err := os.Lstat(p); err != nil || fi.Size() != 1 {
+		t.Fatalf("Lstat: %v, %v", fi, err)
+	}
+
+	node.setSize(2)
+	if fi, err := os.Lstat(p); err != nil || fi.Size() != 1 {
+		t.Errorf("Lstat: got %v, %v, want cached size 1", fi, err)
+	}
+	if code := conn.InvalidateAttr(inode); !code.Ok() {
+		t.Fatalf("InvalidateAttr: %v", code)
+	}
+	if fi, err := os.Lstat(p); err != nil || fi.Size() != 2 {
+		t.Errorf("Lstat after InvalidateAttr: got %v, %v, want size 2", fi, err)
+	}
+}
+
 type nonseekFs struct {
 	pathfs.FileSystem
 	Length int
@@ -169,6 +316,146 @@ func TestNonseekable(t *testing.T) {
 	}
 }
 
+// eventsNode opens its file as a nonseekable file, and possibly as
+// a stream.
+type eventsNode struct {
+	nodefs.Node
+	file   nodefs.File
+	stream bool
+}
+
+func (n *eventsNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func (n *eventsNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return &nodefs.WithFlags{File: n.file, NonSeekable: true, Stream: n.stream, DirectIO: true}, fuse.OK
+}
+
+func TestNonseekableSequential(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	state, _, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	defer state.Unmount()
+	go state.Serve()
+	if err := state.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		stream  bool
+		offsets []int64
+	}{
+		{"nonseekable", false, []int64{0, 100, 200}},
+		// Streams have no position at all.
+		{"stream", true, []int64{0, 0, 0}},
+	} {
+		file := &readSizeFile{File: nodefs.NewDefaultFile()}
+		root.Inode().NewChild(tc.name, false, &eventsNode{nodefs.NewDefaultNode(), file, tc.stream})
+
+		f, err := os.Open(dir + "/" + tc.name)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if _, err := f.Seek(10, 0); err == nil || err.(*os.PathError).Err != syscall.ESPIPE {
+			t.Errorf("%s: Seek: got %v, want ESPIPE", tc.name, err)
+		}
+		b := make([]byte, 100)
+		for i := 0; i < 3; i++ {
+			if n, err := f.Read(b); err != nil || n != len(b) {
+				t.Fatalf("%s: Read: %d, %v", tc.name, n, err)
+			}
+		}
+		f.Close()
+
+		file.mu.Lock()
+		var want [][2]int64
+		for _, off := range tc.offsets {
+			want = append(want, [2]int64{100, off})
+		}
+		if fmt.Sprint(file.reads) != fmt.Sprint(want) {
+			t.Errorf("%s: got reads %v, want %v", tc.name, file.reads, want)
+		}
+		file.mu.Unlock()
+	}
+}
+
+// readSizeFile records the sizes and offsets of reads.
+type readSizeFile struct {
+	nodefs.File
+
+	mu    sync.Mutex
+	reads [][2]int64
+}
+
+func (f *readSizeFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads = append(f.reads, [2]int64{int64(len(dest)), off})
+	return fuse.ReadResultData(bytes.Repeat([]byte{'x'}, len(dest))), fuse.OK
+}
+
+type directIONode struct {
+	nodefs.Node
+	file *readSizeFile
+}
+
+func (n *directIONode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = 1 << 20
+	return fuse.OK
+}
+
+func (n *directIONode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return &nodefs.WithFlags{File: n.file, DirectIO: true}, fuse.OK
+}
+
+func TestDirectIO(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	file := &readSizeFile{File: nodefs.NewDefaultFile()}
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	state, _, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	defer state.Unmount()
+	go state.Serve()
+	if err := state.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	root.Inode().NewChild("stream", false, &directIONode{nodefs.NewDefaultNode(), file})
+
+	f, err := os.Open(dir + "/stream")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1000)
+	if n, err := f.ReadAt(b, 3); err != nil || n != len(b) {
+		t.Fatalf("ReadAt: %d, %v", n, err)
+	}
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if want := [][2]int64{{1000, 3}}; fmt.Sprint(file.reads) != fmt.Sprint(want) {
+		t.Errorf("got reads (size, offset) %v, want %v", file.reads, want)
+	}
+}
+
 func TestGetAttrRace(t *testing.T) {
 	dir := testutil.TempDir()
 	defer os.RemoveAll(dir)
@@ -0,0 +1,123 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// listNode is a directory that lists names, which may change
+// without notice.
+type listNode struct {
+	nodefs.Node
+
+	cache, keep bool
+
+	mu    sync.Mutex
+	names []string
+}
+
+func (n *listNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func (n *listNode) OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var r []fuse.DirEntry
+	for _, name := range n.names {
+		r = append(r, fuse.DirEntry{Name: name, Mode: fuse.S_IFREG})
+	}
+	return r, fuse.OK
+}
+
+func (n *listNode) CacheDir() (cache, keep bool) {
+	return n.cache, n.keep
+}
+
+func (n *listNode) setNames(names ...string) {
+	n.mu.Lock()
+	n.names = names
+	n.mu.Unlock()
+}
+
+func setupCacheDir(t *testing.T, root *listNode) (mnt string, conn *nodefs.FileSystemConnector, clean func()) {
+	mnt = testutil.TempDir()
+	conn = nodefs.NewFileSystemConnector(root, nil)
+	srv, err := fuse.NewServer(conn.RawFS(), mnt, &fuse.MountOptions{Debug: testutil.VerboseTest()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	return mnt, conn, func() {
+		srv.Unmount()
+		os.Remove(mnt)
+	}
+}
+
+func listNames(t *testing.T, dir string) []string {
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames: %v", err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCacheDirDisabled(t *testing.T) {
+	root := &listNode{Node: nodefs.NewDefaultNode()}
+	root.setNames("a")
+	mnt, _, clean := setupCacheDir(t, root)
+	defer clean()
+
+	if got := listNames(t, mnt); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("got %v, want [a]", got)
+	}
+	root.setNames("a", "b")
+	if got := listNames(t, mnt); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("after change: got %v, want [a b]", got)
+	}
+}
+
+func TestCacheDirKeep(t *testing.T) {
+	root := &listNode{Node: nodefs.NewDefaultNode(), cache: true, keep: true}
+	root.setNames("a")
+	mnt, conn, clean := setupCacheDir(t, root)
+	defer clean()
+
+	if conn.Server().KernelSettings().Minor < 28 {
+		t.Skip("kernel does not cache directories")
+	}
+	if got := listNames(t, mnt); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("got %v, want [a]", got)
+	}
+	root.setNames("a", "b")
+	if got := listNames(t, mnt); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("cached: got %v, want [a]", got)
+	}
+	if code := conn.FileNotify(root.Inode(), 0, 0); !code.Ok() {
+		t.Fatalf("FileNotify: %v", code)
+	}
+	if got := listNames(t, mnt); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("after FileNotify: got %v, want [a b]", got)
+	}
+}
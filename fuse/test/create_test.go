@@ -0,0 +1,98 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// openCountFS counts the calls that can create or open files.
+type openCountFS struct {
+	fuse.RawFileSystem
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (fs *openCountFS) count(op string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.counts[op]++
+}
+
+func (fs *openCountFS) Create(cancel <-chan struct{}, input *fuse.CreateIn, name string, out *fuse.CreateOut) fuse.Status {
+	fs.count("CREATE")
+	return fs.RawFileSystem.Create(cancel, input, name, out)
+}
+
+func (fs *openCountFS) Mknod(cancel <-chan struct{}, input *fuse.MknodIn, name string, out *fuse.EntryOut) fuse.Status {
+	fs.count("MKNOD")
+	return fs.RawFileSystem.Mknod(cancel, input, name, out)
+}
+
+func (fs *openCountFS) Open(cancel <-chan struct{}, input *fuse.OpenIn, out *fuse.OpenOut) fuse.Status {
+	fs.count("OPEN")
+	return fs.RawFileSystem.Open(cancel, input, out)
+}
+
+// TestCreateAtomic checks that open(O_CREAT) is served by a single
+// CREATE, which returns both the entry and the open file.
+func TestCreateAtomic(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	orig := filepath.Join(dir, "orig")
+	mnt := filepath.Join(dir, "mnt")
+	for _, d := range []string{orig, mnt} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(orig), nil)
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	fs := &openCountFS{RawFileSystem: conn.RawFS(), counts: map[string]int{}}
+	srv, err := fuse.NewServer(fs, mnt, &fuse.MountOptions{Debug: testutil.VerboseTest()})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	f, err := os.OpenFile(filepath.Join(mnt, "file"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	fs.mu.Lock()
+	if fs.counts["CREATE"] != 1 || fs.counts["MKNOD"] != 0 || fs.counts["OPEN"] != 0 {
+		t.Errorf("got calls %v, want a single CREATE", fs.counts)
+	}
+	fs.mu.Unlock()
+
+	if pfs.Root().Inode().GetChild("file") == nil {
+		t.Errorf("no Inode for the created file")
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(orig, "file")); err != nil || string(got) != "hello" {
+		t.Errorf("ReadFile: got %q, %v, want %q", got, err, "hello")
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
 	"github.com/hanwen/go-fuse/internal/testutil"
 )
 
@@ -117,3 +118,59 @@ func TestDeleteNotify(t *testing.T) {
 		t.Fatalf("lstat after del + mkdir failed: %v", err)
 	}
 }
+
+func TestPathDeleteNotify(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	orig := dir + "/orig"
+	mnt := dir + "/mnt"
+	for _, d := range []string{orig, mnt} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(orig), nil)
+	state, _, err := nodefs.MountRoot(mnt, pfs.Root(), &nodefs.Options{
+		EntryTimeout: time.Hour,
+		AttrTimeout:  time.Hour,
+		Debug:        testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go state.Serve()
+	defer state.Unmount()
+	if err := state.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	if state.KernelSettings().Minor < 18 {
+		t.Skip("Kernel does not support deletion notify")
+	}
+
+	if err := ioutil.WriteFile(orig+"/file", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(mnt + "/file"); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+
+	// The kernel caches the entry, so it does not see the removal
+	// until told.
+	if err := os.Remove(orig + "/file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(mnt + "/file"); err != nil {
+		t.Fatalf("Lstat before DeleteNotify: %v", err)
+	}
+
+	if code := pfs.DeleteNotify("", "file"); !code.Ok() {
+		t.Fatalf("DeleteNotify: %v", code)
+	}
+	if pfs.Root().Inode().GetChild("file") != nil {
+		t.Errorf("Inode for deleted file still present")
+	}
+	if _, err := os.Lstat(mnt + "/file"); !os.IsNotExist(err) {
+		t.Errorf("Lstat after DeleteNotify: got %v, want ENOENT", err)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// countStream generates n entries without storing them.
+type countStream struct {
+	node *streamNode
+	i, n int
+}
+
+func (s *countStream) HasNext() bool {
+	return s.i < s.n
+}
+
+func (s *countStream) Next() (fuse.DirEntry, fuse.Status) {
+	s.i++
+	return fuse.DirEntry{Name: fmt.Sprintf("e%d", s.i-1), Mode: fuse.S_IFREG}, fuse.OK
+}
+
+func (s *countStream) Close() {
+	s.node.mu.Lock()
+	defer s.node.mu.Unlock()
+	s.node.closed++
+}
+
+type streamNode struct {
+	nodefs.Node
+	n int
+
+	mu     sync.Mutex
+	opened int
+	closed int
+}
+
+func (n *streamNode) OpenDirStream(context *fuse.Context) (nodefs.DirStream, fuse.Status) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.opened++
+	return &countStream{node: n, n: n.n}, fuse.OK
+}
+
+func TestDirStream(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := &streamNode{Node: nodefs.NewDefaultNode(), n: 5000}
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		Debug: testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	check := func(names []string) {
+		seen := map[string]bool{}
+		for _, n := range names {
+			if seen[n] {
+				t.Errorf("duplicate entry %q", n)
+			}
+			seen[n] = true
+		}
+		for i := 0; i < root.n; i++ {
+			if n := fmt.Sprintf("e%d", i); !seen[n] {
+				t.Fatalf("missing entry %q among %d", n, len(names))
+			}
+		}
+	}
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames: %v", err)
+	}
+	check(names)
+
+	// rewinddir() reopens the stream.
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	names, err = f.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames after rewind: %v", err)
+	}
+	check(names)
+	f.Close()
+
+	// RELEASEDIR is sent asynchronously.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		root.mu.Lock()
+		opened, closed := root.opened, root.closed
+		root.mu.Unlock()
+		if closed == 2 || time.Now().After(deadline) {
+			if opened != 2 || closed != 2 {
+				t.Errorf("got %d opens, %d closes, want 2 each", opened, closed)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
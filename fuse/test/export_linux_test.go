@@ -0,0 +1,67 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+	"golang.org/x/sys/unix"
+)
+
+// TestExportSupport resolves a file handle, as nfsd does, after the
+// kernel dropped the inode from its cache.
+func TestExportSupport(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.ExportSupport = true
+	opts.Debug = testutil.VerboseTest()
+	server, conn, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+	root.Inode().NewChild("file", false, &blobNode{nodefs.NewDefaultNode(), "hello"})
+
+	p := filepath.Join(dir, "file")
+	h, _, err := unix.NameToHandleAt(unix.AT_FDCWD, p, 0)
+	if err != nil {
+		t.Skipf("NameToHandleAt: %v", err)
+	}
+
+	// Make the kernel forget the inode. Dropping the unused dentry
+	// evicts it, so the handle is resolved with a LOOKUP of ".".
+	if err := conn.EntryNotify(root.Inode(), "file"); !err.Ok() {
+		t.Fatalf("EntryNotify: %v", err)
+	}
+
+	mountFd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer unix.Close(mountFd)
+	fd, err := unix.OpenByHandleAt(mountFd, h, unix.O_RDONLY)
+	if err != nil {
+		t.Fatalf("OpenByHandleAt: %v", err)
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		t.Fatalf("Fstat: %v", err)
+	}
+	if st.Size != int64(len("hello")) {
+		t.Errorf("got size %d, want %d", st.Size, len("hello"))
+	}
+}
@@ -12,15 +12,15 @@ import (
 	"os/exec"
 	"syscall"
 	"testing"
+	"time"
 )
 
-// See https://github.com/hanwen/go-fuse/issues/170
-func disabledTestFlock(t *testing.T) {
+func TestFlock(t *testing.T) {
 	cmd, err := exec.LookPath("flock")
 	if err != nil {
 		t.Skip("flock command not found.")
 	}
-	tc := NewTestCase(t)
+	tc := newLockTestCase(t)
 	defer tc.Cleanup()
 
 	contents := []byte{1, 2, 3}
@@ -42,6 +42,52 @@ func disabledTestFlock(t *testing.T) {
 	}
 }
 
+func TestFlockBlocks(t *testing.T) {
+	tc := newLockTestCase(t)
+	defer tc.Cleanup()
+
+	tc.WriteFile(tc.origFile, []byte{1, 2, 3}, 0700)
+
+	f1, err := os.OpenFile(tc.mountFile, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %v", tc.mountFile, err)
+	}
+	defer f1.Close()
+	f2, err := os.OpenFile(tc.mountFile, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %v", tc.mountFile, err)
+	}
+	defer f2.Close()
+
+	if err := syscall.Flock(int(f1.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatalf("Flock: %v", err)
+	}
+
+	locked := make(chan error, 1)
+	go func() {
+		locked <- syscall.Flock(int(f2.Fd()), syscall.LOCK_EX)
+	}()
+
+	select {
+	case err := <-locked:
+		t.Fatalf("second LOCK_EX did not block: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := syscall.Flock(int(f1.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatalf("Flock(LOCK_UN): %v", err)
+	}
+
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatalf("second Flock: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second LOCK_EX was not granted after unlock")
+	}
+}
+
 func runExternalFlock(flockPath, fname string) ([]byte, error) {
 	f, err := os.OpenFile(fname, os.O_WRONLY, 0)
 	if err != nil {
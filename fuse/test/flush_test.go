@@ -0,0 +1,109 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// flushErrFile fails every Flush with ENOSPC, as a file system with
+// delayed write errors might.
+type flushErrFile struct {
+	nodefs.File
+
+	mu       sync.Mutex
+	flushes  int
+	released chan struct{}
+}
+
+func (f *flushErrFile) Flush() fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushes++
+	return fuse.Status(syscall.ENOSPC)
+}
+
+func (f *flushErrFile) Release() {
+	close(f.released)
+}
+
+type flushErrNode struct {
+	nodefs.Node
+	file *flushErrFile
+}
+
+func (n *flushErrNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func (n *flushErrNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return n.file, fuse.OK
+}
+
+func TestFlushError(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	server, _, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+
+	file := &flushErrFile{
+		File:     nodefs.NewDefaultFile(),
+		released: make(chan struct{}),
+	}
+	root.Inode().NewChild("file", false, &flushErrNode{nodefs.NewDefaultNode(), file})
+
+	fd, err := syscall.Open(filepath.Join(dir, "file"), syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	dup, err := syscall.Dup(fd)
+	if err != nil {
+		t.Fatalf("Dup: %v", err)
+	}
+	if err := syscall.Close(dup); err != syscall.ENOSPC {
+		t.Errorf("Close of dup: got %v, want ENOSPC", err)
+	}
+	select {
+	case <-file.released:
+		t.Fatalf("Release before the last close")
+	default:
+	}
+	if err := syscall.Close(fd); err != syscall.ENOSPC {
+		t.Errorf("Close: got %v, want ENOSPC", err)
+	}
+
+	// RELEASE is sent asynchronously after the last close.
+	select {
+	case <-file.released:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for Release")
+	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.flushes != 2 {
+		t.Errorf("got %d flushes, want 2", file.flushes)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// dropEntries invalidates the kernel's entries for the test files.
+// This makes it evict the unused inodes, which results in
+// (BATCH_)FORGET messages.
+func dropEntries(t *testing.T, conn *nodefs.FileSystemConnector) {
+	root, _ := conn.Node(nil, "")
+	for i := 0; i < forgetFiles; i++ {
+		if code := conn.EntryNotify(root, fmt.Sprintf("file%d", i)); !code.Ok() {
+			t.Fatalf("EntryNotify: %v", code)
+		}
+	}
+}
+
+const forgetFiles = 100
+
+func forgetTest(t *testing.T, remember bool) (conn *nodefs.FileSystemConnector, mnt string, cleanup func()) {
+	dir := testutil.TempDir()
+	root := nodefs.NewMemNodeFSRoot(dir + "/backing")
+	conn = nodefs.NewFileSystemConnector(root, nil)
+	mnt = dir + "/mnt"
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := fuse.NewServer(conn.RawFS(), mnt, &fuse.MountOptions{
+		RememberInodes: remember,
+		Debug:          testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+
+	for i := 0; i < forgetFiles; i++ {
+		name := filepath.Join(mnt, fmt.Sprintf("file%d", i))
+		if err := ioutil.WriteFile(name, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := conn.InodeHandleCount(); got < forgetFiles {
+		t.Fatalf("got %d inodes, want at least %d", got, forgetFiles)
+	}
+
+	return conn, mnt, func() {
+		srv.Unmount()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestForgetBatch(t *testing.T) {
+	conn, _, clean := forgetTest(t, false)
+	defer clean()
+
+	dropEntries(t, conn)
+
+	// Forgets are delivered asynchronously.
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.InodeHandleCount() > 10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := conn.InodeHandleCount(); got > 10 {
+		t.Errorf("got %d inodes after dropping entries, want at most 10", got)
+	}
+}
+
+func TestForgetRememberInodes(t *testing.T) {
+	conn, mnt, clean := forgetTest(t, true)
+	defer clean()
+
+	before := conn.InodeHandleCount()
+	dropEntries(t, conn)
+	time.Sleep(100 * time.Millisecond)
+	if got := conn.InodeHandleCount(); got != before {
+		t.Errorf("got %d inodes after dropping entries, want %d", got, before)
+	}
+
+	// The kernel will look up the files again, which should
+	// reuse the remembered inodes.
+	if _, err := os.Stat(filepath.Join(mnt, "file0")); err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.InodeHandleCount(); got != before {
+		t.Errorf("got %d inodes after stat, want %d", got, before)
+	}
+}
+
+func TestMaxCachedInodes(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.MaxCachedInodes = 10
+	opts.Debug = testutil.VerboseTest()
+	srv, conn, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	const N = 100
+	for i := 0; i < N; i++ {
+		root.Inode().NewChild(fmt.Sprintf("file%d", i), false, &blobNode{nodefs.NewDefaultNode(), ""})
+	}
+	root.Inode().NewChild("open", false, &helloNode{nodefs.NewDefaultNode()})
+	f, err := os.Open(dir + "/open")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	for i := 0; i < N; i++ {
+		if _, err := os.Lstat(filepath.Join(dir, fmt.Sprintf("file%d", i))); err != nil {
+			t.Fatalf("Lstat: %v", err)
+		}
+	}
+
+	// Forgets are delivered asynchronously. The root counts too.
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.InodeHandleCount() > opts.MaxCachedInodes+1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := conn.InodeHandleCount(); got > opts.MaxCachedInodes+1 {
+		t.Errorf("got %d inodes, want at most %d", got, opts.MaxCachedInodes+1)
+	}
+	if _, err := f.Stat(); err != nil {
+		t.Errorf("Stat on open file: %v", err)
+	}
+	if root.Inode().GetChild("open") == nil {
+		t.Error("open file was dropped")
+	}
+}
+
+// lookupCountNode counts the lookups of names it does not have.
+type lookupCountNode struct {
+	nodefs.Node
+
+	mu      sync.Mutex
+	lookups map[string]int
+}
+
+func (n *lookupCountNode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+	n.mu.Lock()
+	n.lookups[name]++
+	n.mu.Unlock()
+	return n.Node.Lookup(out, name, context)
+}
+
+func (n *lookupCountNode) count(name string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lookups[name]
+}
+
+func TestMaxNegativeEntries(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	root := &lookupCountNode{Node: nodefs.NewDefaultNode(), lookups: map[string]int{}}
+	opts := nodefs.NewOptions()
+	opts.NegativeTimeout = time.Hour
+	opts.MaxNegativeEntries = 2
+	opts.Debug = testutil.VerboseTest()
+	srv, _, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	for _, n := range []string{"a", "b", "c"} {
+		if _, err := os.Lstat(filepath.Join(dir, n)); !os.IsNotExist(err) {
+			t.Fatalf("Lstat %s: got %v, want ENOENT", n, err)
+		}
+	}
+	// "c" is one of the two newest, and stays cached.
+	os.Lstat(filepath.Join(dir, "c"))
+	if got := root.count("c"); got != 1 {
+		t.Errorf("got %d lookups of c, want 1", got)
+	}
+
+	// "a" is purged asynchronously, after which the kernel asks
+	// again.
+	deadline := time.Now().Add(5 * time.Second)
+	for root.count("a") < 2 && time.Now().Before(deadline) {
+		os.Lstat(filepath.Join(dir, "a"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := root.count("a"); got != 2 {
+		t.Errorf("got %d lookups of a, want 2", got)
+	}
+}
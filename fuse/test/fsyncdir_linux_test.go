@@ -0,0 +1,79 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// fsyncDirNode records the flags of FsyncDir calls.
+type fsyncDirNode struct {
+	nodefs.Node
+
+	mu    sync.Mutex
+	flags []uint32
+}
+
+func (n *fsyncDirNode) FsyncDir(flags uint32, context *fuse.Context) fuse.Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.flags = append(n.flags, flags)
+	return fuse.OK
+}
+
+func TestFsyncDir(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := &fsyncDirNode{Node: nodefs.NewDefaultNode()}
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	server, _, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+	root.Inode().NewChild("sub", true, nodefs.NewDefaultNode())
+
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		t.Errorf("Sync: %v", err)
+	}
+	if err := syscall.Fdatasync(int(f.Fd())); err != nil {
+		t.Errorf("Fdatasync: %v", err)
+	}
+
+	root.mu.Lock()
+	if len(root.flags) != 2 || root.flags[0] != 0 || root.flags[1]&1 == 0 {
+		t.Errorf("got FsyncDir flags %v, want [0 1]", root.flags)
+	}
+	root.mu.Unlock()
+
+	// The default implementation succeeds.
+	sub, err := os.Open(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sub.Close()
+	if err := sub.Sync(); err != nil {
+		t.Errorf("Sync on default node: %v", err)
+	}
+}
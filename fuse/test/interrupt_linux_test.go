@@ -0,0 +1,172 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// interruptNode blocks in Read and SetLkw until the request is
+// interrupted. Calls after an interrupt succeed immediately.
+type interruptNode struct {
+	nodefs.Node
+	started chan struct{}
+
+	mu          sync.Mutex
+	interrupted int
+}
+
+func (n *interruptNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = 1024
+	return fuse.OK
+}
+
+func (n *interruptNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	// Direct I/O makes the kernel wait for our READ
+	// interruptibly.
+	return &nodefs.WithFlags{
+		File:      nodefs.NewDefaultFile(),
+		FuseFlags: fuse.FOPEN_DIRECT_IO,
+	}, fuse.OK
+}
+
+func (n *interruptNode) block(context *fuse.Context) fuse.Status {
+	n.mu.Lock()
+	done := n.interrupted > 0
+	n.mu.Unlock()
+	if done {
+		return fuse.OK
+	}
+
+	n.started <- struct{}{}
+	select {
+	case <-context.Cancel:
+		n.mu.Lock()
+		n.interrupted++
+		n.mu.Unlock()
+		return fuse.EINTR
+	case <-time.After(5 * time.Second):
+		return fuse.EIO
+	}
+}
+
+func (n *interruptNode) Read(file nodefs.File, dest []byte, off int64, context *fuse.Context) (fuse.ReadResult, fuse.Status) {
+	return nil, n.block(context)
+}
+
+func (n *interruptNode) SetLkw(file nodefs.File, owner uint64, lk *fuse.FileLock, flags uint32, context *fuse.Context) fuse.Status {
+	return n.block(context)
+}
+
+func interruptTest(t *testing.T) (name string, node *interruptNode, cleanup func()) {
+	dir := testutil.TempDir()
+	root := nodefs.NewDefaultNode()
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	node = &interruptNode{
+		Node:    nodefs.NewDefaultNode(),
+		started: make(chan struct{}, 1),
+	}
+	root.Inode().NewChild("file", false, node)
+
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		EnableLocks: true,
+		Debug:       testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	return filepath.Join(dir, "file"), node, func() {
+		srv.Unmount()
+		os.Remove(dir)
+	}
+}
+
+// interruptCall runs fn on a dedicated thread, and signals that
+// thread once the file system is blocked.
+func interruptCall(t *testing.T, node *interruptNode, fn func() error) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	defer signal.Stop(sigs)
+
+	tid := make(chan int, 1)
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		tid <- syscall.Gettid()
+		result <- fn()
+	}()
+
+	select {
+	case <-node.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the file system")
+	}
+	if err := syscall.Tgkill(os.Getpid(), <-tid, syscall.SIGUSR1); err != nil {
+		t.Fatalf("Tgkill: %v", err)
+	}
+	return <-result
+}
+
+func TestInterruptRead(t *testing.T) {
+	name, node, clean := interruptTest(t)
+	defer clean()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	err = interruptCall(t, node, func() error {
+		var buf [1024]byte
+		_, err := syscall.Read(int(f.Fd()), buf[:])
+		return err
+	})
+	if err != syscall.EINTR {
+		t.Errorf("Read: got %v, want EINTR", err)
+	}
+}
+
+func TestInterruptSetLkw(t *testing.T) {
+	name, node, clean := interruptTest(t)
+	defer clean()
+
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+
+	err = interruptCall(t, node, func() error {
+		lk := syscall.Flock_t{Type: syscall.F_WRLCK, Start: 0, Len: 10}
+		return syscall.FcntlFlock(f.Fd(), syscall.F_SETLKW, &lk)
+	})
+	// The kernel restarts lock calls after an interrupt.
+	if err != nil {
+		t.Errorf("F_SETLKW: %v", err)
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.interrupted != 1 {
+		t.Errorf("got %d interrupts, want 1", node.interrupted)
+	}
+}
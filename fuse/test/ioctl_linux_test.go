@@ -0,0 +1,92 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// _IOWR('g', 1, uint64); the kernel copies 8 bytes in and out.
+const ioctlDouble = 3<<30 | 8<<16 | 'g'<<8 | 1
+
+// ioctlFile doubles the number it is passed with ioctlDouble.
+type ioctlFile struct {
+	nodefs.File
+}
+
+func (f *ioctlFile) Ioctl(input []byte, cmd uint32, arg uint64, flags uint32) ([]byte, int32, fuse.Status) {
+	if cmd != ioctlDouble {
+		return nil, 0, fuse.Status(syscall.ENOTTY)
+	}
+	if len(input) != 8 {
+		return nil, 0, fuse.EINVAL
+	}
+	out := make([]byte, 8)
+	binary.LittleEndian.PutUint64(out, 2*binary.LittleEndian.Uint64(input))
+	return out, 42, fuse.OK
+}
+
+type ioctlNode struct {
+	nodefs.Node
+}
+
+func (n *ioctlNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func (n *ioctlNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return &ioctlFile{nodefs.NewDefaultFile()}, fuse.OK
+}
+
+func TestIoctlFile(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := nodefs.NewDefaultNode()
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	root.Inode().NewChild("ctl", false, &ioctlNode{nodefs.NewDefaultNode()})
+
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		Debug: testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	f, err := os.Open(filepath.Join(dir, "ctl"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	v := uint64(21)
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlDouble, uintptr(unsafe.Pointer(&v)))
+	if errno != 0 {
+		t.Fatalf("ioctl: %v", errno)
+	}
+	if r != 42 || v != 42 {
+		t.Errorf("ioctl: got result %d value %d, want 42 42", r, v)
+	}
+
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlDouble+1, uintptr(unsafe.Pointer(&v)))
+	if errno != syscall.ENOTTY {
+		t.Errorf("unknown ioctl: got %v, want ENOTTY", errno)
+	}
+}
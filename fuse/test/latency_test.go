@@ -0,0 +1,173 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// statusLatencyMap counts requests by opcode and status.
+type statusLatencyMap struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (m *statusLatencyMap) Add(name string, dt time.Duration) {
+	panic("Add called for a StatusLatencyMap")
+}
+
+func (m *statusLatencyMap) AddStatus(name string, status fuse.Status, dt time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[name+" "+status.String()]++
+}
+
+func (m *statusLatencyMap) count(name string, status fuse.Status) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[name+" "+status.String()]
+}
+
+// inflightNode records the number of requests in flight during
+// GetAttr.
+type inflightNode struct {
+	nodefs.Node
+	server   **fuse.Server
+	inflight int
+}
+
+func (n *inflightNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	n.inflight = (*n.server).InflightRequests()
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func TestRecordLatencies(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	var server *fuse.Server
+	probe := &inflightNode{Node: nodefs.NewDefaultNode(), server: &server}
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	opts.EntryTimeout = 0
+	opts.AttrTimeout = 0
+	opts.NegativeTimeout = 0
+	server, conn, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	lmap := &statusLatencyMap{counts: map[string]int{}}
+	server.RecordLatencies(lmap)
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+	root.Inode().NewChild("probe", false, probe)
+
+	if _, err := os.Lstat(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("Lstat(missing): got %v, want ENOENT", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "probe")); err != nil {
+		t.Fatalf("Lstat(probe): %v", err)
+	}
+
+	if got := lmap.count("LOOKUP", fuse.ENOENT); got != 1 {
+		t.Errorf("got %d failed LOOKUPs, want 1", got)
+	}
+	if got := lmap.count("LOOKUP", fuse.OK); got < 1 {
+		t.Errorf("got %d LOOKUPs, want at least 1", got)
+	}
+	if probe.inflight < 1 {
+		t.Errorf("got %d requests in flight during GetAttr, want at least 1", probe.inflight)
+	}
+	if got := conn.InodeHandleCount(); got < 1 {
+		t.Errorf("got inode count %d, want at least 1", got)
+	}
+}
+
+// blockingOpenNode blocks Open until release is closed.
+type blockingOpenNode struct {
+	nodefs.Node
+	release chan struct{}
+}
+
+func (n *blockingOpenNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func (n *blockingOpenNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	<-n.release
+	return nodefs.NewDataFile(nil), fuse.OK
+}
+
+func TestInflightRequests(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	server, conn, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+
+	node := &blockingOpenNode{nodefs.NewDefaultNode(), make(chan struct{})}
+	sub := root.Inode().NewChild("dir", true, nodefs.NewDefaultNode())
+	sub.NewChild("slow", false, node)
+
+	done := make(chan error, 1)
+	go func() {
+		f, err := os.Open(filepath.Join(dir, "dir", "slow"))
+		if err == nil {
+			f.Close()
+		}
+		done <- err
+	}()
+
+	var found *nodefs.InflightRequest
+	for i := 0; found == nil && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		for _, r := range conn.InflightRequests() {
+			if r.Opcode == "OPEN" {
+				r := r
+				found = &r
+			}
+		}
+	}
+	close(node.release)
+	if err := <-done; err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if found == nil {
+		t.Fatal("OPEN not listed while in flight")
+	}
+	if found.Path != "dir/slow" {
+		t.Errorf("got path %q, want %q", found.Path, "dir/slow")
+	}
+	if found.Age <= 0 {
+		t.Errorf("got age %v, want > 0", found.Age)
+	}
+	if found.Caller.Pid == 0 {
+		t.Error("caller not set")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// lockFile pretends that some other process holds a write lock on
+// the whole file, and records the locks it is asked to take.
+type lockFile struct {
+	nodefs.File
+
+	mu    sync.Mutex
+	setLk []fuse.FileLock
+	setLw []fuse.FileLock
+}
+
+func (f *lockFile) GetLk(owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) fuse.Status {
+	*out = fuse.FileLock{
+		Start: 0,
+		End:   fuse.OFFSET_MAX,
+		Typ:   syscall.F_WRLCK,
+		Pid:   uint32(os.Getpid()),
+	}
+	return fuse.OK
+}
+
+func (f *lockFile) SetLk(owner uint64, lk *fuse.FileLock, flags uint32) fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setLk = append(f.setLk, *lk)
+	if lk.Typ == syscall.F_UNLCK {
+		return fuse.OK
+	}
+	return fuse.EAGAIN
+}
+
+func (f *lockFile) SetLkw(owner uint64, lk *fuse.FileLock, flags uint32) fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setLw = append(f.setLw, *lk)
+	return fuse.OK
+}
+
+type lockNode struct {
+	nodefs.Node
+	file *lockFile
+}
+
+func (n *lockNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func (n *lockNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return n.file, fuse.OK
+}
+
+func lockTest(t *testing.T, enable bool) (mnt string, file *lockFile, cleanup func()) {
+	dir := testutil.TempDir()
+	root := nodefs.NewDefaultNode()
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	file = &lockFile{File: nodefs.NewDefaultFile()}
+	root.Inode().NewChild("file", false, &lockNode{
+		Node: nodefs.NewDefaultNode(),
+		file: file,
+	})
+
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		EnableLocks: enable,
+		Debug:       testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	if enable && srv.KernelSettings().Flags&fuse.CAP_POSIX_LOCKS == 0 {
+		srv.Unmount()
+		os.Remove(dir)
+		t.Skip("Kernel does not support POSIX locks")
+	}
+	return dir, file, func() {
+		srv.Unmount()
+		os.Remove(dir)
+	}
+}
+
+func TestPosixLocks(t *testing.T) {
+	mnt, file, clean := lockTest(t, true)
+	defer clean()
+
+	f, err := os.OpenFile(filepath.Join(mnt, "file"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+
+	lk := syscall.Flock_t{Type: syscall.F_RDLCK, Start: 0, Len: 10}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lk); err != nil {
+		t.Fatalf("F_GETLK: %v", err)
+	}
+	if lk.Type != syscall.F_WRLCK || lk.Start != 0 || lk.Len != 0 {
+		t.Errorf("F_GETLK: got %+v, want whole file write lock", lk)
+	}
+
+	lk = syscall.Flock_t{Type: syscall.F_WRLCK, Start: 10, Len: 10}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk); err != syscall.EAGAIN {
+		t.Errorf("F_SETLK: got %v, want EAGAIN", err)
+	}
+
+	lk = syscall.Flock_t{Type: syscall.F_WRLCK, Start: 10, Len: 10}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLKW, &lk); err != nil {
+		t.Errorf("F_SETLKW: %v", err)
+	}
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	want := fuse.FileLock{Start: 10, End: 19, Typ: syscall.F_WRLCK}
+	if len(file.setLk) == 0 || file.setLk[0].Start != want.Start ||
+		file.setLk[0].End != want.End || file.setLk[0].Typ != want.Typ {
+		t.Errorf("SetLk: got %v, want %v", file.setLk, want)
+	}
+	if len(file.setLw) != 1 || file.setLw[0].Start != want.Start ||
+		file.setLw[0].End != want.End || file.setLw[0].Typ != want.Typ {
+		t.Errorf("SetLkw: got %v, want %v", file.setLw, want)
+	}
+}
+
+func TestPosixLocksDisabled(t *testing.T) {
+	mnt, file, clean := lockTest(t, false)
+	defer clean()
+
+	f, err := os.OpenFile(filepath.Join(mnt, "file"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+
+	// Without EnableLocks, the kernel handles locks locally.
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Start: 0, Len: 10}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk); err != nil {
+		t.Errorf("F_SETLK: %v", err)
+	}
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if len(file.setLk) != 0 || len(file.setLw) != 0 {
+		t.Errorf("got lock calls %v %v, want none", file.setLk, file.setLw)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestDebugPaths(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	var out lockedBuffer
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = true
+	opts.Logger = log.New(&out, "", 0)
+	server, _, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+
+	sub := root.Inode().NewChild("sub", true, nodefs.NewDefaultNode())
+	sub.NewChild("file", false, &blobNode{nodefs.NewDefaultNode(), "content"})
+	if err := syscall.Setxattr(filepath.Join(dir, "sub", "file"), "user.secret", []byte("hunter2"), 0); err == nil {
+		t.Fatal("Setxattr succeeded")
+	}
+
+	got := out.String()
+	for _, want := range []string{`names: [sub] 4 bytes path: ""`, `SETXATTR`, `path: "sub/file"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "hunter2") {
+		t.Errorf("log contains xattr value:\n%s", got)
+	}
+}
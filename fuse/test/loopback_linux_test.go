@@ -5,6 +5,7 @@
 package test
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"syscall"
@@ -43,6 +44,33 @@ func TestTouch(t *testing.T) {
 	}
 }
 
+func TestNanosecondTimes(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+
+	if err := ioutil.WriteFile(ts.origFile, []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	atime := time.Unix(1500000000, 123456789)
+	mtime := time.Unix(1500000001, 987654321)
+	if err := os.Chtimes(ts.mountFile, atime, mtime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	for _, p := range []string{ts.origFile, ts.mountFile} {
+		var st syscall.Stat_t
+		if err := syscall.Lstat(p, &st); err != nil {
+			t.Fatalf("Lstat failed: %v", err)
+		}
+		if got := time.Unix(st.Atim.Unix()); !got.Equal(atime) {
+			t.Errorf("%s: got atime %v, want %v", p, got, atime)
+		}
+		if got := time.Unix(st.Mtim.Unix()); !got.Equal(mtime) {
+			t.Errorf("%s: got mtime %v, want %v", p, got, mtime)
+		}
+	}
+}
+
 func TestNegativeTime(t *testing.T) {
 	ts := NewTestCase(t)
 	defer ts.Cleanup()
@@ -145,6 +173,133 @@ func TestFallocate(t *testing.T) {
 	}
 }
 
+func TestFallocatePunchHole(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+	if ts.state.KernelSettings().Minor < 19 {
+		t.Log("FUSE does not support Fallocate.")
+		return
+	}
+
+	content := bytes.Repeat([]byte{'x'}, 8192)
+	ts.WriteFile(ts.orig+"/file", content, 0644)
+
+	rwFile, err := os.OpenFile(ts.mnt+"/file", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer rwFile.Close()
+
+	// The mode flags must reach the underlying file unchanged.
+	err = syscall.Fallocate(int(rwFile.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, 0, 4096)
+	if err == syscall.EOPNOTSUPP {
+		t.Skip("backing file system does not support hole punching")
+	} else if err != nil {
+		t.Fatalf("FUSE Fallocate failed: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(ts.orig + "/file")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if len(got) != len(content) {
+		t.Fatalf("KEEP_SIZE: got size %d, want %d", len(got), len(content))
+	}
+	if !bytes.Equal(got[:4096], make([]byte, 4096)) || !bytes.Equal(got[4096:], content[4096:]) {
+		t.Errorf("hole was not punched at [0, 4096)")
+	}
+}
+
+func TestLseekDataHole(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+	if ts.state.KernelSettings().Minor < 24 {
+		t.Skip("FUSE does not support LSEEK.")
+	}
+
+	// A file with a hole, data at [1M, 1M+4k), and a hole up to 2M.
+	f, err := os.Create(ts.origFile)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.WriteAt(bytes.Repeat([]byte{'x'}, 4096), 1<<20); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if err := f.Truncate(2 << 20); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	defer f.Close()
+
+	mf, err := os.Open(ts.mountFile)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer mf.Close()
+
+	for _, c := range []struct {
+		off    int64
+		whence int
+	}{
+		{0, unix.SEEK_DATA},
+		{0, unix.SEEK_HOLE},
+		{1 << 20, unix.SEEK_HOLE},
+	} {
+		want, err := unix.Seek(int(f.Fd()), c.off, c.whence)
+		if err != nil {
+			t.Fatalf("Seek(%d, %d) on backing file: %v", c.off, c.whence, err)
+		}
+		got, err := unix.Seek(int(mf.Fd()), c.off, c.whence)
+		if err != nil {
+			t.Fatalf("Seek(%d, %d): %v", c.off, c.whence, err)
+		}
+		if got != want {
+			t.Errorf("Seek(%d, %d): got %d, want %d", c.off, c.whence, got, want)
+		}
+	}
+}
+
+func TestCopyFileRange(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+	if ts.state.KernelSettings().Minor < 28 {
+		t.Skip("FUSE does not support COPY_FILE_RANGE.")
+	}
+
+	content := make([]byte, 3*4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	ts.WriteFile(ts.origFile, content, 0644)
+
+	src, err := os.Open(ts.mountFile)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer src.Close()
+	dst, err := os.Create(ts.mnt + "/dst")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer dst.Close()
+
+	srcOff, dstOff := int64(4096), int64(0)
+	n, err := unix.CopyFileRange(int(src.Fd()), &srcOff, int(dst.Fd()), &dstOff, 2*4096, 0)
+	if err != nil {
+		t.Fatalf("CopyFileRange failed: %v", err)
+	}
+	if n != 2*4096 {
+		t.Fatalf("CopyFileRange: got %d bytes, want %d", n, 2*4096)
+	}
+
+	got, err := ioutil.ReadFile(ts.orig + "/dst")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !bytes.Equal(got, content[4096:]) {
+		t.Errorf("copied data mismatch")
+	}
+}
+
 // Check that "." and ".." exists. unix.Getdents is linux specific.
 func TestSpecialEntries(t *testing.T) {
 	tc := NewTestCase(t)
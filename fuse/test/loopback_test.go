@@ -65,6 +65,16 @@ func (tc *testCase) WriteFile(name string, content []byte, mode os.FileMode) {
 
 // Create and mount filesystem.
 func NewTestCase(t *testing.T) *testCase {
+	return newTestCase(t, false)
+}
+
+// newLockTestCase is like NewTestCase, but passes file locks to the
+// file system, see fuse.MountOptions.EnableLocks.
+func newLockTestCase(t *testing.T) *testCase {
+	return newTestCase(t, true)
+}
+
+func newTestCase(t *testing.T, enableLocks bool) *testCase {
 	tc := &testCase{}
 	tc.tester = t
 
@@ -104,6 +114,7 @@ func NewTestCase(t *testing.T) *testCase {
 	tc.state, err = fuse.NewServer(
 		fuse.NewRawFileSystem(tc.connector.RawFS()), tc.mnt, &fuse.MountOptions{
 			SingleThreaded: true,
+			EnableLocks:    enableLocks,
 			Debug:          testutil.VerboseTest(),
 		})
 	if err != nil {
@@ -605,6 +616,42 @@ func TestFSync(t *testing.T) {
 	}
 }
 
+// TestTruncateDeleted checks that ftruncate goes to the open file,
+// as the path is gone.
+func TestTruncateDeleted(t *testing.T) {
+	tc := NewTestCase(t)
+	defer tc.Cleanup()
+
+	tc.WriteFile(tc.origFile, []byte("hello there"), 0644)
+	f, err := os.OpenFile(tc.mountFile, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %v", tc.mountFile, err)
+	}
+	defer f.Close()
+	if err := os.Remove(tc.mountFile); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	if err := f.Truncate(5); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if fi.Size() != 5 {
+		t.Errorf("got size %d, want 5", fi.Size())
+	}
+	buf := make([]byte, 20)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if got := string(buf[:n]); got != "hello" {
+		t.Errorf("got content %q, want %q", got, "hello")
+	}
+}
+
 func TestReadZero(t *testing.T) {
 	tc := NewTestCase(t)
 	defer tc.Cleanup()
@@ -943,6 +990,31 @@ func TestUmask(t *testing.T) {
 	}
 }
 
+// TestUmaskCreate checks that the kernel applies the umask for
+// CREATE and MKNOD as it does for MKDIR.
+func TestUmaskCreate(t *testing.T) {
+	tc := NewTestCase(t)
+	defer tc.Cleanup()
+
+	mask := 027
+	for _, c := range []string{"touch file", "mkfifo fifo"} {
+		cmd := exec.Command("/bin/sh", "-c",
+			fmt.Sprintf("cd %s && umask %o && %s", tc.mnt, mask, c))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v, %s", c, err, out)
+		}
+	}
+	for _, n := range []string{"file", "fifo"} {
+		fi, err := os.Lstat(tc.mnt + "/" + n)
+		if err != nil {
+			t.Fatalf("Lstat failed: %v", err)
+		}
+		if got, want := int(fi.Mode().Perm()), 0666&^mask; got != want {
+			t.Errorf("%s: got mode %o, want %o", n, got, want)
+		}
+	}
+}
+
 // Check that chgrp(1) works
 func TestChgrp(t *testing.T) {
 	tc := NewTestCase(t)
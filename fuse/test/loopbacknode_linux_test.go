@@ -0,0 +1,96 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestLoopbackNodeXAttr(t *testing.T) {
+	orig, mnt, clean := loopbackNodeTest(t, false)
+	defer clean()
+
+	if err := ioutil.WriteFile(filepath.Join(orig, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(filepath.Join(orig, "file"), "user.probe", []byte("x"), 0); err != nil {
+		t.Skipf("host does not support user xattrs: %v", err)
+	}
+
+	fn := filepath.Join(mnt, "file")
+	if err := syscall.Setxattr(fn, "user.attr", []byte("value"), 0); err != nil {
+		t.Fatalf("Setxattr: %v", err)
+	}
+	buf := make([]byte, 100)
+	sz, err := syscall.Getxattr(fn, "user.attr", buf)
+	if err != nil || string(buf[:sz]) != "value" {
+		t.Errorf("Getxattr: got %q, %v", buf[:sz], err)
+	}
+	sz, err = syscall.Listxattr(fn, buf)
+	if err != nil || string(buf[:sz]) != "user.probe\x00user.attr\x00" {
+		t.Errorf("Listxattr: got %q, %v", buf[:sz], err)
+	}
+	if err := syscall.Removexattr(fn, "user.attr"); err != nil {
+		t.Errorf("Removexattr: %v", err)
+	}
+	if _, err := syscall.Getxattr(filepath.Join(orig, "file"), "user.attr", buf); err != syscall.ENODATA {
+		t.Errorf("Getxattr after remove: got %v, want ENODATA", err)
+	}
+}
+
+// TestLoopbackNodeAsCaller checks that files are created with the
+// credentials of the caller.
+func TestLoopbackNodeAsCaller(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to switch users")
+	}
+	orig, mnt, clean := loopbackNodeTest(t, true)
+	defer clean()
+
+	const other = 4242
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	syscall.Setfsgid(other)
+	syscall.Setfsuid(other)
+	fd, err := syscall.Open(filepath.Join(mnt, "file"), syscall.O_CREAT|syscall.O_WRONLY, 0644)
+	if err == nil {
+		syscall.Close(fd)
+	}
+	mkdirErr := syscall.Mkdir(filepath.Join(mnt, "sub"), 0755)
+	subErr := syscall.Mkdir(filepath.Join(mnt, "sub", "x"), 0755)
+	syscall.Setfsuid(0)
+	syscall.Setfsgid(0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if mkdirErr != nil || subErr != nil {
+		t.Fatalf("Mkdir: %v, %v", mkdirErr, subErr)
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Lstat(filepath.Join(orig, "file"), &st); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+	if st.Uid != other || st.Gid != other {
+		t.Errorf("got owner %d:%d, want %d:%d", st.Uid, st.Gid, other, other)
+	}
+
+	// The host checks permissions as the caller, so a third user
+	// may not write into the directory.
+	os.Chmod(filepath.Join(orig, "sub"), 0755)
+	syscall.Setfsgid(other + 1)
+	syscall.Setfsuid(other + 1)
+	err = syscall.Mkdir(filepath.Join(mnt, "sub", "y"), 0755)
+	syscall.Setfsuid(0)
+	syscall.Setfsgid(0)
+	if err != syscall.EACCES {
+		t.Errorf("Mkdir as other user: got %v, want EACCES", err)
+	}
+}
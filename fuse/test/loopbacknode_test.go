@@ -0,0 +1,138 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// loopbackNodeTest mounts a node-based loopback of orig on mnt.
+func loopbackNodeTest(t *testing.T, asCaller bool) (orig, mnt string, cleanup func()) {
+	dir := testutil.TempDir()
+	// Other users must be able to reach orig and mnt.
+	os.Chmod(dir, 0755)
+	orig = filepath.Join(dir, "orig")
+	mnt = filepath.Join(dir, "mnt")
+	if err := os.Mkdir(orig, 0777); err != nil {
+		t.Fatal(err)
+	}
+	// Mkdir is subject to the umask.
+	os.Chmod(orig, 0777)
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := nodefs.NewOptions()
+	opts.Owner = nil
+	opts.Debug = testutil.VerboseTest()
+	server, _, err := nodefs.MountRoot(mnt, nodefs.NewLoopbackNodeFSRoot(orig, asCaller), opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	return orig, mnt, func() {
+		server.Unmount()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLoopbackNode(t *testing.T) {
+	orig, mnt, clean := loopbackNodeTest(t, false)
+	defer clean()
+
+	if err := ioutil.WriteFile(filepath.Join(orig, "existing"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(mnt, "existing")); err != nil || string(got) != "hello" {
+		t.Errorf("ReadFile(existing): got %q, %v", got, err)
+	}
+
+	if err := os.Mkdir(filepath.Join(mnt, "dir"), 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(mnt, "dir", "file"), []byte("content"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(orig, "dir", "file")); err != nil || string(got) != "content" {
+		t.Errorf("ReadFile(orig): got %q, %v", got, err)
+	}
+
+	if err := os.Symlink("dir/file", filepath.Join(mnt, "link")); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if got, err := os.Readlink(filepath.Join(mnt, "link")); err != nil || got != "dir/file" {
+		t.Errorf("Readlink: got %q, %v", got, err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(mnt, "link")); err != nil || string(got) != "content" {
+		t.Errorf("ReadFile(link): got %q, %v", got, err)
+	}
+
+	// The inode tree must follow renames of directories.
+	if err := os.Rename(filepath.Join(mnt, "dir"), filepath.Join(mnt, "moved")); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(mnt, "moved", "file")); err != nil || string(got) != "content" {
+		t.Errorf("ReadFile(moved/file): got %q, %v", got, err)
+	}
+	if err := os.Link(filepath.Join(mnt, "moved", "file"), filepath.Join(mnt, "hardlink")); err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Lstat(filepath.Join(mnt, "hardlink"), &st); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	} else if st.Nlink != 2 {
+		t.Errorf("got nlink %d, want 2", st.Nlink)
+	}
+	var origSt syscall.Stat_t
+	if err := syscall.Lstat(filepath.Join(orig, "hardlink"), &origSt); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	} else if st.Ino != origSt.Ino {
+		t.Errorf("got ino %d, want host ino %d", st.Ino, origSt.Ino)
+	}
+
+	f, err := os.Open(mnt)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Readdirnames: %v", err)
+	}
+	sort.Strings(names)
+	want := []string{"existing", "hardlink", "link", "moved"}
+	if len(names) != len(want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("got %v, want %v", names, want)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(mnt, "hardlink")); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := os.Remove(filepath.Join(mnt, "moved", "file")); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := os.Remove(filepath.Join(mnt, "moved")); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(orig, "moved")); !os.IsNotExist(err) {
+		t.Errorf("Lstat(orig/moved): got %v, want ENOENT", err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// maxWriteFS records the largest write it receives.
+type maxWriteFS struct {
+	pathfs.FileSystem
+
+	mu  sync.Mutex
+	max int
+}
+
+type maxWriteFile struct {
+	nodefs.File
+	fs *maxWriteFS
+}
+
+func (f *maxWriteFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	f.fs.mu.Lock()
+	if len(data) > f.fs.max {
+		f.fs.max = len(data)
+	}
+	f.fs.mu.Unlock()
+	return f.File.Write(data, off)
+}
+
+func (fs *maxWriteFS) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	f, code := fs.FileSystem.Create(name, flags, mode, context)
+	if !code.Ok() {
+		return nil, code
+	}
+	return &maxWriteFile{File: f, fs: fs}, code
+}
+
+func TestMaxWrite(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	orig := filepath.Join(dir, "orig")
+	mnt := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(orig, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &maxWriteFS{FileSystem: pathfs.NewLoopbackFileSystem(orig)}
+	pfs := pathfs.NewPathNodeFs(fs, nil)
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	srv, err := fuse.NewServer(conn.RawFS(), mnt, &fuse.MountOptions{
+		MaxWrite:     1 << 20,
+		MaxReadAhead: 1 << 16,
+		Debug:        testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	if ra := srv.KernelSettings().MaxReadAhead; ra > 1<<16 {
+		t.Errorf("got MaxReadAhead %d, want at most %d", ra, 1<<16)
+	}
+
+	want := fuse.MAX_KERNEL_WRITE
+	if srv.KernelSettings().Flags&fuse.CAP_MAX_PAGES != 0 {
+		want = 1 << 20
+	}
+	if got := srv.MaxWrite(); got != want {
+		t.Errorf("got MaxWrite %d, want %d", got, want)
+	}
+
+	content := bytes.Repeat([]byte("abcdefgh"), (1<<20)/8)
+	if err := ioutil.WriteFile(filepath.Join(mnt, "file"), content, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(orig, "file"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("content mismatch: got %d bytes, want %d", len(got), len(content))
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.max > want {
+		t.Errorf("got write of %d bytes, want at most %d", fs.max, want)
+	}
+	if fs.max <= fuse.MAX_KERNEL_WRITE/2 {
+		t.Errorf("got writes of at most %d bytes, want larger writes", fs.max)
+	}
+}
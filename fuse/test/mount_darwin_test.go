@@ -0,0 +1,50 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// TestMountRead mounts through the macFUSE or OSXFUSE mount helper,
+// and reads a file.
+func TestMountRead(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	conn := nodefs.NewFileSystemConnector(root, opts)
+	server, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		Name:    "gofusetest",
+		Options: []string{"local"},
+		Debug:   testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+
+	root.Inode().NewChild("hello", false, &helloNode{nodefs.NewDefaultNode()})
+	got, err := ioutil.ReadFile(filepath.Join(dir, "hello"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+}
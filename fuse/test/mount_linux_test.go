@@ -0,0 +1,151 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+	"golang.org/x/sys/unix"
+)
+
+type contextRecordingNode struct {
+	nodefs.Node
+
+	mu       sync.Mutex
+	contexts []fuse.Context
+}
+
+func (n *contextRecordingNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if context != nil {
+		n.contexts = append(n.contexts, *context)
+	} else {
+		n.contexts = append(n.contexts, fuse.Context{})
+	}
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func TestMountRootContext(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	root := nodefs.NewDefaultNode()
+	s, conn, err := nodefs.MountRoot(dir, root, nil)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go s.Serve()
+	if err := s.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer s.Unmount()
+
+	sub := &contextRecordingNode{Node: nodefs.NewDefaultNode()}
+	if code := conn.Mount(root.Inode(), "sub", sub, nil); !code.Ok() {
+		t.Fatalf("Mount: %v", code)
+	}
+
+	// The kernel reports the ID of the calling thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tid := syscall.Gettid()
+	if _, err := os.Lstat(filepath.Join(dir, "sub")); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+
+	// Other processes may look at the mount too, so only require
+	// that our own stat was seen.
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	for _, c := range sub.contexts {
+		if c.Pid == uint32(tid) && c.Uid == uint32(os.Getuid()) {
+			return
+		}
+	}
+	t.Errorf("got contexts %+v, want one with pid %d uid %d", sub.contexts, tid, os.Getuid())
+}
+
+func TestMountFsNameSubtype(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	srv, err := fuse.NewServer(fuse.NewDefaultRawFileSystem(), dir, &fuse.MountOptions{
+		FsName: "mysource",
+		Name:   "myfs",
+		Debug:  testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	mounts, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	want := "mysource " + dir + " fuse.myfs "
+	if !strings.Contains(string(mounts), want) {
+		t.Errorf("/proc/mounts has no line starting with %q:\n%s", want, mounts)
+	}
+}
+
+func TestMountUnknownOption(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	srv, err := fuse.NewServer(fuse.NewDefaultRawFileSystem(), dir, &fuse.MountOptions{
+		Options: []string{"frobnicate"},
+	})
+	if err == nil {
+		srv.Unmount()
+		t.Fatal("NewServer succeeded with unknown option")
+	}
+	if !strings.Contains(err.Error(), "frobnicate") {
+		t.Errorf("got error %v, want one naming the option", err)
+	}
+}
+
+func TestMountRootReadOnly(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	opts := nodefs.NewOptions()
+	opts.ReadOnly = true
+	s, _, err := nodefs.MountRoot(dir, nodefs.NewMemNodeFSRoot(dir+"-backing"), opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go s.Serve()
+	if err := s.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer s.Unmount()
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		t.Fatalf("Statfs: %v", err)
+	}
+	if st.Flags&unix.ST_RDONLY == 0 {
+		t.Errorf("got mount flags %x, want ST_RDONLY", st.Flags)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err == nil || err.(*os.PathError).Err != syscall.EROFS {
+		t.Errorf("Mkdir: got %v, want EROFS", err)
+	}
+}
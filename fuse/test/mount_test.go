@@ -7,8 +7,10 @@ package test
 import (
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -47,6 +49,92 @@ func TestMountOnExisting(t *testing.T) {
 	}
 }
 
+func TestMountOnStale(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+
+	if err := ioutil.WriteFile(ts.orig+"/mnt", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := os.Lstat(ts.mnt + "/mnt"); err != nil {
+		t.Fatalf("Lstat: %v", err)
+	}
+	// The Inode for mnt stays, but the entry is gone.
+	if err := os.Remove(ts.orig + "/mnt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	// The kernel still holds a lookup count.
+	if code := ts.connector.Mount(ts.rootNode(), "mnt", nodefs.NewDefaultNode(), nil); code != fuse.EBUSY {
+		t.Fatalf("Mount over looked up Inode: got %v, want EBUSY", code)
+	}
+	// Once the kernel forgets it, the Inode is only cached.
+	ts.connector.EntryNotify(ts.rootNode(), "mnt")
+	code := ts.connector.Mount(ts.rootNode(), "mnt", nodefs.NewDefaultNode(), nil)
+	for deadline := time.Now().Add(5 * time.Second); code == fuse.EBUSY && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		code = ts.connector.Mount(ts.rootNode(), "mnt", nodefs.NewDefaultNode(), nil)
+	}
+	if !code.Ok() {
+		t.Fatalf("Mount over stale Inode: %v", code)
+	}
+	defer ts.pathFs.Unmount("mnt")
+
+	// Mount makes the kernel forget the old entry asynchronously.
+	fi, err := os.Lstat(ts.mnt + "/mnt")
+	deadline := time.Now().Add(5 * time.Second)
+	for (err != nil || !fi.IsDir()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		fi, err = os.Lstat(ts.mnt + "/mnt")
+	}
+	if err != nil || !fi.IsDir() {
+		t.Errorf("Lstat: got %v, %v, want directory", fi, err)
+	}
+}
+
+func TestMountOnNegative(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	root := nodefs.NewMemNodeFSRoot(dir + "/")
+	opts := nodefs.NewOptions()
+	opts.NegativeTimeout = time.Hour
+	opts.Debug = testutil.VerboseTest()
+	mnt := dir + "/mnt"
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+	state, conn, err := nodefs.MountRoot(mnt, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	defer state.Unmount()
+	go state.Serve()
+	if err := state.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+
+	if _, err := os.Lstat(mnt + "/sub"); !os.IsNotExist(err) {
+		t.Fatalf("Lstat: got %v, want ENOENT", err)
+	}
+	if code := conn.Mount(root.Inode(), "sub", nodefs.NewDefaultNode(), nil); !code.Ok() {
+		t.Fatalf("Mount: %v", code)
+	}
+	// The kernel would otherwise keep the negative entry for an
+	// hour. Mount sends the notification asynchronously.
+	fi, err := os.Lstat(mnt + "/sub")
+	deadline := time.Now().Add(5 * time.Second)
+	for (err != nil || !fi.IsDir()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		fi, err = os.Lstat(mnt + "/sub")
+	}
+	if err != nil || !fi.IsDir() {
+		t.Errorf("Lstat: got %v, %v, want directory", fi, err)
+	}
+	if code := conn.Unmount(root.Inode().GetChild("sub")); !code.Ok() {
+		t.Errorf("Unmount: %v", code)
+	}
+}
+
 func TestMountRename(t *testing.T) {
 	ts := NewTestCase(t)
 	defer ts.Cleanup()
@@ -63,6 +151,196 @@ func TestMountRename(t *testing.T) {
 	ts.pathFs.Unmount("mnt")
 }
 
+func TestMountRenameCrossMount(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+
+	sub := testutil.TempDir()
+	defer os.RemoveAll(sub)
+	fs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(sub), nil)
+	code := ts.connector.Mount(ts.rootNode(), "mnt", fs.Root(), nil)
+	if !code.Ok() {
+		t.Fatal("mount should succeed")
+	}
+	defer ts.pathFs.Unmount("mnt")
+
+	if err := ioutil.WriteFile(ts.mnt+"/file", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	err := os.Rename(ts.mnt+"/file", ts.mnt+"/mnt/file")
+	if fuse.ToStatus(err) != fuse.EXDEV {
+		t.Errorf("rename into submount should fail with EXDEV: %v", err)
+	}
+	if _, err := os.Lstat(ts.orig + "/file"); err != nil {
+		t.Errorf("Lstat failed: %v", err)
+	}
+	if _, err := os.Lstat(sub + "/file"); err == nil {
+		t.Errorf("file should not appear in submount")
+	}
+
+	if err := ioutil.WriteFile(ts.mnt+"/mnt/other", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	err = os.Rename(ts.mnt+"/mnt/other", ts.mnt+"/other")
+	if fuse.ToStatus(err) != fuse.EXDEV {
+		t.Errorf("rename out of submount should fail with EXDEV: %v", err)
+	}
+}
+
+func TestListMounts(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+
+	if got := ts.connector.ListMounts(); len(got) != 0 {
+		t.Fatalf("got mounts %v, want none", got)
+	}
+
+	outer := nodefs.NewDefaultNode()
+	if code := ts.connector.Mount(ts.rootNode(), "outer", outer, nodefs.NewOptions()); !code.Ok() {
+		t.Fatal("Mount outer:", code)
+	}
+	inner := nodefs.NewDefaultNode()
+	if code := ts.connector.Mount(outer.Inode(), "inner", inner, nil); !code.Ok() {
+		t.Fatal("Mount inner:", code)
+	}
+
+	got := ts.connector.ListMounts()
+	if len(got) != 2 || got[0].Path != "outer" || got[0].Root != outer.Inode() ||
+		got[1].Path != "outer/inner" || got[1].Root != inner.Inode() {
+		t.Fatalf("got mounts %v, want outer and outer/inner", got)
+	}
+
+	// Unmount everything, innermost first.
+	for i := len(got) - 1; i >= 0; i-- {
+		if code := ts.connector.Unmount(got[i].Root); !code.Ok() {
+			t.Errorf("Unmount %q: %v", got[i].Path, code)
+		}
+	}
+	if got := ts.connector.ListMounts(); len(got) != 0 {
+		t.Errorf("got mounts %v after unmount, want none", got)
+	}
+}
+
+// unmountNode is a file system root that records OnUnmount calls.
+type unmountNode struct {
+	nodefs.Node
+	unmounted chan struct{}
+}
+
+func (n *unmountNode) OnUnmount() {
+	close(n.unmounted)
+}
+
+type helloNode struct {
+	nodefs.Node
+}
+
+func (n *helloNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = uint64(len("hello"))
+	return fuse.OK
+}
+
+func (n *helloNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return nodefs.NewDataFile([]byte("hello")), fuse.OK
+}
+
+func TestUnmountLazy(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+
+	root := &unmountNode{
+		Node:      nodefs.NewDefaultNode(),
+		unmounted: make(chan struct{}),
+	}
+	if code := ts.connector.Mount(ts.rootNode(), "mnt", root, nodefs.NewOptions()); !code.Ok() {
+		t.Fatal("Mount:", code)
+	}
+	root.Inode().NewChild("file", false, &helloNode{nodefs.NewDefaultNode()})
+
+	f, err := os.Open(ts.mnt + "/mnt/file")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if code := ts.connector.Unmount(root.Inode()); code != fuse.EBUSY {
+		t.Fatalf("Unmount with open files: got %v, want EBUSY", code)
+	}
+	if code := ts.connector.UnmountFlags(root.Inode(), nodefs.UNMOUNT_LAZY); !code.Ok() {
+		t.Fatalf("lazy Unmount: %v", code)
+	}
+	if _, err := os.Lstat(ts.mnt + "/mnt"); !os.IsNotExist(err) {
+		t.Errorf("Lstat after lazy unmount: got %v, want ENOENT", err)
+	}
+
+	// The open file keeps working.
+	buf := make([]byte, 10)
+	n, err := f.ReadAt(buf, 0)
+	if string(buf[:n]) != "hello" {
+		t.Errorf("ReadAt: got %q, %v, want \"hello\"", buf[:n], err)
+	}
+	select {
+	case <-root.unmounted:
+		t.Fatal("OnUnmount called while files are open")
+	default:
+	}
+
+	f.Close()
+	select {
+	case <-root.unmounted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnUnmount not called after closing the last file")
+	}
+}
+
+func TestUnmountForce(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+
+	outer := nodefs.NewDefaultNode()
+	if code := ts.connector.Mount(ts.rootNode(), "outer", outer, nodefs.NewOptions()); !code.Ok() {
+		t.Fatal("Mount outer:", code)
+	}
+	dir := outer.Inode().NewChild("dir", true, nodefs.NewDefaultNode())
+	if code := ts.connector.Mount(dir, "inner", nodefs.NewDefaultNode(), nil); !code.Ok() {
+		t.Fatal("Mount inner:", code)
+	}
+
+	if code := ts.connector.Unmount(outer.Inode()); code != fuse.EBUSY {
+		t.Fatalf("Unmount with submounts: got %v, want EBUSY", code)
+	}
+
+	// An open file fails the unmount before any submount is
+	// unmounted.
+	outer.Inode().NewChild("file", false, &helloNode{nodefs.NewDefaultNode()})
+	f, err := os.Open(ts.mnt + "/outer/file")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if code := ts.connector.UnmountFlags(outer.Inode(), nodefs.UNMOUNT_FORCE); code != fuse.EBUSY {
+		t.Errorf("forced Unmount with open files: got %v, want EBUSY", code)
+	}
+	if got := ts.connector.ListMounts(); len(got) != 2 {
+		t.Errorf("got mounts %v after failed forced unmount, want 2", got)
+	}
+	f.Close()
+
+	// The kernel releases the file asynchronously.
+	code := ts.connector.UnmountFlags(outer.Inode(), nodefs.UNMOUNT_FORCE)
+	for deadline := time.Now().Add(5 * time.Second); code == fuse.EBUSY && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		code = ts.connector.UnmountFlags(outer.Inode(), nodefs.UNMOUNT_FORCE)
+	}
+	if !code.Ok() {
+		t.Fatalf("forced Unmount: %v", code)
+	}
+	if got := ts.connector.ListMounts(); len(got) != 0 {
+		t.Errorf("got mounts %v after forced unmount, want none", got)
+	}
+}
+
 func TestMountReaddir(t *testing.T) {
 	ts := NewTestCase(t)
 	defer ts.Cleanup()
@@ -223,3 +501,83 @@ func TestLiveness(t *testing.T) {
 		t.Fatalf("ReadDir: %v", err)
 	}
 }
+
+// TestMountUnmountRace resolves paths through a mount point while it
+// is mounted and unmounted, and unmounts it twice concurrently.
+func TestMountUnmountRace(t *testing.T) {
+	ts := NewTestCase(t)
+	defer ts.Cleanup()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			node, rest := ts.connector.Node(ts.rootNode(), "race/x")
+			if len(rest) == 0 {
+				if _, name := node.Parent(); name != "x" {
+					t.Errorf("got node %q, want x", name)
+				}
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		sub := nodefs.NewDefaultNode()
+		if code := ts.connector.Mount(ts.rootNode(), "race", sub, nil); !code.Ok() {
+			t.Fatalf("Mount: %v", code)
+		}
+		sub.Inode().NewChild("x", false, nodefs.NewDefaultNode())
+
+		codes := make(chan fuse.Status, 2)
+		for j := 0; j < 2; j++ {
+			go func() { codes <- ts.connector.Unmount(sub.Inode()) }()
+		}
+		a, b := <-codes, <-codes
+		if a.Ok() == b.Ok() {
+			t.Fatalf("concurrent Unmount: got %v and %v, want one OK", a, b)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+// TestMountFile mounts a file system whose root is a regular file.
+func TestMountFile(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	mnt := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(mnt, nil, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	root := &helloNode{nodefs.NewDefaultNode()}
+	server, _, err := nodefs.MountRoot(mnt, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+
+	if root.Inode().IsDir() {
+		t.Errorf("root is a directory")
+	}
+	out, err := exec.Command("cat", mnt).CombinedOutput()
+	if err != nil {
+		t.Fatalf("cat: %v, %s", err, out)
+	}
+	if string(out) != "hello" {
+		t.Errorf("got %q, want %q", out, "hello")
+	}
+}
@@ -5,6 +5,7 @@
 package test
 
 import (
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -179,3 +180,96 @@ func TestEntryNotify(t *testing.T) {
 		t.Fatalf("Lstat failed: %v", err)
 	}
 }
+
+func TestBatchEntryNotify(t *testing.T) {
+	test := NewNotifyTest(t)
+	defer test.Clean()
+
+	test.fs.sizeChan <- 42
+	test.fs.existChan <- false
+
+	fn := test.dir + "/dir/file"
+	if fi, _ := os.Lstat(fn); fi != nil {
+		t.Errorf("File should not exist, %#v", fi)
+	}
+
+	test.fs.existChan <- true
+	if fi, _ := os.Lstat(fn); fi != nil {
+		t.Errorf("negative entry should have been cached: %#v", fi)
+	}
+
+	code := test.pathfs.BatchEntryNotify("dir", []string{"other", "file", "file"})
+	if !code.Ok() {
+		t.Errorf("BatchEntryNotify returns error: %v", code)
+	}
+
+	if _, err := os.Lstat(fn); err != nil {
+		t.Fatalf("Lstat failed: %v", err)
+	}
+}
+
+func TestExpiryNotify(t *testing.T) {
+	test := NewNotifyTest(t)
+	defer test.Clean()
+
+	dir := test.dir
+	test.fs.sizeChan <- 42
+	test.fs.existChan <- false
+
+	fn := dir + "/dir/file"
+	fi, _ := os.Lstat(fn)
+	if fi != nil {
+		t.Errorf("File should not exist, %#v", fi)
+	}
+
+	test.fs.existChan <- true
+	fi, _ = os.Lstat(fn)
+	if fi != nil {
+		t.Errorf("negative entry should have been cached: %#v", fi)
+	}
+
+	code := test.pathfs.ExpiryNotify("dir", "file")
+	if !code.Ok() {
+		t.Errorf("ExpiryNotify returns error: %v", code)
+	}
+
+	if _, err := os.Lstat(fn); err != nil {
+		t.Fatalf("Lstat failed: %v", err)
+	}
+}
+
+// TestExpiryNotifyKeepsDentry checks what sets expiry apart from
+// invalidation: the kernel keeps the dentry, so an open directory
+// still has its path.
+func TestExpiryNotifyKeepsDentry(t *testing.T) {
+	test := NewNotifyTest(t)
+	defer test.Clean()
+
+	if !test.state.KernelSettings().SupportsVersion(7, 38) {
+		t.Skip("kernel has no expire-only notify")
+	}
+
+	dir := test.dir + "/dir"
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	fdPath := fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+
+	if code := test.pathfs.ExpiryNotify("", "dir"); !code.Ok() {
+		t.Fatalf("ExpiryNotify: %v", code)
+	}
+	if got, err := os.Readlink(fdPath); err != nil || got != dir {
+		t.Errorf("after ExpiryNotify: got %q, %v, want %q", got, err, dir)
+	}
+
+	// Invalidation unhashes the dentry, which then reads as
+	// deleted.
+	if code := test.pathfs.EntryNotify("", "dir"); !code.Ok() {
+		t.Fatalf("EntryNotify: %v", code)
+	}
+	if got, err := os.Readlink(fdPath); err != nil || got != dir+" (deleted)" {
+		t.Errorf("after EntryNotify: got %q, %v, want %q", got, err, dir+" (deleted)")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// storeFile serves zeroes, and counts Read calls.
+type storeFile struct {
+	nodefs.File
+
+	mu    sync.Mutex
+	reads int
+}
+
+func (f *storeFile) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.mu.Lock()
+	f.reads++
+	f.mu.Unlock()
+	return f.File.Read(buf, off)
+}
+
+type storeNode struct {
+	nodefs.Node
+	file *storeFile
+	size uint64
+}
+
+func (n *storeNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = n.size
+	return fuse.OK
+}
+
+func (n *storeNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return &nodefs.WithFlags{
+		File:      n.file,
+		FuseFlags: fuse.FOPEN_KEEP_CACHE,
+	}, fuse.OK
+}
+
+func TestNotifyStoreRetrieve(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	const size = 4096
+	root := nodefs.NewDefaultNode()
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	file := &storeFile{File: nodefs.NewDataFile(make([]byte, size))}
+	ch := root.Inode().NewChild("file", false, &storeNode{
+		Node: nodefs.NewDefaultNode(),
+		file: file,
+		size: size,
+	})
+
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		Debug: testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+	if !srv.KernelSettings().SupportsNotify(fuse.NOTIFY_STORE) {
+		t.Skip("Kernel does not support NOTIFY_STORE")
+	}
+
+	fn := filepath.Join(dir, "file")
+	if _, err := os.Stat(fn); err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	want := bytes.Repeat([]byte("abcd"), size/4)
+	if code := conn.StoreData(ch, 0, want); !code.Ok() {
+		t.Fatalf("StoreData: %v", code)
+	}
+
+	got, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q..., want %q...", got[:8], want[:8])
+	}
+	file.mu.Lock()
+	reads := file.reads
+	file.mu.Unlock()
+	if reads != 0 {
+		t.Errorf("got %d Read calls, want 0", reads)
+	}
+
+	retrieved := make(chan []byte, 1)
+	if code := conn.RetrieveData(ch, 0, size, func(data []byte) {
+		retrieved <- append([]byte{}, data...)
+	}); !code.Ok() {
+		t.Fatalf("RetrieveData: %v", code)
+	}
+	select {
+	case got := <-retrieved:
+		if !bytes.Equal(got, want) {
+			t.Errorf("retrieved %d bytes %q..., want %q...", len(got), got[:8], want[:8])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for NOTIFY_REPLY")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// rendezvousNode answers lookups only once two of them are in flight,
+// or after a timeout.
+type rendezvousNode struct {
+	nodefs.Node
+
+	mu       sync.Mutex
+	inflight int
+	met      chan struct{}
+}
+
+func (n *rendezvousNode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+	n.mu.Lock()
+	n.inflight++
+	if n.inflight == 2 && !n.hasMet() {
+		close(n.met)
+	}
+	n.mu.Unlock()
+
+	select {
+	case <-n.met:
+	case <-time.After(250 * time.Millisecond):
+	}
+	n.mu.Lock()
+	n.inflight--
+	n.mu.Unlock()
+	out.Mode = fuse.S_IFREG | 0644
+	return n.Inode().NewChild(name, false, nodefs.NewDefaultNode()), fuse.OK
+}
+
+func (n *rendezvousNode) hasMet() bool {
+	select {
+	case <-n.met:
+		return true
+	default:
+		return false
+	}
+}
+
+func parallelLookups(t *testing.T, parallel bool) bool {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+
+	root := &rendezvousNode{Node: nodefs.NewDefaultNode(), met: make(chan struct{})}
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		ParallelDirOps: parallel,
+		Debug:          testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	if got := srv.KernelSettings().Flags&fuse.CAP_PARALLEL_DIROPS != 0; got != parallel {
+		t.Errorf("got PARALLEL_DIROPS %v, want %v", got, parallel)
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if _, err := os.Lstat(dir + "/" + name); err != nil {
+				t.Errorf("Lstat: %v", err)
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	return root.hasMet()
+}
+
+func TestParallelDirOps(t *testing.T) {
+	if !parallelLookups(t, true) {
+		t.Error("lookups in one directory did not run in parallel")
+	}
+	if parallelLookups(t, false) {
+		t.Error("lookups in one directory ran in parallel without ParallelDirOps")
+	}
+}
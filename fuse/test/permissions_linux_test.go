@@ -0,0 +1,90 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// modeNode reports a fixed mode and owner, and does not implement
+// Access.
+type modeNode struct {
+	nodefs.Node
+	mode uint32
+	uid  uint32
+}
+
+func (n *modeNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | n.mode
+	out.Uid = n.uid
+	out.Gid = n.uid
+	return fuse.OK
+}
+
+func (n *modeNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return nodefs.NewDataFile([]byte("data")), fuse.OK
+}
+
+func TestDefaultPermissions(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	const other = 4242
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Owner = nil
+	opts.DefaultPermissions = true
+	opts.Debug = testutil.VerboseTest()
+	server, _, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+
+	root.Inode().NewChild("secret", false, &modeNode{nodefs.NewDefaultNode(), 0, other})
+	root.Inode().NewChild("public", false, &modeNode{nodefs.NewDefaultNode(), 0444, other})
+
+	// Open the mount point before switching users, as the
+	// directories above it may not be accessible.
+	dirFd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatalf("Open(%q): %v", dir, err)
+	}
+	defer syscall.Close(dirFd)
+
+	// Root passes permission checks, so check as an unrelated
+	// user. setfsuid only affects the calling thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if os.Getuid() == 0 {
+		syscall.Setfsuid(other + 1)
+		defer syscall.Setfsuid(0)
+	}
+
+	fd, err := syscall.Openat(dirFd, "public", syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Openat(public): %v", err)
+	}
+	syscall.Close(fd)
+
+	fd, err = syscall.Openat(dirFd, "secret", syscall.O_RDONLY, 0)
+	if err == nil {
+		syscall.Close(fd)
+	}
+	if err != syscall.EACCES {
+		t.Errorf("Openat(secret): got %v, want EACCES", err)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+	"golang.org/x/sys/unix"
+)
+
+// pollFile becomes readable once ready is called.
+type pollFile struct {
+	nodefs.File
+
+	mu       sync.Mutex
+	readable bool
+	kh       uint64
+	polled   chan struct{}
+}
+
+func (f *pollFile) Poll(kh uint64, flags uint32, events uint32) (uint32, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.readable {
+		return unix.POLLIN, fuse.OK
+	}
+	if flags&fuse.FUSE_POLL_SCHEDULE_NOTIFY != 0 {
+		f.kh = kh
+		select {
+		case f.polled <- struct{}{}:
+		default:
+		}
+	}
+	return 0, fuse.OK
+}
+
+// ready makes the file readable, and returns the poll handle to
+// notify.
+func (f *pollFile) ready() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readable = true
+	return f.kh
+}
+
+type pollNode struct {
+	nodefs.Node
+	file *pollFile
+}
+
+func (n *pollNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func (n *pollNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	return n.file, fuse.OK
+}
+
+func TestPoll(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := nodefs.NewDefaultNode()
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	file := &pollFile{
+		File:   nodefs.NewDefaultFile(),
+		polled: make(chan struct{}, 1),
+	}
+	root.Inode().NewChild("file", false, &pollNode{
+		Node: nodefs.NewDefaultNode(),
+		file: file,
+	})
+
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		EnablePoll: true,
+		Debug:      testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	// Use a raw file descriptor, so the Go runtime does not poll
+	// it by itself.
+	fd, err := syscall.Open(filepath.Join(dir, "file"), syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	type result struct {
+		fds []unix.PollFd
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, 5000)
+		done <- result{fds, n, err}
+	}()
+
+	select {
+	case <-file.polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for POLL")
+	}
+
+	select {
+	case r := <-done:
+		t.Fatalf("poll returned early: %v", r)
+	default:
+	}
+
+	if code := conn.NotifyPoll(file.ready()); !code.Ok() {
+		t.Fatalf("NotifyPoll: %v", code)
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("Poll: %v", r.err)
+	}
+	if r.n != 1 || r.fds[0].Revents&unix.POLLIN == 0 {
+		t.Errorf("got n=%d revents 0x%x, want POLLIN", r.n, r.fds[0].Revents)
+	}
+}
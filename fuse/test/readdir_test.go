@@ -0,0 +1,180 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// readDirOnlyFS serves a root directory with a fixed listing, and
+// does not implement ReadDirPlus. OpenDir returns fh as the handle,
+// or ENOSYS if fh is zero.
+type readDirOnlyFS struct {
+	fuse.RawFileSystem
+	names []string
+	fh    uint64
+
+	mu  sync.Mutex
+	fhs []uint64
+}
+
+func (fs *readDirOnlyFS) GetAttr(cancel <-chan struct{}, input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	if input.NodeId != fuse.FUSE_ROOT_ID {
+		return fuse.ENOENT
+	}
+	out.Mode = fuse.S_IFDIR | 0755
+	return fuse.OK
+}
+
+func (fs *readDirOnlyFS) OpenDir(cancel <-chan struct{}, input *fuse.OpenIn, out *fuse.OpenOut) fuse.Status {
+	if fs.fh == 0 {
+		return fuse.ENOSYS
+	}
+	out.Fh = fs.fh
+	return fuse.OK
+}
+
+func (fs *readDirOnlyFS) ReadDir(cancel <-chan struct{}, input *fuse.ReadIn, out *fuse.DirEntryList) fuse.Status {
+	fs.mu.Lock()
+	fs.fhs = append(fs.fhs, input.Fh)
+	fs.mu.Unlock()
+	for i := int(input.Offset); i < len(fs.names); i++ {
+		e := fuse.DirEntry{Name: fs.names[i], Mode: fuse.S_IFREG, Ino: uint64(i + 10)}
+		if ok, _ := out.AddDirEntry(e); !ok {
+			break
+		}
+	}
+	return fuse.OK
+}
+
+func testReadDirOnly(t *testing.T, opts *fuse.MountOptions, fh uint64) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	fs := &readDirOnlyFS{
+		RawFileSystem: fuse.NewDefaultRawFileSystem(),
+		names:         []string{"a", "b", "c"},
+		fh:            fh,
+	}
+	opts.Debug = testutil.VerboseTest()
+	srv, err := fuse.NewServer(fs, dir, opts)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames: %v", err)
+	}
+	sort.Strings(names)
+	if len(names) != len(fs.names) {
+		t.Fatalf("got %v, want %v", names, fs.names)
+	}
+	for i := range names {
+		if names[i] != fs.names[i] {
+			t.Fatalf("got %v, want %v", names, fs.names)
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, got := range fs.fhs {
+		if got != fh {
+			t.Errorf("ReadDir got Fh %d, want %d", got, fh)
+		}
+	}
+}
+
+func TestReadDirPlusFallback(t *testing.T) {
+	testReadDirOnly(t, &fuse.MountOptions{}, 42)
+}
+
+func TestDisableReadDirPlus(t *testing.T) {
+	testReadDirOnly(t, &fuse.MountOptions{DisableReadDirPlus: true}, 42)
+}
+
+// Without OpenDir, the kernel lists directories without a handle.
+func TestReadDirNoOpenDir(t *testing.T) {
+	testReadDirOnly(t, &fuse.MountOptions{}, 0)
+}
+
+// TestReadDirSnapshot checks that changes made while a directory is
+// being listed do not show in the listing.
+func TestReadDirSnapshot(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := nodefs.NewDefaultNode()
+	opts := nodefs.NewOptions()
+	opts.Debug = testutil.VerboseTest()
+	server, _, err := nodefs.MountRoot(dir, root, opts)
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer server.Unmount()
+
+	// Enough entries to need several READDIR calls.
+	want := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("file%04d-with-a-longer-name", i)
+		root.Inode().NewChild(name, false, &blobNode{nodefs.NewDefaultNode(), ""})
+		want[name] = true
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	first, err := f.Readdirnames(10)
+	if err != nil {
+		t.Fatalf("Readdirnames: %v", err)
+	}
+
+	for i := 0; i < 1000; i += 2 {
+		root.Inode().RmChild(fmt.Sprintf("file%04d-with-a-longer-name", i))
+		root.Inode().NewChild(fmt.Sprintf("new%04d", i), false, &blobNode{nodefs.NewDefaultNode(), ""})
+	}
+
+	rest, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames: %v", err)
+	}
+	got := map[string]bool{}
+	for _, n := range append(first, rest...) {
+		if got[n] {
+			t.Errorf("duplicate entry %q", n)
+		}
+		got[n] = true
+		if !want[n] {
+			t.Errorf("unexpected entry %q", n)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d entries, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+type statfsNode struct {
+	nodefs.Node
+	out *fuse.StatfsOut
+}
+
+func (n *statfsNode) StatFs() *fuse.StatfsOut {
+	return n.out
+}
+
+func TestStatFsSubmount(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	root := nodefs.NewDefaultNode()
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		Debug: testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	sub := &statfsNode{
+		Node: nodefs.NewDefaultNode(),
+		out: &fuse.StatfsOut{
+			Blocks:  100,
+			Bfree:   50,
+			Bavail:  40,
+			Files:   10,
+			Ffree:   5,
+			Bsize:   1024,
+			NameLen: 128,
+			Frsize:  512,
+		},
+	}
+	if code := conn.Mount(root.Inode(), "sub", sub, nil); !code.Ok() {
+		t.Fatalf("Mount: %v", code)
+	}
+	sub.Inode().NewChild("dir", true, nodefs.NewDefaultNode())
+
+	// The root does not implement StatFs.
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		t.Fatalf("Statfs: %v", err)
+	}
+	if st.Bsize == 0 || st.Frsize == 0 || st.Namelen == 0 {
+		t.Errorf("got zero sizes for default StatFs: %#v", st)
+	}
+
+	// Below the submount, its root answers.
+	for _, p := range []string{"sub", "sub/dir"} {
+		st = syscall.Statfs_t{}
+		if err := syscall.Statfs(filepath.Join(dir, p), &st); err != nil {
+			t.Fatalf("Statfs(%q): %v", p, err)
+		}
+		if st.Blocks != 100 || st.Bfree != 50 || st.Bavail != 40 ||
+			st.Files != 10 || st.Ffree != 5 || st.Bsize != 1024 ||
+			st.Namelen != 128 || st.Frsize != 512 {
+			t.Errorf("Statfs(%q): got %#v", p, st)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// countLinkNode is a symlink that counts Readlink calls.
+type countLinkNode struct {
+	nodefs.Node
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (n *countLinkNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	out.Mode = fuse.S_IFLNK | 0777
+	return fuse.OK
+}
+
+func (n *countLinkNode) Readlink(c *fuse.Context) ([]byte, fuse.Status) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls++
+	return []byte("target"), fuse.OK
+}
+
+func (n *countLinkNode) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.calls
+}
+
+func symlinkCacheTest(t *testing.T, timeout time.Duration) (link string, node *countLinkNode, conn *nodefs.FileSystemConnector, cleanup func()) {
+	dir := testutil.TempDir()
+	opts := nodefs.NewOptions()
+	opts.SymlinkCacheTimeout = timeout
+	root := nodefs.NewDefaultNode()
+	conn = nodefs.NewFileSystemConnector(root, opts)
+	node = &countLinkNode{Node: nodefs.NewDefaultNode()}
+	root.Inode().NewChild("link", false, node)
+
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		Debug: testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	return filepath.Join(dir, "link"), node, conn, func() {
+		srv.Unmount()
+		os.Remove(dir)
+	}
+}
+
+func readlinkN(t *testing.T, link string, n int) {
+	for i := 0; i < n; i++ {
+		if got, err := os.Readlink(link); err != nil || got != "target" {
+			t.Fatalf("Readlink: got %q, %v, want \"target\"", got, err)
+		}
+	}
+}
+
+func TestSymlinkCache(t *testing.T) {
+	link, node, conn, clean := symlinkCacheTest(t, time.Minute)
+	defer clean()
+
+	readlinkN(t, link, 3)
+	if got := node.count(); got != 1 {
+		t.Errorf("got %d Readlink calls, want 1", got)
+	}
+
+	conn.FileNotify(node.Inode(), -1, 0)
+	readlinkN(t, link, 2)
+	if got := node.count(); got != 2 {
+		t.Errorf("got %d Readlink calls after FileNotify, want 2", got)
+	}
+}
+
+func TestSymlinkCacheExpiry(t *testing.T) {
+	link, node, _, clean := symlinkCacheTest(t, 10*time.Millisecond)
+	defer clean()
+
+	readlinkN(t, link, 1)
+	time.Sleep(20 * time.Millisecond)
+	readlinkN(t, link, 1)
+	if got := node.count(); got != 2 {
+		t.Errorf("got %d Readlink calls, want 2", got)
+	}
+}
+
+func TestSymlinkCacheDisabled(t *testing.T) {
+	link, node, _, clean := symlinkCacheTest(t, 0)
+	defer clean()
+
+	readlinkN(t, link, 3)
+	if got := node.count(); got != 3 {
+		t.Errorf("got %d Readlink calls, want 3", got)
+	}
+}
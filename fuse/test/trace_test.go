@@ -0,0 +1,169 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fusetest"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func TestTraceReplay(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	traced := filepath.Join(dir, "traced")
+	replayed := filepath.Join(dir, "replayed")
+	mnt := filepath.Join(dir, "mnt")
+	for _, d := range []string{traced, replayed, mnt} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var trace bytes.Buffer
+	pfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(traced), nil)
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	srv, err := fuse.NewServer(fuse.NewTraceRawFileSystem(conn.RawFS(), &trace), mnt,
+		&fuse.MountOptions{Debug: testutil.VerboseTest()})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+
+	if err := os.Mkdir(filepath.Join(mnt, "dir"), 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(mnt, "dir/file"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Rename(filepath.Join(mnt, "dir/file"), filepath.Join(mnt, "dir/renamed")); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := os.Symlink("renamed", filepath.Join(mnt, "dir/link")); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if err := srv.Unmount(); err != nil {
+		t.Fatalf("Unmount: %v", err)
+	}
+
+	pfs = pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(replayed), nil)
+	conn = nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	if err := fuse.ReplayTrace(&trace, conn.RawFS()); err != nil {
+		t.Fatalf("ReplayTrace: %v", err)
+	}
+
+	if c, err := ioutil.ReadFile(filepath.Join(replayed, "dir/renamed")); err != nil || string(c) != "hello" {
+		t.Errorf("ReadFile: got %q, %v, want %q", c, err, "hello")
+	}
+	if _, err := os.Lstat(filepath.Join(replayed, "dir/file")); !os.IsNotExist(err) {
+		t.Errorf("Lstat(file): got %v, want ENOENT", err)
+	}
+	if l, err := os.Readlink(filepath.Join(replayed, "dir/link")); err != nil || l != "renamed" {
+		t.Errorf("Readlink: got %q, %v, want %q", l, err, "renamed")
+	}
+}
+
+func TestReplayTraceTruncated(t *testing.T) {
+	var trace bytes.Buffer
+	fs := fuse.NewTraceRawFileSystem(fuse.NewDefaultRawFileSystem(), &trace)
+	fs.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "name", &fuse.EntryOut{})
+
+	b := trace.Bytes()
+	if err := fuse.ReplayTrace(bytes.NewReader(b), fuse.NewDefaultRawFileSystem()); err != nil {
+		t.Fatalf("ReplayTrace: %v", err)
+	}
+	if err := fuse.ReplayTrace(bytes.NewReader(b[:len(b)-1]), fuse.NewDefaultRawFileSystem()); err == nil {
+		t.Errorf("ReplayTrace succeeded on truncated trace")
+	}
+}
+
+func TestTraceNodePath(t *testing.T) {
+	root := nodefs.NewDefaultNode()
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	root.Inode().NewChild("file", false, nodefs.NewDefaultNode())
+	fs := fuse.NewTraceRawFileSystem(conn.RawFS(), ioutil.Discard)
+
+	out := &fuse.EntryOut{}
+	if code := fs.Lookup(nil, &fuse.InHeader{NodeId: fuse.FUSE_ROOT_ID}, "file", out); !code.Ok() {
+		t.Fatalf("Lookup: %v", code)
+	}
+	p, ok := fs.(fuse.NodePathFileSystem)
+	if !ok {
+		t.Fatalf("%T does not implement NodePathFileSystem", fs)
+	}
+	if got, ok := p.NodePath(out.NodeId); !ok || got != "file" {
+		t.Errorf("NodePath: got %q, %v, want \"file\"", got, ok)
+	}
+	if _, ok := fs.(fuse.SpliceWriteFileSystem).WriteFd(nil, &fuse.WriteIn{}); ok {
+		t.Errorf("WriteFd succeeded for a file system without descriptors")
+	}
+}
+
+// writeFdRawFS accepts every spliced write, and counts the writes
+// it gets through Write.
+type writeFdRawFS struct {
+	fuse.RawFileSystem
+	writes []fuse.WriteIn
+}
+
+func (fs *writeFdRawFS) WriteFd(cancel <-chan struct{}, input *fuse.WriteIn) (uintptr, bool) {
+	return 3, true
+}
+
+func (fs *writeFdRawFS) Write(cancel <-chan struct{}, input *fuse.WriteIn, data []byte) (uint32, fuse.Status) {
+	fs.writes = append(fs.writes, *input)
+	return uint32(len(data)), fuse.OK
+}
+
+func TestTraceWriteFd(t *testing.T) {
+	var trace bytes.Buffer
+	fs := fuse.NewTraceRawFileSystem(&writeFdRawFS{RawFileSystem: fuse.NewDefaultRawFileSystem()}, &trace)
+	if _, ok := fs.(fuse.SpliceWriteFileSystem).WriteFd(nil, &fuse.WriteIn{Fh: 7, Size: 5}); !ok {
+		t.Fatalf("WriteFd was not forwarded")
+	}
+
+	replay := &writeFdRawFS{RawFileSystem: fuse.NewDefaultRawFileSystem()}
+	if err := fuse.ReplayTrace(&trace, replay); err != nil {
+		t.Fatalf("ReplayTrace: %v", err)
+	}
+	if len(replay.writes) != 1 || replay.writes[0].Fh != 7 {
+		t.Errorf("replayed writes: got %+v, want one write to handle 7", replay.writes)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestTraceWriteFailureLogger(t *testing.T) {
+	var logged bytes.Buffer
+	fs := fuse.NewTraceRawFileSystem(fuse.NewDefaultRawFileSystem(), failWriter{})
+	k, err := fusetest.NewKernel(fs, &fuse.MountOptions{Logger: log.New(&logged, "", 0)})
+	if err != nil {
+		t.Fatalf("NewKernel: %v", err)
+	}
+	if _, err := k.Call(fusetest.Request(fusetest.OpLookup, fuse.FUSE_ROOT_ID, nil, "name")); err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	k.Close()
+	if !bytes.Contains(logged.Bytes(), []byte("tracing stopped")) {
+		t.Errorf("got log %q, want the trace failure", logged.String())
+	}
+}
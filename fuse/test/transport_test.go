@@ -0,0 +1,101 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fusetest"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// chanTransport passes messages through channels, standing in for a
+// platform without a FUSE device.
+type chanTransport struct {
+	requests chan []byte
+	replies  chan []byte
+	closed   chan struct{}
+}
+
+func (t *chanTransport) Read(buf []byte) (int, error) {
+	req, ok := <-t.requests
+	if !ok {
+		return 0, syscall.ENODEV
+	}
+	return copy(buf, req), nil
+}
+
+func (t *chanTransport) Write(msg [][]byte) error {
+	var reply []byte
+	for _, m := range msg {
+		reply = append(reply, m...)
+	}
+	t.replies <- reply
+	return nil
+}
+
+func (t *chanTransport) Close() error {
+	close(t.closed)
+	return nil
+}
+
+func (t *chanTransport) call(req []byte, unique uint64) []byte {
+	h := (*fuse.InHeader)(unsafe.Pointer(&req[0]))
+	h.Length = uint32(len(req))
+	h.Unique = unique
+	t.requests <- req
+	return <-t.replies
+}
+
+func TestServerTransport(t *testing.T) {
+	root := nodefs.NewDefaultNode()
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	root.Inode().NewChild("file", false, nodefs.NewDefaultNode())
+
+	tr := &chanTransport{
+		requests: make(chan []byte, 1),
+		replies:  make(chan []byte, 1),
+		closed:   make(chan struct{}),
+	}
+	init := fusetest.Request(fusetest.OpInit, 0, &fuse.InitIn{Major: 7, Minor: fusetest.Minor})
+	(*fuse.InHeader)(unsafe.Pointer(&init[0])).Length = uint32(len(init))
+	tr.requests <- init
+	srv, err := fuse.NewServerTransport(conn.RawFS(), tr, nil)
+	if err != nil {
+		t.Fatalf("NewServerTransport: %v", err)
+	}
+	if code := fusetest.Status(<-tr.replies); !code.Ok() {
+		t.Fatalf("INIT: %v", code)
+	}
+	if fd := srv.MountFd(); fd != -1 {
+		t.Errorf("MountFd: got %d, want -1", fd)
+	}
+	served := make(chan struct{})
+	go func() {
+		srv.Serve()
+		close(served)
+	}()
+
+	reply := tr.call(fusetest.Request(fusetest.OpLookup, fuse.FUSE_ROOT_ID, nil, "file"), 2)
+	out := (*fuse.OutHeader)(unsafe.Pointer(&reply[0]))
+	if out.Unique != 2 || out.Status != 0 {
+		t.Errorf("LOOKUP: got reply %+v, want unique 2, status 0", *out)
+	}
+	reply = tr.call(fusetest.Request(fusetest.OpLookup, fuse.FUSE_ROOT_ID, nil, "missing"), 3)
+	if code := fusetest.Status(reply); code != fuse.ENOENT {
+		t.Errorf("LOOKUP missing: got %v, want ENOENT", code)
+	}
+
+	close(tr.requests)
+	<-served
+	select {
+	case <-tr.closed:
+	default:
+		t.Errorf("Serve did not close the transport")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// openFlagsFS records the flags passed to Open.
+type openFlagsFS struct {
+	pathfs.FileSystem
+
+	mu    sync.Mutex
+	flags []uint32
+}
+
+func (fs *openFlagsFS) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	fs.mu.Lock()
+	fs.flags = append(fs.flags, flags)
+	fs.mu.Unlock()
+	return fs.FileSystem.Open(name, flags, context)
+}
+
+func testOpenTruncate(t *testing.T, atomic bool) {
+	dir := testutil.TempDir()
+	defer os.RemoveAll(dir)
+	orig := filepath.Join(dir, "orig")
+	mnt := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(orig, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &openFlagsFS{FileSystem: pathfs.NewLoopbackFileSystem(orig)}
+	pfs := pathfs.NewPathNodeFs(fs, nil)
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	srv, err := fuse.NewServer(conn.RawFS(), mnt, &fuse.MountOptions{
+		AtomicTruncate: atomic,
+		Debug:          testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+	if atomic && srv.KernelSettings().Flags&fuse.CAP_ATOMIC_O_TRUNC == 0 {
+		t.Skip("Kernel does not support atomic O_TRUNC")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(orig, "file"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(mnt, "file"), os.O_TRUNC|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write([]byte("hi")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if got, err := ioutil.ReadFile(filepath.Join(orig, "file")); err != nil || string(got) != "hi" {
+		t.Errorf("got %q, %v, want %q", got, err, "hi")
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if len(fs.flags) != 1 {
+		t.Fatalf("got Open flags %v, want one call", fs.flags)
+	}
+	if got := fs.flags[0]&uint32(os.O_TRUNC) != 0; got != atomic {
+		t.Errorf("Open flags %x: got O_TRUNC %v, want %v", fs.flags[0], got, atomic)
+	}
+}
+
+func TestOpenTruncate(t *testing.T) {
+	testOpenTruncate(t, false)
+}
+
+func TestOpenTruncateAtomic(t *testing.T) {
+	testOpenTruncate(t, true)
+}
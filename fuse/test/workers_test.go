@@ -0,0 +1,164 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/fusetest"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+// blockingNode blocks GetAttr until release is closed, and records
+// how many calls were running at once.
+type blockingNode struct {
+	nodefs.Node
+	state *blockingState
+}
+
+type blockingState struct {
+	mu      sync.Mutex
+	running int
+	max     int
+	release chan struct{}
+}
+
+func (n *blockingNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	s := n.state
+	s.mu.Lock()
+	s.running++
+	if s.running > s.max {
+		s.max = s.running
+	}
+	s.mu.Unlock()
+
+	<-s.release
+
+	s.mu.Lock()
+	s.running--
+	s.mu.Unlock()
+	out.Mode = fuse.S_IFREG | 0644
+	return fuse.OK
+}
+
+func TestMaxWorkers(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	const workers = 2
+	const files = 4
+	state := &blockingState{release: make(chan struct{})}
+	root := nodefs.NewDefaultNode()
+	conn := nodefs.NewFileSystemConnector(root, nil)
+	// The kernel serializes lookups in a directory, so put each
+	// file in its own.
+	for i := 0; i < files; i++ {
+		d := root.Inode().NewChild(fmt.Sprintf("d%d", i), true, nodefs.NewDefaultNode())
+		d.NewChild("f", false, &blockingNode{nodefs.NewDefaultNode(), state})
+	}
+
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		MaxWorkers: workers,
+		Debug:      testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	var wg sync.WaitGroup
+	for i := 0; i < files; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := os.Lstat(filepath.Join(dir, fmt.Sprintf("d%d", i), "f")); err != nil {
+				t.Errorf("Lstat: %v", err)
+			}
+		}(i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state.mu.Lock()
+		running := state.running
+		state.mu.Unlock()
+		if running == workers || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give excess requests a chance to start.
+	time.Sleep(50 * time.Millisecond)
+	close(state.release)
+	wg.Wait()
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.max != workers {
+		t.Errorf("got %d concurrent GetAttr calls, want %d", state.max, workers)
+	}
+}
+
+// interruptibleFS blocks GetAttr until it is interrupted.
+type interruptibleFS struct {
+	fuse.RawFileSystem
+	started chan uint64
+}
+
+func (fs *interruptibleFS) GetAttr(cancel <-chan struct{}, input *fuse.GetAttrIn, out *fuse.AttrOut) fuse.Status {
+	fs.started <- input.Unique
+	<-cancel
+	return fuse.EINTR
+}
+
+func TestMaxWorkersInterrupt(t *testing.T) {
+	fs := &interruptibleFS{fuse.NewDefaultRawFileSystem(), make(chan uint64, 2)}
+	k, err := fusetest.NewKernel(fs, &fuse.MountOptions{MaxWorkers: 1})
+	if err != nil {
+		t.Fatalf("NewKernel: %v", err)
+	}
+	defer k.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := k.Send(fusetest.Request(fusetest.OpGetAttr, fuse.FUSE_ROOT_ID, &fuse.GetAttrIn{})); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	// One GETATTR waits for the only worker, but the reader must
+	// still see the INTERRUPT for the other.
+	for i := 0; i < 2; i++ {
+		var u uint64
+		select {
+		case u = <-fs.started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("GETATTR %d did not start", i)
+		}
+		if _, err := k.Send(fusetest.Request(fusetest.OpInterrupt, 0, &fuse.InterruptIn{Unique: u})); err != nil {
+			t.Fatalf("Send INTERRUPT: %v", err)
+		}
+		select {
+		case msg := <-k.Messages():
+			h := (*fuse.OutHeader)(unsafe.Pointer(&msg[0]))
+			if h.Unique != u || fusetest.Status(msg) != fuse.EINTR {
+				t.Errorf("got reply %d: %v, want %d: EINTR", h.Unique, fusetest.Status(msg), u)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("GETATTR %d was not interrupted", u)
+		}
+	}
+}
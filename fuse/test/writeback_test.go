@@ -0,0 +1,134 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/hanwen/go-fuse/internal/testutil"
+)
+
+func writebackTest(t *testing.T) (orig, mnt string, cleanup func()) {
+	dir := testutil.TempDir()
+	orig = filepath.Join(dir, "orig")
+	mnt = filepath.Join(dir, "mnt")
+	if err := os.Mkdir(orig, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pfs := pathfs.NewPathNodeFs(pathfs.NewLoopbackFileSystem(orig), nil)
+	conn := nodefs.NewFileSystemConnector(pfs.Root(), nil)
+	srv, err := fuse.NewServer(conn.RawFS(), mnt, &fuse.MountOptions{
+		WritebackCache: true,
+		Debug:          testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	if srv.KernelSettings().Flags&fuse.CAP_WRITEBACK_CACHE == 0 {
+		srv.Unmount()
+		os.RemoveAll(dir)
+		t.Skip("Kernel does not support the writeback cache")
+	}
+	return orig, mnt, func() {
+		srv.Unmount()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWritebackCache(t *testing.T) {
+	orig, mnt, clean := writebackTest(t)
+	defer clean()
+
+	// Write-only files may be read by the kernel to fill partial
+	// pages.
+	f, err := os.OpenFile(filepath.Join(mnt, "file"), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	var want []byte
+	for i := 0; i < 100; i++ {
+		chunk := bytes.Repeat([]byte{byte('a' + i%26)}, 100)
+		if _, err := f.Write(chunk); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		want = append(want, chunk...)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err = os.OpenFile(filepath.Join(mnt, "file"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Write([]byte("tail")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want = append(want, "tail"...)
+	if err := f.Truncate(int64(len(want) - 2)); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	want = want[:len(want)-2]
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(orig, "file"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes %q..., want %d bytes", len(got), got[:10], len(want))
+	}
+}
+
+func TestConnectorInitOut(t *testing.T) {
+	dir := testutil.TempDir()
+	defer os.Remove(dir)
+
+	conn := nodefs.NewFileSystemConnector(nodefs.NewDefaultNode(), nil)
+	if conn.InitOut() != nil {
+		t.Errorf("InitOut before mounting: got %+v, want nil", conn.InitOut())
+	}
+	srv, err := fuse.NewServer(conn.RawFS(), dir, &fuse.MountOptions{
+		WritebackCache: true,
+		MaxWrite:       64 * 1024,
+		Debug:          testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go srv.Serve()
+	if err := srv.WaitMount(); err != nil {
+		t.Fatal("WaitMount", err)
+	}
+	defer srv.Unmount()
+
+	out := conn.InitOut()
+	if got, want := out.Flags&fuse.CAP_WRITEBACK_CACHE, srv.KernelSettings().Flags&fuse.CAP_WRITEBACK_CACHE; got != want {
+		t.Errorf("got WRITEBACK_CACHE %x, want %x", got, want)
+	}
+	if out.MaxWrite != 64*1024 {
+		t.Errorf("got max_write %d, want %d", out.MaxWrite, 64*1024)
+	}
+	if out.MaxReadAhead != srv.KernelSettings().MaxReadAhead {
+		t.Errorf("got max_readahead %d, want %d", out.MaxReadAhead, srv.KernelSettings().MaxReadAhead)
+	}
+}
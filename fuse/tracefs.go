@@ -0,0 +1,722 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"unsafe"
+)
+
+////////////////////////////////////////////////////////////////
+// Tracing raw FS.
+
+// A trace is a sequence of records, one per call. The framing is
+// little endian; the input struct is as in the kernel protocol. A
+// record is
+//
+//	uint32 length of the rest of the record
+//	int32  opcode
+//	uint64 aux, see below
+//	uint32 n, followed by the n bytes of the input struct
+//	uint32 count of names, each a uint32 length and the bytes
+//	uint32 n, followed by n bytes of data
+//
+// The aux field holds the nlookup for FORGET, the buffer size for
+// READ, READDIR and READDIRPLUS, and 1 for a GETXATTR that asks for
+// the data rather than the size. For a SETLK or SETLKW that is a
+// flock(2) lock, it is traceFlock with the flags for Flock.
+const traceFlock = uint64(1) << 63
+
+type traceRawFileSystem struct {
+	RawFS RawFileSystem
+
+	mu     sync.Mutex
+	w      io.Writer
+	err    error
+	server *Server
+}
+
+// NewTraceRawFileSystem returns a wrapper that writes each call to
+// w before passing it on to fs. Call results are not recorded. For
+// writes that the server splices into a descriptor from WriteFd, the
+// WRITE is recorded without its data. The trace can be fed to ReplayTrace, to repeat the calls
+// against a test instance. If writing to w fails, tracing stops, and
+// the failure is logged to the server's MountOptions.Logger.
+func NewTraceRawFileSystem(fs RawFileSystem, w io.Writer) RawFileSystem {
+	return &traceRawFileSystem{
+		RawFS: fs,
+		w:     w,
+	}
+}
+
+func (fs *traceRawFileSystem) FS() RawFileSystem {
+	return fs.RawFS
+}
+
+func (fs *traceRawFileSystem) record(op int32, aux uint64, in unsafe.Pointer, size uintptr, names []string, data []byte) {
+	var input []byte
+	toSlice(&input, in, size)
+
+	var rec bytes.Buffer
+	binary.Write(&rec, binary.LittleEndian, op)
+	binary.Write(&rec, binary.LittleEndian, aux)
+	binary.Write(&rec, binary.LittleEndian, uint32(len(input)))
+	rec.Write(input)
+	binary.Write(&rec, binary.LittleEndian, uint32(len(names)))
+	for _, n := range names {
+		binary.Write(&rec, binary.LittleEndian, uint32(len(n)))
+		rec.WriteString(n)
+	}
+	binary.Write(&rec, binary.LittleEndian, uint32(len(data)))
+	rec.Write(data)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(rec.Len()))
+	buf.Write(rec.Bytes())
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		return
+	}
+	if _, err := fs.w.Write(buf.Bytes()); err != nil {
+		fs.err = err
+		if fs.server != nil {
+			fs.server.logf("trace: write failed, tracing stopped: %v", err)
+		} else {
+			log.Printf("trace: write failed, tracing stopped: %v", err)
+		}
+	}
+}
+
+func (fs *traceRawFileSystem) Lookup(cancel <-chan struct{}, header *InHeader, name string, out *EntryOut) (code Status) {
+	fs.record(_OP_LOOKUP, 0, unsafe.Pointer(header), unsafe.Sizeof(*header), []string{name}, nil)
+	return fs.RawFS.Lookup(cancel, header, name, out)
+}
+
+func (fs *traceRawFileSystem) SetDebug(dbg bool) {
+	fs.RawFS.SetDebug(dbg)
+}
+
+func (fs *traceRawFileSystem) Forget(nodeID uint64, nlookup uint64) {
+	header := InHeader{NodeId: nodeID}
+	fs.record(_OP_FORGET, nlookup, unsafe.Pointer(&header), unsafe.Sizeof(header), nil, nil)
+	fs.RawFS.Forget(nodeID, nlookup)
+}
+
+func (fs *traceRawFileSystem) GetAttr(cancel <-chan struct{}, input *GetAttrIn, out *AttrOut) (code Status) {
+	fs.record(_OP_GETATTR, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.GetAttr(cancel, input, out)
+}
+
+func (fs *traceRawFileSystem) Open(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status) {
+	fs.record(_OP_OPEN, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.Open(cancel, input, out)
+}
+
+func (fs *traceRawFileSystem) SetAttr(cancel <-chan struct{}, input *SetAttrIn, out *AttrOut) (code Status) {
+	fs.record(_OP_SETATTR, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.SetAttr(cancel, input, out)
+}
+
+func (fs *traceRawFileSystem) Readlink(cancel <-chan struct{}, header *InHeader) (out []byte, code Status) {
+	fs.record(_OP_READLINK, 0, unsafe.Pointer(header), unsafe.Sizeof(*header), nil, nil)
+	return fs.RawFS.Readlink(cancel, header)
+}
+
+func (fs *traceRawFileSystem) Mknod(cancel <-chan struct{}, input *MknodIn, name string, out *EntryOut) (code Status) {
+	fs.record(_OP_MKNOD, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), []string{name}, nil)
+	return fs.RawFS.Mknod(cancel, input, name, out)
+}
+
+func (fs *traceRawFileSystem) Mkdir(cancel <-chan struct{}, input *MkdirIn, name string, out *EntryOut) (code Status) {
+	fs.record(_OP_MKDIR, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), []string{name}, nil)
+	return fs.RawFS.Mkdir(cancel, input, name, out)
+}
+
+func (fs *traceRawFileSystem) Unlink(cancel <-chan struct{}, header *InHeader, name string) (code Status) {
+	fs.record(_OP_UNLINK, 0, unsafe.Pointer(header), unsafe.Sizeof(*header), []string{name}, nil)
+	return fs.RawFS.Unlink(cancel, header, name)
+}
+
+func (fs *traceRawFileSystem) Rmdir(cancel <-chan struct{}, header *InHeader, name string) (code Status) {
+	fs.record(_OP_RMDIR, 0, unsafe.Pointer(header), unsafe.Sizeof(*header), []string{name}, nil)
+	return fs.RawFS.Rmdir(cancel, header, name)
+}
+
+func (fs *traceRawFileSystem) Symlink(cancel <-chan struct{}, header *InHeader, pointedTo string, linkName string, out *EntryOut) (code Status) {
+	fs.record(_OP_SYMLINK, 0, unsafe.Pointer(header), unsafe.Sizeof(*header), []string{pointedTo, linkName}, nil)
+	return fs.RawFS.Symlink(cancel, header, pointedTo, linkName, out)
+}
+
+func (fs *traceRawFileSystem) Rename(cancel <-chan struct{}, input *RenameIn, oldName string, newName string) (code Status) {
+	fs.record(_OP_RENAME, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), []string{oldName, newName}, nil)
+	return fs.RawFS.Rename(cancel, input, oldName, newName)
+}
+
+func (fs *traceRawFileSystem) Link(cancel <-chan struct{}, input *LinkIn, name string, out *EntryOut) (code Status) {
+	fs.record(_OP_LINK, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), []string{name}, nil)
+	return fs.RawFS.Link(cancel, input, name, out)
+}
+
+func (fs *traceRawFileSystem) SetXAttr(cancel <-chan struct{}, input *SetXAttrIn, attr string, data []byte) Status {
+	fs.record(_OP_SETXATTR, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), []string{attr}, data)
+	return fs.RawFS.SetXAttr(cancel, input, attr, data)
+}
+
+func (fs *traceRawFileSystem) GetXAttrData(cancel <-chan struct{}, header *InHeader, attr string) (data []byte, code Status) {
+	fs.record(_OP_GETXATTR, 1, unsafe.Pointer(header), unsafe.Sizeof(*header), []string{attr}, nil)
+	return fs.RawFS.GetXAttrData(cancel, header, attr)
+}
+
+func (fs *traceRawFileSystem) GetXAttrSize(cancel <-chan struct{}, header *InHeader, attr string) (sz int, code Status) {
+	fs.record(_OP_GETXATTR, 0, unsafe.Pointer(header), unsafe.Sizeof(*header), []string{attr}, nil)
+	return fs.RawFS.GetXAttrSize(cancel, header, attr)
+}
+
+func (fs *traceRawFileSystem) ListXAttr(cancel <-chan struct{}, header *InHeader) (data []byte, code Status) {
+	fs.record(_OP_LISTXATTR, 0, unsafe.Pointer(header), unsafe.Sizeof(*header), nil, nil)
+	return fs.RawFS.ListXAttr(cancel, header)
+}
+
+func (fs *traceRawFileSystem) RemoveXAttr(cancel <-chan struct{}, header *InHeader, attr string) Status {
+	fs.record(_OP_REMOVEXATTR, 0, unsafe.Pointer(header), unsafe.Sizeof(*header), []string{attr}, nil)
+	return fs.RawFS.RemoveXAttr(cancel, header, attr)
+}
+
+func (fs *traceRawFileSystem) Access(cancel <-chan struct{}, input *AccessIn) (code Status) {
+	fs.record(_OP_ACCESS, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.Access(cancel, input)
+}
+
+func (fs *traceRawFileSystem) Create(cancel <-chan struct{}, input *CreateIn, name string, out *CreateOut) (code Status) {
+	fs.record(_OP_CREATE, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), []string{name}, nil)
+	return fs.RawFS.Create(cancel, input, name, out)
+}
+
+func (fs *traceRawFileSystem) OpenDir(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status) {
+	fs.record(_OP_OPENDIR, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.OpenDir(cancel, input, out)
+}
+
+func (fs *traceRawFileSystem) Read(cancel <-chan struct{}, input *ReadIn, buf []byte) (ReadResult, Status) {
+	fs.record(_OP_READ, uint64(len(buf)), unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.Read(cancel, input, buf)
+}
+
+func (fs *traceRawFileSystem) Flock(cancel <-chan struct{}, input *FlockIn, flags int) (code Status) {
+	lk := LkIn{
+		InHeader: input.InHeader,
+		Fh:       input.Fh,
+		Owner:    input.Owner,
+	}
+	fs.record(_OP_SETLK, traceFlock|uint64(flags), unsafe.Pointer(&lk), unsafe.Sizeof(lk), nil, nil)
+	return fs.RawFS.Flock(cancel, input, flags)
+}
+
+func (fs *traceRawFileSystem) GetLk(cancel <-chan struct{}, in *LkIn, out *LkOut) (code Status) {
+	fs.record(_OP_GETLK, 0, unsafe.Pointer(in), unsafe.Sizeof(*in), nil, nil)
+	return fs.RawFS.GetLk(cancel, in, out)
+}
+
+func (fs *traceRawFileSystem) SetLk(cancel <-chan struct{}, in *LkIn) (code Status) {
+	fs.record(_OP_SETLK, 0, unsafe.Pointer(in), unsafe.Sizeof(*in), nil, nil)
+	return fs.RawFS.SetLk(cancel, in)
+}
+
+func (fs *traceRawFileSystem) SetLkw(cancel <-chan struct{}, in *LkIn) (code Status) {
+	fs.record(_OP_SETLKW, 0, unsafe.Pointer(in), unsafe.Sizeof(*in), nil, nil)
+	return fs.RawFS.SetLkw(cancel, in)
+}
+
+func (fs *traceRawFileSystem) Release(cancel <-chan struct{}, input *ReleaseIn) {
+	fs.record(_OP_RELEASE, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	fs.RawFS.Release(cancel, input)
+}
+
+func (fs *traceRawFileSystem) ReleaseDir(cancel <-chan struct{}, input *ReleaseIn) {
+	fs.record(_OP_RELEASEDIR, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	fs.RawFS.ReleaseDir(cancel, input)
+}
+
+func (fs *traceRawFileSystem) Write(cancel <-chan struct{}, input *WriteIn, data []byte) (written uint32, code Status) {
+	fs.record(_OP_WRITE, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, data)
+	return fs.RawFS.Write(cancel, input, data)
+}
+
+func (fs *traceRawFileSystem) Flush(cancel <-chan struct{}, input *FlushIn) Status {
+	fs.record(_OP_FLUSH, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.Flush(cancel, input)
+}
+
+func (fs *traceRawFileSystem) Fsync(cancel <-chan struct{}, input *FsyncIn) (code Status) {
+	fs.record(_OP_FSYNC, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.Fsync(cancel, input)
+}
+
+func (fs *traceRawFileSystem) ReadDir(cancel <-chan struct{}, input *ReadIn, out *DirEntryList) Status {
+	fs.record(_OP_READDIR, uint64(out.size), unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.ReadDir(cancel, input, out)
+}
+
+func (fs *traceRawFileSystem) ReadDirPlus(cancel <-chan struct{}, input *ReadIn, out *DirEntryList) Status {
+	fs.record(_OP_READDIRPLUS, uint64(out.size), unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.ReadDirPlus(cancel, input, out)
+}
+
+func (fs *traceRawFileSystem) FsyncDir(cancel <-chan struct{}, input *FsyncIn) (code Status) {
+	fs.record(_OP_FSYNCDIR, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return fs.RawFS.FsyncDir(cancel, input)
+}
+
+func (fs *traceRawFileSystem) Init(s *Server) {
+	fs.mu.Lock()
+	fs.server = s
+	fs.mu.Unlock()
+	fs.RawFS.Init(s)
+}
+
+func (fs *traceRawFileSystem) StatFs(cancel <-chan struct{}, header *InHeader, out *StatfsOut) (code Status) {
+	fs.record(_OP_STATFS, 0, unsafe.Pointer(header), unsafe.Sizeof(*header), nil, nil)
+	return fs.RawFS.StatFs(cancel, header, out)
+}
+
+func (fs *traceRawFileSystem) Fallocate(cancel <-chan struct{}, in *FallocateIn) (code Status) {
+	fs.record(_OP_FALLOCATE, 0, unsafe.Pointer(in), unsafe.Sizeof(*in), nil, nil)
+	return fs.RawFS.Fallocate(cancel, in)
+}
+
+func (fs *traceRawFileSystem) Lseek(cancel <-chan struct{}, in *LseekIn, out *LseekOut) (code Status) {
+	fs.record(_OP_LSEEK, 0, unsafe.Pointer(in), unsafe.Sizeof(*in), nil, nil)
+	return fs.RawFS.Lseek(cancel, in, out)
+}
+
+func (fs *traceRawFileSystem) CopyFileRange(cancel <-chan struct{}, in *CopyFileRangeIn) (written uint32, code Status) {
+	fs.record(_OP_COPY_FILE_RANGE, 0, unsafe.Pointer(in), unsafe.Sizeof(*in), nil, nil)
+	return fs.RawFS.CopyFileRange(cancel, in)
+}
+
+func (fs *traceRawFileSystem) Poll(cancel <-chan struct{}, in *PollIn, out *PollOut) (code Status) {
+	fs.record(_OP_POLL, 0, unsafe.Pointer(in), unsafe.Sizeof(*in), nil, nil)
+	return fs.RawFS.Poll(cancel, in, out)
+}
+
+func (fs *traceRawFileSystem) Bmap(cancel <-chan struct{}, in *BmapIn, out *BmapOut) (code Status) {
+	fs.record(_OP_BMAP, 0, unsafe.Pointer(in), unsafe.Sizeof(*in), nil, nil)
+	return fs.RawFS.Bmap(cancel, in, out)
+}
+
+func (fs *traceRawFileSystem) Ioctl(cancel <-chan struct{}, in *IoctlIn, inbuf []byte, out *IoctlOut) (data []byte, code Status) {
+	fs.record(_OP_IOCTL, 0, unsafe.Pointer(in), unsafe.Sizeof(*in), nil, inbuf)
+	return fs.RawFS.Ioctl(cancel, in, inbuf, out)
+}
+
+func (fs *traceRawFileSystem) WriteFd(cancel <-chan struct{}, input *WriteIn) (fd uintptr, ok bool) {
+	w, ok := fs.RawFS.(SpliceWriteFileSystem)
+	if !ok {
+		return 0, false
+	}
+	fd, ok = w.WriteFd(cancel, input)
+	if ok {
+		// The server splices the data into fd, so only the
+		// header is recorded. If fs declines, the server
+		// falls back to Write, which records the call.
+		fs.record(_OP_WRITE, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	}
+	return fd, ok
+}
+
+func (fs *traceRawFileSystem) NodePath(nodeID uint64) (path string, ok bool) {
+	p, ok := fs.RawFS.(NodePathFileSystem)
+	if !ok {
+		return "", false
+	}
+	return p.NodePath(nodeID)
+}
+
+func (fs *traceRawFileSystem) Tmpfile(cancel <-chan struct{}, input *CreateIn, out *CreateOut) (code Status) {
+	t, ok := fs.RawFS.(TmpfileFileSystem)
+	if !ok {
+		return ENOSYS
+	}
+	fs.record(_OP_TMPFILE, 0, unsafe.Pointer(input), unsafe.Sizeof(*input), nil, nil)
+	return t.Tmpfile(cancel, input, out)
+}
+
+func (fs *traceRawFileSystem) String() string {
+	return fmt.Sprintf("Trace(%s)", fs.RawFS.String())
+}
+
+// traceRecord is a call read back from a trace.
+type traceRecord struct {
+	op    int32
+	aux   uint64
+	in    []byte
+	names []string
+	data  []byte
+}
+
+func readTraceBytes(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return b, nil
+}
+
+// readTraceRecord reads the next record. It returns io.EOF only at
+// the end of a complete trace.
+func readTraceRecord(r io.Reader) (*traceRecord, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	br := bytes.NewReader(buf)
+	rec := &traceRecord{}
+	if err := binary.Read(br, binary.LittleEndian, &rec.op); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err := binary.Read(br, binary.LittleEndian, &rec.aux); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var err error
+	if rec.in, err = readTraceBytes(br); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var count uint32
+	if err := binary.Read(br, binary.LittleEndian, &count); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	for i := uint32(0); i < count; i++ {
+		name, err := readTraceBytes(br)
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		rec.names = append(rec.names, string(name))
+	}
+	if rec.data, err = readTraceBytes(br); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return rec, nil
+}
+
+// load copies the recorded input into the struct at dst, and checks
+// that the record has the given number of names.
+func (rec *traceRecord) load(dst unsafe.Pointer, size uintptr, names int) error {
+	if uintptr(len(rec.in)) != size {
+		return fmt.Errorf("%s: got %d bytes of input, want %d",
+			operationName(rec.op), len(rec.in), size)
+	}
+	if len(rec.names) != names {
+		return fmt.Errorf("%s: got %d names, want %d",
+			operationName(rec.op), len(rec.names), names)
+	}
+	var b []byte
+	toSlice(&b, dst, size)
+	copy(b, rec.in)
+	return nil
+}
+
+// replay makes the recorded call on fs.
+func (rec *traceRecord) replay(fs RawFileSystem) error {
+	switch rec.op {
+	case _OP_LOOKUP:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		fs.Lookup(nil, &in, rec.names[0], &EntryOut{})
+	case _OP_FORGET:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Forget(in.NodeId, rec.aux)
+	case _OP_GETATTR:
+		var in GetAttrIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.GetAttr(nil, &in, &AttrOut{})
+	case _OP_SETATTR:
+		var in SetAttrIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.SetAttr(nil, &in, &AttrOut{})
+	case _OP_READLINK:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Readlink(nil, &in)
+	case _OP_SYMLINK:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 2); err != nil {
+			return err
+		}
+		fs.Symlink(nil, &in, rec.names[0], rec.names[1], &EntryOut{})
+	case _OP_MKNOD:
+		var in MknodIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		fs.Mknod(nil, &in, rec.names[0], &EntryOut{})
+	case _OP_MKDIR:
+		var in MkdirIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		fs.Mkdir(nil, &in, rec.names[0], &EntryOut{})
+	case _OP_UNLINK:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		fs.Unlink(nil, &in, rec.names[0])
+	case _OP_RMDIR:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		fs.Rmdir(nil, &in, rec.names[0])
+	case _OP_RENAME:
+		var in RenameIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 2); err != nil {
+			return err
+		}
+		fs.Rename(nil, &in, rec.names[0], rec.names[1])
+	case _OP_LINK:
+		var in LinkIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		fs.Link(nil, &in, rec.names[0], &EntryOut{})
+	case _OP_OPEN:
+		var in OpenIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Open(nil, &in, &OpenOut{})
+	case _OP_READ:
+		var in ReadIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		buf := make([]byte, rec.aux)
+		if res, _ := fs.Read(nil, &in, buf); res != nil {
+			res.Bytes(buf)
+			res.Done()
+		}
+	case _OP_WRITE:
+		var in WriteIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Write(nil, &in, rec.data)
+	case _OP_STATFS:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.StatFs(nil, &in, &StatfsOut{})
+	case _OP_RELEASE:
+		var in ReleaseIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Release(nil, &in)
+	case _OP_FSYNC:
+		var in FsyncIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Fsync(nil, &in)
+	case _OP_SETXATTR:
+		var in SetXAttrIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		fs.SetXAttr(nil, &in, rec.names[0], rec.data)
+	case _OP_GETXATTR:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		if rec.aux == 0 {
+			fs.GetXAttrSize(nil, &in, rec.names[0])
+		} else {
+			fs.GetXAttrData(nil, &in, rec.names[0])
+		}
+	case _OP_LISTXATTR:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.ListXAttr(nil, &in)
+	case _OP_REMOVEXATTR:
+		var in InHeader
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		fs.RemoveXAttr(nil, &in, rec.names[0])
+	case _OP_FLUSH:
+		var in FlushIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Flush(nil, &in)
+	case _OP_OPENDIR:
+		var in OpenIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.OpenDir(nil, &in, &OpenOut{})
+	case _OP_READDIR:
+		var in ReadIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.ReadDir(nil, &in, NewDirEntryList(make([]byte, rec.aux), in.Offset))
+	case _OP_READDIRPLUS:
+		var in ReadIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.ReadDirPlus(nil, &in, NewDirEntryList(make([]byte, rec.aux), in.Offset))
+	case _OP_RELEASEDIR:
+		var in ReleaseIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.ReleaseDir(nil, &in)
+	case _OP_FSYNCDIR:
+		var in FsyncIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.FsyncDir(nil, &in)
+	case _OP_GETLK:
+		var in LkIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.GetLk(nil, &in, &LkOut{})
+	case _OP_SETLK, _OP_SETLKW:
+		var in LkIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		if rec.aux&traceFlock != 0 {
+			flockIn := FlockIn{
+				InHeader: in.InHeader,
+				Fh:       in.Fh,
+				Owner:    in.Owner,
+			}
+			fs.Flock(nil, &flockIn, int(rec.aux&^traceFlock))
+		} else if rec.op == _OP_SETLK {
+			fs.SetLk(nil, &in)
+		} else {
+			fs.SetLkw(nil, &in)
+		}
+	case _OP_ACCESS:
+		var in AccessIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Access(nil, &in)
+	case _OP_CREATE:
+		var in CreateIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 1); err != nil {
+			return err
+		}
+		fs.Create(nil, &in, rec.names[0], &CreateOut{})
+	case _OP_TMPFILE:
+		var in CreateIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		if t, ok := fs.(TmpfileFileSystem); ok {
+			t.Tmpfile(nil, &in, &CreateOut{})
+		}
+	case _OP_BMAP:
+		var in BmapIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Bmap(nil, &in, &BmapOut{})
+	case _OP_IOCTL:
+		var in IoctlIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Ioctl(nil, &in, rec.data, &IoctlOut{})
+	case _OP_POLL:
+		var in PollIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Poll(nil, &in, &PollOut{})
+	case _OP_FALLOCATE:
+		var in FallocateIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Fallocate(nil, &in)
+	case _OP_LSEEK:
+		var in LseekIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.Lseek(nil, &in, &LseekOut{})
+	case _OP_COPY_FILE_RANGE:
+		var in CopyFileRangeIn
+		if err := rec.load(unsafe.Pointer(&in), unsafe.Sizeof(in), 0); err != nil {
+			return err
+		}
+		fs.CopyFileRange(nil, &in)
+	default:
+		return fmt.Errorf("trace has unknown opcode %d", rec.op)
+	}
+	return nil
+}
+
+// ReplayTrace reads a trace written through NewTraceRawFileSystem,
+// and makes the same calls on fs, in the order they were recorded,
+// for example on the RawFS() of a fresh FileSystemConnector.
+// Concurrent calls are replayed one by one. It returns an error if
+// the trace is malformed.
+func ReplayTrace(r io.Reader, fs RawFileSystem) error {
+	for {
+		rec, err := readTraceRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := rec.replay(fs); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fuse
+
+import (
+	"syscall"
+)
+
+// devTransport is the Transport over a FUSE device descriptor.
+type devTransport struct {
+	fd int
+}
+
+func (t *devTransport) Read(buf []byte) (int, error) {
+	return sysRead(t.fd, buf)
+}
+
+func (t *devTransport) Write(msg [][]byte) error {
+	if len(msg) == 1 {
+		return handleEINTR(func() error {
+			_, err := syscall.Write(t.fd, msg[0])
+			return err
+		})
+	}
+	_, err := writev(t.fd, msg)
+	return err
+}
+
+func (t *devTransport) Close() error {
+	return syscall.Close(t.fd)
+}
@@ -5,6 +5,7 @@
 package fuse
 
 import (
+	"os"
 	"syscall"
 )
 
@@ -27,6 +28,9 @@ const (
 	// EAGAIN Resource temporarily unavailable
 	EAGAIN = Status(syscall.EAGAIN)
 
+	// EINTR Interrupted system call
+	EINTR = Status(syscall.EINTR)
+
 	// EINVAL Invalid argument
 	EINVAL = Status(syscall.EINVAL)
 
@@ -39,9 +43,6 @@ const (
 	// ENOSYS Function not implemented
 	ENOSYS = Status(syscall.ENOSYS)
 
-	// ENODATA No data available
-	ENODATA = Status(syscall.ENODATA)
-
 	// ENOTDIR Not a directory
 	ENOTDIR = Status(syscall.ENOTDIR)
 
@@ -62,6 +63,9 @@ const (
 
 	// EROFS Read-only file system
 	EROFS = Status(syscall.EROFS)
+
+	// ESTALE Stale file handle
+	ESTALE = Status(syscall.ESTALE)
 )
 
 type ForgetIn struct {
@@ -89,18 +93,38 @@ type MkdirIn struct {
 	Umask uint32
 }
 
+// RenameIn is the input for RENAME and RENAME2. Flags holds the
+// RENAME_* flags of renameat2(2); it is always zero for RENAME.
 type RenameIn struct {
-	InHeader
-	Newdir uint64
-}
-
-type Rename2In struct {
 	InHeader
 	Newdir  uint64
 	Flags   uint32
 	Padding uint32
 }
 
+// Rename2In is the earlier name of RenameIn, from when RENAME had an
+// input of its own.
+type Rename2In = RenameIn
+
+// Rename1In is the input of RENAME as sent by the kernel.
+type Rename1In struct {
+	InHeader
+	Newdir uint64
+}
+
+const (
+	// RENAME_NOREPLACE fails the rename with EEXIST if the
+	// destination exists.
+	RENAME_NOREPLACE = (1 << 0)
+
+	// RENAME_EXCHANGE swaps the source and the destination,
+	// which must both exist.
+	RENAME_EXCHANGE = (1 << 1)
+
+	// RENAME_WHITEOUT leaves a whiteout at the source.
+	RENAME_WHITEOUT = (1 << 2)
+)
+
 type LinkIn struct {
 	InHeader
 	Oldnodeid uint64
@@ -166,6 +190,8 @@ const (
 	FOPEN_DIRECT_IO   = (1 << 0)
 	FOPEN_KEEP_CACHE  = (1 << 1)
 	FOPEN_NONSEEKABLE = (1 << 2)
+	FOPEN_CACHE_DIR   = (1 << 3) // protocol version 28.
+	FOPEN_STREAM      = (1 << 4) // protocol version 31.
 )
 
 type OpenOut struct {
@@ -197,6 +223,9 @@ const (
 	CAP_PARALLEL_DIROPS  = (1 << 18)
 	CAP_HANDLE_KILLPRIV  = (1 << 19)
 	CAP_POSIX_ACL        = (1 << 20)
+	CAP_ABORT_ERROR      = (1 << 21)
+	CAP_MAX_PAGES        = (1 << 22)
+	CAP_CACHE_SYMLINKS   = (1 << 23)
 )
 
 type InitIn struct {
@@ -217,7 +246,9 @@ type InitOut struct {
 	CongestionThreshold uint16
 	MaxWrite            uint32
 	TimeGran            uint32
-	Unused              [9]uint32
+	MaxPages            uint16
+	Padding             uint16
+	Unused              [8]uint32
 }
 
 type _CuseInitIn struct {
@@ -245,14 +276,14 @@ type InterruptIn struct {
 	Unique uint64
 }
 
-type _BmapIn struct {
+type BmapIn struct {
 	InHeader
 	Block     uint64
 	Blocksize uint32
 	Padding   uint32
 }
 
-type _BmapOut struct {
+type BmapOut struct {
 	Block uint64
 }
 
@@ -262,7 +293,7 @@ const (
 	FUSE_IOCTL_RETRY        = (1 << 2)
 )
 
-type _IoctlIn struct {
+type IoctlIn struct {
 	InHeader
 	Fh      uint64
 	Flags   uint32
@@ -272,22 +303,29 @@ type _IoctlIn struct {
 	OutSize uint32
 }
 
-type _IoctlOut struct {
+type IoctlOut struct {
 	Result  int32
 	Flags   uint32
 	InIovs  uint32
 	OutIovs uint32
 }
 
-type _PollIn struct {
+// IoctlIovec describes a buffer in the address space of the process
+// calling ioctl(2).
+type IoctlIovec struct {
+	Base uint64
+	Len  uint64
+}
+
+type PollIn struct {
 	InHeader
-	Fh      uint64
-	Kh      uint64
-	Flags   uint32
-	Padding uint32
+	Fh     uint64
+	Kh     uint64
+	Flags  uint32
+	Events uint32
 }
 
-type _PollOut struct {
+type PollOut struct {
 	Revents uint32
 	Padding uint32
 }
@@ -306,24 +344,55 @@ type GetXAttrOut struct {
 	Padding uint32
 }
 
-type _FileLock struct {
+// FileLock describes a POSIX byte range lock. End is inclusive;
+// a lock that extends to the end of the file has End set to
+// OFFSET_MAX.
+type FileLock struct {
 	Start uint64
 	End   uint64
 	Typ   uint32
 	Pid   uint32
 }
 
-type _LkIn struct {
+// ToFlockT fills a syscall.Flock_t with the range and type of the
+// lock.
+func (lk *FileLock) ToFlockT(flockT *syscall.Flock_t) {
+	flockT.Start = int64(lk.Start)
+	if lk.End == OFFSET_MAX {
+		flockT.Len = 0
+	} else {
+		flockT.Len = int64(lk.End - lk.Start + 1)
+	}
+	flockT.Whence = int16(os.SEEK_SET)
+	flockT.Type = int16(lk.Typ)
+}
+
+// FromFlockT sets the lock from a syscall.Flock_t, as returned by
+// F_GETLK.
+func (lk *FileLock) FromFlockT(flockT *syscall.Flock_t) {
+	lk.Typ = uint32(flockT.Type)
+	if flockT.Type != syscall.F_UNLCK {
+		lk.Start = uint64(flockT.Start)
+		if flockT.Len == 0 {
+			lk.End = OFFSET_MAX
+		} else {
+			lk.End = uint64(flockT.Start + flockT.Len - 1)
+		}
+	}
+	lk.Pid = uint32(flockT.Pid)
+}
+
+type LkIn struct {
 	InHeader
 	Fh      uint64
 	Owner   uint64
-	Lk      _FileLock
+	Lk      FileLock
 	LkFlags uint32
 	Padding uint32
 }
 
-type _LkOut struct {
-	Lk _FileLock
+type LkOut struct {
+	Lk FileLock
 }
 
 // For AccessIn.Mask.
@@ -362,9 +431,13 @@ type NotifyInvalInodeOut struct {
 type NotifyInvalEntryOut struct {
 	Parent  uint64
 	NameLen uint32
-	Padding uint32
+	Flags   uint32
 }
 
+// NOTIFY_EXPIRE_ONLY in NotifyInvalEntryOut.Flags marks the entry as
+// expired rather than dropping it. Protocol version 7.38.
+const NOTIFY_EXPIRE_ONLY = (1 << 0)
+
 type NotifyInvalDeleteOut struct {
 	Parent  uint64
 	Child   uint64
@@ -372,12 +445,39 @@ type NotifyInvalDeleteOut struct {
 	Padding uint32
 }
 
+type NotifyStoreOut struct {
+	Nodeid  uint64
+	Offset  uint64
+	Size    uint32
+	Padding uint32
+}
+
+type NotifyRetrieveOut struct {
+	NotifyUnique uint64
+	Nodeid       uint64
+	Offset       uint64
+	Size         uint32
+	Padding      uint32
+}
+
+// NotifyRetrieveIn is the kernel's answer to NOTIFY_RETRIEVE. It
+// has the size of WriteIn, and is followed by the data.
+type NotifyRetrieveIn struct {
+	InHeader
+	Dummy1 uint64
+	Offset uint64
+	Size   uint32
+	Dummy2 uint32
+	Dummy3 uint64
+	Dummy4 uint64
+}
+
 const (
-	//	NOTIFY_POLL         = -1
-	NOTIFY_INVAL_INODE = -2
-	NOTIFY_INVAL_ENTRY = -3
-	//	NOTIFY_STORE        = -4
-	//	NOTIFY_RETRIEVE     = -5
+	NOTIFY_POLL         = -1
+	NOTIFY_INVAL_INODE  = -2
+	NOTIFY_INVAL_ENTRY  = -3
+	NOTIFY_STORE        = -4
+	NOTIFY_RETRIEVE     = -5
 	NOTIFY_INVAL_DELETE = -6
 
 //	NOTIFY_CODE_MAX     = -6
@@ -413,17 +513,30 @@ type CreateOut struct {
 	OpenOut
 }
 
-type Context struct {
+// Caller has data on the process making the request.
+type Caller struct {
 	Owner
 	Pid uint32
 }
 
+// Context carries the caller of a request, and a channel that is
+// closed if the kernel interrupts the request.
+type Context struct {
+	Caller
+
+	// Cancel is closed when the kernel sends an INTERRUPT for
+	// the request, typically because the calling process got a
+	// signal. Operations that may block for a long time should
+	// select on it and return EINTR.
+	Cancel <-chan struct{}
+}
+
 type InHeader struct {
 	Length uint32
 	Opcode int32
 	Unique uint64
 	NodeId uint64
-	Context
+	Caller
 	Padding uint32
 }
 
@@ -467,7 +580,34 @@ type FallocateIn struct {
 	Padding uint32
 }
 
+type LseekIn struct {
+	InHeader
+	Fh      uint64
+	Offset  uint64
+	Whence  uint32
+	Padding uint32
+}
+
+type LseekOut struct {
+	Offset uint64
+}
+
+type CopyFileRangeIn struct {
+	InHeader
+	FhIn      uint64
+	OffIn     uint64
+	NodeIdOut uint64
+	FhOut     uint64
+	OffOut    uint64
+	Len       uint64
+	Flags     uint64
+}
+
 type FlockIn struct {
 	InHeader
 	Fh uint64
+
+	// Owner identifies the open file description holding the
+	// lock.
+	Owner uint64
 }
@@ -10,6 +10,9 @@ import (
 
 const (
 	ENOATTR = Status(syscall.ENOATTR) // ENOATTR is not defined for all GOOS.
+
+	// ENODATA No data available
+	ENODATA = Status(syscall.ENODATA)
 )
 
 type Attr struct {
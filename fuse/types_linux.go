@@ -10,6 +10,9 @@ import (
 
 const (
 	ENOATTR = Status(syscall.ENODATA) // On Linux, ENOATTR is an alias for ENODATA.
+
+	// ENODATA No data available
+	ENODATA = Status(syscall.ENODATA)
 )
 
 type Attr struct {
@@ -38,20 +38,20 @@ func (fs *wrappingFS) SetDebug(dbg bool) {
 	}
 }
 
-func (fs *wrappingFS) StatFs(header *InHeader, out *StatfsOut) Status {
+func (fs *wrappingFS) StatFs(cancel <-chan struct{}, header *InHeader, out *StatfsOut) Status {
 	if s, ok := fs.fs.(interface {
-		StatFs(header *InHeader, out *StatfsOut) Status
+		StatFs(cancel <-chan struct{}, header *InHeader, out *StatfsOut) Status
 	}); ok {
-		return s.StatFs(header, out)
+		return s.StatFs(cancel, header, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Lookup(header *InHeader, name string, out *EntryOut) (code Status) {
+func (fs *wrappingFS) Lookup(cancel <-chan struct{}, header *InHeader, name string, out *EntryOut) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Lookup(header *InHeader, name string, out *EntryOut) (code Status)
+		Lookup(cancel <-chan struct{}, header *InHeader, name string, out *EntryOut) (code Status)
 	}); ok {
-		return s.Lookup(header, name, out)
+		return s.Lookup(cancel, header, name, out)
 	}
 	return ENOSYS
 }
@@ -64,270 +64,342 @@ func (fs *wrappingFS) Forget(nodeID, nlookup uint64) {
 	}
 }
 
-func (fs *wrappingFS) GetAttr(input *GetAttrIn, out *AttrOut) (code Status) {
+func (fs *wrappingFS) GetAttr(cancel <-chan struct{}, input *GetAttrIn, out *AttrOut) (code Status) {
 	if s, ok := fs.fs.(interface {
-		GetAttr(input *GetAttrIn, out *AttrOut) (code Status)
+		GetAttr(cancel <-chan struct{}, input *GetAttrIn, out *AttrOut) (code Status)
 	}); ok {
-		return s.GetAttr(input, out)
+		return s.GetAttr(cancel, input, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Open(input *OpenIn, out *OpenOut) (status Status) {
+func (fs *wrappingFS) Open(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status) {
 	if s, ok := fs.fs.(interface {
-		Open(input *OpenIn, out *OpenOut) (status Status)
+		Open(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status)
 	}); ok {
-		return s.Open(input, out)
+		return s.Open(cancel, input, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) SetAttr(input *SetAttrIn, out *AttrOut) (code Status) {
+func (fs *wrappingFS) SetAttr(cancel <-chan struct{}, input *SetAttrIn, out *AttrOut) (code Status) {
 	if s, ok := fs.fs.(interface {
-		SetAttr(input *SetAttrIn, out *AttrOut) (code Status)
+		SetAttr(cancel <-chan struct{}, input *SetAttrIn, out *AttrOut) (code Status)
 	}); ok {
-		return s.SetAttr(input, out)
+		return s.SetAttr(cancel, input, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Readlink(header *InHeader) (out []byte, code Status) {
+func (fs *wrappingFS) Readlink(cancel <-chan struct{}, header *InHeader) (out []byte, code Status) {
 	if s, ok := fs.fs.(interface {
-		Readlink(header *InHeader) (out []byte, code Status)
+		Readlink(cancel <-chan struct{}, header *InHeader) (out []byte, code Status)
 	}); ok {
-		return s.Readlink(header)
+		return s.Readlink(cancel, header)
 	}
 	return nil, ENOSYS
 }
 
-func (fs *wrappingFS) Mknod(input *MknodIn, name string, out *EntryOut) (code Status) {
+func (fs *wrappingFS) Mknod(cancel <-chan struct{}, input *MknodIn, name string, out *EntryOut) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Mknod(input *MknodIn, name string, out *EntryOut) (code Status)
+		Mknod(cancel <-chan struct{}, input *MknodIn, name string, out *EntryOut) (code Status)
 	}); ok {
-		return s.Mknod(input, name, out)
+		return s.Mknod(cancel, input, name, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Mkdir(input *MkdirIn, name string, out *EntryOut) (code Status) {
+func (fs *wrappingFS) Mkdir(cancel <-chan struct{}, input *MkdirIn, name string, out *EntryOut) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Mkdir(input *MkdirIn, name string, out *EntryOut) (code Status)
+		Mkdir(cancel <-chan struct{}, input *MkdirIn, name string, out *EntryOut) (code Status)
 	}); ok {
-		return s.Mkdir(input, name, out)
+		return s.Mkdir(cancel, input, name, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Unlink(header *InHeader, name string) (code Status) {
+func (fs *wrappingFS) Unlink(cancel <-chan struct{}, header *InHeader, name string) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Unlink(header *InHeader, name string) (code Status)
+		Unlink(cancel <-chan struct{}, header *InHeader, name string) (code Status)
 	}); ok {
-		return s.Unlink(header, name)
+		return s.Unlink(cancel, header, name)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Rmdir(header *InHeader, name string) (code Status) {
+func (fs *wrappingFS) Rmdir(cancel <-chan struct{}, header *InHeader, name string) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Rmdir(header *InHeader, name string) (code Status)
+		Rmdir(cancel <-chan struct{}, header *InHeader, name string) (code Status)
 	}); ok {
-		return s.Rmdir(header, name)
+		return s.Rmdir(cancel, header, name)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Symlink(header *InHeader, pointedTo string, linkName string, out *EntryOut) (code Status) {
+func (fs *wrappingFS) Symlink(cancel <-chan struct{}, header *InHeader, pointedTo string, linkName string, out *EntryOut) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Symlink(header *InHeader, pointedTo string, linkName string, out *EntryOut) (code Status)
+		Symlink(cancel <-chan struct{}, header *InHeader, pointedTo string, linkName string, out *EntryOut) (code Status)
 	}); ok {
-		return s.Symlink(header, pointedTo, linkName, out)
+		return s.Symlink(cancel, header, pointedTo, linkName, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Rename(input *RenameIn, oldName string, newName string) (code Status) {
+func (fs *wrappingFS) Rename(cancel <-chan struct{}, input *RenameIn, oldName string, newName string) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Rename(input *RenameIn, oldName string, newName string) (code Status)
+		Rename(cancel <-chan struct{}, input *RenameIn, oldName string, newName string) (code Status)
 	}); ok {
-		return s.Rename(input, oldName, newName)
+		return s.Rename(cancel, input, oldName, newName)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Link(input *LinkIn, name string, out *EntryOut) (code Status) {
+func (fs *wrappingFS) Link(cancel <-chan struct{}, input *LinkIn, name string, out *EntryOut) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Link(input *LinkIn, name string, out *EntryOut) (code Status)
+		Link(cancel <-chan struct{}, input *LinkIn, name string, out *EntryOut) (code Status)
 	}); ok {
-		return s.Link(input, name, out)
+		return s.Link(cancel, input, name, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) GetXAttrSize(header *InHeader, attr string) (size int, code Status) {
+func (fs *wrappingFS) GetXAttrSize(cancel <-chan struct{}, header *InHeader, attr string) (size int, code Status) {
 	if s, ok := fs.fs.(interface {
-		GetXAttrSize(header *InHeader, attr string) (size int, code Status)
+		GetXAttrSize(cancel <-chan struct{}, header *InHeader, attr string) (size int, code Status)
 	}); ok {
-		return s.GetXAttrSize(header, attr)
+		return s.GetXAttrSize(cancel, header, attr)
 	}
 	return 0, ENOSYS
 }
 
-func (fs *wrappingFS) GetXAttrData(header *InHeader, attr string) (data []byte, code Status) {
+func (fs *wrappingFS) GetXAttrData(cancel <-chan struct{}, header *InHeader, attr string) (data []byte, code Status) {
 	if s, ok := fs.fs.(interface {
-		GetXAttrData(header *InHeader, attr string) (data []byte, code Status)
+		GetXAttrData(cancel <-chan struct{}, header *InHeader, attr string) (data []byte, code Status)
 	}); ok {
-		return s.GetXAttrData(header, attr)
+		return s.GetXAttrData(cancel, header, attr)
 	}
 	return nil, ENOSYS
 }
 
-func (fs *wrappingFS) SetXAttr(input *SetXAttrIn, attr string, data []byte) Status {
+func (fs *wrappingFS) SetXAttr(cancel <-chan struct{}, input *SetXAttrIn, attr string, data []byte) Status {
 	if s, ok := fs.fs.(interface {
-		SetXAttr(input *SetXAttrIn, attr string, data []byte) Status
+		SetXAttr(cancel <-chan struct{}, input *SetXAttrIn, attr string, data []byte) Status
 	}); ok {
-		return s.SetXAttr(input, attr, data)
+		return s.SetXAttr(cancel, input, attr, data)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) ListXAttr(header *InHeader) (data []byte, code Status) {
+func (fs *wrappingFS) ListXAttr(cancel <-chan struct{}, header *InHeader) (data []byte, code Status) {
 	if s, ok := fs.fs.(interface {
-		ListXAttr(header *InHeader) (data []byte, code Status)
+		ListXAttr(cancel <-chan struct{}, header *InHeader) (data []byte, code Status)
 	}); ok {
-		return s.ListXAttr(header)
+		return s.ListXAttr(cancel, header)
 	}
 	return nil, ENOSYS
 }
 
-func (fs *wrappingFS) RemoveXAttr(header *InHeader, attr string) Status {
+func (fs *wrappingFS) RemoveXAttr(cancel <-chan struct{}, header *InHeader, attr string) Status {
 	if s, ok := fs.fs.(interface {
-		RemoveXAttr(header *InHeader, attr string) Status
+		RemoveXAttr(cancel <-chan struct{}, header *InHeader, attr string) Status
 	}); ok {
-		return s.RemoveXAttr(header, attr)
+		return s.RemoveXAttr(cancel, header, attr)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Access(input *AccessIn) (code Status) {
+func (fs *wrappingFS) Access(cancel <-chan struct{}, input *AccessIn) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Access(input *AccessIn) (code Status)
+		Access(cancel <-chan struct{}, input *AccessIn) (code Status)
 	}); ok {
-		return s.Access(input)
+		return s.Access(cancel, input)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Create(input *CreateIn, name string, out *CreateOut) (code Status) {
+func (fs *wrappingFS) Create(cancel <-chan struct{}, input *CreateIn, name string, out *CreateOut) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Create(input *CreateIn, name string, out *CreateOut) (code Status)
+		Create(cancel <-chan struct{}, input *CreateIn, name string, out *CreateOut) (code Status)
 	}); ok {
-		return s.Create(input, name, out)
+		return s.Create(cancel, input, name, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) OpenDir(input *OpenIn, out *OpenOut) (status Status) {
+func (fs *wrappingFS) OpenDir(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status) {
 	if s, ok := fs.fs.(interface {
-		OpenDir(input *OpenIn, out *OpenOut) (status Status)
+		OpenDir(cancel <-chan struct{}, input *OpenIn, out *OpenOut) (status Status)
 	}); ok {
-		return s.OpenDir(input, out)
+		return s.OpenDir(cancel, input, out)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Read(input *ReadIn, buf []byte) (ReadResult, Status) {
+func (fs *wrappingFS) Read(cancel <-chan struct{}, input *ReadIn, buf []byte) (ReadResult, Status) {
 	if s, ok := fs.fs.(interface {
-		Read(input *ReadIn, buf []byte) (ReadResult, Status)
+		Read(cancel <-chan struct{}, input *ReadIn, buf []byte) (ReadResult, Status)
 	}); ok {
-		return s.Read(input, buf)
+		return s.Read(cancel, input, buf)
 	}
 	return nil, ENOSYS
 }
 
-func (fs *wrappingFS) Flock(input *FlockIn, flags int) Status {
+func (fs *wrappingFS) Flock(cancel <-chan struct{}, input *FlockIn, flags int) Status {
 	if s, ok := fs.fs.(interface {
-		Flock(input *FlockIn, flags int) Status
+		Flock(cancel <-chan struct{}, input *FlockIn, flags int) Status
 	}); ok {
-		return s.Flock(input, flags)
+		return s.Flock(cancel, input, flags)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Release(input *ReleaseIn) {
+func (fs *wrappingFS) Lseek(cancel <-chan struct{}, input *LseekIn, out *LseekOut) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Release(input *ReleaseIn)
+		Lseek(cancel <-chan struct{}, input *LseekIn, out *LseekOut) (code Status)
 	}); ok {
-		s.Release(input)
+		return s.Lseek(cancel, input, out)
+	}
+	return ENOSYS
+}
+
+func (fs *wrappingFS) Bmap(cancel <-chan struct{}, input *BmapIn, out *BmapOut) (code Status) {
+	if s, ok := fs.fs.(interface {
+		Bmap(cancel <-chan struct{}, input *BmapIn, out *BmapOut) (code Status)
+	}); ok {
+		return s.Bmap(cancel, input, out)
+	}
+	return ENOSYS
+}
+
+func (fs *wrappingFS) Ioctl(cancel <-chan struct{}, input *IoctlIn, inbuf []byte, out *IoctlOut) (data []byte, code Status) {
+	if s, ok := fs.fs.(interface {
+		Ioctl(cancel <-chan struct{}, input *IoctlIn, inbuf []byte, out *IoctlOut) (data []byte, code Status)
+	}); ok {
+		return s.Ioctl(cancel, input, inbuf, out)
+	}
+	return nil, ENOSYS
+}
+
+func (fs *wrappingFS) Poll(cancel <-chan struct{}, input *PollIn, out *PollOut) (code Status) {
+	if s, ok := fs.fs.(interface {
+		Poll(cancel <-chan struct{}, input *PollIn, out *PollOut) (code Status)
+	}); ok {
+		return s.Poll(cancel, input, out)
+	}
+	return ENOSYS
+}
+
+func (fs *wrappingFS) CopyFileRange(cancel <-chan struct{}, input *CopyFileRangeIn) (written uint32, code Status) {
+	if s, ok := fs.fs.(interface {
+		CopyFileRange(cancel <-chan struct{}, input *CopyFileRangeIn) (written uint32, code Status)
+	}); ok {
+		return s.CopyFileRange(cancel, input)
+	}
+	return 0, ENOSYS
+}
+
+func (fs *wrappingFS) GetLk(cancel <-chan struct{}, input *LkIn, out *LkOut) (code Status) {
+	if s, ok := fs.fs.(interface {
+		GetLk(cancel <-chan struct{}, input *LkIn, out *LkOut) (code Status)
+	}); ok {
+		return s.GetLk(cancel, input, out)
+	}
+	return ENOSYS
+}
+
+func (fs *wrappingFS) SetLk(cancel <-chan struct{}, input *LkIn) (code Status) {
+	if s, ok := fs.fs.(interface {
+		SetLk(cancel <-chan struct{}, input *LkIn) (code Status)
+	}); ok {
+		return s.SetLk(cancel, input)
+	}
+	return ENOSYS
+}
+
+func (fs *wrappingFS) SetLkw(cancel <-chan struct{}, input *LkIn) (code Status) {
+	if s, ok := fs.fs.(interface {
+		SetLkw(cancel <-chan struct{}, input *LkIn) (code Status)
+	}); ok {
+		return s.SetLkw(cancel, input)
+	}
+	return ENOSYS
+}
+
+func (fs *wrappingFS) Release(cancel <-chan struct{}, input *ReleaseIn) {
+	if s, ok := fs.fs.(interface {
+		Release(cancel <-chan struct{}, input *ReleaseIn)
+	}); ok {
+		s.Release(cancel, input)
 	}
 }
 
-func (fs *wrappingFS) Write(input *WriteIn, data []byte) (written uint32, code Status) {
+func (fs *wrappingFS) Write(cancel <-chan struct{}, input *WriteIn, data []byte) (written uint32, code Status) {
 	if s, ok := fs.fs.(interface {
-		Write(input *WriteIn, data []byte) (written uint32, code Status)
+		Write(cancel <-chan struct{}, input *WriteIn, data []byte) (written uint32, code Status)
 	}); ok {
-		return s.Write(input, data)
+		return s.Write(cancel, input, data)
 	}
 	return 0, ENOSYS
 }
 
-func (fs *wrappingFS) Flush(input *FlushIn) Status {
+func (fs *wrappingFS) Flush(cancel <-chan struct{}, input *FlushIn) Status {
 	if s, ok := fs.fs.(interface {
-		Flush(input *FlushIn) Status
+		Flush(cancel <-chan struct{}, input *FlushIn) Status
 	}); ok {
-		return s.Flush(input)
+		return s.Flush(cancel, input)
 	}
 	return OK
 }
 
-func (fs *wrappingFS) Fsync(input *FsyncIn) (code Status) {
+func (fs *wrappingFS) Fsync(cancel <-chan struct{}, input *FsyncIn) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Fsync(input *FsyncIn) (code Status)
+		Fsync(cancel <-chan struct{}, input *FsyncIn) (code Status)
 	}); ok {
-		return s.Fsync(input)
+		return s.Fsync(cancel, input)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) ReadDir(input *ReadIn, l *DirEntryList) Status {
+func (fs *wrappingFS) ReadDir(cancel <-chan struct{}, input *ReadIn, l *DirEntryList) Status {
 	if s, ok := fs.fs.(interface {
-		ReadDir(input *ReadIn, l *DirEntryList) Status
+		ReadDir(cancel <-chan struct{}, input *ReadIn, l *DirEntryList) Status
 	}); ok {
-		return s.ReadDir(input, l)
+		return s.ReadDir(cancel, input, l)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) ReadDirPlus(input *ReadIn, l *DirEntryList) Status {
+func (fs *wrappingFS) ReadDirPlus(cancel <-chan struct{}, input *ReadIn, l *DirEntryList) Status {
 	if s, ok := fs.fs.(interface {
-		ReadDirPlus(input *ReadIn, l *DirEntryList) Status
+		ReadDirPlus(cancel <-chan struct{}, input *ReadIn, l *DirEntryList) Status
 	}); ok {
-		return s.ReadDirPlus(input, l)
+		return s.ReadDirPlus(cancel, input, l)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) ReleaseDir(input *ReleaseIn) {
+func (fs *wrappingFS) ReleaseDir(cancel <-chan struct{}, input *ReleaseIn) {
 	if s, ok := fs.fs.(interface {
-		ReleaseDir(input *ReleaseIn)
+		ReleaseDir(cancel <-chan struct{}, input *ReleaseIn)
 	}); ok {
-		s.ReleaseDir(input)
+		s.ReleaseDir(cancel, input)
 	}
 }
 
-func (fs *wrappingFS) FsyncDir(input *FsyncIn) (code Status) {
+func (fs *wrappingFS) FsyncDir(cancel <-chan struct{}, input *FsyncIn) (code Status) {
 	if s, ok := fs.fs.(interface {
-		FsyncDir(input *FsyncIn) (code Status)
+		FsyncDir(cancel <-chan struct{}, input *FsyncIn) (code Status)
 	}); ok {
-		return s.FsyncDir(input)
+		return s.FsyncDir(cancel, input)
 	}
 	return ENOSYS
 }
 
-func (fs *wrappingFS) Fallocate(in *FallocateIn) (code Status) {
+func (fs *wrappingFS) Fallocate(cancel <-chan struct{}, in *FallocateIn) (code Status) {
 	if s, ok := fs.fs.(interface {
-		Fallocate(in *FallocateIn) (code Status)
+		Fallocate(cancel <-chan struct{}, in *FallocateIn) (code Status)
 	}); ok {
-		return s.Fallocate(in)
+		return s.Fallocate(cancel, in)
 	}
 	return ENOSYS
 }
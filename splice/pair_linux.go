@@ -28,6 +28,12 @@ func (p *Pair) LoadFrom(fd uintptr, sz int) (int, error) {
 	return int(n), err
 }
 
+// WriteToAt moves n bytes from the pipe into fd at offset off.
+func (p *Pair) WriteToAt(fd uintptr, n int, off int64) (int, error) {
+	m, err := syscall.Splice(p.r, nil, int(fd), &off, n, 0)
+	return int(m), err
+}
+
 func (p *Pair) WriteTo(fd uintptr, n int) (int, error) {
 	m, err := syscall.Splice(p.r, nil, int(fd), nil, int(n), 0)
 	if err != nil {
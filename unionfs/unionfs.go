@@ -90,6 +90,18 @@ const (
 	_DROP_CACHE = ".drop_cache"
 )
 
+// LayeredFileSystem is implemented by the file systems NewUnionFs
+// returns.
+type LayeredFileSystem interface {
+	pathfs.FileSystem
+
+	// Layers returns the branches of the union, the writable
+	// one first.
+	Layers() []pathfs.FileSystem
+}
+
+// NewUnionFs returns a union of fileSystems, of which the first is
+// writable. The result is a LayeredFileSystem.
 func NewUnionFs(fileSystems []pathfs.FileSystem, options UnionFsOptions) (pathfs.FileSystem, error) {
 	g := &unionFS{
 		options:     &options,
@@ -975,6 +987,10 @@ func (fs *unionFS) Open(name string, flags uint32, context *fuse.Context) (fuseF
 	return fuseFile, status
 }
 
+func (fs *unionFS) Layers() []pathfs.FileSystem {
+	return append([]pathfs.FileSystem(nil), fs.fileSystems...)
+}
+
 func (fs *unionFS) String() string {
 	names := []string{}
 	for _, fs := range fs.fileSystems {
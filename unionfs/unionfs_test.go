@@ -1485,3 +1485,25 @@ func TestUnionFSBarf(t *testing.T) {
 		t.Fatalf("os.Rename: %v", err)
 	}
 }
+
+func TestUnionFsLayers(t *testing.T) {
+	wd := testutil.TempDir()
+	defer os.RemoveAll(wd)
+	fses := []pathfs.FileSystem{
+		pathfs.NewLoopbackFileSystem(wd),
+		pathfs.NewLoopbackFileSystem(wd + "/ro"),
+	}
+	ufs, err := NewUnionFs(fses, testOpts)
+	if err != nil {
+		t.Fatalf("NewUnionFs: %v", err)
+	}
+	layers := ufs.(LayeredFileSystem).Layers()
+	if len(layers) != len(fses) {
+		t.Fatalf("got %d layers, want %d", len(layers), len(fses))
+	}
+	for i := range fses {
+		if layers[i] != fses[i] {
+			t.Errorf("layer %d: got %v, want %v", i, layers[i], fses[i])
+		}
+	}
+}
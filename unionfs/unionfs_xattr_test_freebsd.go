@@ -0,0 +1,14 @@
+// Copyright 2016 the Go-FUSE Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package unionfs
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// FreeBSD has no syscall.Getxattr(); x/sys/unix emulates it with extattr_get_file.
+func Getxattr(path string, attr string, dest []byte) (sz int, err error) {
+	return unix.Getxattr(path, attr, dest)
+}
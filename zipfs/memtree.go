@@ -17,6 +17,12 @@ type MemFile interface {
 	Data() []byte
 }
 
+// MemFileOpener is an additional interface for MemFiles that can
+// serve reads without loading all of their data at once.
+type MemFileOpener interface {
+	OpenFile() nodefs.File
+}
+
 type memNode struct {
 	nodefs.Node
 	file MemFile
@@ -101,6 +107,9 @@ func (n *memNode) Open(flags uint32, context *fuse.Context) (fuseFile nodefs.Fil
 		return nil, fuse.EPERM
 	}
 
+	if o, ok := n.file.(MemFileOpener); ok {
+		return o.OpenFile(), fuse.OK
+	}
 	return nodefs.NewDataFile(n.file.Data()), fuse.OK
 }
 
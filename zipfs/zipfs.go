@@ -9,9 +9,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
@@ -24,9 +26,8 @@ type ZipFile struct {
 func (f *ZipFile) Stat(out *fuse.Attr) {
 	out.Mode = fuse.S_IFREG | uint32(f.File.Mode())
 	out.Size = uint64(f.File.UncompressedSize)
-	out.Mtime = uint64(f.File.ModTime().Unix())
-	out.Atime = out.Mtime
-	out.Ctime = out.Mtime
+	t := f.File.ModTime()
+	out.SetTimes(&t, &t, &t)
 }
 
 func (f *ZipFile) Data() []byte {
@@ -44,24 +45,107 @@ func (f *ZipFile) Data() []byte {
 	return dest.Bytes()
 }
 
+// OpenFile returns a File that decompresses the entry as it is read.
+func (f *ZipFile) OpenFile() nodefs.File {
+	return &zipReadFile{
+		File: nodefs.NewReadOnlyFile(nodefs.NewDefaultFile()),
+		zf:   f.File,
+	}
+}
+
+// zipReadFile serves reads from a zip entry. Zip data can only be
+// decompressed sequentially, so it keeps the decompressor between
+// reads, and only starts over for reads before the current position.
+type zipReadFile struct {
+	nodefs.File
+	zf *zip.File
+
+	mu  sync.Mutex
+	rc  io.ReadCloser
+	pos int64
+}
+
+func (f *zipReadFile) String() string {
+	return fmt.Sprintf("zipReadFile(%s)", f.zf.Name)
+}
+
+func (f *zipReadFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rc == nil || off < f.pos {
+		if f.rc != nil {
+			f.rc.Close()
+		}
+		rc, err := f.zf.Open()
+		if err != nil {
+			f.rc = nil
+			return nil, fuse.EIO
+		}
+		f.rc = rc
+		f.pos = 0
+	}
+	if off > f.pos {
+		n, err := io.CopyN(ioutil.Discard, f.rc, off-f.pos)
+		f.pos += n
+		if err == io.EOF {
+			return fuse.ReadResultData(nil), fuse.OK
+		} else if err != nil {
+			return nil, fuse.EIO
+		}
+	}
+	n, err := io.ReadFull(f.rc, dest)
+	f.pos += int64(n)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fuse.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), fuse.OK
+}
+
+func (f *zipReadFile) Release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rc != nil {
+		f.rc.Close()
+		f.rc = nil
+	}
+}
+
 // NewZipTree creates a new file-system for the zip file named name.
 func NewZipTree(name string) (map[string]MemFile, error) {
 	r, err := zip.OpenReader(name)
 	if err != nil {
 		return nil, err
 	}
+	return zipTree(&r.Reader), nil
+}
 
+// zipTree returns the files of r. Directories are implied by the
+// file names, and names that would end up outside the tree are
+// skipped.
+func zipTree(r *zip.Reader) map[string]MemFile {
 	out := map[string]MemFile{}
 	for _, f := range r.File {
 		if strings.HasSuffix(f.Name, "/") {
 			continue
 		}
-		n := filepath.Clean(f.Name)
+		n := strings.TrimLeft(filepath.Clean(f.Name), "/")
+		if n == "." || n == ".." || strings.HasPrefix(n, "../") {
+			continue
+		}
 
 		zf := &ZipFile{f}
 		out[n] = zf
 	}
-	return out, nil
+	return out
+}
+
+// NewZipFileSystem returns the root of a read-only file system
+// showing the entries of r. File contents are decompressed when they
+// are read.
+func NewZipFileSystem(r *zip.Reader) nodefs.Node {
+	mfs := NewMemTreeFs(zipTree(r))
+	mfs.Name = "zipfs"
+	return mfs.Root()
 }
 
 func NewArchiveFileSystem(name string) (root nodefs.Node, err error) {
@@ -5,6 +5,9 @@
 package zipfs
 
 import (
+	"archive/zip"
+	"bytes"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -116,3 +119,68 @@ func TestLinkCount(t *testing.T) {
 		t.Fatal("wrong link count", fuse.ToStatT(fi).Nlink)
 	}
 }
+
+func TestZipFileSystem(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	for _, name := range []string{"a/b/big", "a/small", "../escape"} {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mnt := testutil.TempDir()
+	defer os.Remove(mnt)
+	server, _, err := nodefs.MountRoot(mnt, NewZipFileSystem(r), &nodefs.Options{
+		Debug: testutil.VerboseTest(),
+	})
+	if err != nil {
+		t.Fatalf("MountRoot: %v", err)
+	}
+	go server.Serve()
+	server.WaitMount()
+	defer server.Unmount()
+
+	names, err := ioutil.ReadDir(mnt)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(names) != 1 || names[0].Name() != "a" || !names[0].IsDir() {
+		t.Errorf("got root entries %v, want directory a", names)
+	}
+
+	f, err := os.Open(filepath.Join(mnt, "a/b/big"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	// Read forward with a gap, then back to the start.
+	for _, off := range []int64{100, 300000, 5, int64(len(content)) - 10} {
+		got := make([]byte, 1000)
+		n, err := f.ReadAt(got, off)
+		if err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d): %v", off, err)
+		}
+		want := content[off:]
+		if len(want) > len(got) {
+			want = want[:len(got)]
+		}
+		if !bytes.Equal(got[:n], want) {
+			t.Errorf("ReadAt(%d): got %q, want %q", off, got[:n], want)
+		}
+	}
+
+	if _, err := os.OpenFile(filepath.Join(mnt, "a/small"), os.O_WRONLY, 0); err == nil {
+		t.Error("opened file for writing")
+	}
+}